@@ -0,0 +1,117 @@
+package usb
+
+import (
+	"errors"
+	"time"
+)
+
+// A TransferResult is the outcome of an asynchronous transfer.
+type TransferResult struct {
+	// Status is the status the transfer completed with.
+	Status TransferStatus
+
+	// ActualLength is the number of bytes transferred, excluding the setup
+	// packet of control transfers. It is not valid for isochronous
+	// transfers; use the lengths in IsoPackets instead.
+	ActualLength int
+
+	// Data is the part of the transfer buffer that was transferred. It is
+	// not valid for isochronous transfers.
+	Data []byte
+
+	// IsoPackets holds the per packet results of an isochronous transfer.
+	IsoPackets []IsoPacket
+
+	// Err is nil if the transfer completed, and otherwise the same error
+	// Transfer.Err reports.
+	Err error
+}
+
+// An AsyncTransfer is a submitted transfer whose result is delivered over a
+// channel, so that waiting for it composes with select.
+type AsyncTransfer struct {
+	t        *Transfer
+	done     chan TransferResult
+	finished chan struct{}
+	result   TransferResult
+}
+
+// Submit starts a bulk, interrupt or isochronous transfer on an endpoint and
+// returns immediately. For isochronous transfers, buf is split evenly into
+// isoPackets packets; isoPackets is ignored for other types. A timeout of 0
+// never expires.
+func (h *DeviceHandle) Submit(tt TransferType, endpoint uint8, buf []byte, isoPackets int, timeout time.Duration) (*AsyncTransfer, error) {
+	a := newAsyncTransfer()
+	a.t = h.NewTransfer(tt, endpoint, buf, isoPackets, timeout, a.complete)
+	if tt == TransferTypeIsochronous && isoPackets > 0 {
+		a.t.SetIsoPacketLengths(len(buf) / isoPackets)
+	}
+	return a.submit()
+}
+
+// SubmitControl starts a control transfer and returns immediately. The
+// arguments are those of NewControlTransfer.
+func (h *DeviceHandle) SubmitControl(requestType, request uint8, value, index uint16, data []byte, timeout time.Duration) (*AsyncTransfer, error) {
+	a := newAsyncTransfer()
+	a.t = h.NewControlTransfer(requestType, request, value, index, data, timeout, a.complete)
+	return a.submit()
+}
+
+func newAsyncTransfer() *AsyncTransfer {
+	return &AsyncTransfer{
+		done:     make(chan TransferResult, 1),
+		finished: make(chan struct{}),
+	}
+}
+
+func (a *AsyncTransfer) submit() (*AsyncTransfer, error) {
+	if err := a.t.Submit(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// complete may run on the event goroutine, so the send must never block.
+func (a *AsyncTransfer) complete(t *Transfer) {
+	xfer := t.xfer
+	a.result = TransferResult{
+		Status:       t.Status(),
+		ActualLength: xfer.actual_length,
+		Err:          t.Err(),
+	}
+	if t.Type() == TransferTypeIsochronous {
+		a.result.IsoPackets = t.IsoPackets()
+	} else {
+		a.result.Data = t.Data()
+	}
+	close(a.finished)
+	a.done <- a.result
+}
+
+// Done returns a channel that receives the result once the transfer
+// completes, fails or is cancelled. Exactly one value is sent on it.
+func (a *AsyncTransfer) Done() <-chan TransferResult {
+	return a.done
+}
+
+// Wait blocks until the transfer has finished and returns its result and
+// its error. Unlike Done, it may be called any number of times.
+func (a *AsyncTransfer) Wait() (TransferResult, error) {
+	<-a.finished
+	return a.result, a.result.Err
+}
+
+// Cancel asynchronously cancels the transfer, which then finishes with
+// TransferCancelled. Cancelling a transfer that has already finished is not
+// an error.
+func (a *AsyncTransfer) Cancel() error {
+	select {
+	case <-a.finished:
+		return nil
+	default:
+	}
+	if err := a.t.Cancel(); err != nil && !errors.Is(err, ErrNotFound) {
+		return err
+	}
+	return nil
+}
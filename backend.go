@@ -103,9 +103,8 @@ type usbi_os_backend interface {
 	 *
 	 * Your backend should allocate any internal resources required for I/O
 	 * and other operations so that those operations can happen (hopefully)
-	 * without hiccup. This is also a good place to inform libusb that it
-	 * should monitor certain file descriptors related to this device -
-	 * see the usbi_add_pollfd() function.
+	 * without hiccup. This is also a good place to start whatever goroutine
+	 * watches for transfer activity on this device.
 	 *
 	 * This function should not generate any bus I/O and should not block.
 	 *
@@ -125,10 +124,8 @@ type usbi_os_backend interface {
 	Open(*libusb_device_handle) libusb_error
 
 	/* Close a device such that the handle cannot be used again. Your backend
-	 * should destroy any resources that were allocated in the open path.
-	 * This may also be a good place to call usbi_remove_pollfd() to inform
-	 * libusb of any file descriptors associated with this device that should
-	 * no longer be monitored.
+	 * should destroy any resources that were allocated in the open path,
+	 * and must not signal any completions for this handle once it returns.
 	 *
 	 * This function is called when the user closes a device handle.
 	 */
@@ -415,7 +412,7 @@ type usbi_os_backend interface {
 	 *
 	 * This function must not block. The transfer cancellation must complete
 	 * later, resulting in a call to usbi_handle_transfer_cancellation()
-	 * from the context of handle_events.
+	 * from the context of handle_transfer_completion.
 	 */
 	Cancel_transfer(*usbi_transfer) libusb_error
 
@@ -431,53 +428,17 @@ type usbi_os_backend interface {
 	 */
 	Clear_transfer_priv(*usbi_transfer)
 
-	/* Handle any pending events on file descriptors. Optional.
-	 *
-	 * Provide this function when file descriptors directly indicate device
-	 * or transfer activity. If your backend does not have such file descriptors,
-	 * implement the handle_transfer_completion function below.
-	 *
-	 * This involves monitoring any active transfers and processing their
-	 * completion or cancellation.
-	 *
-	 * The function is passed an array of pollfd structures (size nfds)
-	 * as a result of the poll() system call. The num_ready parameter
-	 * indicates the number of file descriptors that have reported events
-	 * (i.e. the poll() return value). This should be enough information
-	 * for you to determine which actions need to be taken on the currently
-	 * active transfers.
-	 *
-	 * For any cancelled transfers, call usbi_handle_transfer_cancellation().
-	 * For completed transfers, call usbi_handle_transfer_completion().
-	 * For control/bulk/interrupt transfers, populate the "transferred"
-	 * element of the appropriate usbi_transfer structure before calling the
-	 * above functions. For isochronous transfers, populate the status and
-	 * transferred fields of the iso packet descriptors of the transfer.
-	 *
-	 * This function should also be able to detect disconnection of the
-	 * device, reporting that situation with usbi_handle_disconnect().
-	 *
-	 * When processing an event related to a transfer, you probably want to
-	 * take usbi_transfer.lock to prevent races. See the documentation for
-	 * the usbi_transfer structure.
-	 *
-	 * Return 0 on success, or a LIBUSB_ERROR code on failure.
-	 */
-	Handle_events(*libusb_context, *pollfd, POLL_NFDS_TYPE, int) libusb_error
-
-	/* Handle transfer completion. Optional.
-	 *
-	 * Provide this function when there are no file descriptors available
-	 * that directly indicate device or transfer activity. If your backend does
-	 * have such file descriptors, implement the handle_events function above.
+	/* Handle transfer completion.
 	 *
 	 * Your backend must tell the library when a transfer has completed by
-	 * calling usbi_signal_transfer_completion(). You should store any private
-	 * information about the transfer and its completion status in the transfer's
-	 * private backend data.
+	 * calling usbi_signal_transfer_completion(), from whichever goroutine
+	 * noticed it. You should store any private information about the
+	 * transfer and its completion status in the transfer's private backend
+	 * data.
 	 *
-	 * During event handling, this function will be called on each transfer for
-	 * which usbi_signal_transfer_completion() was called.
+	 * The context's event handling goroutine will then call this function
+	 * on each transfer for which usbi_signal_transfer_completion() was
+	 * called, so that user callbacks all run on that goroutine.
 	 *
 	 * For any cancelled transfers, call usbi_handle_transfer_cancellation().
 	 * For completed transfers, call usbi_handle_transfer_completion().
@@ -506,3 +467,20 @@ type usbi_os_backend interface {
 	 */
 	Transfer_priv_size() int
 }
+
+// A Backend is the layer through which the library reaches USB devices. The
+// operating system's backend is used unless another one is installed with
+// SetBackend, such as a Simulator's. Backends cannot be implemented outside
+// this package; implement a Driver and make a Backend from it with
+// NewBackend instead.
+type Backend interface {
+	usbi_os_backend
+}
+
+// SetBackend installs b as the backend for all contexts and returns the one
+// it replaces. It must not be called while any Context is open.
+func SetBackend(b Backend) Backend {
+	prev := usbi_backend
+	usbi_backend = b
+	return prev
+}
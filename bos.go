@@ -0,0 +1,419 @@
+package usb
+
+import (
+	"encoding/hex"
+	"fmt"
+)
+
+// Device capability types, the CapabilityType of a DeviceCapability. See
+// table 9-14 of the USB 3.2 specification.
+const (
+	CapabilityWirelessUSB              = 0x01
+	CapabilityUSB20Extension           = 0x02
+	CapabilitySuperSpeed               = 0x03
+	CapabilityContainerID              = 0x04
+	CapabilityPlatform                 = 0x05
+	CapabilityPowerDelivery            = 0x06
+	CapabilityBatteryInfo              = 0x07
+	CapabilityPDConsumerPort           = 0x08
+	CapabilityPDProviderPort           = 0x09
+	CapabilitySuperSpeedPlus           = 0x0a
+	CapabilityPrecisionTimeMeasurement = 0x0b
+	CapabilityWirelessUSBExt           = 0x0c
+	CapabilityBillboard                = 0x0d
+	CapabilityAuthentication           = 0x0e
+	CapabilityBillboardEx              = 0x0f
+	CapabilityConfigurationSummary     = 0x10
+)
+
+// UUID is a 128 bit UUID in the byte order of descriptors, where the first
+// three fields are little endian as in a Microsoft GUID. It is written in
+// the usual text form, such as "d8dd60df-4589-4cc7-9cd2-659d9e648a9f".
+type UUID [16]byte
+
+func (u UUID) String() string {
+	b, _ := u.MarshalText()
+	return string(b)
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (u UUID) MarshalText() ([]byte, error) {
+	return []byte(fmt.Sprintf("%02x%02x%02x%02x-%02x%02x-%02x%02x-%x-%x",
+		u[3], u[2], u[1], u[0], u[5], u[4], u[7], u[6], u[8:10], u[10:])), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (u *UUID) UnmarshalText(text []byte) error {
+	v, err := ParseUUID(string(text))
+	if err != nil {
+		return err
+	}
+	*u = v
+	return nil
+}
+
+// ParseUUID parses a UUID in the text form, with or without braces.
+func ParseUUID(s string) (UUID, error) {
+	var u UUID
+	t := s
+	if len(t) == 38 && t[0] == '{' && t[37] == '}' {
+		t = t[1:37]
+	}
+	if len(t) != 36 || t[8] != '-' || t[13] != '-' || t[18] != '-' || t[23] != '-' {
+		return u, fmt.Errorf("usb: invalid UUID %q", s)
+	}
+	b, err := hex.DecodeString(t[:8] + t[9:13] + t[14:18] + t[19:23] + t[24:])
+	if err != nil {
+		return u, fmt.Errorf("usb: invalid UUID %q", s)
+	}
+	u = UUID{b[3], b[2], b[1], b[0], b[5], b[4], b[7], b[6]}
+	copy(u[8:], b[8:])
+	return u, nil
+}
+
+// Capability returns the first capability of the given type, or nil if the
+// device has none.
+func (b *BOSDescriptor) Capability(capType uint8) *DeviceCapability {
+	for i := range b.Capabilities {
+		if b.Capabilities[i].CapabilityType == capType {
+			return &b.Capabilities[i]
+		}
+	}
+	return nil
+}
+
+// Platform returns the platform capability with the given UUID, or nil if
+// the device has none.
+func (b *BOSDescriptor) Platform(uuid UUID) (*PlatformCapability, error) {
+	for i := range b.Capabilities {
+		if b.Capabilities[i].CapabilityType != CapabilityPlatform {
+			continue
+		}
+		p, err := b.Capabilities[i].Platform()
+		if err != nil {
+			return nil, err
+		}
+		if p.UUID == uuid {
+			return p, nil
+		}
+	}
+	return nil, nil
+}
+
+// PrecisionTimeMeasurement reports whether the device supports Precision
+// Time Measurement, which its capability has no fields to describe.
+func (b *BOSDescriptor) PrecisionTimeMeasurement() bool {
+	return b.Capability(CapabilityPrecisionTimeMeasurement) != nil
+}
+
+// reader checks that the capability is of capType and returns a reader
+// positioned after bDevCapabilityType.
+func (c *DeviceCapability) reader(op string, capType uint8) (*descReader, error) {
+	if c.CapabilityType != capType {
+		return nil, codeError(op, LIBUSB_ERROR_INVALID_PARAM)
+	}
+	r := newDescReader(append([]byte{c.Length, c.DescriptorType, c.CapabilityType}, c.Data...))
+	r.next(LIBUSB_DT_DEVICE_CAPABILITY_SIZE)
+	return r, nil
+}
+
+// capabilityError reports a capability that is too short for its fields.
+func capabilityError(op string, r *descReader) error {
+	if err := r.err(); err != nil {
+		return descriptorError(op, &DescriptorError{"capability", len(r.desc), err.Error()})
+	}
+	return nil
+}
+
+// WirelessUSBCapability is the Wireless USB device capability, see section
+// 7.4.1.1 of the Wireless USB 1.0 specification.
+type WirelessUSBCapability struct {
+	Attributes     uint8  `json:"bmAttributes"`
+	PHYRates       uint16 `json:"wPHYRates"`
+	TFITXPowerInfo uint8  `json:"bmTFITXPowerInfo"`
+	FFITXPowerInfo uint8  `json:"bmFFITXPowerInfo"`
+	BandGroup      uint16 `json:"bmBandGroup"`
+}
+
+// WirelessUSB decodes a Wireless USB device capability.
+func (c *DeviceCapability) WirelessUSB() (*WirelessUSBCapability, error) {
+	const op = "decode wireless USB capability"
+	r, err := c.reader(op, CapabilityWirelessUSB)
+	if err != nil {
+		return nil, err
+	}
+	w := &WirelessUSBCapability{}
+	w.Attributes = r.u8()
+	w.PHYRates = r.u16()
+	w.TFITXPowerInfo = r.u8()
+	w.FFITXPowerInfo = r.u8()
+	w.BandGroup = r.u16()
+	return w, capabilityError(op, r)
+}
+
+// USB20ExtensionCapability is the USB 2.0 Extension capability, see section
+// 9.6.2.1 of the USB 3.2 specification.
+type USB20ExtensionCapability struct {
+	Attributes uint32 `json:"bmAttributes"`
+}
+
+// LinkPowerManagement reports whether the device supports LPM.
+func (e *USB20ExtensionCapability) LinkPowerManagement() bool {
+	return e.Attributes&uint32(LIBUSB_BM_LPM_SUPPORT) != 0
+}
+
+// USB20Extension decodes a USB 2.0 Extension capability.
+func (c *DeviceCapability) USB20Extension() (*USB20ExtensionCapability, error) {
+	const op = "decode USB 2.0 extension capability"
+	r, err := c.reader(op, CapabilityUSB20Extension)
+	if err != nil {
+		return nil, err
+	}
+	e := &USB20ExtensionCapability{Attributes: r.u32()}
+	return e, capabilityError(op, r)
+}
+
+// SuperSpeedCapability is the SuperSpeed USB device capability, see
+// section 9.6.2.2 of the USB 3.2 specification. SpeedsSupported holds a
+// bit for each of low, full, high and SuperSpeed operation.
+type SuperSpeedCapability struct {
+	Attributes           uint8  `json:"bmAttributes"`
+	SpeedsSupported      uint16 `json:"wSpeedsSupported"`
+	FunctionalitySupport uint8  `json:"bFunctionalitySupport"`
+	U1DevExitLat         uint8  `json:"bU1DevExitLat"`
+	U2DevExitLat         uint16 `json:"wU2DevExitLat"`
+}
+
+// SuperSpeed decodes a SuperSpeed USB device capability.
+func (c *DeviceCapability) SuperSpeed() (*SuperSpeedCapability, error) {
+	const op = "decode SuperSpeed capability"
+	r, err := c.reader(op, CapabilitySuperSpeed)
+	if err != nil {
+		return nil, err
+	}
+	s := &SuperSpeedCapability{}
+	s.Attributes = r.u8()
+	s.SpeedsSupported = r.u16()
+	s.FunctionalitySupport = r.u8()
+	s.U1DevExitLat = r.u8()
+	s.U2DevExitLat = r.u16()
+	return s, capabilityError(op, r)
+}
+
+// ContainerIDCapability is the Container ID capability, which identifies
+// the physical device across the ports it is connected through. See
+// section 9.6.2.3 of the USB 3.2 specification.
+type ContainerIDCapability struct {
+	ContainerID UUID `json:"ContainerID"`
+}
+
+// ContainerID decodes a Container ID capability.
+func (c *DeviceCapability) ContainerID() (*ContainerIDCapability, error) {
+	const op = "decode container ID capability"
+	r, err := c.reader(op, CapabilityContainerID)
+	if err != nil {
+		return nil, err
+	}
+	r.u8() /* bReserved */
+	id := &ContainerIDCapability{ContainerID: r.guid()}
+	return id, capabilityError(op, r)
+}
+
+// PlatformCapability is a platform capability, which carries data for a
+// platform or specification identified by UUID. See section 9.6.2.4 of the
+// USB 3.2 specification.
+type PlatformCapability struct {
+	UUID UUID   `json:"PlatformCapabilityUUID"`
+	Data []byte `json:"CapabilityData"`
+}
+
+// Platform decodes a platform capability.
+func (c *DeviceCapability) Platform() (*PlatformCapability, error) {
+	const op = "decode platform capability"
+	r, err := c.reader(op, CapabilityPlatform)
+	if err != nil {
+		return nil, err
+	}
+	r.u8() /* bReserved */
+	p := &PlatformCapability{UUID: r.guid()}
+	p.Data = r.bytes(r.left())
+	return p, capabilityError(op, r)
+}
+
+// SuperSpeedPlusCapability is the SuperSpeedPlus USB device capability,
+// see section 9.6.2.5 of the USB 3.2 specification.
+type SuperSpeedPlusCapability struct {
+	// Attributes holds the number of sublink speed attributes less one in
+	// bits 4:0, and the number of sublink speed IDs less one in bits 8:5.
+	Attributes uint32 `json:"bmAttributes"`
+
+	// FunctionalitySupport holds the ID of the lowest sublink speed at
+	// which the device is fully functional in bits 3:0, and the minimum
+	// receive and transmit lane counts in bits 11:8 and 15:12.
+	FunctionalitySupport uint16 `json:"wFunctionalitySupport"`
+
+	SublinkSpeeds []SublinkSpeed `json:"bmSublinkSpeedAttr"`
+}
+
+// SublinkSpeed is a sublink speed attribute of a SuperSpeedPlus device. A
+// symmetric attribute gives the speed of both directions of the link.
+type SublinkSpeed struct {
+	ID         uint8  `json:"ssid"`
+	Exponent   uint8  `json:"lse"`
+	Asymmetric bool   `json:"asymmetric"`
+	Transmit   bool   `json:"transmit"`
+	Protocol   uint8  `json:"lp"`
+	Mantissa   uint16 `json:"lsm"`
+}
+
+// BitRate returns the speed in bits per second.
+func (s SublinkSpeed) BitRate() uint64 {
+	rate := uint64(s.Mantissa)
+	for i := uint8(0); i < s.Exponent; i++ {
+		rate *= 1000
+	}
+	return rate
+}
+
+func (s SublinkSpeed) String() string {
+	units := [4]string{"b/s", "Kb/s", "Mb/s", "Gb/s"}
+	str := fmt.Sprintf("%d%s", s.Mantissa, units[s.Exponent&3])
+	if s.Asymmetric {
+		str += " Asymmetric"
+	} else {
+		str += " Symmetric"
+	}
+	if s.Transmit {
+		str += " TX"
+	} else {
+		str += " RX"
+	}
+	switch s.Protocol {
+	case 0:
+		str += " SuperSpeed"
+	case 1:
+		str += " SuperSpeedPlus"
+	default:
+		str += fmt.Sprintf(" (protocol %d)", s.Protocol)
+	}
+	return str
+}
+
+// SuperSpeedPlus decodes a SuperSpeedPlus USB device capability.
+func (c *DeviceCapability) SuperSpeedPlus() (*SuperSpeedPlusCapability, error) {
+	const op = "decode SuperSpeedPlus capability"
+	r, err := c.reader(op, CapabilitySuperSpeedPlus)
+	if err != nil {
+		return nil, err
+	}
+	r.u8() /* bReserved */
+	s := &SuperSpeedPlusCapability{}
+	s.Attributes = r.u32()
+	s.FunctionalitySupport = r.u16()
+	r.u16() /* wReserved */
+	for i := 0; i <= int(s.Attributes&0x1f); i++ {
+		attr := r.u32()
+		s.SublinkSpeeds = append(s.SublinkSpeeds, SublinkSpeed{
+			ID:         uint8(attr & 0x0f),
+			Exponent:   uint8(attr>>4) & 0x03,
+			Asymmetric: attr&0x40 != 0,
+			Transmit:   attr&0x80 != 0,
+			Protocol:   uint8(attr>>14) & 0x03,
+			Mantissa:   uint16(attr >> 16),
+		})
+	}
+	return s, capabilityError(op, r)
+}
+
+// BillboardCapability is the Billboard capability of a device that reports
+// the state of USB Type-C alternate modes, see section 3.1.6.2 of the
+// Billboard Device Class 1.22 specification.
+type BillboardCapability struct {
+	AdditionalInfoURLIndex uint8                    `json:"iAdditionalInfoURL"`
+	NumAlternateModes      uint8                    `json:"bNumberOfAlternateModes"`
+	PreferredAlternateMode uint8                    `json:"bPreferredAlternateMode"`
+	VCONNPower             uint16                   `json:"VCONNPower"`
+	Configured             [32]byte                 `json:"bmConfigured"`
+	Version                uint16                   `json:"bcdVersion"`
+	AdditionalFailureInfo  uint8                    `json:"bAdditionalFailureInfo"`
+	AlternateModes         []BillboardAlternateMode `json:"alternateModes"`
+}
+
+// BillboardAlternateMode is an alternate mode of a Billboard capability.
+type BillboardAlternateMode struct {
+	SVID          uint16 `json:"wSVID"`
+	AlternateMode uint8  `json:"bAlternateMode"`
+	StringIndex   uint8  `json:"iAlternateModeString"`
+}
+
+// Alternate mode states, as returned by BillboardCapability.ModeState.
+const (
+	AlternateModeError        = 0
+	AlternateModeNotAttempted = 1
+	AlternateModeUnsuccessful = 2
+	AlternateModeConfigured   = 3
+)
+
+// ModeState returns the state of the alternate mode at index i, from the
+// two bits of Configured that describe it.
+func (b *BillboardCapability) ModeState(i int) uint8 {
+	if i < 0 || i >= 128 {
+		return AlternateModeError
+	}
+	return (b.Configured[i/4] >> (2 * uint(i%4))) & 0x03
+}
+
+// Billboard decodes a Billboard capability.
+func (c *DeviceCapability) Billboard() (*BillboardCapability, error) {
+	const op = "decode billboard capability"
+	r, err := c.reader(op, CapabilityBillboard)
+	if err != nil {
+		return nil, err
+	}
+	b := &BillboardCapability{}
+	b.AdditionalInfoURLIndex = r.u8()
+	b.NumAlternateModes = r.u8()
+	b.PreferredAlternateMode = r.u8()
+	b.VCONNPower = r.u16()
+	copy(b.Configured[:], r.next(len(b.Configured)))
+	b.Version = r.u16()
+	b.AdditionalFailureInfo = r.u8()
+	r.u8() /* bReserved */
+	for i := 0; i < int(b.NumAlternateModes); i++ {
+		b.AlternateModes = append(b.AlternateModes, BillboardAlternateMode{
+			SVID:          r.u16(),
+			AlternateMode: r.u8(),
+			StringIndex:   r.u8(),
+		})
+	}
+	return b, capabilityError(op, r)
+}
+
+// ConfigurationSummaryCapability lists the configurations that implement a
+// function, so that hosts can choose one without reading them all. See
+// section 9.6.2.7 of the USB 3.2 specification.
+type ConfigurationSummaryCapability struct {
+	Version              uint16  `json:"bcdVersion"`
+	Class                uint8   `json:"bClass"`
+	SubClass             uint8   `json:"bSubClass"`
+	Protocol             uint8   `json:"bProtocol"`
+	ConfigurationCount   uint8   `json:"bConfigurationCount"`
+	ConfigurationIndexes []uint8 `json:"bConfigurationIndex"`
+}
+
+// ConfigurationSummary decodes a Configuration Summary capability.
+func (c *DeviceCapability) ConfigurationSummary() (*ConfigurationSummaryCapability, error) {
+	const op = "decode configuration summary capability"
+	r, err := c.reader(op, CapabilityConfigurationSummary)
+	if err != nil {
+		return nil, err
+	}
+	s := &ConfigurationSummaryCapability{}
+	s.Version = r.u16()
+	s.Class = r.u8()
+	s.SubClass = r.u8()
+	s.Protocol = r.u8()
+	s.ConfigurationCount = r.u8()
+	s.ConfigurationIndexes = r.bytes(int(s.ConfigurationCount))
+	return s, capabilityError(op, r)
+}
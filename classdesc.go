@@ -0,0 +1,505 @@
+package usb
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sync"
+)
+
+// Descriptor types of class specific descriptors.
+const (
+	dtInterfaceAssociation = 0x0b
+	dtClassSpecific        = 0x21 // HID, DFU functional, CCID and IPP printer
+	dtCSInterface          = 0x24
+	dtCSEndpoint           = 0x25
+)
+
+// AnyClass registers a descriptor decoder for interfaces of every class.
+const AnyClass = -1
+
+// A ClassDescriptor is a class or vendor specific descriptor, decoded from
+// the Extra bytes of a configuration, interface or endpoint descriptor.
+type ClassDescriptor interface {
+	Header() DescriptorHeader
+}
+
+// DescriptorHeader holds the fields every descriptor starts with. Types
+// that embed it implement ClassDescriptor.
+type DescriptorHeader struct {
+	Length         uint8 `json:"bLength"`
+	DescriptorType uint8 `json:"bDescriptorType"`
+}
+
+// Header returns h.
+func (h DescriptorHeader) Header() DescriptorHeader {
+	return h
+}
+
+// RawDescriptor is a descriptor that no registered decoder recognized. Data
+// holds the bytes that follow the header.
+type RawDescriptor struct {
+	DescriptorHeader
+	Data []byte `json:"data"`
+}
+
+// DescriptorContext describes where a class or vendor specific descriptor
+// was found, as the meaning of most descriptor types depends on the class
+// of the interface they belong to.
+type DescriptorContext struct {
+	InterfaceClass    uint8
+	InterfaceSubClass uint8
+	InterfaceProtocol uint8
+
+	// Endpoint is set for descriptors that follow an endpoint descriptor.
+	Endpoint bool
+}
+
+// A DescriptorDecoder decodes desc, a whole descriptor including its
+// header, whose bLength has been checked against its size. It returns nil
+// and no error for descriptors it does not recognize. Errors describe what
+// is wrong with the descriptor, and are reported as a *DescriptorError.
+type DescriptorDecoder func(ctx DescriptorContext, desc []byte) (ClassDescriptor, error)
+
+type descriptorDecoderKey struct {
+	class    int
+	descType uint8
+}
+
+var (
+	descriptorDecodersMu sync.RWMutex
+	descriptorDecoders   = make(map[descriptorDecoderKey]DescriptorDecoder)
+)
+
+// RegisterDescriptorDecoder registers decode for descriptors of descType
+// in interfaces of class, or in any interface if class is AnyClass. The
+// decoder for the class is tried before the one for AnyClass. Registering
+// a decoder replaces the previous one, so the built in decoders for HID,
+// CDC, audio, video, DFU, printer, smart card and hub descriptors can be
+// overridden, and decoders for vendor specific descriptors added. A nil
+// decode removes the decoder.
+func RegisterDescriptorDecoder(class int, descType uint8, decode DescriptorDecoder) {
+	if class < AnyClass || class > 0xff {
+		panic(fmt.Sprintf("usb: invalid interface class %d", class))
+	}
+	descriptorDecodersMu.Lock()
+	defer descriptorDecodersMu.Unlock()
+	key := descriptorDecoderKey{class, descType}
+	if decode == nil {
+		delete(descriptorDecoders, key)
+	} else {
+		descriptorDecoders[key] = decode
+	}
+}
+
+func decodeDescriptor(ctx DescriptorContext, desc []byte) (ClassDescriptor, error) {
+	descriptorDecodersMu.RLock()
+	decoders := [2]DescriptorDecoder{
+		descriptorDecoders[descriptorDecoderKey{int(ctx.InterfaceClass), desc[1]}],
+		descriptorDecoders[descriptorDecoderKey{AnyClass, desc[1]}],
+	}
+	descriptorDecodersMu.RUnlock()
+
+	for _, decode := range decoders {
+		if decode == nil {
+			continue
+		}
+		d, err := decode(ctx, desc)
+		if err != nil || d != nil {
+			return d, err
+		}
+	}
+	return &RawDescriptor{
+		DescriptorHeader: DescriptorHeader{desc[0], desc[1]},
+		Data:             append([]byte(nil), desc[DESC_HEADER_LENGTH:]...),
+	}, nil
+}
+
+// DecodeDescriptor decodes a single class or vendor specific descriptor,
+// such as a hub descriptor read with DeviceHandle.Descriptor.
+func DecodeDescriptor(ctx DescriptorContext, desc []byte) (ClassDescriptor, error) {
+	const path = "descriptor"
+	switch {
+	case len(desc) < DESC_HEADER_LENGTH:
+		return nil, descriptorError("decode descriptor", &DescriptorError{path, 0,
+			fmt.Sprintf("short descriptor, %d of %d bytes", len(desc), DESC_HEADER_LENGTH)})
+	case int(desc[0]) < DESC_HEADER_LENGTH || int(desc[0]) > len(desc):
+		return nil, descriptorError("decode descriptor", &DescriptorError{path, 0,
+			fmt.Sprintf("invalid bLength %d", desc[0])})
+	}
+	d, err := decodeDescriptor(ctx, desc[:desc[0]])
+	if err != nil {
+		return nil, descriptorError("decode descriptor", &DescriptorError{path, 0, err.Error()})
+	}
+	return d, nil
+}
+
+// DecodeDescriptors decodes the class and vendor specific descriptors in
+// extra, which were found in the place ctx describes.
+func DecodeDescriptors(ctx DescriptorContext, extra []byte) ([]ClassDescriptor, error) {
+	const path = "extra"
+	var descs []ClassDescriptor
+	for off := 0; off < len(extra); {
+		if len(extra)-off < DESC_HEADER_LENGTH {
+			return nil, descriptorError("decode descriptors", &DescriptorError{path, off,
+				fmt.Sprintf("%d bytes of trailing data", len(extra)-off)})
+		}
+		n := int(extra[off])
+		if n < DESC_HEADER_LENGTH || n > len(extra)-off {
+			return nil, descriptorError("decode descriptors", &DescriptorError{path, off,
+				fmt.Sprintf("invalid bLength %d", n)})
+		}
+		d, err := decodeDescriptor(ctx, extra[off:off+n])
+		if err != nil {
+			return nil, descriptorError("decode descriptors", &DescriptorError{
+				fmt.Sprintf("%s[%d]", path, len(descs)), off, err.Error()})
+		}
+		descs = append(descs, d)
+		off += n
+	}
+	return descs, nil
+}
+
+// ClassDescriptors decodes the class and vendor specific descriptors that
+// precede the first interface, usually interface associations.
+func (d *ConfigDescriptor) ClassDescriptors() ([]ClassDescriptor, error) {
+	return DecodeDescriptors(DescriptorContext{}, d.Extra)
+}
+
+func (d *InterfaceDescriptor) descriptorContext() DescriptorContext {
+	return DescriptorContext{
+		InterfaceClass:    d.InterfaceClass,
+		InterfaceSubClass: d.InterfaceSubClass,
+		InterfaceProtocol: d.InterfaceProtocol,
+	}
+}
+
+// ClassDescriptors decodes the class and vendor specific descriptors of the
+// interface.
+func (d *InterfaceDescriptor) ClassDescriptors() ([]ClassDescriptor, error) {
+	return DecodeDescriptors(d.descriptorContext(), d.Extra)
+}
+
+// EndpointClassDescriptors decodes the class and vendor specific
+// descriptors of the endpoint at index i of the interface.
+func (d *InterfaceDescriptor) EndpointClassDescriptors(i int) ([]ClassDescriptor, error) {
+	ctx := d.descriptorContext()
+	ctx.Endpoint = true
+	return DecodeDescriptors(ctx, d.Endpoints[i].Extra)
+}
+
+// descReader reads the little endian fields of a descriptor. Reads past
+// the end return zero and are reported by err.
+type descReader struct {
+	desc  []byte
+	off   int
+	short bool
+}
+
+func newDescReader(desc []byte) *descReader {
+	return &descReader{desc: desc}
+}
+
+func (r *descReader) header() DescriptorHeader {
+	return DescriptorHeader{r.u8(), r.u8()}
+}
+
+func (r *descReader) left() int {
+	if r.off > len(r.desc) {
+		return 0
+	}
+	return len(r.desc) - r.off
+}
+
+func (r *descReader) next(n int) []byte {
+	if n < 0 || r.left() < n {
+		r.short = true
+		r.off = len(r.desc)
+		return nil
+	}
+	b := r.desc[r.off : r.off+n]
+	r.off += n
+	return b
+}
+
+func (r *descReader) u8() uint8 {
+	if b := r.next(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+func (r *descReader) u16() uint16 {
+	if b := r.next(2); b != nil {
+		return binary.LittleEndian.Uint16(b)
+	}
+	return 0
+}
+
+func (r *descReader) u24() uint32 {
+	if b := r.next(3); b != nil {
+		return uint32(b[0]) | uint32(b[1])<<8 | uint32(b[2])<<16
+	}
+	return 0
+}
+
+func (r *descReader) u32() uint32 {
+	if b := r.next(4); b != nil {
+		return binary.LittleEndian.Uint32(b)
+	}
+	return 0
+}
+
+// uint reads an n byte field, as used for bitmaps whose size is given by
+// another field.
+func (r *descReader) uint(n int) uint32 {
+	if n > 4 {
+		r.next(n)
+		r.short = true
+		return 0
+	}
+	var v uint32
+	for i, c := range r.next(n) {
+		v |= uint32(c) << (8 * i)
+	}
+	return v
+}
+
+// bytes returns a copy of the next n bytes.
+func (r *descReader) bytes(n int) []byte {
+	return append([]byte(nil), r.next(n)...)
+}
+
+func (r *descReader) guid() (g [16]byte) {
+	copy(g[:], r.next(16))
+	return g
+}
+
+func (r *descReader) err() error {
+	if r.short {
+		return fmt.Errorf("bLength %d is too short for the fields of the descriptor", len(r.desc))
+	}
+	return nil
+}
+
+func init() {
+	RegisterDescriptorDecoder(AnyClass, dtInterfaceAssociation, decodeInterfaceAssociation)
+	RegisterDescriptorDecoder(AnyClass, uint8(LIBUSB_DT_SS_ENDPOINT_COMPANION), decodeSSEndpointCompanion)
+	RegisterDescriptorDecoder(int(LIBUSB_CLASS_HID), dtClassSpecific, decodeHID)
+	RegisterDescriptorDecoder(int(LIBUSB_CLASS_APPLICATION), dtClassSpecific, decodeDFUFunctional)
+	RegisterDescriptorDecoder(int(LIBUSB_CLASS_SMART_CARD), dtClassSpecific, decodeCCID)
+	RegisterDescriptorDecoder(int(LIBUSB_CLASS_PRINTER), dtClassSpecific, decodePrinter)
+	RegisterDescriptorDecoder(int(LIBUSB_CLASS_HUB), uint8(LIBUSB_DT_HUB), decodeHub)
+	RegisterDescriptorDecoder(int(LIBUSB_CLASS_HUB), uint8(LIBUSB_DT_SUPERSPEED_HUB), decodeHub)
+}
+
+// InterfaceAssociationDescriptor groups the interfaces of a function, see
+// section 9.6.4 of the USB 3.2 specification.
+type InterfaceAssociationDescriptor struct {
+	DescriptorHeader
+	FirstInterface   uint8 `json:"bFirstInterface"`
+	InterfaceCount   uint8 `json:"bInterfaceCount"`
+	FunctionClass    uint8 `json:"bFunctionClass"`
+	FunctionSubClass uint8 `json:"bFunctionSubClass"`
+	FunctionProtocol uint8 `json:"bFunctionProtocol"`
+	FunctionIndex    uint8 `json:"iFunction"`
+}
+
+func decodeInterfaceAssociation(ctx DescriptorContext, desc []byte) (ClassDescriptor, error) {
+	r := newDescReader(desc)
+	d := &InterfaceAssociationDescriptor{DescriptorHeader: r.header()}
+	d.FirstInterface = r.u8()
+	d.InterfaceCount = r.u8()
+	d.FunctionClass = r.u8()
+	d.FunctionSubClass = r.u8()
+	d.FunctionProtocol = r.u8()
+	d.FunctionIndex = r.u8()
+	return d, r.err()
+}
+
+// SSEndpointCompanionDescriptor follows the endpoint descriptors of
+// SuperSpeed devices, see section 9.6.7 of the USB 3.2 specification.
+type SSEndpointCompanionDescriptor struct {
+	DescriptorHeader
+	MaxBurst         uint8  `json:"bMaxBurst"`
+	Attributes       uint8  `json:"bmAttributes"`
+	BytesPerInterval uint16 `json:"wBytesPerInterval"`
+}
+
+func decodeSSEndpointCompanion(ctx DescriptorContext, desc []byte) (ClassDescriptor, error) {
+	if !ctx.Endpoint {
+		return nil, nil
+	}
+	r := newDescReader(desc)
+	d := &SSEndpointCompanionDescriptor{DescriptorHeader: r.header()}
+	d.MaxBurst = r.u8()
+	d.Attributes = r.u8()
+	d.BytesPerInterval = r.u16()
+	return d, r.err()
+}
+
+// HIDDescriptor is the descriptor of a HID interface, see section 6.2.1 of
+// the Device Class Definition for HID 1.11.
+type HIDDescriptor struct {
+	DescriptorHeader
+	HIDVersion     uint16 `json:"bcdHID"`
+	CountryCode    uint8  `json:"bCountryCode"`
+	NumDescriptors uint8  `json:"bNumDescriptors"`
+
+	// Descriptors lists the report and physical descriptors of the
+	// interface, which are read with GET_DESCRIPTOR requests.
+	Descriptors []HIDClassDescriptor `json:"descriptors"`
+}
+
+// HIDClassDescriptor gives the type and length of a report or physical
+// descriptor.
+type HIDClassDescriptor struct {
+	DescriptorType uint8  `json:"bDescriptorType"`
+	Length         uint16 `json:"wDescriptorLength"`
+}
+
+func decodeHID(ctx DescriptorContext, desc []byte) (ClassDescriptor, error) {
+	r := newDescReader(desc)
+	d := &HIDDescriptor{DescriptorHeader: r.header()}
+	d.HIDVersion = r.u16()
+	d.CountryCode = r.u8()
+	d.NumDescriptors = r.u8()
+	for i := 0; i < int(d.NumDescriptors); i++ {
+		d.Descriptors = append(d.Descriptors, HIDClassDescriptor{r.u8(), r.u16()})
+	}
+	return d, r.err()
+}
+
+// DFUFunctionalDescriptor describes the capabilities of a Device Firmware
+// Upgrade interface, see section 4.1.3 of the DFU 1.1 specification.
+// DFUVersion is 0 in the shorter descriptors of DFU 1.0 devices.
+type DFUFunctionalDescriptor struct {
+	DescriptorHeader
+	Attributes    uint8  `json:"bmAttributes"`
+	DetachTimeout uint16 `json:"wDetachTimeOut"`
+	TransferSize  uint16 `json:"wTransferSize"`
+	DFUVersion    uint16 `json:"bcdDFUVersion"`
+}
+
+func decodeDFUFunctional(ctx DescriptorContext, desc []byte) (ClassDescriptor, error) {
+	/* DFU is subclass 1 of the application specific class */
+	if ctx.InterfaceSubClass != 0x01 {
+		return nil, nil
+	}
+	r := newDescReader(desc)
+	d := &DFUFunctionalDescriptor{DescriptorHeader: r.header()}
+	d.Attributes = r.u8()
+	d.DetachTimeout = r.u16()
+	d.TransferSize = r.u16()
+	if r.left() >= 2 {
+		d.DFUVersion = r.u16()
+	}
+	return d, r.err()
+}
+
+// CCIDDescriptor describes a smart card reader, see section 5.1 of the
+// CCID 1.1 specification.
+type CCIDDescriptor struct {
+	DescriptorHeader
+	CCIDVersion           uint16 `json:"bcdCCID"`
+	MaxSlotIndex          uint8  `json:"bMaxSlotIndex"`
+	VoltageSupport        uint8  `json:"bVoltageSupport"`
+	Protocols             uint32 `json:"dwProtocols"`
+	DefaultClock          uint32 `json:"dwDefaultClock"`
+	MaximumClock          uint32 `json:"dwMaximumClock"`
+	NumClockSupported     uint8  `json:"bNumClockSupported"`
+	DataRate              uint32 `json:"dwDataRate"`
+	MaxDataRate           uint32 `json:"dwMaxDataRate"`
+	NumDataRatesSupported uint8  `json:"bNumDataRatesSupported"`
+	MaxIFSD               uint32 `json:"dwMaxIFSD"`
+	SynchProtocols        uint32 `json:"dwSynchProtocols"`
+	Mechanical            uint32 `json:"dwMechanical"`
+	Features              uint32 `json:"dwFeatures"`
+	MaxCCIDMessageLength  uint32 `json:"dwMaxCCIDMessageLength"`
+	ClassGetResponse      uint8  `json:"bClassGetResponse"`
+	ClassEnvelope         uint8  `json:"bClassEnvelope"`
+	LCDLayout             uint16 `json:"wLcdLayout"`
+	PINSupport            uint8  `json:"bPINSupport"`
+	MaxCCIDBusySlots      uint8  `json:"bMaxCCIDBusySlots"`
+}
+
+func decodeCCID(ctx DescriptorContext, desc []byte) (ClassDescriptor, error) {
+	r := newDescReader(desc)
+	d := &CCIDDescriptor{DescriptorHeader: r.header()}
+	d.CCIDVersion = r.u16()
+	d.MaxSlotIndex = r.u8()
+	d.VoltageSupport = r.u8()
+	d.Protocols = r.u32()
+	d.DefaultClock = r.u32()
+	d.MaximumClock = r.u32()
+	d.NumClockSupported = r.u8()
+	d.DataRate = r.u32()
+	d.MaxDataRate = r.u32()
+	d.NumDataRatesSupported = r.u8()
+	d.MaxIFSD = r.u32()
+	d.SynchProtocols = r.u32()
+	d.Mechanical = r.u32()
+	d.Features = r.u32()
+	d.MaxCCIDMessageLength = r.u32()
+	d.ClassGetResponse = r.u8()
+	d.ClassEnvelope = r.u8()
+	d.LCDLayout = r.u16()
+	d.PINSupport = r.u8()
+	d.MaxCCIDBusySlots = r.u8()
+	return d, r.err()
+}
+
+// PrinterDescriptor is the class specific descriptor of IPP over USB
+// printer interfaces. Capabilities holds the capability descriptors that
+// follow the fixed fields, undecoded.
+type PrinterDescriptor struct {
+	DescriptorHeader
+	ReleaseNumber  uint8  `json:"bcdReleaseNumber"`
+	NumDescriptors uint8  `json:"bcdNumDescriptors"`
+	Capabilities   []byte `json:"capabilities"`
+}
+
+func decodePrinter(ctx DescriptorContext, desc []byte) (ClassDescriptor, error) {
+	r := newDescReader(desc)
+	d := &PrinterDescriptor{DescriptorHeader: r.header()}
+	d.ReleaseNumber = r.u8()
+	d.NumDescriptors = r.u8()
+	d.Capabilities = r.bytes(r.left())
+	return d, r.err()
+}
+
+// HubDescriptor is the descriptor of a USB 2.0 hub, see section 11.23.2.1
+// of the USB 2.0 specification, or of a SuperSpeed hub, see section
+// 10.15.2.1 of the USB 3.2 specification. HeaderDecodeLatency and HubDelay
+// are only set for SuperSpeed hubs, and PortPowerControlMask only for
+// USB 2.0 hubs.
+type HubDescriptor struct {
+	DescriptorHeader
+	NumPorts             uint8  `json:"bNbrPorts"`
+	HubCharacteristics   uint16 `json:"wHubCharacteristics"`
+	PowerOnToPowerGood   uint8  `json:"bPwrOn2PwrGood"`
+	ControllerCurrent    uint8  `json:"bHubContrCurrent"`
+	HeaderDecodeLatency  uint8  `json:"bHubHdrDecLat,omitempty"`
+	HubDelay             uint16 `json:"wHubDelay,omitempty"`
+	DeviceRemovable      []byte `json:"DeviceRemovable"`
+	PortPowerControlMask []byte `json:"PortPwrCtrlMask,omitempty"`
+}
+
+func decodeHub(ctx DescriptorContext, desc []byte) (ClassDescriptor, error) {
+	r := newDescReader(desc)
+	d := &HubDescriptor{DescriptorHeader: r.header()}
+	d.NumPorts = r.u8()
+	d.HubCharacteristics = r.u16()
+	d.PowerOnToPowerGood = r.u8()
+	d.ControllerCurrent = r.u8()
+	if d.DescriptorType == uint8(LIBUSB_DT_SUPERSPEED_HUB) {
+		d.HeaderDecodeLatency = r.u8()
+		d.HubDelay = r.u16()
+		d.DeviceRemovable = r.bytes(2)
+	} else {
+		/* one bit per port, and bit 0 is reserved */
+		n := (int(d.NumPorts) + 8) / 8
+		d.DeviceRemovable = r.bytes(n)
+		d.PortPowerControlMask = r.bytes(n)
+	}
+	return d, r.err()
+}
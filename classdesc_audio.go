@@ -0,0 +1,361 @@
+package usb
+
+// Subclasses and protocols of the audio class, and the subtypes of its
+// class specific descriptors, see appendix A of the Audio Device Class 1.0
+// and 2.0 specifications.
+const (
+	audioSubClassControl   = 0x01
+	audioSubClassStreaming = 0x02
+
+	audioProtocol20 = 0x20
+
+	audioHeader         = 0x01
+	audioInputTerminal  = 0x02
+	audioOutputTerminal = 0x03
+	audioFeatureUnit    = 0x06
+	audioClockSource    = 0x0a
+
+	audioStreamingGeneral = 0x01
+	audioFormatType       = 0x02
+
+	audioEndpointGeneral = 0x01
+)
+
+func init() {
+	RegisterDescriptorDecoder(int(LIBUSB_CLASS_AUDIO), dtCSInterface, decodeAudioInterface)
+	RegisterDescriptorDecoder(int(LIBUSB_CLASS_AUDIO), dtCSEndpoint, decodeAudioEndpoint)
+}
+
+// AudioHeaderDescriptor starts the class specific descriptors of an audio
+// control interface, see section 4.3.2 of the Audio 1.0 specification.
+type AudioHeaderDescriptor struct {
+	DescriptorHeader
+	Subtype          uint8   `json:"bDescriptorSubtype"`
+	ADCVersion       uint16  `json:"bcdADC"`
+	TotalLength      uint16  `json:"wTotalLength"`
+	InCollection     uint8   `json:"bInCollection"`
+	InterfaceNumbers []uint8 `json:"baInterfaceNr"`
+}
+
+// Audio2HeaderDescriptor starts the class specific descriptors of an audio
+// control interface, see section 4.7.2 of the Audio 2.0 specification.
+type Audio2HeaderDescriptor struct {
+	DescriptorHeader
+	Subtype     uint8  `json:"bDescriptorSubtype"`
+	ADCVersion  uint16 `json:"bcdADC"`
+	Category    uint8  `json:"bCategory"`
+	TotalLength uint16 `json:"wTotalLength"`
+	Controls    uint8  `json:"bmControls"`
+}
+
+// AudioInputTerminalDescriptor describes where audio enters a function,
+// see section 4.3.2.1 of the Audio 1.0 specification.
+type AudioInputTerminalDescriptor struct {
+	DescriptorHeader
+	Subtype           uint8  `json:"bDescriptorSubtype"`
+	TerminalID        uint8  `json:"bTerminalID"`
+	TerminalType      uint16 `json:"wTerminalType"`
+	AssocTerminal     uint8  `json:"bAssocTerminal"`
+	NumChannels       uint8  `json:"bNrChannels"`
+	ChannelConfig     uint16 `json:"wChannelConfig"`
+	ChannelNamesIndex uint8  `json:"iChannelNames"`
+	TerminalIndex     uint8  `json:"iTerminal"`
+}
+
+// Audio2InputTerminalDescriptor describes where audio enters a function,
+// see section 4.7.2.4 of the Audio 2.0 specification.
+type Audio2InputTerminalDescriptor struct {
+	DescriptorHeader
+	Subtype           uint8  `json:"bDescriptorSubtype"`
+	TerminalID        uint8  `json:"bTerminalID"`
+	TerminalType      uint16 `json:"wTerminalType"`
+	AssocTerminal     uint8  `json:"bAssocTerminal"`
+	ClockSourceID     uint8  `json:"bCSourceID"`
+	NumChannels       uint8  `json:"bNrChannels"`
+	ChannelConfig     uint32 `json:"bmChannelConfig"`
+	ChannelNamesIndex uint8  `json:"iChannelNames"`
+	Controls          uint16 `json:"bmControls"`
+	TerminalIndex     uint8  `json:"iTerminal"`
+}
+
+// AudioOutputTerminalDescriptor describes where audio leaves a function,
+// see section 4.3.2.2 of the Audio 1.0 specification.
+type AudioOutputTerminalDescriptor struct {
+	DescriptorHeader
+	Subtype       uint8  `json:"bDescriptorSubtype"`
+	TerminalID    uint8  `json:"bTerminalID"`
+	TerminalType  uint16 `json:"wTerminalType"`
+	AssocTerminal uint8  `json:"bAssocTerminal"`
+	SourceID      uint8  `json:"bSourceID"`
+	TerminalIndex uint8  `json:"iTerminal"`
+}
+
+// Audio2OutputTerminalDescriptor describes where audio leaves a function,
+// see section 4.7.2.5 of the Audio 2.0 specification.
+type Audio2OutputTerminalDescriptor struct {
+	DescriptorHeader
+	Subtype       uint8  `json:"bDescriptorSubtype"`
+	TerminalID    uint8  `json:"bTerminalID"`
+	TerminalType  uint16 `json:"wTerminalType"`
+	AssocTerminal uint8  `json:"bAssocTerminal"`
+	SourceID      uint8  `json:"bSourceID"`
+	ClockSourceID uint8  `json:"bCSourceID"`
+	Controls      uint16 `json:"bmControls"`
+	TerminalIndex uint8  `json:"iTerminal"`
+}
+
+// AudioFeatureUnitDescriptor describes the controls, such as volume and
+// mute, of each channel of a stream, see section 4.3.2.5 of the Audio 1.0
+// specification and section 4.7.2.8 of the Audio 2.0 specification.
+// Controls holds the bitmap of the master channel followed by those of the
+// logical channels. ControlSize is 0 for Audio 2.0, whose bitmaps are 4
+// bytes long.
+type AudioFeatureUnitDescriptor struct {
+	DescriptorHeader
+	Subtype      uint8    `json:"bDescriptorSubtype"`
+	UnitID       uint8    `json:"bUnitID"`
+	SourceID     uint8    `json:"bSourceID"`
+	ControlSize  uint8    `json:"bControlSize,omitempty"`
+	Controls     []uint32 `json:"bmaControls"`
+	FeatureIndex uint8    `json:"iFeature"`
+}
+
+// Audio2ClockSourceDescriptor describes a clock of an Audio 2.0 function,
+// see section 4.7.2.1 of the Audio 2.0 specification.
+type Audio2ClockSourceDescriptor struct {
+	DescriptorHeader
+	Subtype          uint8 `json:"bDescriptorSubtype"`
+	ClockID          uint8 `json:"bClockID"`
+	Attributes       uint8 `json:"bmAttributes"`
+	Controls         uint8 `json:"bmControls"`
+	AssocTerminal    uint8 `json:"bAssocTerminal"`
+	ClockSourceIndex uint8 `json:"iClockSource"`
+}
+
+// AudioStreamingDescriptor describes an audio streaming interface, see
+// section 4.5.2 of the Audio 1.0 specification.
+type AudioStreamingDescriptor struct {
+	DescriptorHeader
+	Subtype      uint8  `json:"bDescriptorSubtype"`
+	TerminalLink uint8  `json:"bTerminalLink"`
+	Delay        uint8  `json:"bDelay"`
+	FormatTag    uint16 `json:"wFormatTag"`
+}
+
+// Audio2StreamingDescriptor describes an audio streaming interface, see
+// section 4.9.2 of the Audio 2.0 specification.
+type Audio2StreamingDescriptor struct {
+	DescriptorHeader
+	Subtype           uint8  `json:"bDescriptorSubtype"`
+	TerminalLink      uint8  `json:"bTerminalLink"`
+	Controls          uint8  `json:"bmControls"`
+	FormatType        uint8  `json:"bFormatType"`
+	Formats           uint32 `json:"bmFormats"`
+	NumChannels       uint8  `json:"bNrChannels"`
+	ChannelConfig     uint32 `json:"bmChannelConfig"`
+	ChannelNamesIndex uint8  `json:"iChannelNames"`
+}
+
+// AudioFormatDescriptor describes a type I or type III format, see section
+// 2.2.5 of the Audio 1.0 Data Formats specification. SampleRates holds the
+// lower and upper bounds of the sampling frequency when SampleRateType is
+// 0, and the supported frequencies otherwise.
+type AudioFormatDescriptor struct {
+	DescriptorHeader
+	Subtype        uint8    `json:"bDescriptorSubtype"`
+	FormatType     uint8    `json:"bFormatType"`
+	NumChannels    uint8    `json:"bNrChannels"`
+	SubframeSize   uint8    `json:"bSubframeSize"`
+	BitResolution  uint8    `json:"bBitResolution"`
+	SampleRateType uint8    `json:"bSamFreqType"`
+	SampleRates    []uint32 `json:"tSamFreq"`
+}
+
+// Audio2FormatDescriptor describes a type I or type III format, see
+// section 2.3.1.6 of the Audio 2.0 Data Formats specification.
+type Audio2FormatDescriptor struct {
+	DescriptorHeader
+	Subtype       uint8 `json:"bDescriptorSubtype"`
+	FormatType    uint8 `json:"bFormatType"`
+	SubslotSize   uint8 `json:"bSubslotSize"`
+	BitResolution uint8 `json:"bBitResolution"`
+}
+
+// AudioEndpointDescriptor describes an audio data endpoint, see section
+// 4.6.1.2 of the Audio 1.0 specification.
+type AudioEndpointDescriptor struct {
+	DescriptorHeader
+	Subtype        uint8  `json:"bDescriptorSubtype"`
+	Attributes     uint8  `json:"bmAttributes"`
+	LockDelayUnits uint8  `json:"bLockDelayUnits"`
+	LockDelay      uint16 `json:"wLockDelay"`
+}
+
+// Audio2EndpointDescriptor describes an audio data endpoint, see section
+// 4.10.1.2 of the Audio 2.0 specification.
+type Audio2EndpointDescriptor struct {
+	DescriptorHeader
+	Subtype        uint8  `json:"bDescriptorSubtype"`
+	Attributes     uint8  `json:"bmAttributes"`
+	Controls       uint8  `json:"bmControls"`
+	LockDelayUnits uint8  `json:"bLockDelayUnits"`
+	LockDelay      uint16 `json:"wLockDelay"`
+}
+
+func decodeAudioInterface(ctx DescriptorContext, desc []byte) (ClassDescriptor, error) {
+	r := newDescReader(desc)
+	h := r.header()
+	subtype := r.u8()
+	uac2 := ctx.InterfaceProtocol == audioProtocol20
+
+	switch {
+	case ctx.InterfaceSubClass == audioSubClassControl && subtype == audioHeader && uac2:
+		d := &Audio2HeaderDescriptor{DescriptorHeader: h, Subtype: subtype}
+		d.ADCVersion = r.u16()
+		d.Category = r.u8()
+		d.TotalLength = r.u16()
+		d.Controls = r.u8()
+		return d, r.err()
+	case ctx.InterfaceSubClass == audioSubClassControl && subtype == audioHeader:
+		d := &AudioHeaderDescriptor{DescriptorHeader: h, Subtype: subtype}
+		d.ADCVersion = r.u16()
+		d.TotalLength = r.u16()
+		d.InCollection = r.u8()
+		d.InterfaceNumbers = r.bytes(int(d.InCollection))
+		return d, r.err()
+	case ctx.InterfaceSubClass == audioSubClassControl && subtype == audioInputTerminal && uac2:
+		d := &Audio2InputTerminalDescriptor{DescriptorHeader: h, Subtype: subtype}
+		d.TerminalID = r.u8()
+		d.TerminalType = r.u16()
+		d.AssocTerminal = r.u8()
+		d.ClockSourceID = r.u8()
+		d.NumChannels = r.u8()
+		d.ChannelConfig = r.u32()
+		d.ChannelNamesIndex = r.u8()
+		d.Controls = r.u16()
+		d.TerminalIndex = r.u8()
+		return d, r.err()
+	case ctx.InterfaceSubClass == audioSubClassControl && subtype == audioInputTerminal:
+		d := &AudioInputTerminalDescriptor{DescriptorHeader: h, Subtype: subtype}
+		d.TerminalID = r.u8()
+		d.TerminalType = r.u16()
+		d.AssocTerminal = r.u8()
+		d.NumChannels = r.u8()
+		d.ChannelConfig = r.u16()
+		d.ChannelNamesIndex = r.u8()
+		d.TerminalIndex = r.u8()
+		return d, r.err()
+	case ctx.InterfaceSubClass == audioSubClassControl && subtype == audioOutputTerminal && uac2:
+		d := &Audio2OutputTerminalDescriptor{DescriptorHeader: h, Subtype: subtype}
+		d.TerminalID = r.u8()
+		d.TerminalType = r.u16()
+		d.AssocTerminal = r.u8()
+		d.SourceID = r.u8()
+		d.ClockSourceID = r.u8()
+		d.Controls = r.u16()
+		d.TerminalIndex = r.u8()
+		return d, r.err()
+	case ctx.InterfaceSubClass == audioSubClassControl && subtype == audioOutputTerminal:
+		d := &AudioOutputTerminalDescriptor{DescriptorHeader: h, Subtype: subtype}
+		d.TerminalID = r.u8()
+		d.TerminalType = r.u16()
+		d.AssocTerminal = r.u8()
+		d.SourceID = r.u8()
+		d.TerminalIndex = r.u8()
+		return d, r.err()
+	case ctx.InterfaceSubClass == audioSubClassControl && subtype == audioFeatureUnit:
+		d := &AudioFeatureUnitDescriptor{DescriptorHeader: h, Subtype: subtype}
+		d.UnitID = r.u8()
+		d.SourceID = r.u8()
+		size := 4
+		if !uac2 {
+			d.ControlSize = r.u8()
+			size = int(d.ControlSize)
+		}
+		if size == 0 {
+			return nil, r.err()
+		}
+		/* the bitmaps are followed by iFeature */
+		for r.left() > size {
+			d.Controls = append(d.Controls, r.uint(size))
+		}
+		d.FeatureIndex = r.u8()
+		return d, r.err()
+	case ctx.InterfaceSubClass == audioSubClassControl && subtype == audioClockSource && uac2:
+		d := &Audio2ClockSourceDescriptor{DescriptorHeader: h, Subtype: subtype}
+		d.ClockID = r.u8()
+		d.Attributes = r.u8()
+		d.Controls = r.u8()
+		d.AssocTerminal = r.u8()
+		d.ClockSourceIndex = r.u8()
+		return d, r.err()
+	case ctx.InterfaceSubClass == audioSubClassStreaming && subtype == audioStreamingGeneral && uac2:
+		d := &Audio2StreamingDescriptor{DescriptorHeader: h, Subtype: subtype}
+		d.TerminalLink = r.u8()
+		d.Controls = r.u8()
+		d.FormatType = r.u8()
+		d.Formats = r.u32()
+		d.NumChannels = r.u8()
+		d.ChannelConfig = r.u32()
+		d.ChannelNamesIndex = r.u8()
+		return d, r.err()
+	case ctx.InterfaceSubClass == audioSubClassStreaming && subtype == audioStreamingGeneral:
+		d := &AudioStreamingDescriptor{DescriptorHeader: h, Subtype: subtype}
+		d.TerminalLink = r.u8()
+		d.Delay = r.u8()
+		d.FormatTag = r.u16()
+		return d, r.err()
+	case ctx.InterfaceSubClass == audioSubClassStreaming && subtype == audioFormatType:
+		return decodeAudioFormat(r, h, subtype, uac2)
+	}
+	return nil, r.err()
+}
+
+func decodeAudioFormat(r *descReader, h DescriptorHeader, subtype uint8, uac2 bool) (ClassDescriptor, error) {
+	formatType := r.u8()
+	/* type II formats have a different layout */
+	if formatType != 0x01 && formatType != 0x03 {
+		return nil, r.err()
+	}
+	if uac2 {
+		d := &Audio2FormatDescriptor{DescriptorHeader: h, Subtype: subtype, FormatType: formatType}
+		d.SubslotSize = r.u8()
+		d.BitResolution = r.u8()
+		return d, r.err()
+	}
+	d := &AudioFormatDescriptor{DescriptorHeader: h, Subtype: subtype, FormatType: formatType}
+	d.NumChannels = r.u8()
+	d.SubframeSize = r.u8()
+	d.BitResolution = r.u8()
+	d.SampleRateType = r.u8()
+	n := int(d.SampleRateType)
+	if n == 0 {
+		n = 2
+	}
+	for i := 0; i < n; i++ {
+		d.SampleRates = append(d.SampleRates, r.u24())
+	}
+	return d, r.err()
+}
+
+func decodeAudioEndpoint(ctx DescriptorContext, desc []byte) (ClassDescriptor, error) {
+	r := newDescReader(desc)
+	h := r.header()
+	subtype := r.u8()
+	if !ctx.Endpoint || subtype != audioEndpointGeneral {
+		return nil, r.err()
+	}
+	if ctx.InterfaceProtocol == audioProtocol20 {
+		d := &Audio2EndpointDescriptor{DescriptorHeader: h, Subtype: subtype}
+		d.Attributes = r.u8()
+		d.Controls = r.u8()
+		d.LockDelayUnits = r.u8()
+		d.LockDelay = r.u16()
+		return d, r.err()
+	}
+	d := &AudioEndpointDescriptor{DescriptorHeader: h, Subtype: subtype}
+	d.Attributes = r.u8()
+	d.LockDelayUnits = r.u8()
+	d.LockDelay = r.u16()
+	return d, r.err()
+}
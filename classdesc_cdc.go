@@ -0,0 +1,135 @@
+package usb
+
+// Subtypes of the CDC functional descriptors, see table 13 of the CDC 1.2
+// specification.
+const (
+	cdcHeader         = 0x00
+	cdcCallManagement = 0x01
+	cdcACM            = 0x02
+	cdcUnion          = 0x06
+	cdcEthernet       = 0x0f
+	cdcNCM            = 0x1a
+	cdcMBIM           = 0x1b
+)
+
+func init() {
+	RegisterDescriptorDecoder(int(LIBUSB_CLASS_COMM), dtCSInterface, decodeCDC)
+}
+
+// CDCHeaderDescriptor starts the functional descriptors of a
+// communications interface, see section 5.2.3.1 of the CDC 1.2
+// specification.
+type CDCHeaderDescriptor struct {
+	DescriptorHeader
+	Subtype    uint8  `json:"bDescriptorSubtype"`
+	CDCVersion uint16 `json:"bcdCDC"`
+}
+
+// CDCCallManagementDescriptor describes how calls are managed by the
+// device, see section 5.3.1 of the PSTN 1.2 specification.
+type CDCCallManagementDescriptor struct {
+	DescriptorHeader
+	Subtype       uint8 `json:"bDescriptorSubtype"`
+	Capabilities  uint8 `json:"bmCapabilities"`
+	DataInterface uint8 `json:"bDataInterface"`
+}
+
+// CDCACMDescriptor lists the requests an abstract control model interface
+// supports, see section 5.3.2 of the PSTN 1.2 specification.
+type CDCACMDescriptor struct {
+	DescriptorHeader
+	Subtype      uint8 `json:"bDescriptorSubtype"`
+	Capabilities uint8 `json:"bmCapabilities"`
+}
+
+// CDCUnionDescriptor groups the interfaces of a communications function,
+// see section 5.2.3.2 of the CDC 1.2 specification.
+type CDCUnionDescriptor struct {
+	DescriptorHeader
+	Subtype               uint8   `json:"bDescriptorSubtype"`
+	ControlInterface      uint8   `json:"bControlInterface"`
+	SubordinateInterfaces []uint8 `json:"bSubordinateInterface"`
+}
+
+// CDCEthernetDescriptor describes an Ethernet networking interface, see
+// section 5.4 of the ECM 1.2 specification.
+type CDCEthernetDescriptor struct {
+	DescriptorHeader
+	Subtype            uint8  `json:"bDescriptorSubtype"`
+	MACAddressIndex    uint8  `json:"iMACAddress"`
+	EthernetStatistics uint32 `json:"bmEthernetStatistics"`
+	MaxSegmentSize     uint16 `json:"wMaxSegmentSize"`
+	NumMCFilters       uint16 `json:"wNumberMCFilters"`
+	NumPowerFilters    uint8  `json:"bNumberPowerFilters"`
+}
+
+// CDCNCMDescriptor describes a network control model interface, see
+// section 5.2.1 of the NCM 1.0 specification.
+type CDCNCMDescriptor struct {
+	DescriptorHeader
+	Subtype             uint8  `json:"bDescriptorSubtype"`
+	NCMVersion          uint16 `json:"bcdNcmVersion"`
+	NetworkCapabilities uint8  `json:"bmNetworkCapabilities"`
+}
+
+// CDCMBIMDescriptor describes a mobile broadband interface model
+// interface, see section 6.4 of the MBIM 1.0 specification.
+type CDCMBIMDescriptor struct {
+	DescriptorHeader
+	Subtype             uint8  `json:"bDescriptorSubtype"`
+	MBIMVersion         uint16 `json:"bcdMBIMVersion"`
+	MaxControlMessage   uint16 `json:"wMaxControlMessage"`
+	NumFilters          uint8  `json:"bNumberFilters"`
+	MaxFilterSize       uint8  `json:"bMaxFilterSize"`
+	MaxSegmentSize      uint16 `json:"wMaxSegmentSize"`
+	NetworkCapabilities uint8  `json:"bmNetworkCapabilities"`
+}
+
+func decodeCDC(ctx DescriptorContext, desc []byte) (ClassDescriptor, error) {
+	r := newDescReader(desc)
+	h := r.header()
+	subtype := r.u8()
+	switch subtype {
+	case cdcHeader:
+		d := &CDCHeaderDescriptor{DescriptorHeader: h, Subtype: subtype}
+		d.CDCVersion = r.u16()
+		return d, r.err()
+	case cdcCallManagement:
+		d := &CDCCallManagementDescriptor{DescriptorHeader: h, Subtype: subtype}
+		d.Capabilities = r.u8()
+		d.DataInterface = r.u8()
+		return d, r.err()
+	case cdcACM:
+		d := &CDCACMDescriptor{DescriptorHeader: h, Subtype: subtype}
+		d.Capabilities = r.u8()
+		return d, r.err()
+	case cdcUnion:
+		d := &CDCUnionDescriptor{DescriptorHeader: h, Subtype: subtype}
+		d.ControlInterface = r.u8()
+		d.SubordinateInterfaces = r.bytes(r.left())
+		return d, r.err()
+	case cdcEthernet:
+		d := &CDCEthernetDescriptor{DescriptorHeader: h, Subtype: subtype}
+		d.MACAddressIndex = r.u8()
+		d.EthernetStatistics = r.u32()
+		d.MaxSegmentSize = r.u16()
+		d.NumMCFilters = r.u16()
+		d.NumPowerFilters = r.u8()
+		return d, r.err()
+	case cdcNCM:
+		d := &CDCNCMDescriptor{DescriptorHeader: h, Subtype: subtype}
+		d.NCMVersion = r.u16()
+		d.NetworkCapabilities = r.u8()
+		return d, r.err()
+	case cdcMBIM:
+		d := &CDCMBIMDescriptor{DescriptorHeader: h, Subtype: subtype}
+		d.MBIMVersion = r.u16()
+		d.MaxControlMessage = r.u16()
+		d.NumFilters = r.u8()
+		d.MaxFilterSize = r.u8()
+		d.MaxSegmentSize = r.u16()
+		d.NetworkCapabilities = r.u8()
+		return d, r.err()
+	}
+	return nil, r.err()
+}
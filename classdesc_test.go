@@ -0,0 +1,118 @@
+package usb
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestDecodeDescriptor(t *testing.T) {
+	var (
+		hid       = DescriptorContext{InterfaceClass: 0x03}
+		cdc       = DescriptorContext{InterfaceClass: 0x02, InterfaceSubClass: 0x02, InterfaceProtocol: 0x01}
+		uac1      = DescriptorContext{InterfaceClass: 0x01, InterfaceSubClass: 0x01}
+		uac2      = DescriptorContext{InterfaceClass: 0x01, InterfaceSubClass: 0x01, InterfaceProtocol: 0x20}
+		streaming = DescriptorContext{InterfaceClass: 0x01, InterfaceSubClass: 0x02}
+		isoEP     = DescriptorContext{InterfaceClass: 0x01, InterfaceSubClass: 0x02, Endpoint: true}
+	)
+	tests := []struct {
+		name string
+		ctx  DescriptorContext
+		desc []byte
+		want ClassDescriptor
+	}{
+		{"HID", hid,
+			[]byte{0x09, 0x21, 0x11, 0x01, 0x00, 0x01, 0x22, 0x3f, 0x00},
+			&HIDDescriptor{DescriptorHeader{9, 0x21}, 0x0111, 0, 1,
+				[]HIDClassDescriptor{{0x22, 63}}}},
+		{"CDC header", cdc,
+			[]byte{0x05, 0x24, 0x00, 0x10, 0x01},
+			&CDCHeaderDescriptor{DescriptorHeader{5, 0x24}, 0x00, 0x0110}},
+		{"CDC call management", cdc,
+			[]byte{0x05, 0x24, 0x01, 0x03, 0x01},
+			&CDCCallManagementDescriptor{DescriptorHeader{5, 0x24}, 0x01, 0x03, 1}},
+		{"CDC ACM", cdc,
+			[]byte{0x04, 0x24, 0x02, 0x02},
+			&CDCACMDescriptor{DescriptorHeader{4, 0x24}, 0x02, 0x02}},
+		{"CDC union", cdc,
+			[]byte{0x06, 0x24, 0x06, 0x00, 0x01, 0x02},
+			&CDCUnionDescriptor{DescriptorHeader{6, 0x24}, 0x06, 0, []uint8{1, 2}}},
+		{"CDC Ethernet", cdc,
+			[]byte{0x0d, 0x24, 0x0f, 0x04, 0x00, 0x00, 0x00, 0x00, 0xea, 0x05, 0x00, 0x00, 0x00},
+			&CDCEthernetDescriptor{DescriptorHeader{13, 0x24}, 0x0f, 4, 0, 1514, 0, 0}},
+		{"CDC unknown subtype", cdc,
+			[]byte{0x05, 0x24, 0x2a, 0x01, 0x02},
+			&RawDescriptor{DescriptorHeader{5, 0x24}, []byte{0x2a, 0x01, 0x02}}},
+		{"audio header", uac1,
+			[]byte{0x0a, 0x24, 0x01, 0x00, 0x01, 0x28, 0x00, 0x02, 0x01, 0x02},
+			&AudioHeaderDescriptor{DescriptorHeader{10, 0x24}, 0x01, 0x0100, 0x28, 2, []uint8{1, 2}}},
+		{"audio input terminal", uac1,
+			[]byte{0x0c, 0x24, 0x02, 0x01, 0x01, 0x02, 0x00, 0x02, 0x03, 0x00, 0x00, 0x00},
+			&AudioInputTerminalDescriptor{DescriptorHeader{12, 0x24}, 0x02, 1, 0x0201, 0, 2, 0x0003, 0, 0}},
+		{"audio feature unit", uac1,
+			[]byte{0x0a, 0x24, 0x06, 0x02, 0x01, 0x01, 0x01, 0x02, 0x02, 0x05},
+			&AudioFeatureUnitDescriptor{DescriptorHeader{10, 0x24}, 0x06, 2, 1, 1, []uint32{1, 2, 2}, 5}},
+		{"audio 2.0 header", uac2,
+			[]byte{0x09, 0x24, 0x01, 0x00, 0x02, 0x08, 0x3c, 0x00, 0x00},
+			&Audio2HeaderDescriptor{DescriptorHeader{9, 0x24}, 0x01, 0x0200, 0x08, 0x3c, 0}},
+		{"audio 2.0 feature unit", uac2,
+			[]byte{0x0e, 0x24, 0x06, 0x02, 0x01, 0x03, 0x00, 0x00, 0x00, 0x0c, 0x00, 0x00, 0x00, 0x00},
+			&AudioFeatureUnitDescriptor{DescriptorHeader{14, 0x24}, 0x06, 2, 1, 0, []uint32{0x03, 0x0c}, 0}},
+		{"audio streaming", streaming,
+			[]byte{0x07, 0x24, 0x01, 0x01, 0x01, 0x01, 0x00},
+			&AudioStreamingDescriptor{DescriptorHeader{7, 0x24}, 0x01, 1, 1, 0x0001}},
+		{"audio format", streaming,
+			[]byte{0x0b, 0x24, 0x02, 0x01, 0x02, 0x02, 0x10, 0x01, 0x44, 0xac, 0x00},
+			&AudioFormatDescriptor{DescriptorHeader{11, 0x24}, 0x02, 0x01, 2, 2, 16, 1, []uint32{44100}}},
+		{"audio endpoint", isoEP,
+			[]byte{0x07, 0x25, 0x01, 0x01, 0x02, 0x01, 0x00},
+			&AudioEndpointDescriptor{DescriptorHeader{7, 0x25}, 0x01, 0x01, 2, 1}},
+		{"class specific endpoint in an interface", streaming,
+			[]byte{0x07, 0x25, 0x01, 0x01, 0x02, 0x01, 0x00},
+			&RawDescriptor{DescriptorHeader{7, 0x25}, []byte{0x01, 0x01, 0x02, 0x01, 0x00}}},
+	}
+	for _, tt := range tests {
+		got, err := DecodeDescriptor(tt.ctx, tt.desc)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: decoded %#v\nwant %#v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestDecodeDescriptors(t *testing.T) {
+	/* the functional descriptors of a CDC ACM interface */
+	extra := []byte{
+		0x05, 0x24, 0x00, 0x10, 0x01,
+		0x05, 0x24, 0x01, 0x00, 0x01,
+		0x04, 0x24, 0x02, 0x02,
+		0x05, 0x24, 0x06, 0x00, 0x01,
+	}
+	alt := &InterfaceDescriptor{InterfaceClass: 0x02, InterfaceSubClass: 0x02, Extra: extra}
+	descs, err := alt.ClassDescriptors()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []ClassDescriptor{
+		&CDCHeaderDescriptor{DescriptorHeader{5, 0x24}, 0x00, 0x0110},
+		&CDCCallManagementDescriptor{DescriptorHeader{5, 0x24}, 0x01, 0x00, 1},
+		&CDCACMDescriptor{DescriptorHeader{4, 0x24}, 0x02, 0x02},
+		&CDCUnionDescriptor{DescriptorHeader{5, 0x24}, 0x06, 0, []uint8{1}},
+	}
+	if !reflect.DeepEqual(descs, want) {
+		t.Errorf("decoded %#v\nwant %#v", descs, want)
+	}
+
+	/* a HID descriptor that lists more class descriptors than it holds */
+	var derr *DescriptorError
+	hid := DescriptorContext{InterfaceClass: 0x03}
+	if _, err := DecodeDescriptors(hid, []byte{0x09, 0x21, 0x11, 0x01, 0x00, 0x02, 0x22, 0x3f, 0x00}); !errors.As(err, &derr) {
+		t.Errorf("short HID descriptor returned %v, want a *DescriptorError", err)
+	}
+	if _, err := DecodeDescriptors(hid, extra[:7]); !errors.As(err, &derr) || derr.Offset != 5 {
+		t.Errorf("truncated descriptor returned %v, want a *DescriptorError at offset 5", err)
+	}
+}
@@ -0,0 +1,331 @@
+package usb
+
+// Subclasses of the video class and the subtypes of its class specific
+// descriptors, see appendix A of the UVC 1.5 specification.
+const (
+	videoSubClassControl   = 0x01
+	videoSubClassStreaming = 0x02
+
+	videoHeader         = 0x01
+	videoInputTerminal  = 0x02
+	videoOutputTerminal = 0x03
+	videoProcessingUnit = 0x05
+	videoExtensionUnit  = 0x06
+
+	videoInputHeader        = 0x01
+	videoFormatUncompressed = 0x04
+	videoFrameUncompressed  = 0x05
+	videoFormatMJPEG        = 0x06
+	videoFrameMJPEG         = 0x07
+	videoColorFormat        = 0x0d
+	videoEndpointInterrupt  = 0x03
+	videoCameraTerminalType = 0x0201
+)
+
+func init() {
+	RegisterDescriptorDecoder(int(LIBUSB_CLASS_VIDEO), dtCSInterface, decodeVideoInterface)
+	RegisterDescriptorDecoder(int(LIBUSB_CLASS_VIDEO), dtCSEndpoint, decodeVideoEndpoint)
+}
+
+// VideoHeaderDescriptor starts the class specific descriptors of a video
+// control interface, see section 3.7.2 of the UVC 1.5 specification.
+type VideoHeaderDescriptor struct {
+	DescriptorHeader
+	Subtype          uint8   `json:"bDescriptorSubtype"`
+	UVCVersion       uint16  `json:"bcdUVC"`
+	TotalLength      uint16  `json:"wTotalLength"`
+	ClockFrequency   uint32  `json:"dwClockFrequency"`
+	InCollection     uint8   `json:"bInCollection"`
+	InterfaceNumbers []uint8 `json:"baInterfaceNr"`
+}
+
+// VideoInputTerminalDescriptor describes where video enters a function,
+// see section 3.7.2.1 of the UVC 1.5 specification. The fields that follow
+// TerminalIndex are only set for camera terminals, see section 3.7.2.3.
+type VideoInputTerminalDescriptor struct {
+	DescriptorHeader
+	Subtype                 uint8  `json:"bDescriptorSubtype"`
+	TerminalID              uint8  `json:"bTerminalID"`
+	TerminalType            uint16 `json:"wTerminalType"`
+	AssocTerminal           uint8  `json:"bAssocTerminal"`
+	TerminalIndex           uint8  `json:"iTerminal"`
+	ObjectiveFocalLengthMin uint16 `json:"wObjectiveFocalLengthMin,omitempty"`
+	ObjectiveFocalLengthMax uint16 `json:"wObjectiveFocalLengthMax,omitempty"`
+	OcularFocalLength       uint16 `json:"wOcularFocalLength,omitempty"`
+	ControlSize             uint8  `json:"bControlSize,omitempty"`
+	Controls                []byte `json:"bmControls,omitempty"`
+}
+
+// VideoOutputTerminalDescriptor describes where video leaves a function,
+// see section 3.7.2.2 of the UVC 1.5 specification.
+type VideoOutputTerminalDescriptor struct {
+	DescriptorHeader
+	Subtype       uint8  `json:"bDescriptorSubtype"`
+	TerminalID    uint8  `json:"bTerminalID"`
+	TerminalType  uint16 `json:"wTerminalType"`
+	AssocTerminal uint8  `json:"bAssocTerminal"`
+	SourceID      uint8  `json:"bSourceID"`
+	TerminalIndex uint8  `json:"iTerminal"`
+}
+
+// VideoProcessingUnitDescriptor describes the image controls, such as
+// brightness and contrast, of a function, see section 3.7.2.5 of the UVC
+// 1.5 specification. VideoStandards is 0 in UVC 1.0 descriptors.
+type VideoProcessingUnitDescriptor struct {
+	DescriptorHeader
+	Subtype         uint8  `json:"bDescriptorSubtype"`
+	UnitID          uint8  `json:"bUnitID"`
+	SourceID        uint8  `json:"bSourceID"`
+	MaxMultiplier   uint16 `json:"wMaxMultiplier"`
+	ControlSize     uint8  `json:"bControlSize"`
+	Controls        []byte `json:"bmControls"`
+	ProcessingIndex uint8  `json:"iProcessing"`
+	VideoStandards  uint8  `json:"bmVideoStandards"`
+}
+
+// VideoExtensionUnitDescriptor describes vendor specific controls of a
+// function, see section 3.7.2.7 of the UVC 1.5 specification.
+type VideoExtensionUnitDescriptor struct {
+	DescriptorHeader
+	Subtype        uint8    `json:"bDescriptorSubtype"`
+	UnitID         uint8    `json:"bUnitID"`
+	ExtensionCode  [16]byte `json:"guidExtensionCode"`
+	NumControls    uint8    `json:"bNumControls"`
+	NumInPins      uint8    `json:"bNrInPins"`
+	SourceIDs      []uint8  `json:"baSourceID"`
+	ControlSize    uint8    `json:"bControlSize"`
+	Controls       []byte   `json:"bmControls"`
+	ExtensionIndex uint8    `json:"iExtension"`
+}
+
+// VideoInputHeaderDescriptor starts the class specific descriptors of a
+// video streaming interface, see section 3.9.2.1 of the UVC 1.5
+// specification. Controls holds the bmaControls bitmap of each format.
+type VideoInputHeaderDescriptor struct {
+	DescriptorHeader
+	Subtype            uint8    `json:"bDescriptorSubtype"`
+	NumFormats         uint8    `json:"bNumFormats"`
+	TotalLength        uint16   `json:"wTotalLength"`
+	EndpointAddress    uint8    `json:"bEndpointAddress"`
+	Info               uint8    `json:"bmInfo"`
+	TerminalLink       uint8    `json:"bTerminalLink"`
+	StillCaptureMethod uint8    `json:"bStillCaptureMethod"`
+	TriggerSupport     uint8    `json:"bTriggerSupport"`
+	TriggerUsage       uint8    `json:"bTriggerUsage"`
+	ControlSize        uint8    `json:"bControlSize"`
+	Controls           [][]byte `json:"bmaControls"`
+}
+
+// VideoUncompressedFormatDescriptor describes an uncompressed video format,
+// see section 3.1.1 of the UVC 1.5 Uncompressed Payload specification.
+type VideoUncompressedFormatDescriptor struct {
+	DescriptorHeader
+	Subtype             uint8    `json:"bDescriptorSubtype"`
+	FormatIndex         uint8    `json:"bFormatIndex"`
+	NumFrameDescriptors uint8    `json:"bNumFrameDescriptors"`
+	Format              [16]byte `json:"guidFormat"`
+	BitsPerPixel        uint8    `json:"bBitsPerPixel"`
+	DefaultFrameIndex   uint8    `json:"bDefaultFrameIndex"`
+	AspectRatioX        uint8    `json:"bAspectRatioX"`
+	AspectRatioY        uint8    `json:"bAspectRatioY"`
+	InterlaceFlags      uint8    `json:"bmInterlaceFlags"`
+	CopyProtect         uint8    `json:"bCopyProtect"`
+}
+
+// VideoMJPEGFormatDescriptor describes the motion JPEG format, see section
+// 3.1.1 of the UVC 1.5 MJPEG Payload specification.
+type VideoMJPEGFormatDescriptor struct {
+	DescriptorHeader
+	Subtype             uint8 `json:"bDescriptorSubtype"`
+	FormatIndex         uint8 `json:"bFormatIndex"`
+	NumFrameDescriptors uint8 `json:"bNumFrameDescriptors"`
+	Flags               uint8 `json:"bmFlags"`
+	DefaultFrameIndex   uint8 `json:"bDefaultFrameIndex"`
+	AspectRatioX        uint8 `json:"bAspectRatioX"`
+	AspectRatioY        uint8 `json:"bAspectRatioY"`
+	InterlaceFlags      uint8 `json:"bmInterlaceFlags"`
+	CopyProtect         uint8 `json:"bCopyProtect"`
+}
+
+// VideoFrameDescriptor describes a frame size of an uncompressed or MJPEG
+// format. FrameIntervals holds the minimum, maximum and step of a
+// continuous range of intervals when FrameIntervalType is 0, and the
+// supported intervals otherwise, in units of 100ns.
+type VideoFrameDescriptor struct {
+	DescriptorHeader
+	Subtype                 uint8    `json:"bDescriptorSubtype"`
+	FrameIndex              uint8    `json:"bFrameIndex"`
+	Capabilities            uint8    `json:"bmCapabilities"`
+	Width                   uint16   `json:"wWidth"`
+	Height                  uint16   `json:"wHeight"`
+	MinBitRate              uint32   `json:"dwMinBitRate"`
+	MaxBitRate              uint32   `json:"dwMaxBitRate"`
+	MaxVideoFrameBufferSize uint32   `json:"dwMaxVideoFrameBufferSize"`
+	DefaultFrameInterval    uint32   `json:"dwDefaultFrameInterval"`
+	FrameIntervalType       uint8    `json:"bFrameIntervalType"`
+	FrameIntervals          []uint32 `json:"dwFrameInterval"`
+}
+
+// VideoColorFormatDescriptor describes the color space of a format, see
+// section 3.9.2.6 of the UVC 1.5 specification.
+type VideoColorFormatDescriptor struct {
+	DescriptorHeader
+	Subtype                 uint8 `json:"bDescriptorSubtype"`
+	ColorPrimaries          uint8 `json:"bColorPrimaries"`
+	TransferCharacteristics uint8 `json:"bTransferCharacteristics"`
+	MatrixCoefficients      uint8 `json:"bMatrixCoefficients"`
+}
+
+// VideoEndpointDescriptor describes the interrupt endpoint of a video
+// control interface, see section 3.8.2.2 of the UVC 1.5 specification.
+type VideoEndpointDescriptor struct {
+	DescriptorHeader
+	Subtype         uint8  `json:"bDescriptorSubtype"`
+	MaxTransferSize uint16 `json:"wMaxTransferSize"`
+}
+
+func decodeVideoInterface(ctx DescriptorContext, desc []byte) (ClassDescriptor, error) {
+	r := newDescReader(desc)
+	h := r.header()
+	subtype := r.u8()
+
+	if ctx.InterfaceSubClass == videoSubClassControl {
+		switch subtype {
+		case videoHeader:
+			d := &VideoHeaderDescriptor{DescriptorHeader: h, Subtype: subtype}
+			d.UVCVersion = r.u16()
+			d.TotalLength = r.u16()
+			d.ClockFrequency = r.u32()
+			d.InCollection = r.u8()
+			d.InterfaceNumbers = r.bytes(int(d.InCollection))
+			return d, r.err()
+		case videoInputTerminal:
+			d := &VideoInputTerminalDescriptor{DescriptorHeader: h, Subtype: subtype}
+			d.TerminalID = r.u8()
+			d.TerminalType = r.u16()
+			d.AssocTerminal = r.u8()
+			d.TerminalIndex = r.u8()
+			if d.TerminalType == videoCameraTerminalType {
+				d.ObjectiveFocalLengthMin = r.u16()
+				d.ObjectiveFocalLengthMax = r.u16()
+				d.OcularFocalLength = r.u16()
+				d.ControlSize = r.u8()
+				d.Controls = r.bytes(int(d.ControlSize))
+			}
+			return d, r.err()
+		case videoOutputTerminal:
+			d := &VideoOutputTerminalDescriptor{DescriptorHeader: h, Subtype: subtype}
+			d.TerminalID = r.u8()
+			d.TerminalType = r.u16()
+			d.AssocTerminal = r.u8()
+			d.SourceID = r.u8()
+			d.TerminalIndex = r.u8()
+			return d, r.err()
+		case videoProcessingUnit:
+			d := &VideoProcessingUnitDescriptor{DescriptorHeader: h, Subtype: subtype}
+			d.UnitID = r.u8()
+			d.SourceID = r.u8()
+			d.MaxMultiplier = r.u16()
+			d.ControlSize = r.u8()
+			d.Controls = r.bytes(int(d.ControlSize))
+			d.ProcessingIndex = r.u8()
+			if r.left() > 0 {
+				d.VideoStandards = r.u8()
+			}
+			return d, r.err()
+		case videoExtensionUnit:
+			d := &VideoExtensionUnitDescriptor{DescriptorHeader: h, Subtype: subtype}
+			d.UnitID = r.u8()
+			d.ExtensionCode = r.guid()
+			d.NumControls = r.u8()
+			d.NumInPins = r.u8()
+			d.SourceIDs = r.bytes(int(d.NumInPins))
+			d.ControlSize = r.u8()
+			d.Controls = r.bytes(int(d.ControlSize))
+			d.ExtensionIndex = r.u8()
+			return d, r.err()
+		}
+		return nil, r.err()
+	}
+
+	if ctx.InterfaceSubClass == videoSubClassStreaming {
+		switch subtype {
+		case videoInputHeader:
+			d := &VideoInputHeaderDescriptor{DescriptorHeader: h, Subtype: subtype}
+			d.NumFormats = r.u8()
+			d.TotalLength = r.u16()
+			d.EndpointAddress = r.u8()
+			d.Info = r.u8()
+			d.TerminalLink = r.u8()
+			d.StillCaptureMethod = r.u8()
+			d.TriggerSupport = r.u8()
+			d.TriggerUsage = r.u8()
+			d.ControlSize = r.u8()
+			for i := 0; i < int(d.NumFormats); i++ {
+				d.Controls = append(d.Controls, r.bytes(int(d.ControlSize)))
+			}
+			return d, r.err()
+		case videoFormatUncompressed:
+			d := &VideoUncompressedFormatDescriptor{DescriptorHeader: h, Subtype: subtype}
+			d.FormatIndex = r.u8()
+			d.NumFrameDescriptors = r.u8()
+			d.Format = r.guid()
+			d.BitsPerPixel = r.u8()
+			d.DefaultFrameIndex = r.u8()
+			d.AspectRatioX = r.u8()
+			d.AspectRatioY = r.u8()
+			d.InterlaceFlags = r.u8()
+			d.CopyProtect = r.u8()
+			return d, r.err()
+		case videoFormatMJPEG:
+			d := &VideoMJPEGFormatDescriptor{DescriptorHeader: h, Subtype: subtype}
+			d.FormatIndex = r.u8()
+			d.NumFrameDescriptors = r.u8()
+			d.Flags = r.u8()
+			d.DefaultFrameIndex = r.u8()
+			d.AspectRatioX = r.u8()
+			d.AspectRatioY = r.u8()
+			d.InterlaceFlags = r.u8()
+			d.CopyProtect = r.u8()
+			return d, r.err()
+		case videoFrameUncompressed, videoFrameMJPEG:
+			d := &VideoFrameDescriptor{DescriptorHeader: h, Subtype: subtype}
+			d.FrameIndex = r.u8()
+			d.Capabilities = r.u8()
+			d.Width = r.u16()
+			d.Height = r.u16()
+			d.MinBitRate = r.u32()
+			d.MaxBitRate = r.u32()
+			d.MaxVideoFrameBufferSize = r.u32()
+			d.DefaultFrameInterval = r.u32()
+			d.FrameIntervalType = r.u8()
+			n := int(d.FrameIntervalType)
+			if n == 0 {
+				n = 3
+			}
+			for i := 0; i < n; i++ {
+				d.FrameIntervals = append(d.FrameIntervals, r.u32())
+			}
+			return d, r.err()
+		case videoColorFormat:
+			d := &VideoColorFormatDescriptor{DescriptorHeader: h, Subtype: subtype}
+			d.ColorPrimaries = r.u8()
+			d.TransferCharacteristics = r.u8()
+			d.MatrixCoefficients = r.u8()
+			return d, r.err()
+		}
+	}
+	return nil, r.err()
+}
+
+func decodeVideoEndpoint(ctx DescriptorContext, desc []byte) (ClassDescriptor, error) {
+	r := newDescReader(desc)
+	h := r.header()
+	subtype := r.u8()
+	if !ctx.Endpoint || subtype != videoEndpointInterrupt {
+		return nil, r.err()
+	}
+	d := &VideoEndpointDescriptor{DescriptorHeader: h, Subtype: subtype}
+	d.MaxTransferSize = r.u16()
+	return d, r.err()
+}
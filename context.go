@@ -0,0 +1,108 @@
+package usb
+
+import "errors"
+
+// A Context is an independent libusb session. Devices and handles obtained
+// through a Context must be closed before the Context itself is closed.
+type Context struct {
+	ctx *libusb_context
+}
+
+// NewContext initializes a new libusb session.
+func NewContext() (*Context, error) {
+	var ctx *libusb_context
+	if r := libusb_init(&ctx); r < 0 {
+		return nil, codeError("init", r)
+	}
+	return &Context{ctx: ctx}, nil
+}
+
+// Close deinitializes the session. It is an error to use the Context, or
+// anything obtained from it, after Close returns.
+func (c *Context) Close() error {
+	if c.ctx == nil {
+		return nil
+	}
+	libusb_exit(c.ctx)
+	c.ctx = nil
+	return nil
+}
+
+// SetDebug sets the log message verbosity, see libusb_set_debug. It has no
+// effect if the LIBUSB_DEBUG environment variable was set at init time.
+func (c *Context) SetDebug(level int) {
+	libusb_set_debug(c.ctx, level)
+}
+
+// Devices returns every USB device currently attached to the system. Each
+// returned Device holds a reference that must be released with Close.
+func (c *Context) Devices() ([]*Device, error) {
+	var list []*libusb_device
+	if r := libusb_get_device_list(c.ctx, &list); r < 0 {
+		return nil, codeError("get device list", r)
+	}
+	defer libusb_free_device_list(list, 1)
+
+	devs := make([]*Device, 0, len(list))
+	for _, dev := range list {
+		if dev == nil {
+			break
+		}
+		devs = append(devs, newDevice(c, dev))
+	}
+	return devs, nil
+}
+
+// OpenDevices opens every device for which match returns true. Devices that
+// fail to open are skipped, and their errors are returned joined together
+// alongside any handles that were opened successfully.
+func (c *Context) OpenDevices(match func(*Device) bool) ([]*DeviceHandle, error) {
+	devs, err := c.Devices()
+	if err != nil {
+		return nil, err
+	}
+
+	var handles []*DeviceHandle
+	var errs []error
+	for _, dev := range devs {
+		if match(dev) {
+			h, err := dev.Open()
+			if err != nil {
+				errs = append(errs, err)
+			} else {
+				handles = append(handles, h)
+			}
+		}
+		dev.Close()
+	}
+	return handles, errors.Join(errs...)
+}
+
+// OpenDeviceWithVIDPID opens the first device matching the given vendor and
+// product IDs. It returns an error wrapping ErrNotFound if there is no such
+// device.
+func (c *Context) OpenDeviceWithVIDPID(vid, pid uint16) (*DeviceHandle, error) {
+	devs, err := c.Devices()
+	if err != nil {
+		return nil, err
+	}
+
+	var handle *DeviceHandle
+	err = codeError("open", LIBUSB_ERROR_NOT_FOUND)
+	for _, dev := range devs {
+		if handle == nil && dev.VendorID() == vid && dev.ProductID() == pid {
+			handle, err = dev.Open()
+		}
+		dev.Close()
+	}
+	return handle, err
+}
+
+// HandleEvents blocks until at least one asynchronous transfer completion
+// or hotplug event has been handled, or the internal timeout expires.
+// Events are handled by a goroutine the Context runs for itself, so calling
+// HandleEvents is never required; callbacks run on that goroutine and must
+// not block waiting for other events.
+func (c *Context) HandleEvents() error {
+	return codeError("handle events", libusb_error(libusb_handle_events(c.ctx)))
+}
@@ -0,0 +1,75 @@
+package usb
+
+import (
+	"errors"
+	"testing"
+)
+
+// testDeviceDescriptor is the device descriptor of a full speed vendor
+// specific device, 1234:5678, with one configuration.
+var testDeviceDescriptor = []byte{
+	0x12, 0x01, 0x00, 0x02, 0xff, 0x00, 0x00, 0x40,
+	0x34, 0x12, 0x78, 0x56, 0x00, 0x01, 0x01, 0x02, 0x00, 0x01,
+}
+
+// newTestContext installs a simulator with devs plugged in and opens a
+// context on it. Both are torn down when the test ends.
+func newTestContext(t *testing.T, devs ...*SimDevice) (*Context, *Simulator) {
+	t.Helper()
+	sim := NewSimulator()
+	prev := SetBackend(sim.Backend())
+	t.Cleanup(func() { SetBackend(prev) })
+	for _, d := range devs {
+		if err := sim.Plug(d); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c, err := NewContext()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c, sim
+}
+
+func TestContextOpenClose(t *testing.T) {
+	c, _ := newTestContext(t, &SimDevice{
+		Device:  testDeviceDescriptor,
+		Configs: [][]byte{fuzzConfig},
+	})
+
+	devs, err := c.Devices()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(devs) != 1 {
+		t.Fatalf("found %d devices, want 1", len(devs))
+	}
+	for _, d := range devs {
+		d.Close()
+	}
+
+	h, err := c.OpenDeviceWithVIDPID(0x1234, 0x5678)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := h.Device(); d.VendorID() != 0x1234 || d.ProductID() != 0x5678 {
+		t.Errorf("opened %04x:%04x, want 1234:5678", d.VendorID(), d.ProductID())
+	}
+	if err := h.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	handles, err := c.OpenDevices(func(*Device) bool { return true })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(handles) != 1 {
+		t.Fatalf("opened %d devices, want 1", len(handles))
+	}
+	handles[0].Close()
+
+	if _, err := c.OpenDeviceWithVIDPID(0x1234, 0x0000); !errors.Is(err, ErrNotFound) {
+		t.Errorf("opening a missing device returned %v, want ErrNotFound", err)
+	}
+}
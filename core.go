@@ -47,13 +47,13 @@ func discovered_devs_append(discdevs []*libusb_device, dev *libusb_device) []*li
 /* Allocate a new device with a specific session ID. The returned device has
  * a reference count of 1. */
 func usbi_alloc_device(ctx *libusb_context, session_id uint64) *libusb_device {
-	priv_size := usbi_backend.Device_priv_size()
 	dev := &libusb_device{}
 
 	dev.ctx = ctx
 	dev.refcnt = 1
 	dev.session_data = session_id
 	dev.speed = LIBUSB_SPEED_UNKNOWN
+	dev.list = &LinkedList{member: dev}
 
 	if !libusb_has_capability(LIBUSB_CAP_HAS_HOTPLUG) {
 		usbi_connect_device(dev)
@@ -72,9 +72,9 @@ func usbi_connect_device(dev *libusb_device) {
 	dev.ctx.usb_devs_lock.Unlock()
 
 	/* Signal that an event has occurred for this device if we support hotplug AND
-	 * the hotplug message list is ready. This prevents an event from getting raised
-	 * during initial enumeration. */
-	if libusb_has_capability(LIBUSB_CAP_HAS_HOTPLUG) && dev.ctx.hotplug_msgs.next != nil {
+	 * the event handling goroutine is running. This prevents an event from getting
+	 * raised during initial enumeration. */
+	if libusb_has_capability(LIBUSB_CAP_HAS_HOTPLUG) && dev.ctx.event_in != nil {
 		usbi_hotplug_notification(ctx, dev, LIBUSB_HOTPLUG_EVENT_DEVICE_ARRIVED)
 	}
 }
@@ -108,25 +108,10 @@ func libusb_exit(ctx *libusb_context) {
 
 	if libusb_has_capability(LIBUSB_CAP_HAS_HOTPLUG) {
 		usbi_hotplug_deregister_all(ctx)
-
-		/*
-		 * Ensure any pending unplug events are read from the hotplug
-		 * pipe. The usb_device-s hold in the events are no longer part
-		 * of usb_devs, but the events still hold a reference!
-		 *
-		 * Note we don't do this if the application has left devices
-		 * open (which implies a buggy app) to avoid packet completion
-		 * handlers running when the app does not expect them to run.
-		 */
-		var tv time.Time
-		if list_empty(ctx.open_devs) {
-			libusb_handle_events_timeout(ctx, &tv)
-		}
 	}
 
-	/* a few sanity checks. don't bother with locking because unless
-	 * there is an application bug, nobody will be accessing these. */
-
+	/* stopping the event handling goroutines releases the references held
+	 * by any unplug events that have not been dispatched yet. */
 	usbi_io_exit(ctx)
 	usbi_backend.Exit()
 }
@@ -144,13 +129,13 @@ func libusb_has_capability(capability libusb_capability) bool {
 	case LIBUSB_CAP_HAS_CAPABILITY:
 		return true
 	case LIBUSB_CAP_HAS_HOTPLUG:
-		// Go todo: have Get_device_list return an error
-		return usbi_backend.Get_device_list != nil
+		/* every backend reports devices through hotplug, and returns
+		 * LIBUSB_ERROR_NOT_SUPPORTED from Get_device_list */
+		return usbi_backend != nil
 	case LIBUSB_CAP_HAS_HID_ACCESS:
-		// Go todo: have Caps return an error
-		return (usbi_backend.Caps != nil && USBI_CAP_HAS_HID_ACCESS != 0)
+		return usbi_backend != nil && usbi_backend.Caps()&USBI_CAP_HAS_HID_ACCESS != 0
 	case LIBUSB_CAP_SUPPORTS_DETACH_KERNEL_DRIVER:
-		return (usbi_backend.Caps != nil && USBI_CAP_SUPPORTS_DETACH_KERNEL_DRIVER != 0)
+		return usbi_backend != nil && usbi_backend.Caps()&USBI_CAP_SUPPORTS_DETACH_KERNEL_DRIVER != 0
 	}
 	return false
 }
@@ -236,11 +221,7 @@ func libusb_alloc_streams(dev_handle *libusb_device_handle, num_streams uint32,
 		return LIBUSB_ERROR_NO_DEVICE
 	}
 
-	// todo: return error from Alloc streams
-	if usbi_backend.Alloc_streams != nil {
-		return usbi_backend.Alloc_streams(dev_handle, num_streams, endpoints, num_endpoints)
-	}
-	return LIBUSB_ERROR_NOT_SUPPORTED
+	return usbi_backend.Alloc_streams(dev_handle, num_streams, endpoints, num_endpoints)
 }
 
 /** \ingroup libusb_asyncio
@@ -465,10 +446,10 @@ func usbi_disconnect_device(dev *libusb_device) {
 	ctx.usb_devs_lock.Unlock()
 
 	/* Signal that an event has occurred for this device if we support hotplug AND
-	 * the hotplug message list is ready. This prevents an event from getting raised
-	 * during initial enumeration. libusb_handle_events will take care of dereferencing
-	 * the device. */
-	if libusb_has_capability(LIBUSB_CAP_HAS_HOTPLUG) && dev.ctx.hotplug_msgs.next != nil {
+	 * the event handling goroutine is running. This prevents an event from getting
+	 * raised during initial enumeration. The event handling goroutine will take care
+	 * of dereferencing the device. */
+	if libusb_has_capability(LIBUSB_CAP_HAS_HOTPLUG) && dev.ctx.event_in != nil {
 		usbi_hotplug_notification(ctx, dev, LIBUSB_HOTPLUG_EVENT_DEVICE_LEFT)
 	}
 }
@@ -499,7 +480,8 @@ func usbi_sanitize_device(dev *libusb_device) libusb_error {
 func usbi_get_device_by_session_id(ctx *libusb_context, session_id uint64) *libusb_device {
 	var ret *libusb_device
 	ctx.usb_devs_lock.Lock()
-	for dev := list_entry(ctx.usb_devs.next).(*libusb_device); &dev.list != (&ctx.usb_devs); dev = list_entry(dev.list.next).(*libusb_device) {
+	for e := ctx.usb_devs.next; e != ctx.usb_devs; e = e.next {
+		dev := list_entry(e).(*libusb_device)
 		if dev.session_data == session_id {
 			ret = libusb_ref_device(dev)
 			break
@@ -533,7 +515,6 @@ func usbi_get_device_by_session_id(ctx *libusb_context, session_id uint64) *libu
 func libusb_get_device_list(ctx *libusb_context, list *[]*libusb_device) libusb_error {
 	discdevs := make([]*libusb_device, 0) // had a cap of DISCOVERED_DEVICES_SIZE_STEP, but that's gone missing
 	var r libusb_error
-	var i int
 	ctx = USBI_GET_CONTEXT(ctx)
 
 	if libusb_has_capability(LIBUSB_CAP_HAS_HOTPLUG) {
@@ -541,8 +522,8 @@ func libusb_get_device_list(ctx *libusb_context, list *[]*libusb_device) libusb_
 		usbi_backend.Hotplug_poll()
 		ctx.usb_devs_lock.Lock()
 
-		for dev := list_entry((ctx.usb_devs).next).(*libusb_device); &dev.list != (&ctx.usb_devs); dev = list_entry(dev.list.next).(*libusb_device) {
-			discdevs = discovered_devs_append(discdevs, dev)
+		for e := ctx.usb_devs.next; e != ctx.usb_devs; e = e.next {
+			discdevs = discovered_devs_append(discdevs, list_entry(e).(*libusb_device))
 		}
 
 		ctx.usb_devs_lock.Unlock()
@@ -580,15 +561,87 @@ func libusb_free_device_list(list []*libusb_device, unref_devices int) {
 	}
 
 	if unref_devices != 0 {
-		i := 0
-		dev := list[i]
-		for dev != nil {
+		for _, dev := range list {
+			if dev == nil {
+				break
+			}
 			libusb_unref_device(dev)
-			i++
 		}
 	}
 }
 
+/** \ingroup libusb_dev
+ * Get the number of the bus that a device is connected to.
+ * \param dev a device
+ * \returns the bus number
+ */
+func libusb_get_bus_number(dev *libusb_device) uint8 {
+	return dev.bus_number
+}
+
+/** \ingroup libusb_dev
+ * Get the number of the port that a device is connected to.
+ * Unless the OS does something funky, or you are hot-plugging USB extension cards,
+ * the port number returned by this call is usually guaranteed to be uniquely tied
+ * to a physical port, meaning that different devices plugged on the same physical
+ * port should return the same port number.
+ *
+ * But outside of this, there is no guarantee that the port number returned by this
+ * call will remain the same, or even match the order in which ports have been
+ * numbered by the HUB/HCD manufacturer.
+ *
+ * \param dev a device
+ * \returns the port number (0 if not available)
+ */
+func libusb_get_port_number(dev *libusb_device) uint8 {
+	return dev.port_number
+}
+
+/** \ingroup libusb_dev
+ * Get the the parent from the specified device.
+ * \param dev a device
+ * \returns the device parent or nil if not available
+ * You should issue a \ref libusb_get_device_list() before calling this
+ * function and make sure that you only access the parent before issuing
+ * \ref libusb_free_device_list(). The reason is that libusb currently does
+ * not maintain a permanent list of device instances, and therefore can
+ * only guarantee that parents are fully instantiated within a
+ * libusb_get_device_list() - libusb_free_device_list() block.
+ */
+func libusb_get_parent(dev *libusb_device) *libusb_device {
+	return dev.parent_dev
+}
+
+/** \ingroup libusb_dev
+ * Get the address of the device on the bus it is connected to.
+ * \param dev a device
+ * \returns the device address
+ */
+func libusb_get_device_address(dev *libusb_device) uint8 {
+	return dev.device_address
+}
+
+/** \ingroup libusb_dev
+ * Get the negotiated connection speed for a device.
+ * \param dev a device
+ * \returns a \ref libusb_speed code, where LIBUSB_SPEED_UNKNOWN means that
+ * the OS doesn't know or doesn't support returning the negotiated speed.
+ */
+func libusb_get_device_speed(dev *libusb_device) libusb_speed {
+	return dev.speed
+}
+
+/** \ingroup libusb_dev
+ * Get the underlying device for a device handle. This function does not modify
+ * the reference count of the returned device, so do not feel compelled to
+ * unreference it when you are done.
+ * \param dev_handle a device handle
+ * \returns the underlying device
+ */
+func libusb_get_device(dev_handle *libusb_device_handle) *libusb_device {
+	return dev_handle.dev
+}
+
 /** \ingroup libusb_dev
  * Get the list of all port numbers from root for the specified device
  *
@@ -601,11 +654,15 @@ func libusb_free_device_list(list []*libusb_device, unref_devices int) {
  * \returns LIBUSB_ERROR_OVERFLOW if the array is too small
  */
 func libusb_get_port_numbers(dev *libusb_device, port_numbers []uint8) int {
-	ctx := dev.ctx
 	i := len(port_numbers)
 
 	// HCDs can be listed as devices with port #0
 	for dev != nil && dev.port_number != 0 {
+		i--
+		if i < 0 {
+			// usbi_warn(ctx, "port numbers array is too small")
+			return int(LIBUSB_ERROR_OVERFLOW)
+		}
 		port_numbers[i] = dev.port_number
 		dev = dev.parent_dev
 	}
@@ -765,40 +822,6 @@ func libusb_unref_device(dev *libusb_device) {
 	}
 }
 
-/*
- * Signal the event pipe so that the event handling thread will be
- * interrupted to process an internal event.
- */
-func usbi_signal_event(ctx *libusb_context) libusb_error {
-	var dummy uint8
-
-	/* read some data on event pipe to clear it */
-	r := usbi_write(ctx.event_pipe[1], &dummy, 1)
-	if r != 1 {
-		// usbi_warn(ctx, "internal signalling read failed")
-		return LIBUSB_ERROR_IO
-	}
-
-	return 0
-}
-
-/*
- * Clear the event pipe so that the event handling will no longer be
- * interrupted.
- */
-func usbi_clear_event(ctx *libusb_context) libusb_error {
-	var dummy uint8
-
-	/* read some data on event pipe to clear it */
-	r := usbi_read(ctx.event_pipe[0], &dummy, 1)
-	if r != 1 {
-		// usbi_warn(ctx, "internal signalling read failed")
-		return LIBUSB_ERROR_IO
-	}
-
-	return 0
-}
-
 /** \ingroup libusb_dev
  * Open a device and obtain a device handle. A handle allows you to perform
  * I/O on the device in question.
@@ -820,7 +843,6 @@ func usbi_clear_event(ctx *libusb_context) libusb_error {
  */
 func libusb_open(dev *libusb_device, dev_handle **libusb_device_handle) libusb_error {
 	ctx := dev.ctx
-	priv_size := usbi_backend.Device_handle_priv_size()
 	// usbi_dbg("open %d.%d", dev.bus_number, dev.device_address)
 
 	if !dev.attached {
@@ -829,7 +851,7 @@ func libusb_open(dev *libusb_device, dev_handle **libusb_device_handle) libusb_e
 
 	_dev_handle := &libusb_device_handle{}
 	_dev_handle.dev = libusb_ref_device(dev)
-	_dev_handle.os_priv = make([]uint8, priv_size)
+	_dev_handle.list = &LinkedList{member: _dev_handle}
 
 	r := usbi_backend.Open(_dev_handle)
 	if r < 0 {
@@ -906,13 +928,9 @@ func do_close(ctx *libusb_context, dev_handle *libusb_device_handle) {
 	ctx.flying_transfers_lock.Lock()
 
 	/* safe iteration because transfers may be being deleted */
-	for {
-		itransfer := list_entry(ctx.flying_transfers.next).(*usbi_transfer)
-		tmp := list_entry(itransfer.list.next).(*usbi_transfer)
-		if &itransfer.list == (&ctx.flying_transfers) {
-			break
-		}
-		itransfer, tmp = tmp, list_entry(tmp.list.next).(*usbi_transfer)
+	for e := ctx.flying_transfers.next; e != ctx.flying_transfers; {
+		itransfer := list_entry(e).(*usbi_transfer)
+		e = e.next
 
 		transfer := itransfer.libusbTransfer
 
@@ -972,50 +990,11 @@ func libusb_close(dev_handle *libusb_device_handle) {
 	}
 	// usbi_dbg("")
 
-	ctx := dev_handle.dev.ctx
-	handling_events := true
-	pending_events := false
-
-	/* Similarly to libusb_open(), we want to interrupt all event handlers
-	 * at this point. More importantly, we want to perform the actual close of
-	 * the device while holding the event handling lock (preventing any other
-	 * thread from doing event handling) because we will be removing a file
-	 * descriptor from the polling loop. If this is being called by the current
-	 * event handler, we can bypass the interruption code because we already
-	 * hold the event handling lock. */
-
-	if !handling_events {
-		/* Record that we are closing a device.
-		 * Only signal an event if there are no prior pending events. */
-		ctx.event_data_lock.Lock()
-		pending_events = usbi_pending_events(ctx)
-		ctx.device_close++
-		if !pending_events {
-			usbi_signal_event(ctx)
-		}
-		ctx.event_data_lock.Unlock()
-
-		/* take event handling lock */
-		libusb_lock_events(ctx)
-	}
-
-	/* Close the device */
-	do_close(ctx, dev_handle)
-
-	if !handling_events {
-		/* We're done with closing this device.
-		 * Clear the event pipe if there are no further pending events. */
-		ctx.event_data_lock.Lock()
-		ctx.device_close--
-		pending_events = usbi_pending_events(ctx)
-		if !pending_events {
-			usbi_clear_event(ctx)
-		}
-		ctx.event_data_lock.Unlock()
-
-		/* Release event handling lock and wake up event waiters */
-		libusb_unlock_events(ctx)
-	}
+	/* Backends stop delivering events for a handle in their close function,
+	 * so there is no need to interrupt the event handling goroutine, and a
+	 * handle may be closed from any goroutine, including a transfer
+	 * callback. */
+	do_close(dev_handle.dev.ctx, dev_handle)
 }
 
 /** \ingroup libusb_dev
@@ -1302,12 +1281,19 @@ func libusb_init(context **libusb_context) libusb_error {
 		// usbi_dbg("created default context")
 	}
 
+	ctx.usb_devs = &LinkedList{}
 	list_init(ctx.usb_devs)
+	ctx.open_devs = &LinkedList{}
 	list_init(ctx.open_devs)
+	ctx.hotplug_cbs = &LinkedList{}
 	list_init(ctx.hotplug_cbs)
+	ctx.list = &LinkedList{member: ctx}
 
 	active_contexts_lock.Lock()
-	initOnce.Do(func() { list_init(active_contexts_list) })
+	initOnce.Do(func() {
+		active_contexts_list = &LinkedList{}
+		list_init(active_contexts_list)
+	})
 	list_add(ctx.list, active_contexts_list)
 	active_contexts_lock.Unlock()
 
@@ -1341,14 +1327,8 @@ err_free_ctx:
 
 	ctx.usb_devs_lock.Lock()
 
-	var dev, next *libusb_device
-	for {
-		dev := list_entry((ctx.usb_devs).next).(*libusb_device)
-		next := list_entry(dev.list.next).(*libusb_device)
-		if &dev.list == (&ctx.usb_devs) {
-			break
-		}
-		dev, next = next, list_entry(next.list.next).(*libusb_device)
+	for !list_empty(ctx.usb_devs) {
+		dev := list_first_entry(ctx.usb_devs).(*libusb_device)
 		list_del(dev.list)
 		libusb_unref_device(dev)
 	}
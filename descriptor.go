@@ -20,6 +20,11 @@ package usb
  * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA
  */
 
+import (
+	"encoding/binary"
+	"fmt"
+)
+
 const DESC_HEADER_LENGTH = 2
 const DEVICE_DESC_LENGTH = 18
 const CONFIG_DESC_LENGTH = 9
@@ -44,9 +49,6 @@ func libusb_get_container_id_descriptor(ctx *libusb_context,
 	dev_cap *libusb_bos_dev_capability_descriptor,
 	container_id **libusb_container_id_descriptor) libusb_error {
 
-	var _container_id *libusb_container_id_descriptor
-	host_endian := false
-
 	if dev_cap.bDevCapabilityType != LIBUSB_BT_CONTAINER_ID {
 		// usbi_err(ctx, "unexpected bDevCapabilityType %x (expected %x)",
 		//  dev_cap.bDevCapabilityType,
@@ -59,10 +61,8 @@ func libusb_get_container_id_descriptor(ctx *libusb_context,
 		return LIBUSB_ERROR_IO
 	}
 
-	idDest := usbi_parse_descriptor(dev_cap.ToBytes(), "bbbbu", host_endian)
-
 	var err error
-	*container_id, err = containerIdFromBytes(idDest)
+	*container_id, err = containerIdFromBytes(dev_cap.ToBytes()[:LIBUSB_BT_CONTAINER_ID_SIZE])
 	if err != nil {
 		return LIBUSB_ERROR_INVALID_PARAM
 	}
@@ -172,10 +172,9 @@ func libusb_get_ss_usb_device_capability_descriptor(
 		return LIBUSB_ERROR_IO
 	}
 
-	capDest := usbi_parse_descriptor(dev_cap.ToBytes(), "bbbbwbbw", false)
-
 	var err error
-	*ss_usb_device_cap, err = SsUsbDeviceCapabilityDescriptorFromBytes(capDest)
+	*ss_usb_device_cap, err = SsUsbDeviceCapabilityDescriptorFromBytes(
+		dev_cap.ToBytes()[:LIBUSB_BT_SS_USB_DEVICE_CAPABILITY_SIZE])
 	if err != nil {
 		return LIBUSB_ERROR_INVALID_PARAM
 	}
@@ -212,10 +211,9 @@ func libusb_get_usb_2_0_extension_descriptor(
 		return LIBUSB_ERROR_IO
 	}
 
-	extDest := usbi_parse_descriptor(dev_cap.ToBytes(), "bbbd", false)
-
 	var err error
-	*usb_2_0_extension, err = Usb20ExtensionDescriptorFromBytes(extDest)
+	*usb_2_0_extension, err = Usb20ExtensionDescriptorFromBytes(
+		dev_cap.ToBytes()[:LIBUSB_BT_USB_2_0_EXTENSION_SIZE])
 	if err != nil {
 		return LIBUSB_ERROR_INVALID_PARAM
 	}
@@ -235,6 +233,20 @@ func libusb_get_usb_2_0_extension_descriptor(
  */
 func libusb_get_bos_descriptor(dev_handle *libusb_device_handle, bos **libusb_bos_descriptor) libusb_error {
 
+	bos_data, r := read_bos_descriptor(dev_handle)
+	if r < 0 {
+		return r
+	}
+	if err := parse_bos(dev_handle.dev.ctx, bos, bos_data, false); err != nil {
+		// usbi_err(dev_handle.dev.ctx, "parse_bos failed: %v", err)
+		return LIBUSB_ERROR_IO
+	}
+	return LIBUSB_SUCCESS
+}
+
+/* read_bos_descriptor reads the whole of the BOS descriptor. */
+func read_bos_descriptor(dev_handle *libusb_device_handle) ([]uint8, libusb_error) {
+
 	bos_header := make([]uint8, LIBUSB_DT_BOS_SIZE)
 
 	/* Read the BOS. This generates 2 requests on the bus,
@@ -243,27 +255,83 @@ func libusb_get_bos_descriptor(dev_handle *libusb_device_handle, bos **libusb_bo
 	if r < 0 {
 		// if (r != LIBUSB_ERROR_PIPE)
 		// usbi_err(dev_handle), "failed to read BOS (%d)", r.dev.ctx;
-		return r
+		return nil, r
 	}
 	if r < LIBUSB_DT_BOS_SIZE {
 		// usbi_err(dev_handle.dev.ctx, "short BOS read %d/%d",
 		//  r, LIBUSB_DT_BOS_SIZE)
-		return LIBUSB_ERROR_IO
+		return nil, LIBUSB_ERROR_IO
 	}
 
-	bosBytes := usbi_parse_descriptor(bos_header, "bbwb", false)
-	_bos, _ := BosDescriptorFromBytes(bosBytes)
+	_bos, _ := BosDescriptorFromBytes(bos_header)
 	// usbi_dbg("found BOS descriptor: size %d bytes, %d capabilities",
 	//  _bos.wTotalLength, _bos.bNumDeviceCaps);
 	bos_data := make([]uint8, _bos.wTotalLength)
 
 	r = libusb_get_descriptor(dev_handle, LIBUSB_DT_BOS, 0, bos_data, _bos.wTotalLength)
-	if r >= 0 {
-		r = parse_bos(dev_handle.dev.ctx, bos, bos_data, int(r), false)
+	if r < 0 {
+		// usbi_err(dev_handle), "failed to read BOS (%d)", r.dev.ctx;
+		return nil, r
+	}
+	if int(r) < len(bos_data) {
+		bos_data = bos_data[:r]
+	}
+	return bos_data, LIBUSB_SUCCESS
+}
+
+/* parse_device_qualifier_descriptor parses a device qualifier descriptor,
+ * which is always little-endian as it is only read from the device. */
+func parse_device_qualifier_descriptor(desc *libusb_device_qualifier_descriptor, buffer []uint8) error {
+	const path = "qualifier"
+	p := new_descriptor_parser(buffer, false)
+	if err := p.parse_header(0, len(buffer), path, LIBUSB_DT_DEVICE_QUALIFIER,
+		LIBUSB_DT_DEVICE_QUALIFIER_SIZE); err != nil {
+		return err
+	}
+
+	desc.bLength = buffer[0]
+	desc.bDescriptorType = buffer[1]
+	desc.bcdUSB = p.order.Uint16(buffer[2:])
+	desc.bDeviceClass = buffer[4]
+	desc.bDeviceSubClass = buffer[5]
+	desc.bDeviceProtocol = buffer[6]
+	desc.bMaxPacketSize0 = buffer[7]
+	desc.bNumConfigurations = buffer[8]
+	desc.bReserved = buffer[9]
+	return nil
+}
+
+/* read_other_speed_config_descriptor reads the whole of the other speed
+ * configuration at an index. */
+func read_other_speed_config_descriptor(dev_handle *libusb_device_handle, config_index uint8) ([]uint8, libusb_error) {
+
+	tmp := make([]uint8, LIBUSB_DT_CONFIG_SIZE)
+
+	/* Like the BOS, this generates 2 requests on the bus, one for the
+	 * header, and one for the full configuration */
+	r := libusb_get_descriptor(dev_handle, LIBUSB_DT_OTHER_SPEED_CONFIGURATION,
+		libusb_descriptor_type(config_index), tmp, LIBUSB_DT_CONFIG_SIZE)
+	if r < 0 {
+		return nil, r
 	}
-	// else usbi_err(dev_handle), "failed to read BOS (%d)", r.dev.ctx;
+	if r < LIBUSB_DT_CONFIG_SIZE {
+		// usbi_err(dev_handle.dev.ctx, "short other speed config read %d/%d",
+		//  r, LIBUSB_DT_CONFIG_SIZE)
+		return nil, LIBUSB_ERROR_IO
+	}
+
+	wTotalLength := binary.LittleEndian.Uint16(tmp[2:])
+	buf := make([]uint8, wTotalLength)
 
-	return r
+	r = libusb_get_descriptor(dev_handle, LIBUSB_DT_OTHER_SPEED_CONFIGURATION,
+		libusb_descriptor_type(config_index), buf, wTotalLength)
+	if r < 0 {
+		return nil, r
+	}
+	if int(r) < len(buf) {
+		buf = buf[:r]
+	}
+	return buf, LIBUSB_SUCCESS
 }
 
 /** \ingroup libusb_desc
@@ -284,36 +352,37 @@ func libusb_get_ss_endpoint_companion_descriptor(
 	endpoint *libusb_endpoint_descriptor,
 	ep_comp **libusb_ss_endpoint_companion_descriptor) int {
 
-	var header usb_descriptor_header
 	buffer := endpoint.extra
-	buffi := 0
-	size := len(buffer)
+	off := 0
 
 	*ep_comp = nil
 
-	for size >= DESC_HEADER_LENGTH {
-		usbi_parse_descriptor(buffer[buffi:], "bb", &header, 0)
-		if header.bLength < 2 || header.bLength > size {
+	for len(buffer)-off >= DESC_HEADER_LENGTH {
+		bLength := int(buffer[off])
+		if bLength < DESC_HEADER_LENGTH || bLength > len(buffer)-off {
 			// usbi_err(ctx, "invalid descriptor length %d",
-			//  header.bLength);
-			return LIBUSB_ERROR_IO
+			//  bLength);
+			return int(LIBUSB_ERROR_IO)
 		}
-		if header.bDescriptorType != LIBUSB_DT_SS_ENDPOINT_COMPANION {
-			buffi += header.bLength
-			size -= header.bLength
+		if libusb_descriptor_type(buffer[off+1]) != LIBUSB_DT_SS_ENDPOINT_COMPANION {
+			off += bLength
 			continue
 		}
-		if header.bLength < LIBUSB_DT_SS_ENDPOINT_COMPANION_SIZE {
+		if bLength < LIBUSB_DT_SS_ENDPOINT_COMPANION_SIZE {
 			// usbi_err(ctx, "invalid ss-ep-comp-desc length %d",
-			//  header.bLength);
-			return LIBUSB_ERROR_IO
+			//  bLength);
+			return int(LIBUSB_ERROR_IO)
 		}
-		*ep_comp = &(&libusb_ss_endpoint_companion_descriptor{})
-
-		usbi_parse_descriptor(buffer, "bbbbw", *ep_comp, 0)
-		return LIBUSB_SUCCESS
+		*ep_comp = &libusb_ss_endpoint_companion_descriptor{
+			bLength:           buffer[off],
+			bDescriptorType:   buffer[off+1],
+			bMaxBurst:         buffer[off+2],
+			bmAttributes:      buffer[off+3],
+			wBytesPerInterval: binary.LittleEndian.Uint16(buffer[off+4:]),
+		}
+		return int(LIBUSB_SUCCESS)
 	}
-	return LIBUSB_ERROR_NOT_FOUND
+	return int(LIBUSB_ERROR_NOT_FOUND)
 }
 
 /** \ingroup libusb_desc
@@ -336,26 +405,38 @@ func libusb_get_ss_endpoint_companion_descriptor(
 func libusb_get_config_descriptor_by_value(dev *libusb_device,
 	bConfigurationValue uint8, config **libusb_config_descriptor) int {
 
-	var r, idx int
-	host_endian := false
+	buf, host_endian, r := read_config_descriptor_by_value(dev, bConfigurationValue)
+	if r < 0 {
+		return int(r)
+	}
+	return raw_desc_to_config(dev.ctx, buf, host_endian, config)
+}
+
+/* read_config_descriptor_by_value reads the whole of the configuration with
+ * a specific bConfigurationValue. */
+func read_config_descriptor_by_value(dev *libusb_device, bConfigurationValue uint8) ([]uint8, bool, libusb_error) {
+	host_endian := 0
 	var buf []uint8
 
-	if usbi_backend.get_config_descriptor_by_value {
-		r = usbi_backend.get_config_descriptor_by_value(dev, bConfigurationValue, &buf, &host_endian)
+	/* optional for backends, which return LIBUSB_ERROR_NOT_SUPPORTED */
+	r := usbi_backend.Get_config_descriptor_by_value(dev, bConfigurationValue, &buf, &host_endian)
+	if r != LIBUSB_ERROR_NOT_SUPPORTED {
 		if r < 0 {
-			return r
+			return nil, false, r
 		}
-		return raw_desc_to_config(dev.ctx, buf, r, host_endian, config)
+		if int(r) < len(buf) {
+			buf = buf[:r]
+		}
+		return buf, host_endian != 0, LIBUSB_SUCCESS
 	}
 
-	r = usbi_get_config_index_by_value(dev, bConfigurationValue, &idx)
-	if r < 0 {
-		return r
+	var idx int
+	if r := usbi_get_config_index_by_value(dev, bConfigurationValue, &idx); r < 0 {
+		return nil, false, libusb_error(r)
 	} else if idx < 0 {
-		return LIBUSB_ERROR_NOT_FOUND
-	} else {
-		return libusb_get_config_descriptor(dev, uint8(idx), config)
+		return nil, false, LIBUSB_ERROR_NOT_FOUND
 	}
+	return read_config_descriptor(dev, uint8(idx))
 }
 
 /* iterate through all configurations, returning the index of the configuration
@@ -366,18 +447,18 @@ func libusb_get_config_descriptor_by_value(dev *libusb_device,
 func usbi_get_config_index_by_value(dev *libusb_device, bConfigurationValue uint8, idx *int) int {
 
 	// usbi_dbg("value %d", bConfigurationValue);
-	for i := 0; i < dev.num_configurations; i++ {
+	for i := uint8(0); i < dev.num_configurations; i++ {
 
-		var tmp [6]uint8
+		tmp := make([]uint8, 6)
 
-		host_endian := false
-		r := usbi_backend.get_config_descriptor(dev, i, tmp, sizeof(tmp), &host_endian)
+		host_endian := 0
+		r := usbi_backend.Get_config_descriptor(dev, i, tmp, len(tmp), &host_endian)
 		if r < 0 {
 			*idx = -1
-			return r
+			return int(r)
 		}
 		if tmp[5] == bConfigurationValue {
-			*idx = i
+			*idx = int(i)
 			return 0
 		}
 	}
@@ -404,37 +485,47 @@ func usbi_get_config_index_by_value(dev *libusb_device, bConfigurationValue uint
  */
 func libusb_get_config_descriptor(dev *libusb_device, config_index uint8, config **libusb_config_descriptor) int {
 
-	var tmp [LIBUSB_DT_CONIFG_SIZE]uint8
+	buf, host_endian, r := read_config_descriptor(dev, config_index)
+	if r < 0 {
+		return int(r)
+	}
+	return raw_desc_to_config(dev.ctx, buf, host_endian, config)
+}
+
+/* read_config_descriptor reads the whole of the configuration at an index,
+ * returning its data and whether it is in host byte order. */
+func read_config_descriptor(dev *libusb_device, config_index uint8) ([]uint8, bool, libusb_error) {
+
+	tmp := make([]uint8, LIBUSB_DT_CONFIG_SIZE)
 
-	host_endian := false
+	host_endian := 0
 
 	// usbi_dbg("index %d", config_index);
 	if config_index >= dev.num_configurations {
-		return LIBUSB_ERROR_NOT_FOUND
+		return nil, false, LIBUSB_ERROR_NOT_FOUND
 	}
 
-	r := usbi_backend.get_config_descriptor(dev, config_index, tmp, LIBUSB_DT_CONFIG_SIZE, &host_endian)
+	r := usbi_backend.Get_config_descriptor(dev, config_index, tmp, LIBUSB_DT_CONFIG_SIZE, &host_endian)
 	if r < 0 {
-		return r
+		return nil, false, r
 	}
 	if r < LIBUSB_DT_CONFIG_SIZE {
 		// usbi_err(dev.ctx, "short config descriptor read %d/%d",
 		//  r, LIBUSB_DT_CONFIG_SIZE);
-		return LIBUSB_ERROR_IO
+		return nil, false, LIBUSB_ERROR_IO
 	}
 
-	_config := libusb_config_descriptor{}
-
-	usbi_parse_descriptor(tmp, "bbw", &_config, host_endian)
+	wTotalLength := descriptor_byte_order(host_endian != 0).Uint16(tmp[2:])
+	buf := make([]uint8, wTotalLength)
 
-	buf := make([]uint8, _config.wTotalLength)
-
-	r = usbi_backend.get_config_descriptor(dev, config_index, buf, _config.wTotalLength, &host_endian)
-	if r >= 0 {
-		r = raw_desc_to_config(dev.ctx, buf, r, host_endian, config)
+	r = usbi_backend.Get_config_descriptor(dev, config_index, buf, len(buf), &host_endian)
+	if r < 0 {
+		return nil, false, r
 	}
-
-	return r
+	if int(r) < len(buf) {
+		buf = buf[:r]
+	}
+	return buf, host_endian != 0, LIBUSB_SUCCESS
 }
 
 /** \ingroup libusb_desc
@@ -453,30 +544,42 @@ func libusb_get_config_descriptor(dev *libusb_device, config_index uint8, config
  */
 func libusb_get_active_config_descriptor(dev *libusb_device, config **libusb_config_descriptor) int {
 
-	var _config libusb_config_descriptor
-	var tmp [LIBUSB_DT_CONFIG_SIZE]uint8
+	buf, host_endian, r := read_active_config_descriptor(dev)
+	if r < 0 {
+		return int(r)
+	}
+	return raw_desc_to_config(dev.ctx, buf, host_endian, config)
+}
+
+/* read_active_config_descriptor reads the whole of the active
+ * configuration. */
+func read_active_config_descriptor(dev *libusb_device) ([]uint8, bool, libusb_error) {
 
-	host_endian := false
+	tmp := make([]uint8, LIBUSB_DT_CONFIG_SIZE)
 
-	r := usbi_backend.get_active_config_descriptor(dev, tmp, LIBUSB_DT_CONFIG_SIZE, &host_endian)
+	host_endian := 0
+
+	r := usbi_backend.Get_active_config_descriptor(dev, tmp, &host_endian)
 	if r < 0 {
-		return r
+		return nil, false, r
 	}
 	if r < LIBUSB_DT_CONFIG_SIZE {
 		// usbi_err(dev.ctx, "short config descriptor read %d/%d",
 		//  r, LIBUSB_DT_CONFIG_SIZE);
-		return LIBUSB_ERROR_IO
+		return nil, false, LIBUSB_ERROR_IO
 	}
 
-	usbi_parse_descriptor(tmp, "bbw", &_config, host_endian)
-	buf := make([]uint8, _config.wTotalLength)
+	wTotalLength := descriptor_byte_order(host_endian != 0).Uint16(tmp[2:])
+	buf := make([]uint8, wTotalLength)
 
-	r = usbi_backend.get_active_config_descriptor(dev, buf, _config.wTotalLength, &host_endian)
-	if r >= 0 {
-		r = raw_desc_to_config(dev.ctx, buf, r, host_endian, config)
+	r = usbi_backend.Get_active_config_descriptor(dev, buf, &host_endian)
+	if r < 0 {
+		return nil, false, r
 	}
-
-	return r
+	if int(r) < len(buf) {
+		buf = buf[:r]
+	}
+	return buf, host_endian != 0, LIBUSB_SUCCESS
 }
 
 /** \ingroup libusb_desc
@@ -497,478 +600,416 @@ func libusb_get_device_descriptor(dev *libusb_device) libusb_device_descriptor {
 }
 
 func usbi_device_cache_descriptor(dev *libusb_device) libusb_error {
-	host_endian := false
-	r := usbi_backend.get_device_descriptor(dev, []uint8(&dev.device_descriptor), &host_endian)
+	host_endian := 0
+	buf := make([]uint8, LIBUSB_DT_DEVICE_SIZE)
+	r := usbi_backend.Get_device_descriptor(dev, buf, &host_endian)
 	if r < 0 {
 		return r
 	}
 
-	if !host_endian {
-		dev.device_descriptor.bcdUSB = libusb_le16_to_cpu(dev.device_descriptor.bcdUSB)
-		dev.device_descriptor.idVendor = libusb_le16_to_cpu(dev.device_descriptor.idVendor)
-		dev.device_descriptor.idProduct = libusb_le16_to_cpu(dev.device_descriptor.idProduct)
-		dev.device_descriptor.bcdDevice = libusb_le16_to_cpu(dev.device_descriptor.bcdDevice)
+	if parse_device_descriptor(&dev.device_descriptor, buf, host_endian != 0) < 0 {
+		return LIBUSB_ERROR_IO
 	}
-
 	return LIBUSB_SUCCESS
 }
 
+/* descriptor_byte_order returns the byte order of the multi-byte fields in
+ * a descriptor read from the backend. Descriptors are little endian on the
+ * bus, but backends may return them in host order instead, see the
+ * host_endian output parameter of usbi_os_backend. */
+func descriptor_byte_order(host_endian bool) binary.ByteOrder {
+	if host_endian {
+		return binary.NativeEndian
+	}
+	return binary.LittleEndian
+}
+
+func parse_device_descriptor(desc *libusb_device_descriptor, buffer []uint8, host_endian bool) int {
+	if len(buffer) < LIBUSB_DT_DEVICE_SIZE {
+		return int(LIBUSB_ERROR_IO)
+	}
+	order := descriptor_byte_order(host_endian)
+
+	desc.bLength = buffer[0]
+	desc.bDescriptorType = buffer[1]
+	desc.bcdUSB = order.Uint16(buffer[2:])
+	desc.bDeviceClass = buffer[4]
+	desc.bDeviceSubClass = buffer[5]
+	desc.bDeviceProtocol = buffer[6]
+	desc.bMaxPacketSize0 = buffer[7]
+	desc.idVendor = order.Uint16(buffer[8:])
+	desc.idProduct = order.Uint16(buffer[10:])
+	desc.bcdDevice = order.Uint16(buffer[12:])
+	desc.iManufacturer = buffer[14]
+	desc.iProduct = buffer[15]
+	desc.iSerialNumber = buffer[16]
+	desc.bNumConfigurations = buffer[17]
+	return LIBUSB_DT_DEVICE_SIZE
+}
+
 func raw_desc_to_config(ctx *libusb_context,
-	buf []uint8, size int, host_endian bool,
+	buf []uint8, host_endian bool,
 	config **libusb_config_descriptor) int {
 
 	_config := &libusb_config_descriptor{}
 
-	r := parse_configuration(ctx, _config, buf, size, host_endian)
-	if r < 0 {
-		// usbi_err(ctx, "parse_configuration failed with error %d", r);
-		return r
+	_, err := parse_configuration(ctx, _config, buf, LIBUSB_DT_CONFIG, host_endian)
+	if err != nil {
+		// usbi_err(ctx, "parse_configuration failed: %v", err);
+		return int(LIBUSB_ERROR_IO)
 	}
 	// else if (r > 0) {
 	// 	// usbi_warn(ctx, "still %d bytes of descriptor data left", r);
 	// }
 
 	*config = _config
-	return LIBUSB_SUCCESS
+	return int(LIBUSB_SUCCESS)
 }
 
-func parse_configuration(ctx *libusb_context,
-	config *libusb_config_descriptor, buffer []uint8,
-	size int, host_endian bool) int {
+/* descriptor_parser walks a buffer of descriptors. Every length is checked
+ * against the buffer before it is used, and problems are reported as a
+ * *DescriptorError locating them by offset and descriptor path.
+ *
+ * Class and vendor specific descriptors are kept in the extra field of the
+ * standard descriptor they follow, as are descriptors that are cut short by
+ * the end of the data, so that nothing a device returns is lost. */
+type descriptor_parser struct {
+	buf   []uint8
+	order binary.ByteOrder
+}
 
-	if size < LIBUSB_DT_CONFIG_SIZE {
-		// usbi_err(ctx, "short config descriptor read %d/%d",
-		// size, LIBUSB_DT_CONFIG_SIZE)
-		return LIBUSB_ERROR_IO
-	}
+func new_descriptor_parser(buf []uint8, host_endian bool) *descriptor_parser {
+	return &descriptor_parser{buf: buf, order: descriptor_byte_order(host_endian)}
+}
 
-	var header usb_descriptor_header
+func (p *descriptor_parser) errorf(off int, path string, format string, args ...interface{}) error {
+	return &DescriptorError{Path: path, Offset: off, Reason: fmt.Sprintf(format, args...)}
+}
 
-	usbi_parse_descriptor(buffer, "bbwbbbbb", config, host_endian)
-	if config.bDescriptorType != LIBUSB_DT_CONFIG {
-		// usbi_err(ctx, "unexpected descriptor %x (expected %x)",
-		// config.bDescriptorType, LIBUSB_DT_CONFIG)
-		return LIBUSB_ERROR_IO
+/* parse_header checks the fixed part of a standard descriptor of type
+ * bDescriptorType and length size at off, which must end by end. */
+func (p *descriptor_parser) parse_header(off, end int, path string, bDescriptorType libusb_descriptor_type, size int) error {
+	if end-off < size {
+		return p.errorf(off, path, "short descriptor, %d of %d bytes", end-off, size)
 	}
-	if config.bLength < LIBUSB_DT_CONFIG_SIZE {
-		// usbi_err(ctx, "invalid config bLength (%d)", config.bLength)
-		return LIBUSB_ERROR_IO
+	if libusb_descriptor_type(p.buf[off+1]) != bDescriptorType {
+		return p.errorf(off+1, path, "unexpected descriptor type 0x%02x, expected 0x%02x",
+			p.buf[off+1], uint8(bDescriptorType))
 	}
-	if config.bLength > size {
-		// usbi_err(ctx, "short config descriptor read %d/%d",
-		// size, config.bLength)
-		return LIBUSB_ERROR_IO
+	if bLength := int(p.buf[off]); bLength < size {
+		return p.errorf(off, path, "invalid bLength %d", bLength)
+	} else if bLength > end-off {
+		return p.errorf(off, path, "bLength %d runs past the end of the data", bLength)
 	}
-	if config.bNumInterfaces > USB_MAXINTERFACES {
-		// usbi_err(ctx, "too many interfaces (%d)", config.bNumInterfaces)
-		return LIBUSB_ERROR_IO
+	return nil
+}
+
+/* parse_total_length returns the end of a descriptor set whose wTotalLength
+ * is at off+2. A device may return less than wTotalLength, in which case
+ * what there is is parsed, but anything beyond wTotalLength is not part of
+ * the set. */
+func (p *descriptor_parser) parse_total_length(off int, path string) (int, error) {
+	wTotalLength := int(p.order.Uint16(p.buf[off+2:]))
+	if wTotalLength < int(p.buf[off]) {
+		return 0, p.errorf(off+2, path, "invalid wTotalLength %d", wTotalLength)
+	}
+	if end := off + wTotalLength; end < len(p.buf) {
+		return end, nil
 	}
+	return len(p.buf), nil
+}
 
-	config.iface = make([]libusb_interface, config.bNumInterfaces)
+/* trailing reports data left over at off. */
+func (p *descriptor_parser) trailing(off int, path string) error {
+	return p.errorf(off, path, "%d bytes of trailing data", len(p.buf)-off)
+}
 
-	buffi := config.bLength
-	size -= config.bLength
-
-	config.extra = nil
-
-	for i := 0; i < config.bNumInterfaces; i++ {
-		/* Skip over the rest of the Class Specific or Vendor */
-		/*  Specific descriptors */
-		begin := buffer[buffi:]
-		for size >= DESC_HEADER_LENGTH {
-			usbi_parse_descriptor(buffer[buffi:], "bb", &header, 0)
-
-			if header.bLength < DESC_HEADER_LENGTH {
-				// usbi_err(ctx,
-				// "invalid extra config desc len (%d)",
-				// header.bLength)
-				return LIBUSB_ERROR_IO
-			} else if header.bLength > size {
-				// usbi_warn(ctx,
-				//  "short extra config desc read %d/%d",
-				//  size, header.bLength)
-				config.bNumInterfaces = uint8(i)
-				return size
-			}
+func (p *descriptor_parser) copy_extra(off, end int) []uint8 {
+	if off == end {
+		return nil
+	}
+	return append([]uint8(nil), p.buf[off:end]...)
+}
 
-			/* If we find another "proper" descriptor then we're done */
-			if (header.bDescriptorType == LIBUSB_DT_ENDPOINT) ||
-				(header.bDescriptorType == LIBUSB_DT_INTERFACE) ||
-				(header.bDescriptorType == LIBUSB_DT_CONFIG) ||
-				(header.bDescriptorType == LIBUSB_DT_DEVICE) {
-				break
-			}
+/* parse_configuration parses a configuration descriptor and the interfaces
+ * that follow it, returning the number of bytes left over. desc_type is the
+ * type the caller asked the device for: LIBUSB_DT_CONFIG, or
+ * LIBUSB_DT_OTHER_SPEED_CONFIGURATION, which has the same layout. */
+func parse_configuration(ctx *libusb_context,
+	config *libusb_config_descriptor, buffer []uint8,
+	desc_type libusb_descriptor_type, host_endian bool) (int, error) {
 
-			// usbi_dbg("skipping descriptor 0x%x", header.bDescriptorType)
-			buffi += header.bLength
-			size -= header.bLength
-		}
+	const path = "config"
+	p := new_descriptor_parser(buffer, host_endian)
 
-		/* Copy any unknown descriptors into a storage area for */
-		/*  drivers to later parse */
-		ln := int(buffi - begin)
-		if ln != 0 {
-			if len(config.extra) {
-				config.extra = make([]uint8, ln)
+	if err := p.parse_header(0, len(buffer), path, desc_type, LIBUSB_DT_CONFIG_SIZE); err != nil {
+		return 0, err
+	}
+	end, err := p.parse_total_length(0, path)
+	if err != nil {
+		return 0, err
+	}
 
-				copy(config.extra, begin)
-			}
-		}
+	config.bLength = buffer[0]
+	config.bDescriptorType = buffer[1]
+	config.wTotalLength = p.order.Uint16(buffer[2:])
+	config.bNumInterfaces = buffer[4]
+	config.bConfigurationValue = buffer[5]
+	config.iConfiguration = buffer[6]
+	config.bmAttributes = buffer[7]
+	config.MaxPower = buffer[8]
+	if config.bNumInterfaces > USB_MAXINTERFACES {
+		return 0, p.errorf(4, path, "too many interfaces (%d)", config.bNumInterfaces)
+	}
 
-		r := parse_interface(ctx, config.iface[i:], buffer[buffi:], size, host_endian)
-		if r < 0 {
-			return r
+	config.tail = p.copy_extra(LIBUSB_DT_CONFIG_SIZE, int(config.bLength))
+	off := int(config.bLength)
+
+	/* Copy any unknown descriptors into a storage area for */
+	/*  drivers to later parse */
+	next, err := p.parse_extra(off, end, path)
+	if err != nil {
+		return 0, err
+	}
+	config.extra = p.copy_extra(off, next)
+	off = next
+
+	config.iface = make([]libusb_interface, config.bNumInterfaces)
+	for i := range config.iface {
+		next, err := p.parse_interface(ctx, &config.iface[i], off, end,
+			fmt.Sprintf("%s/interface[%d]", path, i))
+		if err != nil {
+			return 0, err
 		}
-		if r == 0 {
+		if next == off {
+			// usbi_warn(ctx, "only %d of %d interfaces", i, config.bNumInterfaces)
 			config.bNumInterfaces = uint8(i)
+			config.iface = config.iface[:i]
 			break
 		}
-
-		buffi += r
-		size -= r
+		off = next
 	}
 
-	return size
+	return len(buffer) - off, nil
 }
 
-func descriptorLen(desc string) (size int) {
-	for _, r := range desc {
-		switch r {
-		case 'b':
-			size++
-		case 'w':
-			size += 2
-		case 'd':
-			size += 4
-		case 'u':
-			size += 16
+/* parse_extra returns the end of the class or vendor specific descriptors
+ * at off, which run until the next standard descriptor. A descriptor that
+ * is cut short by end, standard or not, is taken to be part of the run. */
+func (p *descriptor_parser) parse_extra(off, end int, path string) (int, error) {
+	for end-off >= DESC_HEADER_LENGTH {
+		bLength := int(p.buf[off])
+		bDescriptorType := libusb_descriptor_type(p.buf[off+1])
+
+		if bLength < DESC_HEADER_LENGTH {
+			// usbi_err(ctx, "invalid extra desc len (%d)", bLength)
+			return 0, p.errorf(off, path, "invalid bLength %d of extra descriptor", bLength)
+		} else if bLength > end-off {
+			// usbi_warn(ctx, "short extra desc read %d/%d",
+			//  end-off, bLength)
+			return end, nil
 		}
-	}
-}
-
-/* set host_endian if the w values are already in host endian format,
- * as opposed to bus endian. */
-func usbi_parse_descriptor(sp []uint8, descriptor string, host_endian bool) []uint8 {
-
-	i := 0
-	di := 0
-
-	dp := make([]uint8, descriptorLen(descriptor))
-
-	for _, r := range descriptor {
-		switch r {
-		case 'b': /* 8-bit byte */
-			dp[i+di] = sp[i]
-			i++
-		case 'w': /* 16-bit word, convert from little endian to CPU */
-			if i%2 != 0 {
-				di++ /* Align to word boundary */
-			}
 
-			if host_endian {
-				dp[i+di] = sp[i]
-				dp[ii+di1] = sp[i+1]
-			} else {
-				// I'm not sure this is valid.
-				dp[i+di] = sp[i+1]
-				dp[i+di+1] = sp[i]
-			}
-			i += 2
-		case 'd': /* 32-bit word, convert from little endian to CPU */
-			if i%2 != 0 {
-				di++ /* Align to word boundary */
-			}
-
-			if host_endian {
-				dp[i+di] = sp[i]
-				dp[i+di+1] = sp[i+1]
-				dp[i+di+2] = sp[i+2]
-				dp[i+di+3] = sp[i+3]
-			} else {
-				dp[i+di] = sp[i+3]
-				dp[i+di+1] = sp[i+2]
-				dp[i+di+2] = sp[i+1]
-				dp[i+di+3] + sp[i]
-			}
-			sp += 4
-			dp += 4
-		case 'u': /* 16 byte UUID */
-			for j := i; j < i+16; j++ {
-				dp[j+di] = sp[j]
-			}
-			i += 16
+		/* If we find another "proper" descriptor then we're done */
+		if (bDescriptorType == LIBUSB_DT_ENDPOINT) ||
+			(bDescriptorType == LIBUSB_DT_INTERFACE) ||
+			(bDescriptorType == LIBUSB_DT_CONFIG) ||
+			(bDescriptorType == LIBUSB_DT_DEVICE) {
+			return off, nil
 		}
-	}
 
-	// ?
-	return i
+		// usbi_dbg("skipping descriptor 0x%x", bDescriptorType)
+		off += bLength
+	}
+	/* a single trailing byte cannot be a descriptor, but is kept too */
+	return end, nil
 }
 
-func parse_interface(ctx *libusb_context, usb_interface *libusb_interface, buffer []uint8, size int, host_endian bool) int {
+/* parse_interface parses the alternate settings of an interface at off,
+ * returning the offset after them, which is off if there are none. */
+func (p *descriptor_parser) parse_interface(ctx *libusb_context, usb_interface *libusb_interface, off, end int, path string) (int, error) {
 
-	parsed := 0
 	interface_number := -1
-	var header usb_descriptor_header
 
+	usb_interface.altsetting = nil
 	usb_interface.num_altsetting = 0
-	alti := 0
 
-	for size >= INTERFACE_DESC_LENGTH {
-
-		altsetting := make([]libusb_interface_descriptor, usb.num_altsetting+1)
-		usb_interface.altsetting = altsetting
-
-		// todo: this replaces pointer math nonsense
-		ifp := usb_interface.AltSettingNum(usb_interface.num_altsetting)
-
-		usbi_parse_descriptor(buffer, "bbbbbbbbb", ifp, 0)
-		if ifp.bDescriptorType != LIBUSB_DT_INTERFACE {
-			// usbi_err(ctx, "unexpected descriptor %x (expected %x)",
-			// ifp.bDescriptorType, LIBUSB_DT_INTERFACE)
-			return parsed
+	for end-off >= DESC_HEADER_LENGTH {
+		if libusb_descriptor_type(p.buf[off+1]) != LIBUSB_DT_INTERFACE {
+			break
 		}
-		if ifp.bLength < INTERFACE_DESC_LENGTH {
-			// usbi_err(ctx, "invalid interface bLength (%d)",
-			// ifp.bLength)
-			return LIBUSB_ERROR_IO
+		/* We check to see if it's an alternate to the previous one */
+		if interface_number != -1 && end-off >= INTERFACE_DESC_LENGTH &&
+			int(p.buf[off+2]) != interface_number {
+			break
 		}
-		if ifp.bLength > size {
-			// usbi_warn(ctx, "short intf descriptor read %d/%d",
-			// size, ifp.bLength)
-			return parsed
+
+		apath := fmt.Sprintf("%s/altsetting[%d]", path, usb_interface.num_altsetting)
+		if err := p.parse_header(off, end, apath, LIBUSB_DT_INTERFACE, INTERFACE_DESC_LENGTH); err != nil {
+			return 0, err
 		}
-		if ifp.bNumEndpoints > USB_MAXENDPOINTS {
-			// usbi_err(ctx, "too many endpoints (%d)", ifp.bNumEndpoints)
-			return LIBUSB_ERROR_IO
+		b := p.buf[off:]
+		if b[4] > USB_MAXENDPOINTS {
+			return 0, p.errorf(off+4, apath, "too many endpoints (%d)", b[4])
 		}
 
+		usb_interface.altsetting = append(usb_interface.altsetting, libusb_interface_descriptor{
+			bLength:            b[0],
+			bDescriptorType:    b[1],
+			bInterfaceNumber:   b[2],
+			bAlternateSetting:  b[3],
+			bNumEndpoints:      b[4],
+			bInterfaceClass:    b[5],
+			bInterfaceSubClass: b[6],
+			bInterfaceProtocol: b[7],
+			iInterface:         b[8],
+		})
 		usb_interface.num_altsetting++
-		ifp.extra = nil
-		ifp.endpoint = nil
+		ifp := &usb_interface.altsetting[usb_interface.num_altsetting-1]
+
+		ifp.tail = p.copy_extra(off+INTERFACE_DESC_LENGTH, off+int(ifp.bLength))
 
 		if interface_number == -1 {
-			interface_number = ifp.bInterfaceNumber
+			interface_number = int(ifp.bInterfaceNumber)
 		}
 
 		/* Skip over the interface */
-		parsed += ifp.bLength
-		size -= ifp.bLength
-
-		begin := buffer[parsed:]
+		off += int(ifp.bLength)
 
 		/* Skip over any interface, class or vendor descriptors */
-		for size >= DESC_HEADER_LENGTH {
-			usbi_parse_descriptor(buffer, "bb", &header, 0)
-			if header.bLength < DESC_HEADER_LENGTH {
-				// usbi_err(ctx,
-				// "invalid extra intf desc len (%d)",
-				// header.bLength)
-				return LIBUSB_ERROR_IO
-			} else if header.bLength > size {
-				// usbi_warn(ctx,
-				//  "short extra intf desc read %d/%d",
-				//  size, header.bLength)
-				return parsed
-			}
-
-			/* If we find another "proper" descriptor then we're done */
-			if (header.bDescriptorType == LIBUSB_DT_INTERFACE) ||
-				(header.bDescriptorType == LIBUSB_DT_ENDPOINT) ||
-				(header.bDescriptorType == LIBUSB_DT_CONFIG) ||
-				(header.bDescriptorType == LIBUSB_DT_DEVICE) {
-				break
-			}
-
-			parsed += header.bLength
-			size -= header.bLength
+		next, err := p.parse_extra(off, end, apath)
+		if err != nil {
+			return 0, err
 		}
 
 		/* Copy any unknown descriptors into a storage area for */
 		/*  drivers to later parse */
-		ln := len(buffer) - len(begin)
-		if ln != 0 {
-			ifp.extra = make([]uint8, ln)
-			copy(ifp.extra, begin)
-		}
+		ifp.extra = p.copy_extra(off, next)
+		off = next
 
 		if ifp.bNumEndpoints > 0 {
-			endpoint := make([]libusb_endpoint_descriptor, ifp.bNumEndpoints)
-			ifp.endpoint = endpoint
+			ifp.endpoint = make([]libusb_endpoint_descriptor, ifp.bNumEndpoints)
 
-			for i := 0; i < ifp.bNumEndpoints; i++ {
-				r := parse_endpoint(ctx, endpoint+i, buffer, size, host_endian)
-				if r < 0 {
-					return r
+			for i := range ifp.endpoint {
+				next, err := p.parse_endpoint(ctx, &ifp.endpoint[i], off, end,
+					fmt.Sprintf("%s/endpoint[%d]", apath, i))
+				if err != nil {
+					return 0, err
 				}
-				if r == 0 {
+				if next == off {
+					// usbi_warn(ctx, "only %d of %d endpoints", i, ifp.bNumEndpoints)
 					ifp.bNumEndpoints = uint8(i)
+					ifp.endpoint = ifp.endpoint[:i]
 					break
 				}
-
-				parsed += r
-				size -= r
+				off = next
 			}
 		}
-
-		/* We check to see if it's an alternate to this one */
-		// todo: replacing conversions from raw bytes to structs
-		//binary.Read() will replace it
-		ifp = NewLibusbInterfaceDescriptor(buffer)
-		if size < LIBUSB_DT_INTERFACE_SIZE ||
-			ifp.bDescriptorType != LIBUSB_DT_INTERFACE ||
-			ifp.bInterfaceNumber != interface_number {
-			return parsed
-		}
 	}
 
-	return parsed
+	return off, nil
 }
 
-func parse_endpoint(ctx *libusb_context, endpoint *libusb_endpoint_descriptor, buffer []uint8, size int, host_endian bool) int {
-
-	var header usb_descriptor_header
-	parsed := 0
+/* parse_endpoint parses an endpoint descriptor at off and the descriptors
+ * that follow it, returning the offset after them, or off if there is no
+ * endpoint descriptor there. */
+func (p *descriptor_parser) parse_endpoint(ctx *libusb_context, endpoint *libusb_endpoint_descriptor, off, end int, path string) (int, error) {
 
-	if size < DESC_HEADER_LENGTH {
-		// usbi_err(ctx, "short endpoint descriptor read %d/%d",
-		//  size, DESC_HEADER_LENGTH);
-		return LIBUSB_ERROR_IO
-	}
-
-	usbi_parse_descriptor(buffer, "bb", &header, 0)
-	if header.bDescriptorType != LIBUSB_DT_ENDPOINT {
-		// usbi_err(ctx, "unexpected descriptor %x (expected %x)",
-		// header.bDescriptorType, LIBUSB_DT_ENDPOINT);
-		return parsed
+	if end-off < DESC_HEADER_LENGTH ||
+		libusb_descriptor_type(p.buf[off+1]) != LIBUSB_DT_ENDPOINT {
+		return off, nil
 	}
-	if header.bLength > size {
-		// usbi_warn(ctx, "short endpoint descriptor read %d/%d",
-		//   size, header.bLength);
-		return parsed
+	if err := p.parse_header(off, end, path, LIBUSB_DT_ENDPOINT, ENDPOINT_DESC_LENGTH); err != nil {
+		return 0, err
 	}
-	if header.bLength >= ENDPOINT_AUDIO_DESC_LENGTH {
-		usbi_parse_descriptor(buffer, "bbbbwbbb", endpoint, host_endian)
-	} else if header.bLength >= ENDPOINT_DESC_LENGTH {
-		usbi_parse_descriptor(buffer, "bbbbwb", endpoint, host_endian)
-	} else {
-		// usbi_err(ctx, "invalid endpoint bLength (%d)", header.bLength);
-		return LIBUSB_ERROR_IO
+
+	b := p.buf[off:]
+	endpoint.bLength = b[0]
+	endpoint.bDescriptorType = b[1]
+	endpoint.bEndpointAddress = b[2]
+	endpoint.bmAttributes = b[3]
+	endpoint.wMaxPacketSize = p.order.Uint16(b[4:])
+	endpoint.bInterval = b[6]
+	std := ENDPOINT_DESC_LENGTH
+	if endpoint.bLength >= ENDPOINT_AUDIO_DESC_LENGTH {
+		endpoint.bRefresh = b[7]
+		endpoint.bSynchAddress = b[8]
+		std = ENDPOINT_AUDIO_DESC_LENGTH
 	}
+	endpoint.tail = p.copy_extra(off+std, off+int(endpoint.bLength))
 
-	size -= header.bLength
-	parsed += header.bLength
+	off += int(endpoint.bLength)
 
 	/* Skip over the rest of the Class Specific or Vendor Specific */
 	/*  descriptors */
-	begin := buffer[parsed]
-	for size >= DESC_HEADER_LENGTH {
-		usbi_parse_descriptor(buffer, "bb", &header, 0)
-		if header.bLength < DESC_HEADER_LENGTH {
-			// usbi_err(ctx, "invalid extra ep desc len (%d)",
-			//  header.bLength)
-			return LIBUSB_ERROR_IO
-		} else if header.bLength > size {
-			// usbi_warn(ctx, "short extra ep desc read %d/%d",
-			//   size, header.bLength)
-			return parsed
-		}
-
-		/* If we find another "proper" descriptor then we're done  */
-		if (header.bDescriptorType == LIBUSB_DT_ENDPOINT) ||
-			(header.bDescriptorType == LIBUSB_DT_INTERFACE) ||
-			(header.bDescriptorType == LIBUSB_DT_CONFIG) ||
-			(header.bDescriptorType == LIBUSB_DT_DEVICE) {
-			break
-		}
-
-		// usbi_dbg("skipping descriptor %x", header.bDescriptorType);
-		size -= header.bLength
-		parsed += header.bLength
+	next, err := p.parse_extra(off, end, path)
+	if err != nil {
+		return 0, err
 	}
 
 	/* Copy any unknown descriptors into a storage area for drivers */
 	/*  to later parse */
-	ln = len(buffer) - len(begin)
-	if ln == 0 {
-		endpoint.extra = nil
-		return parsed
-	}
-
-	extra := make([]uint8, ln)
-	endpoint.extra = extra
-
-	copy(extra, begin)
+	endpoint.extra = p.copy_extra(off, next)
 
-	return parsed
+	return next, nil
 }
 
-func parse_bos(ctx *libusb_context, bos **libusb_bos_descriptor, buffer []uint8, size int, host_endian bool) libusb_error {
-
-	var dev_cap libusb_bos_dev_capability_descriptor
-
-	if size < LIBUSB_DT_BOS_SIZE {
-		// usbi_err(ctx, "short bos descriptor read %d/%d",
-		//  size, LIBUSB_DT_BOS_SIZE);
-		return LIBUSB_ERROR_IO
-	}
+/* parse_bos parses a BOS descriptor and the device capabilities that follow
+ * it. */
+func parse_bos(ctx *libusb_context, bos **libusb_bos_descriptor, buffer []uint8, host_endian bool) error {
 
-	bos_header := libusb_bos_descriptor{}
+	const path = "bos"
+	p := new_descriptor_parser(buffer, host_endian)
 
-	usbi_parse_descriptor(buffer, "bbwb", &bos_header, host_endian)
-	if bos_header.bDescriptorType != LIBUSB_DT_BOS {
-		// usbi_err(ctx, "unexpected descriptor %x (expected %x)",
-		//  bos_header.bDescriptorType, LIBUSB_DT_BOS);
-		return LIBUSB_ERROR_IO
+	if err := p.parse_header(0, len(buffer), path, LIBUSB_DT_BOS, LIBUSB_DT_BOS_SIZE); err != nil {
+		return err
 	}
-	if bos_header.bLength < LIBUSB_DT_BOS_SIZE {
-		// usbi_err(ctx, "invalid bos bLength (%d)", bos_header.bLength);
-		return LIBUSB_ERROR_IO
-	}
-	if bos_header.bLength > size {
-		// usbi_err(ctx, "short bos descriptor read %d/%d",
-		//  size, bos_header.bLength);
-		return LIBUSB_ERROR_IO
+	end, err := p.parse_total_length(0, path)
+	if err != nil {
+		return err
 	}
 
-	_bos = &libusb_bos_descriptor{}
-
-	usbi_parse_descriptor(buffer, "bbwb", _bos, host_endian)
-	buffi := bos_header.bLength
-	size -= bos_header.bLength
+	_bos := &libusb_bos_descriptor{
+		bLength:         buffer[0],
+		bDescriptorType: buffer[1],
+		wTotalLength:    p.order.Uint16(buffer[2:]),
+		bNumDeviceCaps:  buffer[4],
+	}
+	off := int(_bos.bLength)
 
 	/* Get the device capability descriptors */
-	i := 0
-	for i = 0; i < bos_header.bNumDeviceCaps; i++ {
-		if size < LIBUSB_DT_DEVICE_CAPABILITY_SIZE {
+	for i := 0; i < int(_bos.bNumDeviceCaps); i++ {
+		if end-off < LIBUSB_DT_DEVICE_CAPABILITY_SIZE {
 			// usbi_warn(ctx, "short dev-cap descriptor read %d/%d",
-			//     size, LIBUSB_DT_DEVICE_CAPABILITY_SIZE);
+			//     end-off, LIBUSB_DT_DEVICE_CAPABILITY_SIZE);
 			break
 		}
-		usbi_parse_descriptor(buffer[buffi], "bbb", &dev_cap, host_endian)
-		if dev_cap.bDescriptorType != LIBUSB_DT_DEVICE_CAPABILITY {
+		if libusb_descriptor_type(buffer[off+1]) != LIBUSB_DT_DEVICE_CAPABILITY {
 			// usbi_warn(ctx, "unexpected descriptor %x (expected %x)",
-			//   dev_cap.bDescriptorType, LIBUSB_DT_DEVICE_CAPABILITY);
+			//   buffer[off+1], LIBUSB_DT_DEVICE_CAPABILITY);
 			break
 		}
-		if dev_cap.bLength < LIBUSB_DT_DEVICE_CAPABILITY_SIZE {
-			// usbi_err(ctx, "invalid dev-cap bLength (%d)",
-			//     dev_cap.bLength);
-			return LIBUSB_ERROR_IO
+		bLength := int(buffer[off])
+		if bLength < LIBUSB_DT_DEVICE_CAPABILITY_SIZE {
+			// usbi_err(ctx, "invalid dev-cap bLength (%d)", bLength);
+			return p.errorf(off, fmt.Sprintf("%s/capability[%d]", path, i), "invalid bLength %d", bLength)
 		}
-		if dev_cap.bLength > size {
+		if bLength > end-off {
 			// usbi_warn(ctx, "short dev-cap descriptor read %d/%d",
-			//     size, dev_cap.bLength);
+			//     end-off, bLength);
 			break
 		}
 
-		_bos.dev_capability[i] = make([]uint8, dev_cap.bLength)
-
-		copy(_bos.dev_capability[i], buffer[buffi:])
-		buffi += dev_cap.bLength
-		size -= dev_cap.bLength
+		_bos.dev_capability = append(_bos.dev_capability, &libusb_bos_dev_capability_descriptor{
+			bLength:             buffer[off],
+			bDescriptorType:     buffer[off+1],
+			bDevCapabilityType:  libusb_bos_type(buffer[off+2]),
+			dev_capability_data: append([]uint8(nil), buffer[off+LIBUSB_DT_DEVICE_CAPABILITY_SIZE:off+bLength]...),
+		})
+		off += bLength
 	}
-	_bos.bNumDeviceCaps = uint8(i)
+	_bos.bNumDeviceCaps = uint8(len(_bos.dev_capability))
 	*bos = _bos
 
-	return LIBUSB_SUCCESS
+	return nil
 }
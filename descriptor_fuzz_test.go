@@ -0,0 +1,253 @@
+package usb
+
+import (
+	"bytes"
+	"encoding"
+	"errors"
+	"testing"
+)
+
+// fuzzConfig is a configuration with an interface association, a class
+// specific descriptor, a SuperSpeed endpoint companion and an interface
+// with two alternate settings.
+var fuzzConfig = []byte{
+	0x09, 0x02, 0x4c, 0x00, 0x02, 0x01, 0x00, 0x80, 0x32, // configuration
+	0x08, 0x0b, 0x00, 0x02, 0x02, 0x02, 0x00, 0x00, // interface association
+	0x09, 0x04, 0x00, 0x00, 0x01, 0x02, 0x02, 0x00, 0x00, // interface 0
+	0x05, 0x24, 0x00, 0x10, 0x01, // CDC header
+	0x07, 0x05, 0x81, 0x03, 0x08, 0x00, 0x10, // interrupt IN
+	0x06, 0x30, 0x00, 0x00, 0x08, 0x00, // endpoint companion
+	0x09, 0x04, 0x01, 0x00, 0x00, 0x0a, 0x00, 0x00, 0x00, // interface 1
+	0x09, 0x04, 0x01, 0x01, 0x02, 0x0a, 0x00, 0x00, 0x00, // interface 1, alternate 1
+	0x07, 0x05, 0x02, 0x02, 0x00, 0x02, 0x00, // bulk OUT
+	0x07, 0x05, 0x83, 0x02, 0x00, 0x02, 0x00, // bulk IN
+}
+
+// fuzzBOS is a BOS descriptor with a USB 2.0 extension and a SuperSpeed
+// device capability.
+var fuzzBOS = []byte{
+	0x05, 0x0f, 0x16, 0x00, 0x02, // BOS
+	0x07, 0x10, 0x02, 0x02, 0x00, 0x00, 0x00, // USB 2.0 extension
+	0x0a, 0x10, 0x03, 0x00, 0x0e, 0x00, 0x01, 0x0a, 0xff, 0x07, // SuperSpeed
+}
+
+// fuzzBOSPlus is a BOS descriptor with a SuperSpeedPlus capability of two
+// sublink speeds and a platform capability.
+var fuzzBOSPlus = []byte{
+	0x05, 0x0f, 0x31, 0x00, 0x02, // BOS
+	0x14, 0x10, 0x0a, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x11, 0x00, 0x00,
+	0x30, 0x40, 0x0a, 0x00, 0xb0, 0x40, 0x0a, 0x00, // SuperSpeedPlus
+	0x18, 0x10, 0x05, 0x00, 0x38, 0xb6, 0x08, 0x34, 0xa9, 0x09, 0xa0, 0x47,
+	0x8b, 0xfd, 0xa0, 0x76, 0x88, 0x15, 0xb6, 0x65, 0x00, 0x01, 0x01, 0x01, // WebUSB
+}
+
+// fuzzMSOS20 is an MS OS 2.0 descriptor set with a vendor revision and a
+// configuration subset holding a function subset with a compatible ID and
+// a registry property.
+var fuzzMSOS20 = []byte{
+	0x0a, 0x00, 0x00, 0x00, 0x00, 0x00, 0x03, 0x06, 0x50, 0x00, // set header
+	0x06, 0x00, 0x08, 0x00, 0x01, 0x00, // vendor revision
+	0x08, 0x00, 0x01, 0x00, 0x00, 0x00, 0x40, 0x00, // configuration subset
+	0x08, 0x00, 0x02, 0x00, 0x01, 0x00, 0x38, 0x00, // function subset
+	0x14, 0x00, 0x03, 0x00, 0x57, 0x49, 0x4e, 0x55, 0x53, 0x42, 0x00, 0x00,
+	0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, // compatible ID
+	0x1c, 0x00, 0x04, 0x00, 0x01, 0x00, 0x0c, 0x00, 0x4c, 0x00, 0x61, 0x00,
+	0x62, 0x00, 0x65, 0x00, 0x6c, 0x00, 0x00, 0x00, 0x06, 0x00, 0x67, 0x00,
+	0x6f, 0x00, 0x00, 0x00, // registry property
+}
+
+// fuzzCompatID is an MS OS 1.0 extended compat ID descriptor with one
+// function.
+var fuzzCompatID = []byte{
+	0x28, 0x00, 0x00, 0x00, 0x00, 0x01, 0x04, 0x00, 0x01, 0x00, 0x00, 0x00,
+	0x00, 0x00, 0x00, 0x00, // header
+	0x00, 0x01, 0x57, 0x49, 0x4e, 0x55, 0x53, 0x42, 0x00, 0x00, 0x00, 0x00,
+	0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, // function
+}
+
+// fuzzProperties is an MS OS 1.0 extended properties descriptor with one
+// property.
+var fuzzProperties = []byte{
+	0x2a, 0x00, 0x00, 0x00, 0x00, 0x01, 0x05, 0x00, 0x01, 0x00, // header
+	0x20, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x0c, 0x00, 0x4c, 0x00,
+	0x61, 0x00, 0x62, 0x00, 0x65, 0x00, 0x6c, 0x00, 0x00, 0x00, 0x06, 0x00,
+	0x00, 0x00, 0x67, 0x00, 0x6f, 0x00, 0x00, 0x00, // property
+}
+
+func checkDescriptorError(t *testing.T, data []byte, err error) {
+	t.Helper()
+	var de *DescriptorError
+	if !errors.As(err, &de) {
+		t.Fatalf("error %v is not a *DescriptorError", err)
+	}
+	if !errors.Is(err, ErrIO) {
+		t.Fatalf("error %v does not wrap ErrIO", err)
+	}
+	if de.Offset < 0 || de.Offset > len(data) {
+		t.Fatalf("error %v has offset outside the %d bytes of data", err, len(data))
+	}
+}
+
+// FuzzConfigDescriptor checks that parsing a configuration never panics,
+// that failures are reported as a *DescriptorError, and that marshalling
+// what UnmarshalBinary accepts is stable. The seed must round trip exactly.
+func FuzzConfigDescriptor(f *testing.F) {
+	var seed ConfigDescriptor
+	if err := seed.UnmarshalBinary(fuzzConfig); err != nil {
+		f.Fatal(err)
+	}
+	if out, _ := seed.MarshalBinary(); !bytes.Equal(out, fuzzConfig) {
+		f.Fatalf("marshalled % x, want % x", out, fuzzConfig)
+	}
+
+	f.Add(fuzzConfig)
+	f.Add(fuzzConfig[:len(fuzzConfig)-3])
+	f.Add(fuzzConfig[:9])
+	otherSpeed := append([]byte(nil), fuzzConfig...)
+	otherSpeed[1] = byte(LIBUSB_DT_OTHER_SPEED_CONFIGURATION)
+	f.Add(otherSpeed)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var config libusb_config_descriptor
+		left, err := parse_configuration(nil, &config, data, configDescriptorType(data), false)
+		if err != nil {
+			checkDescriptorError(t, data, err)
+			return
+		}
+		if left < 0 || left > len(data) {
+			t.Fatalf("%d bytes left over from %d", left, len(data))
+		}
+		if int(config.bNumInterfaces) != len(config.iface) {
+			t.Fatalf("bNumInterfaces %d with %d interfaces", config.bNumInterfaces, len(config.iface))
+		}
+		for _, iface := range config.iface {
+			for _, alt := range iface.altsetting {
+				if int(alt.bNumEndpoints) != len(alt.endpoint) {
+					t.Fatalf("bNumEndpoints %d with %d endpoints", alt.bNumEndpoints, len(alt.endpoint))
+				}
+				for i := range alt.endpoint {
+					var comp *libusb_ss_endpoint_companion_descriptor
+					libusb_get_ss_endpoint_companion_descriptor(nil, &alt.endpoint[i], &comp)
+				}
+			}
+		}
+
+		var d ConfigDescriptor
+		if err := d.UnmarshalBinary(data); err != nil {
+			checkDescriptorError(t, data, err)
+			return
+		}
+		out, err := d.MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var d2 ConfigDescriptor
+		if err := d2.UnmarshalBinary(out); err != nil {
+			t.Fatalf("unmarshalling % x: %v", out, err)
+		}
+		out2, err := d2.MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(out, out2) {
+			t.Fatalf("marshalled % x, then % x", out, out2)
+		}
+	})
+}
+
+// FuzzBOSDescriptor checks that parsing a BOS descriptor and decoding its
+// capabilities never panics, and that failures are reported as a
+// *DescriptorError.
+func FuzzBOSDescriptor(f *testing.F) {
+	f.Add(fuzzBOS)
+	f.Add(fuzzBOS[:len(fuzzBOS)-4])
+	f.Add(fuzzBOSPlus)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var bos *libusb_bos_descriptor
+		if err := parse_bos(nil, &bos, data, false); err != nil {
+			checkDescriptorError(t, data, err)
+			return
+		}
+		total := int(bos.bLength)
+		for _, dev_cap := range bos.dev_capability {
+			if int(dev_cap.bLength) != LIBUSB_DT_DEVICE_CAPABILITY_SIZE+len(dev_cap.dev_capability_data) {
+				t.Fatalf("capability bLength %d with %d bytes of data", dev_cap.bLength, len(dev_cap.dev_capability_data))
+			}
+			total += int(dev_cap.bLength)
+		}
+		if total > len(data) {
+			t.Fatalf("parsed %d bytes from %d", total, len(data))
+		}
+
+		var d BOSDescriptor
+		if err := d.UnmarshalBinary(data); err != nil {
+			return
+		}
+		for i := range d.Capabilities {
+			c := &d.Capabilities[i]
+			for _, decode := range []func() error{
+				func() error { _, err := c.WirelessUSB(); return err },
+				func() error { _, err := c.USB20Extension(); return err },
+				func() error { _, err := c.SuperSpeed(); return err },
+				func() error { _, err := c.ContainerID(); return err },
+				func() error { _, err := c.Platform(); return err },
+				func() error { _, err := c.SuperSpeedPlus(); return err },
+				func() error { _, err := c.Billboard(); return err },
+				func() error { _, err := c.ConfigurationSummary(); return err },
+				func() error {
+					p, err := c.Platform()
+					if err == nil {
+						_, err = p.WebUSB()
+					}
+					return err
+				},
+				func() error {
+					p, err := c.Platform()
+					if err == nil {
+						_, err = p.MSOS20DescriptorSets()
+					}
+					return err
+				},
+			} {
+				if err := decode(); err != nil && !errors.Is(err, ErrInvalidParam) {
+					checkDescriptorError(t, data, err)
+				}
+			}
+		}
+	})
+}
+
+// FuzzClassDescriptors checks that decoding class specific descriptors in
+// any interface class never panics, and that failures are reported as a
+// *DescriptorError.
+func FuzzClassDescriptors(f *testing.F) {
+	f.Add(uint8(LIBUSB_CLASS_COMM), uint8(0x02), uint8(0x00), false, fuzzConfig[9:])
+	f.Add(uint8(LIBUSB_CLASS_AUDIO), uint8(0x02), uint8(0x20), true, fuzzConfig[9:])
+	f.Fuzz(func(t *testing.T, class, subclass, protocol uint8, endpoint bool, data []byte) {
+		ctx := DescriptorContext{class, subclass, protocol, endpoint}
+		if _, err := DecodeDescriptors(ctx, data); err != nil {
+			checkDescriptorError(t, data, err)
+		}
+	})
+}
+
+// FuzzMSOSDescriptors checks that parsing MS OS 1.0 and 2.0 descriptors
+// never panics, and that failures are reported as a *DescriptorError.
+func FuzzMSOSDescriptors(f *testing.F) {
+	f.Add(fuzzMSOS20)
+	f.Add(fuzzCompatID)
+	f.Add(fuzzProperties)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var str MSOSStringDescriptor
+		var compat ExtendedCompatIDDescriptor
+		var props ExtendedPropertiesDescriptor
+		var set MSOS20DescriptorSet
+		for _, d := range []encoding.BinaryUnmarshaler{&str, &compat, &props, &set} {
+			if err := d.UnmarshalBinary(data); err != nil {
+				checkDescriptorError(t, data, err)
+			}
+		}
+		for i := range props.Properties {
+			props.Properties[i].Value()
+		}
+	})
+}
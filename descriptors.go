@@ -0,0 +1,644 @@
+package usb
+
+import "encoding/binary"
+
+// DeviceDescriptor is the standard device descriptor, see table 9-11 of the
+// USB 3.2 specification.
+type DeviceDescriptor struct {
+	Length            uint8  `json:"bLength"`
+	DescriptorType    uint8  `json:"bDescriptorType"`
+	USBVersion        uint16 `json:"bcdUSB"`
+	DeviceClass       uint8  `json:"bDeviceClass"`
+	DeviceSubClass    uint8  `json:"bDeviceSubClass"`
+	DeviceProtocol    uint8  `json:"bDeviceProtocol"`
+	MaxPacketSize0    uint8  `json:"bMaxPacketSize0"`
+	VendorID          uint16 `json:"idVendor"`
+	ProductID         uint16 `json:"idProduct"`
+	DeviceVersion     uint16 `json:"bcdDevice"`
+	ManufacturerIndex uint8  `json:"iManufacturer"`
+	ProductIndex      uint8  `json:"iProduct"`
+	SerialNumberIndex uint8  `json:"iSerialNumber"`
+	NumConfigurations uint8  `json:"bNumConfigurations"`
+}
+
+// DeviceQualifierDescriptor describes a high-speed capable device as it
+// would be when operating at its other speed, see table 9-9 of the USB 2.0
+// specification.
+type DeviceQualifierDescriptor struct {
+	Length            uint8  `json:"bLength"`
+	DescriptorType    uint8  `json:"bDescriptorType"`
+	USBVersion        uint16 `json:"bcdUSB"`
+	DeviceClass       uint8  `json:"bDeviceClass"`
+	DeviceSubClass    uint8  `json:"bDeviceSubClass"`
+	DeviceProtocol    uint8  `json:"bDeviceProtocol"`
+	MaxPacketSize0    uint8  `json:"bMaxPacketSize0"`
+	NumConfigurations uint8  `json:"bNumConfigurations"`
+	Reserved          uint8  `json:"bReserved"`
+}
+
+// ConfigDescriptor is a configuration descriptor together with the
+// interface, endpoint and class or vendor specific descriptors that follow
+// it, as returned by GET_DESCRIPTOR(CONFIGURATION). An other speed
+// configuration descriptor, which has the same layout, is held in a
+// ConfigDescriptor with a DescriptorType of 7.
+type ConfigDescriptor struct {
+	Length             uint8  `json:"bLength"`
+	DescriptorType     uint8  `json:"bDescriptorType"`
+	TotalLength        uint16 `json:"wTotalLength"`
+	NumInterfaces      uint8  `json:"bNumInterfaces"`
+	ConfigurationValue uint8  `json:"bConfigurationValue"`
+	ConfigurationIndex uint8  `json:"iConfiguration"`
+	Attributes         uint8  `json:"bmAttributes"`
+	MaxPower           uint8  `json:"bMaxPower"`
+
+	// Interfaces holds the alternate settings of each interface, in the
+	// order they appear.
+	Interfaces [][]InterfaceDescriptor `json:"interfaces"`
+
+	// Extra holds the class or vendor specific descriptors between the
+	// configuration descriptor and the first interface.
+	Extra []byte `json:"extra,omitempty"`
+
+	// Trailing holds the bytes past the standard fields of a descriptor
+	// whose Length is longer than the standard size.
+	Trailing []byte `json:"trailing,omitempty"`
+}
+
+// InterfaceDescriptor is the descriptor of one alternate setting of an
+// interface, together with its endpoints.
+type InterfaceDescriptor struct {
+	Length            uint8 `json:"bLength"`
+	DescriptorType    uint8 `json:"bDescriptorType"`
+	InterfaceNumber   uint8 `json:"bInterfaceNumber"`
+	AlternateSetting  uint8 `json:"bAlternateSetting"`
+	NumEndpoints      uint8 `json:"bNumEndpoints"`
+	InterfaceClass    uint8 `json:"bInterfaceClass"`
+	InterfaceSubClass uint8 `json:"bInterfaceSubClass"`
+	InterfaceProtocol uint8 `json:"bInterfaceProtocol"`
+	InterfaceIndex    uint8 `json:"iInterface"`
+
+	Endpoints []EndpointDescriptor `json:"endpoints"`
+
+	// Extra holds the class or vendor specific descriptors between the
+	// interface descriptor and its first endpoint.
+	Extra []byte `json:"extra,omitempty"`
+
+	// Trailing holds the bytes past the standard fields of a descriptor
+	// whose Length is longer than the standard size.
+	Trailing []byte `json:"trailing,omitempty"`
+}
+
+// EndpointDescriptor is an endpoint descriptor. Refresh and SynchAddress
+// are only present in the 9 byte descriptors of audio class endpoints.
+type EndpointDescriptor struct {
+	Length          uint8  `json:"bLength"`
+	DescriptorType  uint8  `json:"bDescriptorType"`
+	EndpointAddress uint8  `json:"bEndpointAddress"`
+	Attributes      uint8  `json:"bmAttributes"`
+	MaxPacketSize   uint16 `json:"wMaxPacketSize"`
+	Interval        uint8  `json:"bInterval"`
+	Refresh         uint8  `json:"bRefresh"`
+	SynchAddress    uint8  `json:"bSynchAddress"`
+
+	// Extra holds the class or vendor specific descriptors that follow the
+	// endpoint descriptor, such as a SuperSpeed endpoint companion.
+	Extra []byte `json:"extra,omitempty"`
+
+	// Trailing holds the bytes past the standard fields of a descriptor
+	// whose Length is longer than the standard size.
+	Trailing []byte `json:"trailing,omitempty"`
+}
+
+// BOSDescriptor is the Binary device Object Store descriptor together with
+// the device capabilities that follow it. Devices that report a bcdUSB of
+// 2.01 or above have one.
+type BOSDescriptor struct {
+	Length         uint8  `json:"bLength"`
+	DescriptorType uint8  `json:"bDescriptorType"`
+	TotalLength    uint16 `json:"wTotalLength"`
+	NumDeviceCaps  uint8  `json:"bNumDeviceCaps"`
+
+	Capabilities []DeviceCapability `json:"capabilities"`
+}
+
+// DeviceCapability is a device capability descriptor from a BOS
+// descriptor. Data holds the fields that follow bDevCapabilityType.
+type DeviceCapability struct {
+	Length         uint8  `json:"bLength"`
+	DescriptorType uint8  `json:"bDescriptorType"`
+	CapabilityType uint8  `json:"bDevCapabilityType"`
+	Data           []byte `json:"data"`
+}
+
+// descriptorLength returns the bLength a descriptor is marshalled with: its
+// Length, or size if that is unset or too small to hold the fixed fields.
+// A size that does not fit bLength is reported as an overflow of op.
+func descriptorLength(op string, length uint8, size int) (int, error) {
+	if size > 0xff {
+		return 0, codeError(op, LIBUSB_ERROR_OVERFLOW)
+	}
+	if int(length) < size {
+		return size, nil
+	}
+	return int(length), nil
+}
+
+func newDeviceDescriptor(d *libusb_device_descriptor) DeviceDescriptor {
+	return DeviceDescriptor{
+		Length:            d.bLength,
+		DescriptorType:    d.bDescriptorType,
+		USBVersion:        d.bcdUSB,
+		DeviceClass:       d.bDeviceClass,
+		DeviceSubClass:    d.bDeviceSubClass,
+		DeviceProtocol:    d.bDeviceProtocol,
+		MaxPacketSize0:    d.bMaxPacketSize0,
+		VendorID:          d.idVendor,
+		ProductID:         d.idProduct,
+		DeviceVersion:     d.bcdDevice,
+		ManufacturerIndex: d.iManufacturer,
+		ProductIndex:      d.iProduct,
+		SerialNumberIndex: d.iSerialNumber,
+		NumConfigurations: d.bNumConfigurations,
+	}
+}
+
+func newConfigDescriptor(c *libusb_config_descriptor) *ConfigDescriptor {
+	d := &ConfigDescriptor{
+		Length:             c.bLength,
+		DescriptorType:     c.bDescriptorType,
+		TotalLength:        c.wTotalLength,
+		NumInterfaces:      c.bNumInterfaces,
+		ConfigurationValue: c.bConfigurationValue,
+		ConfigurationIndex: c.iConfiguration,
+		Attributes:         c.bmAttributes,
+		MaxPower:           c.MaxPower,
+		Interfaces:         make([][]InterfaceDescriptor, len(c.iface)),
+		Extra:              c.extra,
+		Trailing:           c.tail,
+	}
+	for i, iface := range c.iface {
+		d.Interfaces[i] = make([]InterfaceDescriptor, len(iface.altsetting))
+		for j := range iface.altsetting {
+			d.Interfaces[i][j] = newInterfaceDescriptor(&iface.altsetting[j])
+		}
+	}
+	return d
+}
+
+func newInterfaceDescriptor(a *libusb_interface_descriptor) InterfaceDescriptor {
+	d := InterfaceDescriptor{
+		Length:            a.bLength,
+		DescriptorType:    a.bDescriptorType,
+		InterfaceNumber:   a.bInterfaceNumber,
+		AlternateSetting:  a.bAlternateSetting,
+		NumEndpoints:      a.bNumEndpoints,
+		InterfaceClass:    a.bInterfaceClass,
+		InterfaceSubClass: a.bInterfaceSubClass,
+		InterfaceProtocol: a.bInterfaceProtocol,
+		InterfaceIndex:    a.iInterface,
+		Endpoints:         make([]EndpointDescriptor, len(a.endpoint)),
+		Extra:             a.extra,
+		Trailing:          a.tail,
+	}
+	for i := range a.endpoint {
+		d.Endpoints[i] = newEndpointDescriptor(&a.endpoint[i])
+	}
+	return d
+}
+
+func newEndpointDescriptor(e *libusb_endpoint_descriptor) EndpointDescriptor {
+	return EndpointDescriptor{
+		Length:          e.bLength,
+		DescriptorType:  e.bDescriptorType,
+		EndpointAddress: e.bEndpointAddress,
+		Attributes:      e.bmAttributes,
+		MaxPacketSize:   e.wMaxPacketSize,
+		Interval:        e.bInterval,
+		Refresh:         e.bRefresh,
+		SynchAddress:    e.bSynchAddress,
+		Extra:           e.extra,
+		Trailing:        e.tail,
+	}
+}
+
+func newDeviceQualifierDescriptor(q *libusb_device_qualifier_descriptor) *DeviceQualifierDescriptor {
+	return &DeviceQualifierDescriptor{
+		Length:            q.bLength,
+		DescriptorType:    q.bDescriptorType,
+		USBVersion:        q.bcdUSB,
+		DeviceClass:       q.bDeviceClass,
+		DeviceSubClass:    q.bDeviceSubClass,
+		DeviceProtocol:    q.bDeviceProtocol,
+		MaxPacketSize0:    q.bMaxPacketSize0,
+		NumConfigurations: q.bNumConfigurations,
+		Reserved:          q.bReserved,
+	}
+}
+
+func newBOSDescriptor(b *libusb_bos_descriptor) *BOSDescriptor {
+	d := &BOSDescriptor{
+		Length:         b.bLength,
+		DescriptorType: b.bDescriptorType,
+		TotalLength:    b.wTotalLength,
+		NumDeviceCaps:  b.bNumDeviceCaps,
+		Capabilities:   make([]DeviceCapability, len(b.dev_capability)),
+	}
+	for i, c := range b.dev_capability {
+		d.Capabilities[i] = DeviceCapability{
+			Length:         c.bLength,
+			DescriptorType: c.bDescriptorType,
+			CapabilityType: uint8(c.bDevCapabilityType),
+			Data:           c.dev_capability_data,
+		}
+	}
+	return d
+}
+
+// MarshalBinary encodes the descriptor as the device sends it. A zero
+// Length is encoded as the standard 18 bytes.
+func (d *DeviceDescriptor) MarshalBinary() ([]byte, error) {
+	n, err := descriptorLength("marshal device descriptor", d.Length, LIBUSB_DT_DEVICE_SIZE)
+	if err != nil {
+		return nil, err
+	}
+	buf := make([]byte, n)
+	buf[0] = uint8(n)
+	buf[1] = d.DescriptorType
+	binary.LittleEndian.PutUint16(buf[2:], d.USBVersion)
+	buf[4] = d.DeviceClass
+	buf[5] = d.DeviceSubClass
+	buf[6] = d.DeviceProtocol
+	buf[7] = d.MaxPacketSize0
+	binary.LittleEndian.PutUint16(buf[8:], d.VendorID)
+	binary.LittleEndian.PutUint16(buf[10:], d.ProductID)
+	binary.LittleEndian.PutUint16(buf[12:], d.DeviceVersion)
+	buf[14] = d.ManufacturerIndex
+	buf[15] = d.ProductIndex
+	buf[16] = d.SerialNumberIndex
+	buf[17] = d.NumConfigurations
+	return buf, nil
+}
+
+// UnmarshalBinary decodes a device descriptor.
+func (d *DeviceDescriptor) UnmarshalBinary(data []byte) error {
+	const op, path = "parse device descriptor", "device"
+	p := new_descriptor_parser(data, false)
+	if err := p.parse_header(0, len(data), path, LIBUSB_DT_DEVICE, LIBUSB_DT_DEVICE_SIZE); err != nil {
+		return descriptorError(op, err)
+	}
+	if n := int(data[0]); n != len(data) {
+		return descriptorError(op, p.trailing(n, path))
+	}
+	var desc libusb_device_descriptor
+	parse_device_descriptor(&desc, data, false)
+	*d = newDeviceDescriptor(&desc)
+	return nil
+}
+
+// MarshalBinary encodes the descriptor as the device sends it. A zero
+// Length is encoded as the standard 10 bytes.
+func (d *DeviceQualifierDescriptor) MarshalBinary() ([]byte, error) {
+	n, err := descriptorLength("marshal device qualifier descriptor", d.Length, LIBUSB_DT_DEVICE_QUALIFIER_SIZE)
+	if err != nil {
+		return nil, err
+	}
+	buf := make([]byte, n)
+	buf[0] = uint8(n)
+	buf[1] = d.DescriptorType
+	binary.LittleEndian.PutUint16(buf[2:], d.USBVersion)
+	buf[4] = d.DeviceClass
+	buf[5] = d.DeviceSubClass
+	buf[6] = d.DeviceProtocol
+	buf[7] = d.MaxPacketSize0
+	buf[8] = d.NumConfigurations
+	buf[9] = d.Reserved
+	return buf, nil
+}
+
+// UnmarshalBinary decodes a device qualifier descriptor.
+func (d *DeviceQualifierDescriptor) UnmarshalBinary(data []byte) error {
+	const op, path = "parse device qualifier descriptor", "qualifier"
+	var desc libusb_device_qualifier_descriptor
+	if err := parse_device_qualifier_descriptor(&desc, data); err != nil {
+		return descriptorError(op, err)
+	}
+	if n := int(data[0]); n != len(data) {
+		return descriptorError(op, new_descriptor_parser(data, false).trailing(n, path))
+	}
+	*d = *newDeviceQualifierDescriptor(&desc)
+	return nil
+}
+
+// MarshalBinary encodes the configuration descriptor and everything that
+// follows it. TotalLength, NumInterfaces and the NumEndpoints of each
+// interface are recomputed from the contents, so that a descriptor can be
+// edited before it is encoded. Unmarshalling and then marshalling a
+// descriptor reproduces it exactly, unless one of its counts disagrees with
+// its contents.
+func (d *ConfigDescriptor) MarshalBinary() ([]byte, error) {
+	const op = "marshal config descriptor"
+	n, err := descriptorLength(op, d.Length, LIBUSB_DT_CONFIG_SIZE+len(d.Trailing))
+	if err != nil {
+		return nil, err
+	}
+	buf := make([]byte, n)
+	copy(buf[LIBUSB_DT_CONFIG_SIZE:], d.Trailing)
+	buf[0] = uint8(n)
+	buf[1] = d.DescriptorType
+	buf[4] = uint8(len(d.Interfaces))
+	buf[5] = d.ConfigurationValue
+	buf[6] = d.ConfigurationIndex
+	buf[7] = d.Attributes
+	buf[8] = d.MaxPower
+	buf = append(buf, d.Extra...)
+	for _, alts := range d.Interfaces {
+		for i := range alts {
+			if buf, err = alts[i].appendBinary(buf); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if len(buf) > 0xffff {
+		return nil, codeError(op, LIBUSB_ERROR_OVERFLOW)
+	}
+	binary.LittleEndian.PutUint16(buf[2:], uint16(len(buf)))
+	return buf, nil
+}
+
+// UnmarshalBinary decodes a configuration descriptor, or an other speed
+// configuration descriptor, and everything that follows it. data must hold
+// exactly the descriptors that make up the configuration.
+func (d *ConfigDescriptor) UnmarshalBinary(data []byte) error {
+	const op, path = "parse config descriptor", "config"
+	var config libusb_config_descriptor
+	left, err := parse_configuration(nil, &config, data, configDescriptorType(data), false)
+	if err != nil {
+		return descriptorError(op, err)
+	}
+	p := new_descriptor_parser(data, false)
+	if left != 0 {
+		return descriptorError(op, p.trailing(len(data)-left, path))
+	}
+	if n := int(config.wTotalLength); n != len(data) {
+		return descriptorError(op, p.errorf(2, path, "wTotalLength %d does not match the %d bytes of data", n, len(data)))
+	}
+	*d = *newConfigDescriptor(&config)
+	return nil
+}
+
+// MarshalBinary encodes the interface descriptor followed by its extra
+// descriptors and endpoints. NumEndpoints is recomputed from Endpoints.
+func (d *InterfaceDescriptor) MarshalBinary() ([]byte, error) {
+	return d.appendBinary(nil)
+}
+
+func (d *InterfaceDescriptor) appendBinary(buf []byte) ([]byte, error) {
+	n, err := descriptorLength("marshal interface descriptor", d.Length, INTERFACE_DESC_LENGTH+len(d.Trailing))
+	if err != nil {
+		return nil, err
+	}
+	b := make([]byte, n)
+	copy(b[INTERFACE_DESC_LENGTH:], d.Trailing)
+	b[0] = uint8(n)
+	b[1] = d.DescriptorType
+	b[2] = d.InterfaceNumber
+	b[3] = d.AlternateSetting
+	b[4] = uint8(len(d.Endpoints))
+	b[5] = d.InterfaceClass
+	b[6] = d.InterfaceSubClass
+	b[7] = d.InterfaceProtocol
+	b[8] = d.InterfaceIndex
+	buf = append(buf, b...)
+	buf = append(buf, d.Extra...)
+	for i := range d.Endpoints {
+		if buf, err = d.Endpoints[i].appendBinary(buf); err != nil {
+			return nil, err
+		}
+	}
+	return buf, nil
+}
+
+// UnmarshalBinary decodes one interface descriptor followed by its extra
+// descriptors and endpoints. data must not hold any other alternate
+// settings.
+func (d *InterfaceDescriptor) UnmarshalBinary(data []byte) error {
+	const op, path = "parse interface descriptor", "interface"
+	p := new_descriptor_parser(data, false)
+	if err := p.parse_header(0, len(data), path, LIBUSB_DT_INTERFACE, INTERFACE_DESC_LENGTH); err != nil {
+		return descriptorError(op, err)
+	}
+	var iface libusb_interface
+	n, err := p.parse_interface(nil, &iface, 0, len(data), path)
+	if err != nil {
+		return descriptorError(op, err)
+	}
+	if iface.num_altsetting != 1 {
+		return descriptorError(op, p.errorf(0, path, "%d alternate settings, expected 1", iface.num_altsetting))
+	}
+	if n != len(data) {
+		return descriptorError(op, p.trailing(n, path))
+	}
+	*d = newInterfaceDescriptor(&iface.altsetting[0])
+	return nil
+}
+
+// MarshalBinary encodes the endpoint descriptor followed by its extra
+// descriptors. A zero Length is encoded as the standard 7 bytes, leaving
+// out Refresh and SynchAddress.
+func (d *EndpointDescriptor) MarshalBinary() ([]byte, error) {
+	return d.appendBinary(nil)
+}
+
+func (d *EndpointDescriptor) appendBinary(buf []byte) ([]byte, error) {
+	std := ENDPOINT_DESC_LENGTH
+	if d.Length >= ENDPOINT_AUDIO_DESC_LENGTH {
+		std = ENDPOINT_AUDIO_DESC_LENGTH
+	}
+	n, err := descriptorLength("marshal endpoint descriptor", d.Length, std+len(d.Trailing))
+	if err != nil {
+		return nil, err
+	}
+	b := make([]byte, n)
+	b[0] = uint8(n)
+	b[1] = d.DescriptorType
+	b[2] = d.EndpointAddress
+	b[3] = d.Attributes
+	binary.LittleEndian.PutUint16(b[4:], d.MaxPacketSize)
+	b[6] = d.Interval
+	if std == ENDPOINT_AUDIO_DESC_LENGTH {
+		b[7] = d.Refresh
+		b[8] = d.SynchAddress
+	}
+	copy(b[std:], d.Trailing)
+	buf = append(buf, b...)
+	return append(buf, d.Extra...), nil
+}
+
+// UnmarshalBinary decodes one endpoint descriptor followed by its extra
+// descriptors.
+func (d *EndpointDescriptor) UnmarshalBinary(data []byte) error {
+	const op, path = "parse endpoint descriptor", "endpoint"
+	p := new_descriptor_parser(data, false)
+	if err := p.parse_header(0, len(data), path, LIBUSB_DT_ENDPOINT, ENDPOINT_DESC_LENGTH); err != nil {
+		return descriptorError(op, err)
+	}
+	var ep libusb_endpoint_descriptor
+	n, err := p.parse_endpoint(nil, &ep, 0, len(data), path)
+	if err != nil {
+		return descriptorError(op, err)
+	}
+	if n != len(data) {
+		return descriptorError(op, p.trailing(n, path))
+	}
+	*d = newEndpointDescriptor(&ep)
+	return nil
+}
+
+// MarshalBinary encodes the BOS descriptor and its device capabilities.
+// TotalLength, NumDeviceCaps and the Length of each capability are
+// recomputed from the contents.
+func (d *BOSDescriptor) MarshalBinary() ([]byte, error) {
+	const op = "marshal bos descriptor"
+	n, err := descriptorLength(op, d.Length, LIBUSB_DT_BOS_SIZE)
+	if err != nil {
+		return nil, err
+	}
+	buf := make([]byte, n)
+	buf[0] = uint8(n)
+	buf[1] = d.DescriptorType
+	buf[4] = uint8(len(d.Capabilities))
+	for _, c := range d.Capabilities {
+		n := LIBUSB_DT_DEVICE_CAPABILITY_SIZE + len(c.Data)
+		if n > 0xff {
+			return nil, codeError(op, LIBUSB_ERROR_OVERFLOW)
+		}
+		buf = append(buf, uint8(n), c.DescriptorType, c.CapabilityType)
+		buf = append(buf, c.Data...)
+	}
+	if len(buf) > 0xffff || len(d.Capabilities) > 0xff {
+		return nil, codeError(op, LIBUSB_ERROR_OVERFLOW)
+	}
+	binary.LittleEndian.PutUint16(buf[2:], uint16(len(buf)))
+	return buf, nil
+}
+
+// UnmarshalBinary decodes a BOS descriptor and its device capabilities.
+// data must hold exactly the descriptors that make up the BOS.
+func (d *BOSDescriptor) UnmarshalBinary(data []byte) error {
+	const op, path = "parse bos descriptor", "bos"
+	var bos *libusb_bos_descriptor
+	if err := parse_bos(nil, &bos, data, false); err != nil {
+		return descriptorError(op, err)
+	}
+	p := new_descriptor_parser(data, false)
+	n := int(bos.bLength)
+	for _, c := range bos.dev_capability {
+		n += int(c.bLength)
+	}
+	if n != len(data) {
+		return descriptorError(op, p.trailing(n, path))
+	}
+	if n := int(bos.wTotalLength); n != len(data) {
+		return descriptorError(op, p.errorf(2, path, "wTotalLength %d does not match the %d bytes of data", n, len(data)))
+	}
+	*d = *newBOSDescriptor(bos)
+	return nil
+}
+
+// Descriptor returns the device descriptor, which is cached when the device
+// is enumerated.
+func (d *Device) Descriptor() DeviceDescriptor {
+	desc := libusb_get_device_descriptor(d.dev)
+	return newDeviceDescriptor(&desc)
+}
+
+// ConfigDescriptor reads the configuration descriptor at an index, from 0
+// to NumConfigurations-1.
+func (d *Device) ConfigDescriptor(index int) (*ConfigDescriptor, error) {
+	const op = "get config descriptor"
+	if index < 0 || index > 0xff {
+		return nil, d.codeError(op, LIBUSB_ERROR_NOT_FOUND)
+	}
+	buf, hostEndian, r := read_config_descriptor(d.dev, uint8(index))
+	return d.configDescriptor(op, buf, LIBUSB_DT_CONFIG, hostEndian, r)
+}
+
+// ActiveConfigDescriptor reads the descriptor of the active configuration.
+func (d *Device) ActiveConfigDescriptor() (*ConfigDescriptor, error) {
+	buf, hostEndian, r := read_active_config_descriptor(d.dev)
+	return d.configDescriptor("get active config descriptor", buf, LIBUSB_DT_CONFIG, hostEndian, r)
+}
+
+// ConfigDescriptorByValue reads the descriptor of the configuration with the
+// given bConfigurationValue.
+func (d *Device) ConfigDescriptorByValue(value uint8) (*ConfigDescriptor, error) {
+	buf, hostEndian, r := read_config_descriptor_by_value(d.dev, value)
+	return d.configDescriptor("get config descriptor by value", buf, LIBUSB_DT_CONFIG, hostEndian, r)
+}
+
+// configDescriptorType returns the type of the configuration descriptor in
+// data, for callers that accept either kind.
+func configDescriptorType(data []byte) libusb_descriptor_type {
+	if len(data) >= DESC_HEADER_LENGTH &&
+		libusb_descriptor_type(data[1]) == LIBUSB_DT_OTHER_SPEED_CONFIGURATION {
+		return LIBUSB_DT_OTHER_SPEED_CONFIGURATION
+	}
+	return LIBUSB_DT_CONFIG
+}
+
+// configDescriptor parses a configuration of descType read from the device.
+// Data left over after the last interface is ignored, as libusb does.
+func (d *Device) configDescriptor(op string, buf []byte, descType libusb_descriptor_type, hostEndian bool, r libusb_error) (*ConfigDescriptor, error) {
+	if r < 0 {
+		return nil, d.codeError(op, r)
+	}
+	var config libusb_config_descriptor
+	if _, err := parse_configuration(d.dev.ctx, &config, buf, descType, hostEndian); err != nil {
+		return nil, d.descriptorError(op, err)
+	}
+	return newConfigDescriptor(&config), nil
+}
+
+// BOSDescriptor reads the BOS descriptor. Devices without one fail the
+// request with ErrPipe.
+func (h *DeviceHandle) BOSDescriptor() (*BOSDescriptor, error) {
+	const op = "get bos descriptor"
+	buf, r := read_bos_descriptor(h.handle)
+	if r < 0 {
+		return nil, h.codeError(op, r)
+	}
+	var bos *libusb_bos_descriptor
+	if err := parse_bos(h.dev.dev.ctx, &bos, buf, false); err != nil {
+		return nil, h.dev.descriptorError(op, err)
+	}
+	return newBOSDescriptor(bos), nil
+}
+
+// DeviceQualifierDescriptor reads the device qualifier descriptor. Devices
+// that are not high-speed capable fail the request with ErrPipe.
+func (h *DeviceHandle) DeviceQualifierDescriptor() (*DeviceQualifierDescriptor, error) {
+	const op = "get device qualifier descriptor"
+	buf := make([]byte, LIBUSB_DT_DEVICE_QUALIFIER_SIZE)
+	r := libusb_get_descriptor(h.handle, LIBUSB_DT_DEVICE_QUALIFIER, 0, buf, uint16(len(buf)))
+	if r < 0 {
+		return nil, h.codeError(op, r)
+	}
+	var desc libusb_device_qualifier_descriptor
+	if err := parse_device_qualifier_descriptor(&desc, buf[:r]); err != nil {
+		return nil, h.dev.descriptorError(op, err)
+	}
+	return newDeviceQualifierDescriptor(&desc), nil
+}
+
+// OtherSpeedConfigDescriptor reads the configuration at an index, from 0 to
+// the NumConfigurations of the device qualifier less one, as it would be
+// when the device operates at its other speed. Devices that are not
+// high-speed capable fail the request with ErrPipe.
+func (h *DeviceHandle) OtherSpeedConfigDescriptor(index int) (*ConfigDescriptor, error) {
+	const op = "get other speed config descriptor"
+	if index < 0 || index > 0xff {
+		return nil, h.codeError(op, LIBUSB_ERROR_NOT_FOUND)
+	}
+	buf, r := read_other_speed_config_descriptor(h.handle, uint8(index))
+	return h.dev.configDescriptor(op, buf, LIBUSB_DT_OTHER_SPEED_CONFIGURATION, false, r)
+}
@@ -0,0 +1,179 @@
+package usb
+
+import (
+	"bytes"
+	"encoding"
+	"errors"
+	"testing"
+)
+
+// testQualifier is the device qualifier of a high-speed device with one
+// configuration at full speed.
+var testQualifier = []byte{0x0a, 0x06, 0x00, 0x02, 0x00, 0x00, 0x00, 0x40, 0x01, 0x00}
+
+// longConfig is a configuration whose standard descriptors are all longer
+// than their standard size.
+var longConfig = []byte{
+	0x0a, 0x02, 0x2b, 0x00, 0x01, 0x01, 0x00, 0x80, 0x32, 0xc1, // configuration
+	0x0b, 0x04, 0x00, 0x00, 0x02, 0xff, 0x00, 0x00, 0x00, 0xc2, 0xc3, // interface 0
+	0x04, 0x24, 0x01, 0x02, // class specific
+	0x08, 0x05, 0x81, 0x02, 0x40, 0x00, 0x00, 0xe1, // bulk IN
+	0x0a, 0x05, 0x02, 0x01, 0x40, 0x00, 0x01, 0x00, 0x83, 0xe2, // iso OUT
+}
+
+type binaryDescriptor interface {
+	encoding.BinaryMarshaler
+	encoding.BinaryUnmarshaler
+}
+
+func TestDescriptorRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		desc binaryDescriptor
+		data []byte
+	}{
+		{"device", new(DeviceDescriptor), testDeviceDescriptor},
+		{"qualifier", new(DeviceQualifierDescriptor), testQualifier},
+		{"config", new(ConfigDescriptor), simTestConfig},
+		{"config with alternate settings", new(ConfigDescriptor), fuzzConfig},
+		{"long config", new(ConfigDescriptor), longConfig},
+		{"long interface", new(InterfaceDescriptor), longConfig[10:]},
+		{"short audio endpoint", new(EndpointDescriptor), longConfig[25:33]},
+		{"long audio endpoint", new(EndpointDescriptor), longConfig[33:]},
+	}
+	for _, tt := range tests {
+		if err := tt.desc.UnmarshalBinary(tt.data); err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		out, err := tt.desc.MarshalBinary()
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if !bytes.Equal(out, tt.data) {
+			t.Errorf("%s: marshalled\n% x\nwant\n% x", tt.name, out, tt.data)
+		}
+	}
+}
+
+func TestDescriptorTrailing(t *testing.T) {
+	var c ConfigDescriptor
+	if err := c.UnmarshalBinary(longConfig); err != nil {
+		t.Fatal(err)
+	}
+	alt := &c.Interfaces[0][0]
+	in, out := &alt.Endpoints[0], &alt.Endpoints[1]
+	checks := []struct {
+		name      string
+		got, want []byte
+	}{
+		{"config", c.Trailing, []byte{0xc1}},
+		{"interface", alt.Trailing, []byte{0xc2, 0xc3}},
+		{"interface extra", alt.Extra, []byte{0x04, 0x24, 0x01, 0x02}},
+		{"8 byte endpoint", in.Trailing, []byte{0xe1}},
+		{"10 byte endpoint", out.Trailing, []byte{0xe2}},
+	}
+	for _, ck := range checks {
+		if !bytes.Equal(ck.got, ck.want) {
+			t.Errorf("%s trailing bytes % x, want % x", ck.name, ck.got, ck.want)
+		}
+	}
+	if out.SynchAddress != 0x83 {
+		t.Errorf("bSynchAddress 0x%02x, want 0x83", out.SynchAddress)
+	}
+
+	/* counts and lengths follow edits */
+	alt.Endpoints = alt.Endpoints[:1]
+	in.Trailing = nil
+	data, err := c.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var edited ConfigDescriptor
+	if err := edited.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+	if n := int(edited.TotalLength); n != len(longConfig)-10 {
+		t.Errorf("wTotalLength %d after the edit, want %d", n, len(longConfig)-10)
+	}
+	e := edited.Interfaces[0][0]
+	if e.NumEndpoints != 1 || e.Endpoints[0].Length != 8 || !bytes.Equal(e.Endpoints[0].Trailing, []byte{0}) {
+		t.Errorf("edited endpoints %+v", e.Endpoints)
+	}
+}
+
+func TestDescriptorTrailingOverflow(t *testing.T) {
+	trailing := func(n int) []byte { return bytes.Repeat([]byte{0xee}, n) }
+	tests := []struct {
+		name string
+		desc func(tail []byte) binaryDescriptor
+		size int
+	}{
+		{"config", func(tail []byte) binaryDescriptor {
+			return &ConfigDescriptor{DescriptorType: uint8(LIBUSB_DT_CONFIG), Trailing: tail}
+		}, LIBUSB_DT_CONFIG_SIZE},
+		{"interface", func(tail []byte) binaryDescriptor {
+			return &InterfaceDescriptor{DescriptorType: uint8(LIBUSB_DT_INTERFACE), Trailing: tail}
+		}, INTERFACE_DESC_LENGTH},
+		{"endpoint", func(tail []byte) binaryDescriptor {
+			return &EndpointDescriptor{DescriptorType: uint8(LIBUSB_DT_ENDPOINT), Trailing: tail}
+		}, ENDPOINT_DESC_LENGTH},
+		{"endpoint in a config", func(tail []byte) binaryDescriptor {
+			return &ConfigDescriptor{DescriptorType: uint8(LIBUSB_DT_CONFIG), Interfaces: [][]InterfaceDescriptor{{{
+				DescriptorType: uint8(LIBUSB_DT_INTERFACE),
+				Endpoints:      []EndpointDescriptor{{DescriptorType: uint8(LIBUSB_DT_ENDPOINT), Trailing: tail}},
+			}}}}
+		}, ENDPOINT_DESC_LENGTH},
+	}
+	for _, tt := range tests {
+		/* the longest trailing bytes that fit bLength round trip */
+		data, err := tt.desc(trailing(0xff - tt.size)).MarshalBinary()
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		got := tt.desc(nil)
+		if err := got.UnmarshalBinary(data); err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if again, err := got.MarshalBinary(); err != nil || !bytes.Equal(again, data) {
+			t.Errorf("%s: marshalled\n% x, %v\nwant\n% x", tt.name, again, err, data)
+		}
+
+		if _, err := tt.desc(trailing(250)).MarshalBinary(); !errors.Is(err, ErrOverflow) {
+			t.Errorf("%s: 250 trailing bytes marshalled with %v, want ErrOverflow", tt.name, err)
+		}
+	}
+}
+
+func TestDeviceQualifierDescriptor(t *testing.T) {
+	var q DeviceQualifierDescriptor
+	if err := q.UnmarshalBinary(testQualifier); err != nil {
+		t.Fatal(err)
+	}
+	if q.USBVersion != 0x0200 || q.MaxPacketSize0 != 64 || q.NumConfigurations != 1 {
+		t.Errorf("decoded %+v", q)
+	}
+
+	bad := func(edit func(b []byte) []byte) []byte {
+		return edit(append([]byte(nil), testQualifier...))
+	}
+	tests := []struct {
+		name   string
+		data   []byte
+		offset int
+	}{
+		{"short", testQualifier[:9], 0},
+		{"device descriptor", testDeviceDescriptor, 1},
+		{"wrong type", bad(func(b []byte) []byte { b[1] = 0x01; return b }), 1},
+		{"short bLength", bad(func(b []byte) []byte { b[0] = 9; return b }), 0},
+	}
+	for _, tt := range tests {
+		var derr *DescriptorError
+		if err := q.UnmarshalBinary(tt.data); !errors.As(err, &derr) || derr.Offset != tt.offset {
+			t.Errorf("%s: returned %v, want a *DescriptorError at offset %d", tt.name, err, tt.offset)
+		}
+	}
+}
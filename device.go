@@ -0,0 +1,163 @@
+package usb
+
+import "fmt"
+
+// Speed is the negotiated connection speed of a device.
+type Speed uint8
+
+const (
+	SpeedUnknown = Speed(LIBUSB_SPEED_UNKNOWN)
+	SpeedLow     = Speed(LIBUSB_SPEED_LOW)
+	SpeedFull    = Speed(LIBUSB_SPEED_FULL)
+	SpeedHigh    = Speed(LIBUSB_SPEED_HIGH)
+	SpeedSuper   = Speed(LIBUSB_SPEED_SUPER)
+)
+
+func (s Speed) String() string {
+	switch s {
+	case SpeedLow:
+		return "1.5 Mbit/s"
+	case SpeedFull:
+		return "12 Mbit/s"
+	case SpeedHigh:
+		return "480 Mbit/s"
+	case SpeedSuper:
+		return "5 Gbit/s"
+	}
+	return "unknown speed"
+}
+
+// A Device is a USB device attached to the system. It is not open; call
+// Open to obtain a DeviceHandle for I/O. A Device holds a reference on the
+// underlying libusb_device which is released by Close.
+type Device struct {
+	ctx *Context
+	dev *libusb_device
+}
+
+func newDevice(ctx *Context, dev *libusb_device) *Device {
+	return &Device{
+		ctx: ctx,
+		dev: libusb_ref_device(dev),
+	}
+}
+
+// Close releases the reference this Device holds. It does not affect any
+// DeviceHandle previously opened from it.
+func (d *Device) Close() error {
+	if d.dev == nil {
+		return nil
+	}
+	libusb_unref_device(d.dev)
+	d.dev = nil
+	return nil
+}
+
+// Context returns the session this device was enumerated from.
+func (d *Device) Context() *Context {
+	return d.ctx
+}
+
+// Bus returns the number of the bus the device is connected to.
+func (d *Device) Bus() int {
+	return int(libusb_get_bus_number(d.dev))
+}
+
+// Address returns the address of the device on its bus.
+func (d *Device) Address() int {
+	return int(libusb_get_device_address(d.dev))
+}
+
+// Port returns the number of the port the device is connected to, or 0
+// if it is not known.
+func (d *Device) Port() int {
+	return int(libusb_get_port_number(d.dev))
+}
+
+// PortNumbers returns the port numbers from the root hub to the device.
+func (d *Device) PortNumbers() ([]int, error) {
+	/* As per the USB 3.0 specs, the current maximum limit for the depth is 7 */
+	var buf [7]uint8
+	n := libusb_get_port_numbers(d.dev, buf[:])
+	if n < 0 {
+		return nil, d.codeError("get port numbers", libusb_error(n))
+	}
+	ports := make([]int, n)
+	for i := range ports {
+		ports[i] = int(buf[i])
+	}
+	return ports, nil
+}
+
+// Parent returns the hub the device is connected to, or nil for a root hub.
+// The returned Device must be closed like any other.
+func (d *Device) Parent() *Device {
+	parent := libusb_get_parent(d.dev)
+	if parent == nil {
+		return nil
+	}
+	return newDevice(d.ctx, parent)
+}
+
+// Speed returns the negotiated connection speed of the device.
+func (d *Device) Speed() Speed {
+	return Speed(libusb_get_device_speed(d.dev))
+}
+
+// VendorID returns the idVendor field of the device descriptor.
+func (d *Device) VendorID() uint16 {
+	return libusb_get_device_descriptor(d.dev).idVendor
+}
+
+// ProductID returns the idProduct field of the device descriptor.
+func (d *Device) ProductID() uint16 {
+	return libusb_get_device_descriptor(d.dev).idProduct
+}
+
+// Class returns the bDeviceClass field of the device descriptor.
+func (d *Device) Class() uint8 {
+	return libusb_get_device_descriptor(d.dev).bDeviceClass
+}
+
+// NumConfigurations returns the number of possible configurations.
+func (d *Device) NumConfigurations() int {
+	return int(libusb_get_device_descriptor(d.dev).bNumConfigurations)
+}
+
+// MaxPacketSize returns the wMaxPacketSize of an endpoint in the active
+// configuration.
+func (d *Device) MaxPacketSize(endpoint uint8) (int, error) {
+	r := libusb_get_max_packet_size(d.dev, endpoint)
+	if r < 0 {
+		return 0, d.endpointError("get max packet size", endpoint, r, 0)
+	}
+	return int(r), nil
+}
+
+// MaxIsoPacketSize returns the number of bytes an isochronous or interrupt
+// endpoint can move in a single (micro)frame, see
+// libusb_get_max_iso_packet_size.
+func (d *Device) MaxIsoPacketSize(endpoint uint8) (int, error) {
+	r := libusb_get_max_iso_packet_size(d.dev, endpoint)
+	if r < 0 {
+		return 0, d.endpointError("get max iso packet size", endpoint, r, 0)
+	}
+	return int(r), nil
+}
+
+// Open opens the device for I/O.
+func (d *Device) Open() (*DeviceHandle, error) {
+	var handle *libusb_device_handle
+	if r := libusb_open(d.dev, &handle); r < 0 {
+		return nil, d.codeError("open", r)
+	}
+	return &DeviceHandle{
+		dev:    newDevice(d.ctx, d.dev),
+		handle: handle,
+	}, nil
+}
+
+func (d *Device) String() string {
+	return fmt.Sprintf("Bus %03d Device %03d: ID %04x:%04x",
+		d.Bus(), d.Address(), d.VendorID(), d.ProductID())
+}
@@ -0,0 +1,457 @@
+package usb
+
+import (
+	"errors"
+	"sync"
+)
+
+// A Driver reaches USB devices on behalf of a Backend made with NewBackend.
+// It lets packages outside this one supply a backend of their own, such as
+// one that forwards transfers over a network. Methods return errors wrapping
+// the package's sentinel errors; any other error is reported as ErrOther.
+type Driver interface {
+	// Name is a human-readable name for the driver.
+	Name() string
+
+	// Start is called once, by NewBackend. From then on the driver reports
+	// the devices it finds, and those that leave, to host.
+	Start(host *DriverHost)
+
+	// Configuration returns the bConfigurationValue of the active
+	// configuration of a device, or 0 if it is unconfigured.
+	Configuration(session uint64) (int, error)
+
+	// Open opens a device.
+	Open(session uint64) (DriverHandle, error)
+}
+
+// A DriverDevice describes a device found by a Driver.
+type DriverDevice struct {
+	// Session identifies this connection of the device. It must differ from
+	// that of every other device, and change if the device is unplugged and
+	// plugged back in.
+	Session uint64
+
+	Bus, Port, Address uint8
+	Speed              Speed
+
+	// Device is the 18 byte device descriptor. Configs holds each full
+	// configuration descriptor, in the order of their indexes.
+	Device  []byte
+	Configs [][]byte
+}
+
+// A DriverHandle is a device opened by a Driver.
+type DriverHandle interface {
+	Close()
+
+	Configuration() (int, error)
+
+	// SetConfiguration makes a configuration active. A config of -1 puts
+	// the device in the unconfigured state.
+	SetConfiguration(config int) error
+
+	ClaimInterface(iface int) error
+	ReleaseInterface(iface int) error
+	SetInterfaceAltSetting(iface, altsetting int) error
+	ClearHalt(endpoint uint8) error
+	Reset() error
+
+	// Submit starts a transfer and returns immediately. The driver calls
+	// t.Complete exactly once, when the transfer finishes, fails or is
+	// cancelled.
+	Submit(t *DriverTransfer) error
+
+	// Cancel asks for a submitted transfer to be completed early, with
+	// TransferCancelled.
+	Cancel(t *DriverTransfer) error
+}
+
+// A DriverTransfer is a transfer handed to a DriverHandle. Timeouts are
+// enforced by the library, which cancels transfers that run out of time.
+type DriverTransfer struct {
+	Type     TransferType
+	Endpoint uint8
+	StreamID uint32
+
+	// Data is the transfer buffer, which the driver fills for IN transfers.
+	// For control transfers it starts with the 8 byte setup packet.
+	Data []byte
+
+	// IsoPackets holds the Length of each packet of an isochronous
+	// transfer. The driver sets their ActualLength and Status before it
+	// calls Complete; their Data is not used.
+	IsoPackets []IsoPacket
+
+	itransfer   *usbi_transfer
+	once        sync.Once
+	status      TransferStatus
+	transferred int
+}
+
+// Complete reports that the transfer has ended, after moving transferred
+// bytes. It may be called from any goroutine; calls after the first are
+// ignored.
+func (t *DriverTransfer) Complete(status TransferStatus, transferred int) {
+	t.once.Do(func() {
+		t.status = status
+		t.transferred = transferred
+		usbi_signal_transfer_completion(t.itransfer)
+	})
+}
+
+// A DriverHost is where a Driver reports devices.
+type DriverHost struct {
+	b *driverBackend
+}
+
+// Connect reports that a device has been found. Open contexts see it arrive
+// as a hotplug event, and contexts created later find it when they
+// enumerate.
+func (h *DriverHost) Connect(d DriverDevice) error {
+	if len(d.Device) < LIBUSB_DT_DEVICE_SIZE {
+		return codeError("connect", LIBUSB_ERROR_INVALID_PARAM)
+	}
+
+	b := h.b
+	b.mu.Lock()
+	if _, ok := b.devices[d.Session]; ok {
+		b.mu.Unlock()
+		return codeError("connect", LIBUSB_ERROR_BUSY)
+	}
+	dev := &d
+	b.devices[d.Session] = dev
+	active := b.contexts > 0
+	b.mu.Unlock()
+
+	if active {
+		active_contexts_lock.Lock()
+		for e := active_contexts_list.next; e != active_contexts_list; e = e.next {
+			driver_enumerate_device(list_entry(e).(*libusb_context), dev)
+		}
+		active_contexts_lock.Unlock()
+	}
+	return nil
+}
+
+// Disconnect reports that a device has left. The driver must complete any
+// transfers still in flight to it, with TransferNoDevice.
+func (h *DriverHost) Disconnect(session uint64) {
+	b := h.b
+	b.mu.Lock()
+	if _, ok := b.devices[session]; !ok {
+		b.mu.Unlock()
+		return
+	}
+	delete(b.devices, session)
+	active := b.contexts > 0
+	b.mu.Unlock()
+
+	if active {
+		active_contexts_lock.Lock()
+		for e := active_contexts_list.next; e != active_contexts_list; e = e.next {
+			dev := usbi_get_device_by_session_id(list_entry(e).(*libusb_context), session)
+			if dev != nil {
+				usbi_disconnect_device(dev)
+				libusb_unref_device(dev)
+			}
+		}
+		active_contexts_lock.Unlock()
+	}
+}
+
+// NewBackend returns a Backend that reaches devices through d, to install
+// with SetBackend.
+func NewBackend(d Driver) Backend {
+	b := &driverBackend{driver: d, devices: make(map[uint64]*DriverDevice)}
+	d.Start(&DriverHost{b})
+	return b
+}
+
+func driver_enumerate_device(ctx *libusb_context, d *DriverDevice) {
+	dev := usbi_get_device_by_session_id(ctx, d.Session)
+	if dev != nil {
+		/* device already exists in the context */
+		libusb_unref_device(dev)
+		return
+	}
+
+	dev = usbi_alloc_device(ctx, d.Session)
+	dev.os_priv = d
+	dev.bus_number = d.Bus
+	dev.port_number = d.Port
+	dev.device_address = d.Address
+	dev.speed = libusb_speed(d.Speed)
+
+	if usbi_sanitize_device(dev) < 0 {
+		libusb_unref_device(dev)
+		return
+	}
+	usbi_connect_device(dev)
+}
+
+/* driverErrorCode converts an error returned by a Driver into the error code
+ * it wraps. */
+func driverErrorCode(err error) libusb_error {
+	if err == nil {
+		return LIBUSB_SUCCESS
+	}
+	var code libusb_error
+	if errors.As(err, &code) && code < 0 {
+		return code
+	}
+	return LIBUSB_ERROR_OTHER
+}
+
+/* driverConfig returns the configuration descriptor of d with the given
+ * bConfigurationValue, or nil. */
+func driverConfig(d *DriverDevice, value int) []byte {
+	for _, cfg := range d.Configs {
+		if len(cfg) >= LIBUSB_DT_CONFIG_SIZE && int(cfg[5]) == value {
+			return cfg
+		}
+	}
+	return nil
+}
+
+type driverBackend struct {
+	driver Driver
+
+	mu      sync.Mutex
+	devices map[uint64]*DriverDevice
+
+	/* number of contexts initialized against the backend */
+	contexts int
+}
+
+func (b *driverBackend) Name() string {
+	return b.driver.Name()
+}
+
+func (*driverBackend) Caps() uint32 {
+	return 0
+}
+
+func (b *driverBackend) Init(ctx *libusb_context) libusb_error {
+	b.mu.Lock()
+	b.contexts++
+	devices := make([]*DriverDevice, 0, len(b.devices))
+	for _, d := range b.devices {
+		devices = append(devices, d)
+	}
+	b.mu.Unlock()
+
+	for _, d := range devices {
+		driver_enumerate_device(ctx, d)
+	}
+	return LIBUSB_SUCCESS
+}
+
+func (b *driverBackend) Exit() {
+	b.mu.Lock()
+	b.contexts--
+	b.mu.Unlock()
+}
+
+/* Devices are added to contexts by Init and DriverHost.Connect. */
+func (*driverBackend) Get_device_list(*libusb_context, *[]*libusb_device) libusb_error {
+	return LIBUSB_ERROR_NOT_SUPPORTED
+}
+
+func (*driverBackend) Hotplug_poll() {}
+
+func (b *driverBackend) Open(handle *libusb_device_handle) libusb_error {
+	d := handle.dev.os_priv.(*DriverDevice)
+	h, err := b.driver.Open(d.Session)
+	if err != nil {
+		return driverErrorCode(err)
+	}
+	handle.os_priv = h
+	return LIBUSB_SUCCESS
+}
+
+func (*driverBackend) Close(handle *libusb_device_handle) {
+	handle.os_priv.(DriverHandle).Close()
+}
+
+func (*driverBackend) Get_device_descriptor(dev *libusb_device, buffer []uint8, host_endian *int) libusb_error {
+	d := dev.os_priv.(*DriverDevice)
+	*host_endian = 0
+	copy(buffer, d.Device[:LIBUSB_DT_DEVICE_SIZE])
+	return LIBUSB_SUCCESS
+}
+
+func (b *driverBackend) Get_active_config_descriptor(dev *libusb_device, buffer []uint8, host_endian *int) libusb_error {
+	d := dev.os_priv.(*DriverDevice)
+	*host_endian = 0
+
+	config, err := b.driver.Configuration(d.Session)
+	if err != nil {
+		return driverErrorCode(err)
+	}
+	cfg := driverConfig(d, config)
+	if cfg == nil {
+		return LIBUSB_ERROR_NOT_FOUND
+	}
+	return libusb_error(copy(buffer, cfg))
+}
+
+func (*driverBackend) Get_config_descriptor(dev *libusb_device, config_index uint8, buffer []uint8, length int, host_endian *int) libusb_error {
+	d := dev.os_priv.(*DriverDevice)
+	*host_endian = 0
+
+	if int(config_index) >= len(d.Configs) {
+		return LIBUSB_ERROR_NOT_FOUND
+	}
+	if length < len(buffer) {
+		buffer = buffer[:length]
+	}
+	return libusb_error(copy(buffer, d.Configs[config_index]))
+}
+
+func (*driverBackend) Get_config_descriptor_by_value(dev *libusb_device, value uint8, buffer *[]uint8, host_endian *int) libusb_error {
+	d := dev.os_priv.(*DriverDevice)
+	*host_endian = 0
+
+	cfg := driverConfig(d, int(value))
+	if cfg == nil {
+		return LIBUSB_ERROR_NOT_FOUND
+	}
+	*buffer = cfg
+	return libusb_error(len(cfg))
+}
+
+func (*driverBackend) Get_configuration(handle *libusb_device_handle, config *int) libusb_error {
+	c, err := handle.os_priv.(DriverHandle).Configuration()
+	if err != nil {
+		return driverErrorCode(err)
+	}
+	*config = c
+	return LIBUSB_SUCCESS
+}
+
+func (*driverBackend) Set_configuration(handle *libusb_device_handle, config *int) libusb_error {
+	return driverErrorCode(handle.os_priv.(DriverHandle).SetConfiguration(*config))
+}
+
+func (*driverBackend) Claim_interface(handle *libusb_device_handle, iface uint) libusb_error {
+	return driverErrorCode(handle.os_priv.(DriverHandle).ClaimInterface(int(iface)))
+}
+
+func (*driverBackend) Release_interface(handle *libusb_device_handle, iface uint) libusb_error {
+	return driverErrorCode(handle.os_priv.(DriverHandle).ReleaseInterface(int(iface)))
+}
+
+func (*driverBackend) Set_interface_altsetting(handle *libusb_device_handle, iface uint, altsetting int) libusb_error {
+	return driverErrorCode(handle.os_priv.(DriverHandle).SetInterfaceAltSetting(int(iface), altsetting))
+}
+
+func (*driverBackend) Clear_halt(handle *libusb_device_handle, endpoint uint8) libusb_error {
+	return driverErrorCode(handle.os_priv.(DriverHandle).ClearHalt(endpoint))
+}
+
+func (*driverBackend) Reset_device(handle *libusb_device_handle) libusb_error {
+	return driverErrorCode(handle.os_priv.(DriverHandle).Reset())
+}
+
+func (*driverBackend) Alloc_streams(*libusb_device_handle, uint32, []uint8, int) libusb_error {
+	return LIBUSB_ERROR_NOT_SUPPORTED
+}
+
+func (*driverBackend) Free_streams(*libusb_device_handle, []uint8, int) libusb_error {
+	return LIBUSB_ERROR_NOT_SUPPORTED
+}
+
+func (*driverBackend) Dev_mem_alloc(*libusb_device_handle, int) []uint8 {
+	return nil
+}
+
+func (*driverBackend) Dev_mem_free(*libusb_device_handle, []uint8, int) libusb_error {
+	return LIBUSB_ERROR_NOT_SUPPORTED
+}
+
+/* Drivers have no notion of kernel drivers. */
+func (*driverBackend) Kernel_driver_active(*libusb_device_handle, int) libusb_error {
+	return 0
+}
+
+func (*driverBackend) Detach_kernel_driver(*libusb_device_handle, int) libusb_error {
+	return LIBUSB_ERROR_NOT_SUPPORTED
+}
+
+func (*driverBackend) Attach_kernel_driver(*libusb_device_handle, int) libusb_error {
+	return LIBUSB_ERROR_NOT_SUPPORTED
+}
+
+func (*driverBackend) Destroy_device(dev *libusb_device) {
+	dev.os_priv = nil
+}
+
+func (*driverBackend) Submit_transfer(itransfer *usbi_transfer) libusb_error {
+	transfer := itransfer.libusbTransfer
+	t := &DriverTransfer{
+		Type:      TransferType(transfer._type),
+		Endpoint:  transfer.endpoint,
+		StreamID:  itransfer.stream_id,
+		Data:      transfer.buffer[:transfer.length],
+		itransfer: itransfer,
+	}
+	if transfer._type == uint8(LIBUSB_TRANSFER_TYPE_ISOCHRONOUS) {
+		t.IsoPackets = make([]IsoPacket, transfer.num_iso_packets)
+		for i := range t.IsoPackets {
+			t.IsoPackets[i].Length = int(transfer.iso_packet_desc[i].length)
+		}
+	}
+
+	itransfer.tpriv = t
+	r := driverErrorCode(transfer.dev_handle.os_priv.(DriverHandle).Submit(t))
+	if r != LIBUSB_SUCCESS {
+		itransfer.tpriv = nil
+	}
+	return r
+}
+
+func (*driverBackend) Cancel_transfer(itransfer *usbi_transfer) libusb_error {
+	t, ok := itransfer.tpriv.(*DriverTransfer)
+	if !ok {
+		return LIBUSB_ERROR_NOT_FOUND
+	}
+	return driverErrorCode(itransfer.libusbTransfer.dev_handle.os_priv.(DriverHandle).Cancel(t))
+}
+
+func (*driverBackend) Clear_transfer_priv(itransfer *usbi_transfer) {
+	itransfer.tpriv = nil
+}
+
+/* Completions are signalled by DriverTransfer.Complete and reported from
+ * here, on the event handling goroutine. */
+func (*driverBackend) Handle_transfer_completion(itransfer *usbi_transfer) libusb_error {
+	t := itransfer.tpriv.(*DriverTransfer)
+	transfer := itransfer.libusbTransfer
+
+	itransfer.lock.Lock()
+	itransfer.transferred = t.transferred
+	for i, pkt := range t.IsoPackets {
+		transfer.iso_packet_desc[i].actual_length = uint(pkt.ActualLength)
+		transfer.iso_packet_desc[i].status = libusb_transfer_status(pkt.Status)
+	}
+	status := libusb_transfer_status(t.status)
+	itransfer.lock.Unlock()
+
+	if status == LIBUSB_TRANSFER_CANCELLED {
+		return libusb_error(usbi_handle_transfer_cancellation(itransfer))
+	}
+	return libusb_error(usbi_handle_transfer_completion(itransfer, status))
+}
+
+func (*driverBackend) Device_priv_size() int {
+	return 0
+}
+
+func (*driverBackend) Device_handle_priv_size() int {
+	return 0
+}
+
+func (*driverBackend) Transfer_priv_size() int {
+	return 0
+}
@@ -0,0 +1,94 @@
+package usb
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+// echoDriver serves one device whose bulk IN endpoint returns the data last
+// written to its bulk OUT endpoint.
+type echoDriver struct {
+	host *DriverHost
+	data []byte
+}
+
+func (*echoDriver) Name() string                      { return "echo" }
+func (d *echoDriver) Start(host *DriverHost)          { d.host = host }
+func (*echoDriver) Configuration(uint64) (int, error) { return 1, nil }
+func (d *echoDriver) Open(uint64) (DriverHandle, error) {
+	return echoHandle{d}, nil
+}
+
+type echoHandle struct{ d *echoDriver }
+
+func (echoHandle) Close()                                {}
+func (echoHandle) Configuration() (int, error)           { return 1, nil }
+func (echoHandle) SetConfiguration(int) error            { return nil }
+func (echoHandle) ClaimInterface(int) error              { return nil }
+func (echoHandle) ReleaseInterface(int) error            { return nil }
+func (echoHandle) SetInterfaceAltSetting(int, int) error { return nil }
+func (echoHandle) ClearHalt(uint8) error                 { return nil }
+func (echoHandle) Reset() error                          { return nil }
+func (echoHandle) Cancel(*DriverTransfer) error          { return ErrNotFound }
+
+func (h echoHandle) Submit(t *DriverTransfer) error {
+	if t.Type != TransferTypeBulk {
+		return ErrNotSupported
+	}
+	go func() {
+		n := len(t.Data)
+		if t.Endpoint&0x80 != 0 {
+			n = copy(t.Data, h.d.data)
+		} else {
+			h.d.data = append([]byte(nil), t.Data...)
+		}
+		t.Complete(TransferCompleted, n)
+	}()
+	return nil
+}
+
+func TestDriverBackend(t *testing.T) {
+	d := &echoDriver{}
+	prev := SetBackend(NewBackend(d))
+	defer SetBackend(prev)
+
+	err := d.host.Connect(DriverDevice{
+		Session: 1,
+		Bus:     1,
+		Address: 2,
+		Device:  testDeviceDescriptor,
+		Configs: [][]byte{simTestConfig},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := NewContext()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	h, err := c.OpenDeviceWithVIDPID(0x1234, 0x5678)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+	if got := h.Device().Address(); got != 2 {
+		t.Errorf("device address %d, want 2", got)
+	}
+
+	out := []byte("ping")
+	if _, err := h.BulkTransfer(0x01, out, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	in := make([]byte, 16)
+	n, err := h.BulkTransfer(0x81, in, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(in[:n], out) {
+		t.Errorf("read %q, want %q", in[:n], out)
+	}
+}
@@ -0,0 +1,590 @@
+package usb
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+)
+
+// A DeviceDump is a snapshot of the descriptors of a device, for support
+// bundles and bug reports. It can be rendered in the format of lsusb -v with
+// WriteText, or encoded as JSON with WriteJSON.
+type DeviceDump struct {
+	Bus     int   `json:"bus"`
+	Address int   `json:"address"`
+	Speed   Speed `json:"speed"`
+
+	Device  DeviceDescriptor    `json:"device"`
+	Configs []*ConfigDescriptor `json:"configs"`
+
+	// Qualifier is the device qualifier descriptor, which is only read
+	// from an open device that is high-speed capable.
+	Qualifier *DeviceQualifierDescriptor `json:"qualifier,omitempty"`
+
+	// OtherSpeedConfigs are the configurations the qualifier counts, as
+	// they would be at the device's other speed.
+	OtherSpeedConfigs []*ConfigDescriptor `json:"otherSpeedConfigs,omitempty"`
+
+	// BOS is the BOS descriptor, which is only read from an open device
+	// that has one.
+	BOS *BOSDescriptor `json:"bos,omitempty"`
+
+	// LandingPage is the URL of the landing page of a WebUSB device, which
+	// is only read from an open device that advertises one.
+	LandingPage string `json:"landingPage,omitempty"`
+
+	// Strings holds the string descriptors the other descriptors refer to,
+	// by index. They are only read from an open device.
+	Strings map[uint8]string `json:"strings,omitempty"`
+}
+
+// Dump takes a dump of the device from the descriptors the operating system
+// caches, without making any requests to the device. String descriptors and
+// the BOS descriptor are left out; open the device and use
+// DeviceHandle.Dump to include them.
+func (d *Device) Dump() (*DeviceDump, error) {
+	dump := &DeviceDump{
+		Bus:     d.Bus(),
+		Address: d.Address(),
+		Speed:   d.Speed(),
+		Device:  d.Descriptor(),
+	}
+	for i := 0; i < int(dump.Device.NumConfigurations); i++ {
+		config, err := d.ConfigDescriptor(i)
+		if err != nil {
+			return nil, err
+		}
+		dump.Configs = append(dump.Configs, config)
+	}
+	return dump, nil
+}
+
+// Dump takes a dump of the device like Device.Dump, and also reads its
+// device qualifier, other speed configuration and BOS descriptors, the
+// landing page of a WebUSB device and the string descriptors the others
+// refer to. Strings that cannot be read are left out.
+func (h *DeviceHandle) Dump() (*DeviceDump, error) {
+	dump, err := h.dev.Dump()
+	if err != nil {
+		return nil, err
+	}
+
+	if dump.Device.USBVersion >= 0x0200 {
+		qualifier, err := h.DeviceQualifierDescriptor()
+		if err != nil && !errors.Is(err, ErrPipe) {
+			return nil, err
+		}
+		dump.Qualifier = qualifier
+		for i := 0; qualifier != nil && i < int(qualifier.NumConfigurations); i++ {
+			config, err := h.OtherSpeedConfigDescriptor(i)
+			if err != nil {
+				return nil, err
+			}
+			dump.OtherSpeedConfigs = append(dump.OtherSpeedConfigs, config)
+		}
+	}
+
+	if dump.Device.USBVersion >= 0x0201 {
+		bos, err := h.BOSDescriptor()
+		if err != nil && !errors.Is(err, ErrPipe) {
+			return nil, err
+		}
+		dump.BOS = bos
+		if bos != nil {
+			if w, _ := bos.WebUSB(); w != nil && w.LandingPageIndex != 0 {
+				if url, err := h.WebUSBURL(w.VendorCode, w.LandingPageIndex); err == nil {
+					dump.LandingPage = url.String()
+				}
+			}
+		}
+	}
+
+	dump.Strings = make(map[uint8]string)
+	h.dumpString(dump, dump.Device.ManufacturerIndex)
+	h.dumpString(dump, dump.Device.ProductIndex)
+	h.dumpString(dump, dump.Device.SerialNumberIndex)
+	for _, config := range dump.Configs {
+		h.dumpConfigStrings(dump, config)
+	}
+	for _, config := range dump.OtherSpeedConfigs {
+		h.dumpConfigStrings(dump, config)
+	}
+	return dump, nil
+}
+
+func (h *DeviceHandle) dumpConfigStrings(dump *DeviceDump, config *ConfigDescriptor) {
+	h.dumpString(dump, config.ConfigurationIndex)
+	for _, alts := range config.Interfaces {
+		for _, alt := range alts {
+			h.dumpString(dump, alt.InterfaceIndex)
+		}
+	}
+}
+
+func (h *DeviceHandle) dumpString(dump *DeviceDump, index uint8) {
+	if index == 0 {
+		return
+	}
+	if _, ok := dump.Strings[index]; ok {
+		return
+	}
+	if s, err := h.StringDescriptor(index); err == nil {
+		dump.Strings[index] = s
+	}
+}
+
+// WriteJSON writes the dump as indented JSON. Field names follow the
+// descriptor fields of the USB specification, and byte strings, such as
+// the extra descriptors, are encoded in base64.
+func (dump *DeviceDump) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(dump)
+}
+
+// WriteText writes the dump in the format of lsusb -v, naming vendors,
+// products and classes from DefaultIDs.
+func (dump *DeviceDump) WriteText(w io.Writer) error {
+	t := &dumpWriter{w: w, dump: dump, ids: DefaultIDs()}
+	t.device()
+	for _, config := range dump.Configs {
+		t.config("Configuration Descriptor", config)
+	}
+	if dump.Qualifier != nil {
+		t.qualifier(dump.Qualifier)
+	}
+	for _, config := range dump.OtherSpeedConfigs {
+		t.config("Other Speed Configuration Descriptor", config)
+	}
+	if dump.BOS != nil {
+		t.bos(dump.BOS)
+	}
+	return t.err
+}
+
+// dumpWriter renders a DeviceDump as text, keeping the first write error.
+type dumpWriter struct {
+	w    io.Writer
+	dump *DeviceDump
+	ids  *IDs
+	err  error
+}
+
+func (t *dumpWriter) printf(format string, args ...interface{}) {
+	if t.err == nil {
+		_, t.err = fmt.Fprintf(t.w, format, args...)
+	}
+}
+
+// str returns the string descriptor at index, or "" if it was not read.
+func (t *dumpWriter) str(index uint8) string {
+	return t.dump.Strings[index]
+}
+
+// bcd formats a binary coded decimal version as lsusb does.
+func bcd(v uint16) string {
+	return fmt.Sprintf("%2x.%02x", v>>8, v&0xff)
+}
+
+func (t *dumpWriter) device() {
+	d := &t.dump.Device
+	vendor, product := t.ids.DeviceNames(d)
+	class, subclass, protocol := t.ids.DeviceClassNames(d)
+	t.printf("Bus %03d Device %03d: ID %04x:%04x %s\n",
+		t.dump.Bus, t.dump.Address, d.VendorID, d.ProductID,
+		strings.TrimSpace(vendor+" "+product))
+	t.printf("Device Descriptor:\n"+
+		"  bLength             %5d\n"+
+		"  bDescriptorType     %5d\n"+
+		"  bcdUSB              %s\n"+
+		"  bDeviceClass        %5d %s\n"+
+		"  bDeviceSubClass     %5d %s\n"+
+		"  bDeviceProtocol     %5d %s\n"+
+		"  bMaxPacketSize0     %5d\n"+
+		"  idVendor           0x%04x %s\n"+
+		"  idProduct          0x%04x %s\n"+
+		"  bcdDevice           %s\n"+
+		"  iManufacturer       %5d %s\n"+
+		"  iProduct            %5d %s\n"+
+		"  iSerial             %5d %s\n"+
+		"  bNumConfigurations  %5d\n",
+		d.Length, d.DescriptorType, bcd(d.USBVersion),
+		d.DeviceClass, class, d.DeviceSubClass, subclass, d.DeviceProtocol, protocol,
+		d.MaxPacketSize0,
+		d.VendorID, vendor, d.ProductID, product,
+		bcd(d.DeviceVersion),
+		d.ManufacturerIndex, t.str(d.ManufacturerIndex),
+		d.ProductIndex, t.str(d.ProductIndex),
+		d.SerialNumberIndex, t.str(d.SerialNumberIndex),
+		d.NumConfigurations)
+}
+
+func (t *dumpWriter) config(title string, c *ConfigDescriptor) {
+	t.printf("  %s:\n"+
+		"    bLength             %5d\n"+
+		"    bDescriptorType     %5d\n"+
+		"    wTotalLength       0x%04x\n"+
+		"    bNumInterfaces      %5d\n"+
+		"    bConfigurationValue %5d\n"+
+		"    iConfiguration      %5d %s\n"+
+		"    bmAttributes         0x%02x\n",
+		title, c.Length, c.DescriptorType, c.TotalLength, c.NumInterfaces,
+		c.ConfigurationValue, c.ConfigurationIndex, t.str(c.ConfigurationIndex),
+		c.Attributes)
+	if c.Attributes&0x80 == 0 {
+		t.printf("      (Missing must-be-set bit!)\n")
+	}
+	if c.Attributes&0x40 != 0 {
+		t.printf("      Self Powered\n")
+	} else {
+		t.printf("      (Bus Powered)\n")
+	}
+	if c.Attributes&0x20 != 0 {
+		t.printf("      Remote Wakeup\n")
+	}
+	if c.Attributes&0x10 != 0 {
+		t.printf("      Battery Powered\n")
+	}
+	/* bMaxPower is in units of 8mA when operating at SuperSpeed */
+	power := int(c.MaxPower) * 2
+	if t.dump.Speed >= SpeedSuper {
+		power = int(c.MaxPower) * 8
+	}
+	t.printf("    MaxPower            %5dmA\n", power)
+	t.extra(4, DescriptorContext{}, c.Extra)
+	for _, alts := range c.Interfaces {
+		for i := range alts {
+			t.iface(&alts[i])
+		}
+	}
+}
+
+func (t *dumpWriter) iface(i *InterfaceDescriptor) {
+	class, subclass, protocol := t.ids.InterfaceClassNames(i)
+	t.printf("    Interface Descriptor:\n"+
+		"      bLength             %5d\n"+
+		"      bDescriptorType     %5d\n"+
+		"      bInterfaceNumber    %5d\n"+
+		"      bAlternateSetting   %5d\n"+
+		"      bNumEndpoints       %5d\n"+
+		"      bInterfaceClass     %5d %s\n"+
+		"      bInterfaceSubClass  %5d %s\n"+
+		"      bInterfaceProtocol  %5d %s\n"+
+		"      iInterface          %5d %s\n",
+		i.Length, i.DescriptorType, i.InterfaceNumber, i.AlternateSetting,
+		i.NumEndpoints, i.InterfaceClass, class, i.InterfaceSubClass, subclass,
+		i.InterfaceProtocol, protocol, i.InterfaceIndex, t.str(i.InterfaceIndex))
+	ctx := i.descriptorContext()
+	t.extra(6, ctx, i.Extra)
+	ctx.Endpoint = true
+	for j := range i.Endpoints {
+		t.endpoint(ctx, &i.Endpoints[j])
+	}
+}
+
+var (
+	dumpTransferTypes = [4]string{"Control", "Isochronous", "Bulk", "Interrupt"}
+	dumpSynchTypes    = [4]string{"None", "Asynchronous", "Adaptive", "Synchronous"}
+	dumpUsageTypes    = [4]string{"Data", "Feedback", "Implicit feedback Data", "(reserved)"}
+	dumpTransactions  = [4]string{"1x", "2x", "3x", "(??)"}
+	dumpSpeeds        = [4]string{"Low Speed (1Mbps)", "Full Speed (12Mbps)", "High Speed (480Mbps)", "SuperSpeed (5Gbps)"}
+	dumpModeStates    = [4]string{"Unspecified Error", "Not attempted or exited", "Unsuccessful", "Configured"}
+)
+
+func (t *dumpWriter) endpoint(ctx DescriptorContext, e *EndpointDescriptor) {
+	dir := "OUT"
+	if e.EndpointAddress&LIBUSB_ENDPOINT_DIR_MASK != 0 {
+		dir = "IN"
+	}
+	t.printf("      Endpoint Descriptor:\n"+
+		"        bLength             %5d\n"+
+		"        bDescriptorType     %5d\n"+
+		"        bEndpointAddress     0x%02x  EP %d %s\n"+
+		"        bmAttributes        %5d\n"+
+		"          Transfer Type            %s\n"+
+		"          Synch Type               %s\n"+
+		"          Usage Type               %s\n"+
+		"        wMaxPacketSize     0x%04x  %s %d bytes\n"+
+		"        bInterval           %5d\n",
+		e.Length, e.DescriptorType, e.EndpointAddress, e.EndpointAddress&0x0f, dir,
+		e.Attributes, dumpTransferTypes[e.Attributes&3],
+		dumpSynchTypes[(e.Attributes>>2)&3], dumpUsageTypes[(e.Attributes>>4)&3],
+		e.MaxPacketSize, dumpTransactions[(e.MaxPacketSize>>11)&3], e.MaxPacketSize&0x7ff,
+		e.Interval)
+	if e.Length == ENDPOINT_AUDIO_DESC_LENGTH {
+		t.printf("        bRefresh            %5d\n"+
+			"        bSynchAddress       %5d\n",
+			e.Refresh, e.SynchAddress)
+	}
+	t.extra(8, ctx, e.Extra)
+}
+
+// extra writes the class and vendor specific descriptors in extra, decoded
+// by the decoders registered for the place ctx describes. Descriptors that
+// no decoder recognizes or that fail to decode, and data that does not form
+// a whole descriptor, are written as bytes.
+func (t *dumpWriter) extra(indent int, ctx DescriptorContext, extra []byte) {
+	for len(extra) > 0 {
+		n := len(extra)
+		if n >= DESC_HEADER_LENGTH && int(extra[0]) >= DESC_HEADER_LENGTH && int(extra[0]) <= n {
+			n = int(extra[0])
+		}
+		desc := extra[:n]
+		extra = extra[n:]
+		if n < DESC_HEADER_LENGTH || int(desc[0]) != n {
+			t.unrecognized(indent, desc)
+			continue
+		}
+		d, err := decodeDescriptor(ctx, desc)
+		if _, raw := d.(*RawDescriptor); err != nil || raw {
+			t.unrecognized(indent, desc)
+			continue
+		}
+		t.classDescriptor(indent, d)
+	}
+}
+
+// classDescriptor writes a decoded descriptor one field per line, under the
+// field names of its specification, which are also those of its JSON
+// encoding.
+func (t *dumpWriter) classDescriptor(indent int, d ClassDescriptor) {
+	v := reflect.Indirect(reflect.ValueOf(d))
+	t.printf("%*s%s:\n", indent, "", descriptorTitle(v.Type().Name()))
+	t.fields(indent+2, v)
+}
+
+func (t *dumpWriter) fields(indent int, v reflect.Value) {
+	for i := 0; i < v.NumField(); i++ {
+		f := v.Type().Field(i)
+		if f.Anonymous {
+			t.fields(indent, v.Field(i))
+			continue
+		}
+		name := strings.Split(f.Tag.Get("json"), ",")[0]
+		if name == "" {
+			name = f.Name
+		}
+		fv := v.Field(i)
+		if fv.Kind() != reflect.Slice {
+			t.field(indent, name, fv)
+			continue
+		}
+		for j := 0; j < fv.Len(); j++ {
+			if e := fv.Index(j); e.Kind() == reflect.Struct {
+				t.fields(indent, e)
+			} else {
+				t.field(indent, fmt.Sprintf("%s(%d)", name, j), e)
+			}
+		}
+	}
+}
+
+// field writes a field of a class specific descriptor. Bitmaps are written
+// in hex, versions in binary coded decimal and GUIDs in their text form.
+func (t *dumpWriter) field(indent int, name string, v reflect.Value) {
+	var s string
+	switch {
+	case v.Kind() == reflect.Array:
+		var u UUID
+		for i := range u {
+			u[i] = uint8(v.Index(i).Uint())
+		}
+		s = "{" + u.String() + "}"
+	case v.Kind() == reflect.Slice:
+		s = fmt.Sprintf("% x", v.Bytes())
+	case strings.HasPrefix(name, "bcd"):
+		s = bcd(uint16(v.Uint()))
+	case strings.HasPrefix(name, "bm"):
+		s = fmt.Sprintf("0x%0*x", 2*int(v.Type().Size()), v.Uint())
+	default:
+		s = fmt.Sprintf("%5d", v.Uint())
+	}
+	t.printf("%*s%-19s %s\n", indent, "", name, s)
+}
+
+// descriptorTitle splits the name of a descriptor type into words, such as
+// "CDC Header Descriptor" for CDCHeaderDescriptor.
+func descriptorTitle(name string) string {
+	var b strings.Builder
+	for i := 0; i < len(name); i++ {
+		upper := func(j int) bool { return name[j] >= 'A' && name[j] <= 'Z' }
+		if i > 0 && upper(i) && (!upper(i-1) || i+1 < len(name) && !upper(i+1)) {
+			b.WriteByte(' ')
+		}
+		b.WriteByte(name[i])
+	}
+	return b.String()
+}
+
+func (t *dumpWriter) unrecognized(indent int, desc []byte) {
+	var b strings.Builder
+	for _, c := range desc {
+		fmt.Fprintf(&b, " %02x", c)
+	}
+	t.printf("%*s** UNRECOGNIZED: %s\n", indent, "", b.String())
+}
+
+func (t *dumpWriter) qualifier(q *DeviceQualifierDescriptor) {
+	t.printf("Device Qualifier (for other device speed):\n"+
+		"  bLength             %5d\n"+
+		"  bDescriptorType     %5d\n"+
+		"  bcdUSB              %s\n"+
+		"  bDeviceClass        %5d %s\n"+
+		"  bDeviceSubClass     %5d %s\n"+
+		"  bDeviceProtocol     %5d %s\n"+
+		"  bMaxPacketSize0     %5d\n"+
+		"  bNumConfigurations  %5d\n",
+		q.Length, q.DescriptorType, bcd(q.USBVersion),
+		q.DeviceClass, t.ids.Class(q.DeviceClass),
+		q.DeviceSubClass, t.ids.SubClass(q.DeviceClass, q.DeviceSubClass),
+		q.DeviceProtocol, t.ids.Protocol(q.DeviceClass, q.DeviceSubClass, q.DeviceProtocol),
+		q.MaxPacketSize0, q.NumConfigurations)
+}
+
+func (t *dumpWriter) bos(b *BOSDescriptor) {
+	t.printf("Binary Object Store Descriptor:\n"+
+		"  bLength             %5d\n"+
+		"  bDescriptorType     %5d\n"+
+		"  wTotalLength       0x%04x\n"+
+		"  bNumDeviceCaps      %5d\n",
+		b.Length, b.DescriptorType, b.TotalLength, b.NumDeviceCaps)
+	for i := range b.Capabilities {
+		t.capability(&b.Capabilities[i])
+	}
+}
+
+// capability writes a device capability, falling back to its bytes for
+// types that are not decoded or that fail to decode.
+func (t *dumpWriter) capability(c *DeviceCapability) {
+	header := func(name string) {
+		t.printf("  %s:\n"+
+			"    bLength             %5d\n"+
+			"    bDescriptorType     %5d\n"+
+			"    bDevCapabilityType  %5d\n",
+			name, c.Length, c.DescriptorType, c.CapabilityType)
+	}
+	var err error
+	switch c.CapabilityType {
+	case CapabilityUSB20Extension:
+		var e *USB20ExtensionCapability
+		if e, err = c.USB20Extension(); err == nil {
+			header("USB 2.0 Extension Device Capability")
+			t.printf("    bmAttributes   0x%08x\n", e.Attributes)
+			if e.LinkPowerManagement() {
+				t.printf("      Link Power Management (LPM) Supported\n")
+			}
+		}
+	case CapabilitySuperSpeed:
+		var s *SuperSpeedCapability
+		if s, err = c.SuperSpeed(); err == nil {
+			header("SuperSpeed USB Device Capability")
+			t.printf("    bmAttributes         0x%02x\n", s.Attributes)
+			if s.Attributes&uint8(LIBUSB_BM_LTM_SUPPORT) != 0 {
+				t.printf("      Latency Tolerance Messages (LTM) Supported\n")
+			}
+			t.printf("    wSpeedsSupported   0x%04x\n", s.SpeedsSupported)
+			for i, speed := range dumpSpeeds {
+				if s.SpeedsSupported&(1<<uint(i)) != 0 {
+					t.printf("      Device can operate at %s\n", speed)
+				}
+			}
+			t.printf("    bFunctionalitySupport %3d\n", s.FunctionalitySupport)
+			if int(s.FunctionalitySupport) < len(dumpSpeeds) {
+				t.printf("      Lowest fully-functional device speed is %s\n",
+					dumpSpeeds[s.FunctionalitySupport])
+			}
+			t.printf("    bU1DevExitLat        %4d micro seconds\n"+
+				"    bU2DevExitLat        %4d micro seconds\n",
+				s.U1DevExitLat, s.U2DevExitLat)
+		}
+	case CapabilityContainerID:
+		var id *ContainerIDCapability
+		if id, err = c.ContainerID(); err == nil {
+			header("Container ID Device Capability")
+			t.printf("    ContainerID             {%s}\n", id.ContainerID)
+		}
+	case CapabilityPlatform:
+		var p *PlatformCapability
+		if p, err = c.Platform(); err == nil {
+			header("Platform Device Capability")
+			t.printf("    PlatformCapabilityUUID    {%s}\n", p.UUID)
+			if w, werr := p.WebUSB(); werr == nil {
+				t.printf("      WebUSB:\n"+
+					"        bcdVersion   %s\n"+
+					"        bVendorCode  %5d\n"+
+					"        iLandingPage %5d %s\n",
+					bcd(w.Version), w.VendorCode, w.LandingPageIndex, t.dump.LandingPage)
+			} else {
+				for i, b := range p.Data {
+					t.printf("    CapabilityData[%d]    0x%02x\n", i, b)
+				}
+			}
+		}
+	case CapabilitySuperSpeedPlus:
+		var s *SuperSpeedPlusCapability
+		if s, err = c.SuperSpeedPlus(); err == nil {
+			header("SuperSpeedPlus USB Device Capability")
+			t.printf("    bmAttributes         0x%08x\n"+
+				"      Sublink Speed Attribute count %d\n"+
+				"      Sublink Speed ID count %d\n"+
+				"    wFunctionalitySupport   0x%04x\n"+
+				"      Min functional Speed Attribute ID: %d\n"+
+				"      Min functional RX lanes: %d\n"+
+				"      Min functional TX lanes: %d\n",
+				s.Attributes, s.Attributes&0x1f+1, (s.Attributes>>5)&0x0f+1,
+				s.FunctionalitySupport, s.FunctionalitySupport&0x0f,
+				(s.FunctionalitySupport>>8)&0x0f, s.FunctionalitySupport>>12)
+			for i, speed := range s.SublinkSpeeds {
+				t.printf("    bmSublinkSpeedAttr[%d]\n"+
+					"      Speed Attribute ID: %d %s\n", i, speed.ID, speed)
+			}
+		}
+	case CapabilityPrecisionTimeMeasurement:
+		header("Precision Time Measurement Device Capability")
+	case CapabilityBillboard:
+		var b *BillboardCapability
+		if b, err = c.Billboard(); err == nil {
+			header("Billboard Capability")
+			t.printf("    iAdditionalInfoURL  %5d %s\n"+
+				"    bNumberOfAlternateModes %d\n"+
+				"    bPreferredAlternateMode %d\n"+
+				"    VCONN Power         %5d\n"+
+				"    bcdVersion          %s\n"+
+				"    bAdditionalFailureInfo %d\n",
+				b.AdditionalInfoURLIndex, t.str(b.AdditionalInfoURLIndex),
+				b.NumAlternateModes, b.PreferredAlternateMode, b.VCONNPower,
+				bcd(b.Version), b.AdditionalFailureInfo)
+			for i, mode := range b.AlternateModes {
+				t.printf("    Alternate Mode %d : %s\n"+
+					"      wSVID[%d]                    0x%04x\n"+
+					"      bAlternateMode[%d]       %5d\n"+
+					"      iAlternateModeString[%d] %5d %s\n",
+					i, dumpModeStates[b.ModeState(i)],
+					i, mode.SVID, i, mode.AlternateMode,
+					i, mode.StringIndex, t.str(mode.StringIndex))
+			}
+		}
+	case CapabilityConfigurationSummary:
+		var s *ConfigurationSummaryCapability
+		if s, err = c.ConfigurationSummary(); err == nil {
+			header("Configuration Summary Device Capability")
+			t.printf("    bcdVersion          %s\n"+
+				"    bClass              %5d\n"+
+				"    bSubClass           %5d\n"+
+				"    bProtocol           %5d\n"+
+				"    bConfigurationCount %5d\n",
+				bcd(s.Version), s.Class, s.SubClass, s.Protocol, s.ConfigurationCount)
+			for _, index := range s.ConfigurationIndexes {
+				t.printf("    bConfigurationIndex %5d\n", index)
+			}
+		}
+	default:
+		t.unrecognized(2, append([]byte{c.Length, c.DescriptorType, c.CapabilityType}, c.Data...))
+	}
+	if err != nil {
+		t.unrecognized(2, append([]byte{c.Length, c.DescriptorType, c.CapabilityType}, c.Data...))
+	}
+}
@@ -0,0 +1,87 @@
+package usb
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestDumpClassDescriptors(t *testing.T) {
+	config := new(ConfigDescriptor)
+	if err := config.UnmarshalBinary(fuzzConfig); err != nil {
+		t.Fatal(err)
+	}
+	/* a vendor specific descriptor no decoder recognizes */
+	config.Interfaces[1][0].Extra = []byte{0x04, 0xff, 0x01, 0x02}
+	dump := &DeviceDump{Configs: []*ConfigDescriptor{config}}
+	if err := dump.Device.UnmarshalBinary(testDeviceDescriptor); err != nil {
+		t.Fatal(err)
+	}
+	var b bytes.Buffer
+	if err := dump.WriteText(&b); err != nil {
+		t.Fatal(err)
+	}
+	out := b.String()
+	for _, want := range []string{
+		"    Interface Association Descriptor:\n      bLength                 8\n",
+		"      bFunctionClass          2\n",
+		"      CDC Header Descriptor:\n",
+		"        bcdCDC               1.10\n",
+		"        SS Endpoint Companion Descriptor:\n",
+		"          wBytesPerInterval       8\n",
+		"      ** UNRECOGNIZED:  04 ff 01 02\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("dump lacks %q:\n%s", want, out)
+		}
+	}
+	if n := strings.Count(out, "UNRECOGNIZED"); n != 1 {
+		t.Errorf("%d unrecognized descriptors, want 1:\n%s", n, out)
+	}
+}
+
+func TestDumpOtherSpeed(t *testing.T) {
+	otherSpeed := append([]byte(nil), simTestConfig...)
+	otherSpeed[1] = byte(LIBUSB_DT_OTHER_SPEED_CONFIGURATION)
+	d := newLoopbackDevice()
+	d.Descriptors = map[uint16][]byte{
+		uint16(LIBUSB_DT_DEVICE_QUALIFIER) << 8:          testQualifier,
+		uint16(LIBUSB_DT_OTHER_SPEED_CONFIGURATION) << 8: otherSpeed,
+	}
+	h, _ := openLoopback(t, d)
+
+	dump, err := h.Dump()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dump.Qualifier == nil || len(dump.OtherSpeedConfigs) != 1 {
+		t.Fatalf("dumped qualifier %+v and %d other speed configurations, want 1", dump.Qualifier, len(dump.OtherSpeedConfigs))
+	}
+	if typ := dump.OtherSpeedConfigs[0].DescriptorType; typ != uint8(LIBUSB_DT_OTHER_SPEED_CONFIGURATION) {
+		t.Errorf("other speed configuration of type %d", typ)
+	}
+	var b bytes.Buffer
+	if err := dump.WriteText(&b); err != nil {
+		t.Fatal(err)
+	}
+	out := b.String()
+	q := strings.Index(out, "Device Qualifier (for other device speed):\n")
+	o := strings.Index(out, "  Other Speed Configuration Descriptor:\n    bLength                 9\n    bDescriptorType         7\n")
+	if q < 0 || o < q {
+		t.Errorf("dump lacks the qualifier followed by the other speed configuration:\n%s", out)
+	}
+
+	/* the type of a configuration is checked against the request */
+	d.Configs = [][]byte{otherSpeed}
+	if _, err := h.Device().ConfigDescriptor(0); !errors.Is(err, ErrIO) {
+		t.Errorf("other speed configuration read as configuration 0 returned %v, want ErrIO", err)
+	}
+	if _, err := h.OtherSpeedConfigDescriptor(0); err != nil {
+		t.Error(err)
+	}
+	d.Descriptors[uint16(LIBUSB_DT_OTHER_SPEED_CONFIGURATION)<<8] = simTestConfig
+	if _, err := h.OtherSpeedConfigDescriptor(0); !errors.Is(err, ErrIO) {
+		t.Errorf("configuration read as other speed configuration 0 returned %v, want ErrIO", err)
+	}
+}
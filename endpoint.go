@@ -0,0 +1,243 @@
+package usb
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+)
+
+// endpointMaxTransfer bounds the size of each transfer a write is split
+// into. It is rounded down to a multiple of the endpoint's wMaxPacketSize.
+const endpointMaxTransfer = 16384
+
+// An Interface is a claimed interface of an open device, in one of its
+// alternate settings.
+type Interface struct {
+	handle *DeviceHandle
+	number int
+	desc   libusb_interface_descriptor
+}
+
+// Interface claims an interface and activates one of its alternate
+// settings. Close releases it.
+func (h *DeviceHandle) Interface(number, altsetting int) (*Interface, error) {
+	var config *libusb_config_descriptor
+	if r := libusb_get_active_config_descriptor(h.dev.dev, &config); r < 0 {
+		return nil, h.codeError("get active config descriptor", libusb_error(r))
+	}
+	i := &Interface{handle: h, number: number}
+	found := false
+	for _, iface := range config.iface {
+		for _, alt := range iface.altsetting {
+			if int(alt.bInterfaceNumber) == number && int(alt.bAlternateSetting) == altsetting {
+				i.desc = alt
+				found = true
+			}
+		}
+	}
+	if !found {
+		return nil, h.codeError("claim interface", LIBUSB_ERROR_NOT_FOUND)
+	}
+
+	if err := h.ClaimInterface(number); err != nil {
+		return nil, err
+	}
+	if altsetting != 0 {
+		if err := h.SetInterfaceAltSetting(number, altsetting); err != nil {
+			h.ReleaseInterface(number)
+			return nil, err
+		}
+	}
+	return i, nil
+}
+
+// Number returns the bInterfaceNumber of the interface.
+func (i *Interface) Number() int {
+	return i.number
+}
+
+// AltSetting returns the bAlternateSetting that is active.
+func (i *Interface) AltSetting() int {
+	return int(i.desc.bAlternateSetting)
+}
+
+// Close releases the interface. Endpoints obtained from it must not be used
+// afterwards.
+func (i *Interface) Close() error {
+	return i.handle.ReleaseInterface(i.number)
+}
+
+// Endpoint returns a byte stream over a bulk or interrupt endpoint of the
+// interface.
+func (i *Interface) Endpoint(address uint8) (*Endpoint, error) {
+	for _, ep := range i.desc.endpoint {
+		if ep.bEndpointAddress != address {
+			continue
+		}
+		tt := libusb_transfer_type(ep.bmAttributes & 0x3)
+		if tt != LIBUSB_TRANSFER_TYPE_BULK && tt != LIBUSB_TRANSFER_TYPE_INTERRUPT {
+			return nil, i.handle.transferError("open endpoint", address, LIBUSB_ERROR_NOT_SUPPORTED, 0)
+		}
+		if ep.wMaxPacketSize&0x07ff == 0 {
+			return nil, i.handle.transferError("open endpoint", address, LIBUSB_ERROR_INVALID_PARAM, 0)
+		}
+		e := &Endpoint{
+			handle:    i.handle,
+			address:   address,
+			tt:        tt,
+			maxPacket: int(ep.wMaxPacketSize & 0x07ff),
+		}
+		e.ctx, e.cancel = context.WithCancel(context.Background())
+		return e, nil
+	}
+	return nil, i.handle.transferError("open endpoint", address, LIBUSB_ERROR_NOT_FOUND, 0)
+}
+
+// An Endpoint is an io.ReadWriteCloser over a bulk or interrupt endpoint.
+// IN endpoints can only be read and OUT endpoints only written.
+//
+// Reads are made in multiples of wMaxPacketSize, so that the device can
+// never overflow the buffer; data beyond what fits in p is kept for the next
+// Read. A read returns early when the device sends a short packet, and a
+// zero length packet is returned as a read of 0 bytes with a nil error.
+//
+// Writes are split into transfers of a multiple of wMaxPacketSize. With
+// SetAddZeroPacket, a write whose length is a multiple of wMaxPacketSize is
+// terminated with a zero length packet so the device can find its end.
+//
+// An Endpoint is not safe for concurrent use, except that Close may be
+// called to abort a Read or Write in progress.
+type Endpoint struct {
+	handle    *DeviceHandle
+	address   uint8
+	tt        libusb_transfer_type
+	maxPacket int
+	timeout   time.Duration
+	zlp       bool
+
+	buf     []byte
+	pending []byte
+
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// Address returns the endpoint address.
+func (e *Endpoint) Address() uint8 {
+	return e.address
+}
+
+// MaxPacketSize returns the wMaxPacketSize of the endpoint.
+func (e *Endpoint) MaxPacketSize() int {
+	return e.maxPacket
+}
+
+// SetTimeout sets the timeout of each transfer made by Read and Write. A
+// timeout of 0, the default, waits forever.
+func (e *Endpoint) SetTimeout(d time.Duration) {
+	e.timeout = d
+}
+
+// SetAddZeroPacket makes writes whose length is a multiple of
+// wMaxPacketSize end with a zero length packet.
+func (e *Endpoint) SetAddZeroPacket(enable bool) {
+	e.zlp = enable
+}
+
+func (e *Endpoint) op(in bool) string {
+	s := TransferType(e.tt).String()
+	if in {
+		return s + " read"
+	}
+	return s + " write"
+}
+
+func (e *Endpoint) transfer(in bool, data []byte) (int, error) {
+	n, err := e.handle.syncTransfer(e.ctx, e.op(in), e.tt, e.address, data, e.timeout)
+	if err != nil && errors.Is(err, context.Canceled) {
+		err = io.ErrClosedPipe
+	}
+	return n, err
+}
+
+// Read reads from an IN endpoint.
+func (e *Endpoint) Read(p []byte) (int, error) {
+	if e.address&LIBUSB_ENDPOINT_DIR_MASK != uint8(LIBUSB_ENDPOINT_IN) {
+		return 0, e.handle.transferError(e.op(true), e.address, LIBUSB_ERROR_INVALID_PARAM, 0)
+	}
+	if len(e.pending) > 0 {
+		n := copy(p, e.pending)
+		e.pending = e.pending[n:]
+		return n, nil
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if e.ctx.Err() != nil {
+		return 0, io.ErrClosedPipe
+	}
+
+	if len(p) >= e.maxPacket {
+		return e.transfer(true, p[:len(p)-len(p)%e.maxPacket])
+	}
+
+	/* too small for a whole packet, so read one into the buffer and keep
+	 * what does not fit */
+	if e.buf == nil {
+		e.buf = make([]byte, e.maxPacket)
+	}
+	n, err := e.transfer(true, e.buf)
+	c := copy(p, e.buf[:n])
+	e.pending = e.buf[c:n]
+	return c, err
+}
+
+// Write writes to an OUT endpoint. It returns the number of bytes written,
+// which is less than len(p) only if an error occurred.
+func (e *Endpoint) Write(p []byte) (int, error) {
+	if e.address&LIBUSB_ENDPOINT_DIR_MASK != uint8(LIBUSB_ENDPOINT_OUT) {
+		return 0, e.handle.transferError(e.op(false), e.address, LIBUSB_ERROR_INVALID_PARAM, 0)
+	}
+	if e.ctx.Err() != nil {
+		return 0, io.ErrClosedPipe
+	}
+
+	limit := endpointMaxTransfer - endpointMaxTransfer%e.maxPacket
+	if limit == 0 {
+		limit = e.maxPacket
+	}
+
+	written := 0
+	for written < len(p) {
+		chunk := p[written:]
+		if len(chunk) > limit {
+			chunk = chunk[:limit]
+		}
+		n, err := e.transfer(false, chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		if n < len(chunk) {
+			return written, io.ErrShortWrite
+		}
+	}
+
+	/* the sync API has no transfer flags, so rather than rely on
+	 * LIBUSB_TRANSFER_ADD_ZERO_PACKET the zero length packet is sent as a
+	 * transfer of its own, which works on every backend */
+	if e.zlp && len(p) > 0 && len(p)%e.maxPacket == 0 {
+		if _, err := e.transfer(false, nil); err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+// Close aborts any Read or Write in progress and makes further calls fail
+// with io.ErrClosedPipe. It does not release the interface.
+func (e *Endpoint) Close() error {
+	e.cancel()
+	return nil
+}
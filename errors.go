@@ -0,0 +1,180 @@
+package usb
+
+import (
+	"context"
+	"fmt"
+)
+
+// Sentinel errors for each libusb error code. Every error returned by the
+// exported API wraps one of these, so they can be tested for with errors.Is.
+var (
+	ErrIO           error = LIBUSB_ERROR_IO
+	ErrInvalidParam error = LIBUSB_ERROR_INVALID_PARAM
+	ErrAccess       error = LIBUSB_ERROR_ACCESS
+	ErrNoDevice     error = LIBUSB_ERROR_NO_DEVICE
+	ErrNotFound     error = LIBUSB_ERROR_NOT_FOUND
+	ErrBusy         error = LIBUSB_ERROR_BUSY
+	ErrTimeout      error = LIBUSB_ERROR_TIMEOUT
+	ErrOverflow     error = LIBUSB_ERROR_OVERFLOW
+	ErrPipe         error = LIBUSB_ERROR_PIPE
+	ErrInterrupted  error = LIBUSB_ERROR_INTERRUPTED
+	ErrNoMem        error = LIBUSB_ERROR_NO_MEM
+	ErrNotSupported error = LIBUSB_ERROR_NOT_SUPPORTED
+	ErrOther        error = LIBUSB_ERROR_OTHER
+)
+
+// Error describes a failed operation. Fields that do not apply to the
+// operation are left at their zero value, except Endpoint which is -1 when
+// no endpoint was involved.
+type Error struct {
+	// Op names the operation, such as "open" or "bulk transfer".
+	Op string
+
+	// Bus and Address identify the device, and are 0 for operations that
+	// are not specific to a device.
+	Bus, Address int
+	VendorID     uint16
+	ProductID    uint16
+
+	// Endpoint is the endpoint address the operation was made to.
+	Endpoint int
+
+	// Transferred is the number of bytes moved before the error occurred.
+	Transferred int
+
+	// Err is one of the sentinel errors above, the error of the
+	// context.Context that ended the operation, or a *DescriptorError.
+	Err error
+}
+
+func (e *Error) Error() string {
+	s := "usb: " + e.Op
+	if e.Bus != 0 || e.Address != 0 {
+		s += fmt.Sprintf(" on bus %03d device %03d (%04x:%04x)",
+			e.Bus, e.Address, e.VendorID, e.ProductID)
+	}
+	if e.Endpoint >= 0 {
+		s += fmt.Sprintf(" endpoint 0x%02x", e.Endpoint)
+	}
+	s += ": " + e.Err.Error()
+	if e.Transferred > 0 {
+		s += fmt.Sprintf(" (%d bytes transferred)", e.Transferred)
+	}
+	return s
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// DescriptorError describes malformed descriptor data, as returned by a
+// device or passed to UnmarshalBinary. It wraps ErrIO.
+type DescriptorError struct {
+	// Path locates the descriptor the problem was found in, such as
+	// "config/interface[0]/altsetting[1]/endpoint[0]".
+	Path string
+
+	// Offset is the offset of the problem from the start of the data.
+	Offset int
+
+	// Reason describes the problem.
+	Reason string
+}
+
+func (e *DescriptorError) Error() string {
+	return fmt.Sprintf("malformed descriptor at %s (offset %d): %s", e.Path, e.Offset, e.Reason)
+}
+
+func (e *DescriptorError) Unwrap() error {
+	return ErrIO
+}
+
+// err maps a transfer status to the error code a synchronous transfer would
+// have returned. Unlike sync.go, a cancelled transfer maps to
+// LIBUSB_ERROR_INTERRUPTED so that it can be told apart from an I/O error.
+func (s TransferStatus) err() libusb_error {
+	switch s {
+	case TransferCompleted:
+		return LIBUSB_SUCCESS
+	case TransferTimedOut:
+		return LIBUSB_ERROR_TIMEOUT
+	case TransferStall:
+		return LIBUSB_ERROR_PIPE
+	case TransferNoDevice:
+		return LIBUSB_ERROR_NO_DEVICE
+	case TransferOverflow:
+		return LIBUSB_ERROR_OVERFLOW
+	case TransferCancelled:
+		return LIBUSB_ERROR_INTERRUPTED
+	}
+	return LIBUSB_ERROR_IO
+}
+
+// codeError converts a libusb return code into an *Error for an operation
+// that is not tied to a device. Success and positive return values (byte or
+// device counts) yield nil.
+func codeError(op string, r libusb_error) error {
+	if r >= LIBUSB_SUCCESS {
+		return nil
+	}
+	return &Error{Op: op, Endpoint: -1, Err: r}
+}
+
+// codeError is like the package level codeError, but records the device.
+func (d *Device) codeError(op string, r libusb_error) error {
+	if r >= LIBUSB_SUCCESS {
+		return nil
+	}
+	e := &Error{Op: op, Endpoint: -1, Err: r}
+	if d.dev != nil {
+		desc := libusb_get_device_descriptor(d.dev)
+		e.Bus = int(d.dev.bus_number)
+		e.Address = int(d.dev.device_address)
+		e.VendorID = desc.idVendor
+		e.ProductID = desc.idProduct
+	}
+	return e
+}
+
+// descriptorError reports err, a *DescriptorError, as the failure of op.
+func descriptorError(op string, err error) error {
+	return &Error{Op: op, Endpoint: -1, Err: err}
+}
+
+// descriptorError is like the package level descriptorError, but records
+// the device.
+func (d *Device) descriptorError(op string, err error) error {
+	e := d.codeError(op, LIBUSB_ERROR_IO).(*Error)
+	e.Err = err
+	return e
+}
+
+// endpointError is like codeError, but also records the endpoint and the
+// number of bytes moved before the failure.
+func (d *Device) endpointError(op string, endpoint uint8, r libusb_error, transferred int) error {
+	err := d.codeError(op, r)
+	if err != nil {
+		e := err.(*Error)
+		e.Endpoint = int(endpoint)
+		e.Transferred = transferred
+	}
+	return err
+}
+
+func (h *DeviceHandle) codeError(op string, r libusb_error) error {
+	return h.dev.codeError(op, r)
+}
+
+func (h *DeviceHandle) transferError(op string, endpoint uint8, r libusb_error, transferred int) error {
+	return h.dev.endpointError(op, endpoint, r, transferred)
+}
+
+// contextError is like transferError, but reports ctx.Err() in place of
+// ErrInterrupted when the operation was abandoned because ctx was done.
+func (h *DeviceHandle) contextError(ctx context.Context, op string, endpoint uint8, r libusb_error, transferred int) error {
+	err := h.transferError(op, endpoint, r, transferred)
+	if r == LIBUSB_ERROR_INTERRUPTED && ctx.Err() != nil {
+		err.(*Error).Err = ctx.Err()
+	}
+	return err
+}
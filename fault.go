@@ -0,0 +1,542 @@
+package usb
+
+import (
+	"errors"
+	"math/rand"
+	"sync"
+)
+
+// FaultKind selects what an injected Fault does to a transfer.
+type FaultKind int
+
+const (
+	// FaultSubmitError makes submission fail with Fault.Err.
+	FaultSubmitError FaultKind = iota
+	// FaultStall completes the transfer with TransferStall.
+	FaultStall
+	// FaultTimeout completes the transfer with TransferTimedOut.
+	FaultTimeout
+	// FaultNoDevice completes the transfer with TransferNoDevice.
+	FaultNoDevice
+	// FaultError completes the transfer with TransferError.
+	FaultError
+	// FaultShort lets at most Fault.Length bytes of a bulk or interrupt
+	// transfer through to the device. Control and isochronous transfers do
+	// not match it.
+	FaultShort
+	// FaultUnplug disconnects the device right after the transfer is
+	// submitted, so that it completes with TransferNoDevice. Replug
+	// connects it again.
+	FaultUnplug
+)
+
+// A Fault describes a failure for a FaultInjector to inject.
+type Fault struct {
+	Kind FaultKind
+
+	// Endpoints lists the addresses of the endpoints whose transfers are
+	// hit, 0 standing for the default control pipe. A Fault with no
+	// Endpoints hits transfers to every endpoint.
+	Endpoints []uint8
+
+	// Probability is the chance that a matching transfer is hit, from 0 to
+	// 1. Zero is taken to mean 1.
+	Probability float64
+
+	// After lets the first After matching transfers through untouched.
+	After int
+
+	// Count limits how many times the fault fires. Zero means no limit.
+	Count int
+
+	// Err is the error FaultSubmitError fails submission with. It defaults
+	// to ErrIO.
+	Err error
+
+	// Length is the number of bytes FaultShort lets through.
+	Length int
+}
+
+type faultRule struct {
+	Fault
+	seen, fired int
+}
+
+/* a transfer passed through to the wrapped backend */
+type faultFlight struct {
+	itransfer *usbi_transfer
+	no_device bool
+}
+
+// A FaultInjector is a Backend that wraps another backend and makes
+// transfers fail in configurable ways, so that error handling can be
+// tested. Stalls, timeouts and the like are completed by the injector
+// without reaching the wrapped backend, through the same completion path
+// as real ones.
+type FaultInjector struct {
+	b Backend
+
+	mu       sync.Mutex
+	rand     *rand.Rand
+	rules    []*faultRule
+	flying   map[*usbi_transfer]*faultFlight
+	injected map[*usbi_transfer]libusb_transfer_status
+
+	/* a reference to each unplugged device, to connect it again from */
+	unplugged map[uint64]*libusb_device
+}
+
+// NewFaultInjector returns a FaultInjector wrapping b. Probabilistic faults
+// are drawn from a source seeded with seed, so runs are repeatable.
+func NewFaultInjector(b Backend, seed int64) *FaultInjector {
+	return &FaultInjector{
+		b:         b,
+		rand:      rand.New(rand.NewSource(seed)),
+		flying:    make(map[*usbi_transfer]*faultFlight),
+		injected:  make(map[*usbi_transfer]libusb_transfer_status),
+		unplugged: make(map[uint64]*libusb_device),
+	}
+}
+
+// Backend returns the Backend to install with SetBackend.
+func (f *FaultInjector) Backend() Backend {
+	return (*faultBackend)(f)
+}
+
+// Add arms a fault. Faults are matched against each submitted transfer in
+// the order they were added, and the first that fires is applied. A
+// FaultShort with a negative Length or aimed at the default control pipe
+// fails with ErrInvalidParam.
+func (f *FaultInjector) Add(fault Fault) error {
+	if fault.Kind == FaultShort {
+		if fault.Length < 0 {
+			return codeError("add fault", LIBUSB_ERROR_INVALID_PARAM)
+		}
+		for _, ep := range fault.Endpoints {
+			if ep&^uint8(LIBUSB_ENDPOINT_DIR_MASK) == 0 {
+				return codeError("add fault", LIBUSB_ERROR_INVALID_PARAM)
+			}
+		}
+	}
+	fault.Endpoints = append([]uint8(nil), fault.Endpoints...)
+
+	f.mu.Lock()
+	f.rules = append(f.rules, &faultRule{Fault: fault})
+	f.mu.Unlock()
+	return nil
+}
+
+// Clear disarms all faults.
+func (f *FaultInjector) Clear() {
+	f.mu.Lock()
+	f.rules = nil
+	f.mu.Unlock()
+}
+
+// Unplug makes a device appear disconnected: every context sees it leave
+// as a hotplug event, its transfers in flight complete with
+// TransferNoDevice and its open handles fail with ErrNoDevice. Backends
+// that rescan the bus, such as the Linux one, find it again on their next
+// hotplug poll.
+func (f *FaultInjector) Unplug(d *Device) {
+	f.unplug(d.dev.session_data, nil)
+}
+
+// Replug connects a device disconnected by Unplug or FaultUnplug again, as
+// a new Device that every context sees arrive as a hotplug event. Handles
+// opened before it was unplugged keep failing with ErrNoDevice. It fails
+// with ErrNotFound if the device was not unplugged by f.
+func (f *FaultInjector) Replug(d *Device) error {
+	session := d.dev.session_data
+	f.mu.Lock()
+	prev := f.unplugged[session]
+	delete(f.unplugged, session)
+	f.mu.Unlock()
+	if prev == nil {
+		return d.codeError("replug", LIBUSB_ERROR_NOT_FOUND)
+	}
+
+	active_contexts_lock.Lock()
+	for e := active_contexts_list.next; e != active_contexts_list; e = e.next {
+		fault_reconnect_device(list_entry(e).(*libusb_context), prev)
+	}
+	active_contexts_lock.Unlock()
+	libusb_unref_device(prev)
+	return nil
+}
+
+func fault_reconnect_device(ctx *libusb_context, prev *libusb_device) {
+	dev := usbi_get_device_by_session_id(ctx, prev.session_data)
+	if dev != nil {
+		/* device already exists in the context */
+		libusb_unref_device(dev)
+		return
+	}
+
+	dev = usbi_alloc_device(ctx, prev.session_data)
+	dev.os_priv = prev.os_priv
+	dev.bus_number = prev.bus_number
+	dev.port_number = prev.port_number
+	dev.device_address = prev.device_address
+	dev.speed = prev.speed
+	if prev.parent_dev != nil {
+		/* the reference is dropped when the device is freed */
+		dev.parent_dev = usbi_get_device_by_session_id(ctx, prev.parent_dev.session_data)
+	}
+
+	if usbi_sanitize_device(dev) < 0 {
+		libusb_unref_device(dev)
+		return
+	}
+	usbi_connect_device(dev)
+}
+
+// unplug disconnects a device. current is a transfer whose lock the caller
+// already holds.
+func (f *FaultInjector) unplug(session uint64, current *usbi_transfer) {
+	var prev *libusb_device
+	active_contexts_lock.Lock()
+	for e := active_contexts_list.next; e != active_contexts_list; e = e.next {
+		dev := usbi_get_device_by_session_id(list_entry(e).(*libusb_context), session)
+		if dev != nil {
+			usbi_disconnect_device(dev)
+			if prev == nil {
+				prev = dev
+			} else {
+				libusb_unref_device(dev)
+			}
+		}
+	}
+	active_contexts_lock.Unlock()
+
+	if prev != nil {
+		f.mu.Lock()
+		if old := f.unplugged[session]; old != nil {
+			libusb_unref_device(old)
+		}
+		f.unplugged[session] = prev
+		f.mu.Unlock()
+	}
+
+	var victims []*usbi_transfer
+	f.mu.Lock()
+	for itransfer, fl := range f.flying {
+		if itransfer.libusbTransfer.dev_handle.dev.session_data == session {
+			fl.no_device = true
+			victims = append(victims, itransfer)
+		}
+	}
+	f.mu.Unlock()
+
+	for _, itransfer := range victims {
+		if itransfer != current {
+			itransfer.lock.Lock()
+		}
+		f.b.Cancel_transfer(itransfer)
+		if itransfer != current {
+			itransfer.lock.Unlock()
+		}
+	}
+}
+
+// pick returns the fault to apply to a transfer, if any.
+func (f *FaultInjector) pick(transfer *libusb_transfer) *Fault {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, rule := range f.rules {
+		if !rule.matches(transfer) {
+			continue
+		}
+		rule.seen++
+		if rule.seen <= rule.After || (rule.Count > 0 && rule.fired >= rule.Count) {
+			continue
+		}
+		if rule.Probability > 0 && rule.Probability < 1 && f.rand.Float64() >= rule.Probability {
+			continue
+		}
+		rule.fired++
+		fault := rule.Fault
+		return &fault
+	}
+	return nil
+}
+
+// matches reports whether a transfer is one the rule's fault applies to.
+func (rule *faultRule) matches(transfer *libusb_transfer) bool {
+	if rule.Kind == FaultShort && (transfer._type == uint8(LIBUSB_TRANSFER_TYPE_CONTROL) ||
+		transfer._type == uint8(LIBUSB_TRANSFER_TYPE_ISOCHRONOUS)) {
+		return false
+	}
+	if len(rule.Endpoints) == 0 {
+		return true
+	}
+	for _, ep := range rule.Endpoints {
+		if ep == transfer.endpoint {
+			return true
+		}
+	}
+	return false
+}
+
+type faultBackend FaultInjector
+
+func (b *faultBackend) inj() *FaultInjector {
+	return (*FaultInjector)(b)
+}
+
+func (b *faultBackend) Submit_transfer(itransfer *usbi_transfer) libusb_error {
+	transfer := itransfer.libusbTransfer
+	if !transfer.dev_handle.dev.attached {
+		return LIBUSB_ERROR_NO_DEVICE
+	}
+
+	fault := b.inj().pick(transfer)
+	if fault == nil {
+		return b.pass(itransfer, -1)
+	}
+
+	switch fault.Kind {
+	case FaultSubmitError:
+		var code libusb_error
+		if !errors.As(fault.Err, &code) || code >= 0 {
+			code = LIBUSB_ERROR_IO
+		}
+		return code
+	case FaultStall:
+		return b.inject(itransfer, LIBUSB_TRANSFER_STALL)
+	case FaultTimeout:
+		return b.inject(itransfer, LIBUSB_TRANSFER_TIMED_OUT)
+	case FaultNoDevice:
+		return b.inject(itransfer, LIBUSB_TRANSFER_NO_DEVICE)
+	case FaultError:
+		return b.inject(itransfer, LIBUSB_TRANSFER_ERROR)
+	case FaultShort:
+		return b.pass(itransfer, fault.Length)
+	case FaultUnplug:
+		r := b.pass(itransfer, -1)
+		if r == LIBUSB_SUCCESS {
+			b.inj().unplug(transfer.dev_handle.dev.session_data, itransfer)
+		}
+		return r
+	}
+	return b.pass(itransfer, -1)
+}
+
+// inject completes a transfer with status without submitting it.
+func (b *faultBackend) inject(itransfer *usbi_transfer, status libusb_transfer_status) libusb_error {
+	ctx := itransfer.libusbTransfer.dev_handle.dev.ctx
+
+	b.mu.Lock()
+	b.injected[itransfer] = status
+	b.mu.Unlock()
+
+	/* there is nothing for the core to time out */
+	ctx.flying_transfers_lock.Lock()
+	itransfer.timeout_flags |= uint8(USBI_TRANSFER_OS_HANDLES_TIMEOUT)
+	ctx.flying_transfers_lock.Unlock()
+
+	usbi_signal_transfer_completion(itransfer)
+	return LIBUSB_SUCCESS
+}
+
+// pass submits a transfer to the wrapped backend, shortened to limit bytes
+// if limit is not negative. Its callback is interposed to undo the
+// shortening and to report unplugged devices.
+func (b *faultBackend) pass(itransfer *usbi_transfer, limit int) libusb_error {
+	transfer := itransfer.libusbTransfer
+	callback := transfer.callback
+	length := transfer.length
+	if limit >= 0 && limit < length {
+		transfer.length = limit
+	}
+
+	fl := &faultFlight{itransfer: itransfer}
+	b.mu.Lock()
+	b.flying[itransfer] = fl
+	b.mu.Unlock()
+
+	transfer.callback = func(transfer *libusb_transfer) {
+		transfer.callback = callback
+		transfer.length = length
+
+		b.mu.Lock()
+		delete(b.flying, itransfer)
+		no_device := fl.no_device
+		b.mu.Unlock()
+
+		if no_device && transfer.status != LIBUSB_TRANSFER_COMPLETED {
+			transfer.status = LIBUSB_TRANSFER_NO_DEVICE
+		}
+		/* the core compared against the shortened length */
+		if transfer.status == LIBUSB_TRANSFER_COMPLETED &&
+			transfer.flags&uint8(LIBUSB_TRANSFER_SHORT_NOT_OK) != 0 &&
+			transfer.actual_length < length {
+			transfer.status = LIBUSB_TRANSFER_ERROR
+		}
+		if callback != nil {
+			callback(transfer)
+		}
+	}
+
+	r := b.b.Submit_transfer(itransfer)
+	if r < 0 {
+		transfer.callback = callback
+		transfer.length = length
+		b.mu.Lock()
+		delete(b.flying, itransfer)
+		b.mu.Unlock()
+	}
+	return r
+}
+
+func (b *faultBackend) Cancel_transfer(itransfer *usbi_transfer) libusb_error {
+	b.mu.Lock()
+	_, injected := b.injected[itransfer]
+	b.mu.Unlock()
+	if injected {
+		/* already completing with the injected status */
+		return LIBUSB_ERROR_NOT_FOUND
+	}
+	return b.b.Cancel_transfer(itransfer)
+}
+
+func (b *faultBackend) Handle_transfer_completion(itransfer *usbi_transfer) libusb_error {
+	b.mu.Lock()
+	status, injected := b.injected[itransfer]
+	delete(b.injected, itransfer)
+	b.mu.Unlock()
+
+	if !injected {
+		return b.b.Handle_transfer_completion(itransfer)
+	}
+
+	itransfer.lock.Lock()
+	itransfer.transferred = 0
+	itransfer.lock.Unlock()
+	return libusb_error(usbi_handle_transfer_completion(itransfer, status))
+}
+
+func (b *faultBackend) Name() string {
+	return b.b.Name()
+}
+
+func (b *faultBackend) Caps() uint32 {
+	return b.b.Caps()
+}
+
+func (b *faultBackend) Init(ctx *libusb_context) libusb_error {
+	return b.b.Init(ctx)
+}
+
+func (b *faultBackend) Exit() {
+	b.b.Exit()
+}
+
+func (b *faultBackend) Get_device_list(ctx *libusb_context, discdevs *[]*libusb_device) libusb_error {
+	return b.b.Get_device_list(ctx, discdevs)
+}
+
+func (b *faultBackend) Hotplug_poll() {
+	b.b.Hotplug_poll()
+}
+
+func (b *faultBackend) Open(handle *libusb_device_handle) libusb_error {
+	return b.b.Open(handle)
+}
+
+func (b *faultBackend) Close(handle *libusb_device_handle) {
+	b.b.Close(handle)
+}
+
+func (b *faultBackend) Get_device_descriptor(dev *libusb_device, buffer []uint8, host_endian *int) libusb_error {
+	return b.b.Get_device_descriptor(dev, buffer, host_endian)
+}
+
+func (b *faultBackend) Get_active_config_descriptor(dev *libusb_device, buffer []uint8, host_endian *int) libusb_error {
+	return b.b.Get_active_config_descriptor(dev, buffer, host_endian)
+}
+
+func (b *faultBackend) Get_config_descriptor(dev *libusb_device, config_index uint8, buffer []uint8, length int, host_endian *int) libusb_error {
+	return b.b.Get_config_descriptor(dev, config_index, buffer, length, host_endian)
+}
+
+func (b *faultBackend) Get_config_descriptor_by_value(dev *libusb_device, value uint8, buffer *[]uint8, host_endian *int) libusb_error {
+	return b.b.Get_config_descriptor_by_value(dev, value, buffer, host_endian)
+}
+
+func (b *faultBackend) Get_configuration(handle *libusb_device_handle, config *int) libusb_error {
+	return b.b.Get_configuration(handle, config)
+}
+
+func (b *faultBackend) Set_configuration(handle *libusb_device_handle, config *int) libusb_error {
+	return b.b.Set_configuration(handle, config)
+}
+
+func (b *faultBackend) Claim_interface(handle *libusb_device_handle, iface uint) libusb_error {
+	return b.b.Claim_interface(handle, iface)
+}
+
+func (b *faultBackend) Release_interface(handle *libusb_device_handle, iface uint) libusb_error {
+	return b.b.Release_interface(handle, iface)
+}
+
+func (b *faultBackend) Set_interface_altsetting(handle *libusb_device_handle, iface uint, altsetting int) libusb_error {
+	return b.b.Set_interface_altsetting(handle, iface, altsetting)
+}
+
+func (b *faultBackend) Clear_halt(handle *libusb_device_handle, endpoint uint8) libusb_error {
+	return b.b.Clear_halt(handle, endpoint)
+}
+
+func (b *faultBackend) Reset_device(handle *libusb_device_handle) libusb_error {
+	return b.b.Reset_device(handle)
+}
+
+func (b *faultBackend) Alloc_streams(handle *libusb_device_handle, num_streams uint32, endpoints []uint8, num_endpoints int) libusb_error {
+	return b.b.Alloc_streams(handle, num_streams, endpoints, num_endpoints)
+}
+
+func (b *faultBackend) Free_streams(handle *libusb_device_handle, endpoints []uint8, num_endpoints int) libusb_error {
+	return b.b.Free_streams(handle, endpoints, num_endpoints)
+}
+
+func (b *faultBackend) Dev_mem_alloc(handle *libusb_device_handle, length int) []uint8 {
+	return b.b.Dev_mem_alloc(handle, length)
+}
+
+func (b *faultBackend) Dev_mem_free(handle *libusb_device_handle, buffer []uint8, length int) libusb_error {
+	return b.b.Dev_mem_free(handle, buffer, length)
+}
+
+func (b *faultBackend) Kernel_driver_active(handle *libusb_device_handle, iface int) libusb_error {
+	return b.b.Kernel_driver_active(handle, iface)
+}
+
+func (b *faultBackend) Detach_kernel_driver(handle *libusb_device_handle, iface int) libusb_error {
+	return b.b.Detach_kernel_driver(handle, iface)
+}
+
+func (b *faultBackend) Attach_kernel_driver(handle *libusb_device_handle, iface int) libusb_error {
+	return b.b.Attach_kernel_driver(handle, iface)
+}
+
+func (b *faultBackend) Destroy_device(dev *libusb_device) {
+	b.b.Destroy_device(dev)
+}
+
+func (b *faultBackend) Clear_transfer_priv(itransfer *usbi_transfer) {
+	b.b.Clear_transfer_priv(itransfer)
+}
+
+func (b *faultBackend) Device_priv_size() int {
+	return b.b.Device_priv_size()
+}
+
+func (b *faultBackend) Device_handle_priv_size() int {
+	return b.b.Device_handle_priv_size()
+}
+
+func (b *faultBackend) Transfer_priv_size() int {
+	return b.b.Transfer_priv_size()
+}
@@ -0,0 +1,151 @@
+package usb
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// openFaulty opens the loopback device through a FaultInjector wrapping the
+// simulator. Its interrupt IN endpoint blocks until the transfer is
+// aborted.
+func openFaulty(t *testing.T) (*Context, *DeviceHandle, *FaultInjector) {
+	t.Helper()
+	d := newLoopbackDevice()
+	d.Endpoints[0x82] = func(req *SimRequest) (int, error) {
+		<-req.Done
+		return 0, nil
+	}
+	sim := NewSimulator()
+	if err := sim.Plug(d); err != nil {
+		t.Fatal(err)
+	}
+	f := NewFaultInjector(sim.Backend(), 1)
+	prev := SetBackend(f.Backend())
+	t.Cleanup(func() { SetBackend(prev) })
+
+	c, err := NewContext()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c, openTestDevice(t, c), f
+}
+
+func TestFaultCompletions(t *testing.T) {
+	_, h, f := openFaulty(t)
+	buf := make([]byte, 8)
+
+	tests := []struct {
+		kind FaultKind
+		want error
+	}{
+		{FaultSubmitError, ErrIO},
+		{FaultStall, ErrPipe},
+		{FaultTimeout, ErrTimeout},
+		{FaultNoDevice, ErrNoDevice},
+		{FaultError, ErrIO},
+	}
+	for _, tt := range tests {
+		f.Clear()
+		if err := f.Add(Fault{Kind: tt.kind, Endpoints: []uint8{0x81}}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := h.BulkTransfer(0x81, buf, time.Second); !errors.Is(err, tt.want) {
+			t.Errorf("fault %d: bulk IN returned %v, want %v", tt.kind, err, tt.want)
+		}
+		/* other endpoints are not hit */
+		if _, err := h.BulkTransfer(0x01, buf, time.Second); err != nil {
+			t.Errorf("fault %d: bulk OUT returned %v", tt.kind, err)
+		}
+	}
+}
+
+func TestFaultAfterCount(t *testing.T) {
+	_, h, f := openFaulty(t)
+	buf := make([]byte, 8)
+
+	/* no Endpoints hits the control pipe too */
+	if err := f.Add(Fault{Kind: FaultTimeout, After: 1, Count: 1}); err != nil {
+		t.Fatal(err)
+	}
+	want := []error{nil, ErrTimeout, nil}
+	for i, w := range want {
+		_, err := h.Control(0xc0, 0x01, 0, 0, buf[:2], time.Second)
+		if !errors.Is(err, w) {
+			t.Errorf("request %d returned %v, want %v", i, err, w)
+		}
+	}
+}
+
+func TestFaultShort(t *testing.T) {
+	_, h, f := openFaulty(t)
+
+	if err := f.Add(Fault{Kind: FaultShort, Endpoints: []uint8{0}, Length: 1}); !errors.Is(err, ErrInvalidParam) {
+		t.Errorf("short fault on the control pipe added with %v, want ErrInvalidParam", err)
+	}
+	if err := f.Add(Fault{Kind: FaultShort, Length: 3, Count: 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	/* control transfers neither match nor use up the fault */
+	buf := make([]byte, 2)
+	if n, err := h.Control(0xc0, 0x01, 0xbeef, 0, buf, time.Second); err != nil || n != 2 {
+		t.Fatalf("control request moved %d bytes, %v", n, err)
+	}
+	n, err := h.BulkTransfer(0x01, []byte("shortened"), time.Second)
+	if err != nil || n != 3 {
+		t.Fatalf("bulk OUT moved %d bytes, %v, want 3", n, err)
+	}
+	in := make([]byte, 64)
+	n, err = h.BulkTransfer(0x81, in, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(in[:n]) != "sho" {
+		t.Errorf("device received %q, want %q", in[:n], "sho")
+	}
+}
+
+func TestFaultUnplugReplug(t *testing.T) {
+	c, h, f := openFaulty(t)
+	devs, err := c.Devices()
+	if err != nil || len(devs) != 1 {
+		t.Fatalf("found %d devices, %v", len(devs), err)
+	}
+	dev := devs[0]
+	defer dev.Close()
+
+	if err := f.Add(Fault{Kind: FaultUnplug, Endpoints: []uint8{0x82}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := h.InterruptTransfer(0x82, make([]byte, 8), time.Second); !errors.Is(err, ErrNoDevice) {
+		t.Errorf("transfer returned %v, want ErrNoDevice", err)
+	}
+	if _, err := h.BulkTransfer(0x01, []byte("x"), time.Second); !errors.Is(err, ErrNoDevice) {
+		t.Errorf("transfer after unplugging returned %v, want ErrNoDevice", err)
+	}
+	h.Close()
+	devs, err = c.Devices()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(devs) != 0 {
+		t.Fatalf("found %d devices after unplugging, want 0", len(devs))
+	}
+
+	if err := f.Replug(dev); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Replug(dev); !errors.Is(err, ErrNotFound) {
+		t.Errorf("second replug returned %v, want ErrNotFound", err)
+	}
+	h, err = c.OpenDeviceWithVIDPID(0x1234, 0x5678)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+	if _, err := h.BulkTransfer(0x01, []byte("x"), time.Second); err != nil {
+		t.Errorf("transfer after replugging returned %v", err)
+	}
+}
@@ -0,0 +1,3 @@
+module github.com/200sc/go-usb
+
+go 1.21
@@ -0,0 +1,229 @@
+package usb
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// A DeviceHandle is an open device on which I/O can be performed.
+type DeviceHandle struct {
+	dev    *Device
+	handle *libusb_device_handle
+
+	mu               sync.Mutex
+	stall            *StallPolicy
+	endpointStall    map[uint8]*StallPolicy
+	preferredLangIDs []uint16
+	strings          map[stringKey]string
+
+	/* langMu guards langIDs and is held while they are read from the
+	 * device, so that concurrent callers make a single request. mu cannot
+	 * be held that long, as the event goroutine takes it when a transfer
+	 * stalls. */
+	langMu  sync.Mutex
+	langIDs []uint16
+}
+
+// Close closes the handle and releases its reference on the device. All
+// claimed interfaces should be released first.
+func (h *DeviceHandle) Close() error {
+	if h.handle == nil {
+		return nil
+	}
+	libusb_close(h.handle)
+	h.handle = nil
+	return h.dev.Close()
+}
+
+// Device returns the device this handle was opened on. The returned Device
+// is owned by the handle and must not be closed by the caller.
+func (h *DeviceHandle) Device() *Device {
+	return h.dev
+}
+
+// Configuration returns the bConfigurationValue of the active
+// configuration, or 0 if the device is unconfigured.
+func (h *DeviceHandle) Configuration() (int, error) {
+	var config int
+	if r := libusb_get_configuration(h.handle, &config); r < 0 {
+		return 0, h.codeError("get configuration", r)
+	}
+	return config, nil
+}
+
+// SetConfiguration activates a configuration by its bConfigurationValue.
+// A value of -1 puts the device in the unconfigured state.
+func (h *DeviceHandle) SetConfiguration(config int) error {
+	return h.codeError("set configuration", libusb_set_configuration(h.handle, config))
+}
+
+// ClaimInterface claims an interface so that I/O can be performed on its
+// endpoints.
+func (h *DeviceHandle) ClaimInterface(iface int) error {
+	return h.codeError("claim interface", libusb_claim_interface(h.handle, uint(iface)))
+}
+
+// ReleaseInterface releases a previously claimed interface.
+func (h *DeviceHandle) ReleaseInterface(iface int) error {
+	return h.codeError("release interface", libusb_release_interface(h.handle, uint(iface)))
+}
+
+// SetInterfaceAltSetting activates an alternate setting of a claimed
+// interface.
+func (h *DeviceHandle) SetInterfaceAltSetting(iface, altsetting int) error {
+	return h.codeError("set interface alt setting", libusb_set_interface_alt_setting(h.handle, uint(iface), altsetting))
+}
+
+// ClearHalt clears the halt/stall condition of an endpoint.
+func (h *DeviceHandle) ClearHalt(endpoint uint8) error {
+	return h.transferError("clear halt", endpoint, libusb_clear_halt(h.handle, endpoint), 0)
+}
+
+// Reset performs a USB port reset. If the device has to be re-enumerated
+// afterwards, an error wrapping ErrNotFound is returned and the handle should be
+// closed.
+func (h *DeviceHandle) Reset() error {
+	return h.codeError("reset", libusb_reset_device(h.handle))
+}
+
+// KernelDriverActive reports whether a kernel driver is bound to an
+// interface.
+func (h *DeviceHandle) KernelDriverActive(iface int) (bool, error) {
+	r := libusb_kernel_driver_active(h.handle, iface)
+	if r < 0 {
+		return false, h.codeError("kernel driver active", r)
+	}
+	return r == 1, nil
+}
+
+// DetachKernelDriver detaches the kernel driver bound to an interface.
+func (h *DeviceHandle) DetachKernelDriver(iface int) error {
+	return h.codeError("detach kernel driver", libusb_detach_kernel_driver(h.handle, iface))
+}
+
+// AttachKernelDriver re-attaches a kernel driver previously detached with
+// DetachKernelDriver.
+func (h *DeviceHandle) AttachKernelDriver(iface int) error {
+	return h.codeError("attach kernel driver", libusb_attach_kernel_driver(h.handle, iface))
+}
+
+// SetAutoDetachKernelDriver enables or disables automatic kernel driver
+// detachment when claiming and releasing interfaces.
+func (h *DeviceHandle) SetAutoDetachKernelDriver(enable bool) error {
+	e := 0
+	if enable {
+		e = 1
+	}
+	return h.codeError("set auto detach kernel driver", libusb_set_auto_detach_kernel_driver(h.handle, e))
+}
+
+// Control performs a synchronous control transfer. The direction is taken
+// from bit 7 of requestType; data is written to the device for OUT requests
+// and filled from the device for IN requests. It returns the number of bytes
+// transferred, also alongside an error. A timeout of 0 waits forever.
+//
+// Control must not be called from a transfer or hotplug callback, which runs
+// on the goroutine that would complete the transfer: it would deadlock.
+func (h *DeviceHandle) Control(requestType, request uint8, value, index uint16, data []byte, timeout time.Duration) (int, error) {
+	return h.control(context.Background(), requestType, request, value, index, data, timeout)
+}
+
+// ControlContext is like Control, but is bounded by ctx instead of a
+// timeout. If ctx is done before the transfer completes, the transfer is
+// cancelled and the returned error wraps ctx.Err(); the count of bytes moved
+// before the cancellation is still returned.
+func (h *DeviceHandle) ControlContext(ctx context.Context, requestType, request uint8, value, index uint16, data []byte) (int, error) {
+	return h.control(ctx, requestType, request, value, index, data, 0)
+}
+
+func (h *DeviceHandle) control(ctx context.Context, requestType, request uint8, value, index uint16, data []byte, timeout time.Duration) (int, error) {
+	var transferred int
+	r := libusb_control_transfer_context(ctx, h.handle, requestType, request,
+		value, index, data, uint16(len(data)), &transferred, timeout)
+	if r < 0 {
+		return transferred, h.contextError(ctx, "control transfer", 0, r, transferred)
+	}
+	return int(r), nil
+}
+
+// BulkTransfer performs a synchronous bulk transfer on an endpoint, whose
+// direction bit selects reading into or writing from data. The number of
+// bytes transferred is returned even if an error such as a timeout occurs.
+// Like Control, it must not be called from a transfer or hotplug callback.
+func (h *DeviceHandle) BulkTransfer(endpoint uint8, data []byte, timeout time.Duration) (int, error) {
+	return h.syncTransfer(context.Background(), "bulk transfer", LIBUSB_TRANSFER_TYPE_BULK, endpoint, data, timeout)
+}
+
+// BulkTransferContext is like BulkTransfer, but is bounded by ctx instead of
+// a timeout. If ctx is done before the transfer completes, the transfer is
+// cancelled and the returned error wraps ctx.Err(); the count of bytes moved
+// before the cancellation is still returned.
+func (h *DeviceHandle) BulkTransferContext(ctx context.Context, endpoint uint8, data []byte) (int, error) {
+	return h.syncTransfer(ctx, "bulk transfer", LIBUSB_TRANSFER_TYPE_BULK, endpoint, data, 0)
+}
+
+// InterruptTransfer performs a synchronous interrupt transfer, with the same
+// semantics as BulkTransfer.
+func (h *DeviceHandle) InterruptTransfer(endpoint uint8, data []byte, timeout time.Duration) (int, error) {
+	return h.syncTransfer(context.Background(), "interrupt transfer", LIBUSB_TRANSFER_TYPE_INTERRUPT, endpoint, data, timeout)
+}
+
+// InterruptTransferContext is like InterruptTransfer, but is bounded by ctx
+// in the same way as BulkTransferContext.
+func (h *DeviceHandle) InterruptTransferContext(ctx context.Context, endpoint uint8, data []byte) (int, error) {
+	return h.syncTransfer(ctx, "interrupt transfer", LIBUSB_TRANSFER_TYPE_INTERRUPT, endpoint, data, 0)
+}
+
+// syncTransfer performs a bulk or interrupt transfer, recovering from
+// stalls according to the endpoint's StallPolicy. A retry continues from
+// where the stalled attempt left off.
+func (h *DeviceHandle) syncTransfer(ctx context.Context, op string, typ libusb_transfer_type, endpoint uint8, data []byte, timeout time.Duration) (int, error) {
+	total := 0
+	for attempt := 0; ; attempt++ {
+		var transferred int
+		r := libusb_error(do_sync_bulk_transfer(ctx, h.handle, endpoint, data[total:],
+			len(data)-total, &transferred, timeout, uint8(typ)))
+		total += transferred
+		if r != LIBUSB_ERROR_PIPE {
+			return total, h.contextError(ctx, op, endpoint, r, total)
+		}
+		retry, delay := h.recoverStall(endpoint, attempt, true)
+		if !retry || !retryStalledSync(ctx, delay) {
+			return total, h.contextError(ctx, op, endpoint, r, total)
+		}
+	}
+}
+
+// Descriptor reads a descriptor of the given type and index from the default
+// control pipe into data, returning the number of bytes read.
+func (h *DeviceHandle) Descriptor(descType, index uint8, data []byte) (int, error) {
+	r := libusb_get_descriptor(h.handle, libusb_descriptor_type(descType),
+		libusb_descriptor_type(index), data, uint16(len(data)))
+	if r < 0 {
+		return 0, h.codeError("get descriptor", r)
+	}
+	return int(r), nil
+}
+
+// StringDescriptorASCII reads a string descriptor in the device's first
+// language, replacing non-ASCII characters with '?'. StringDescriptor
+// decodes the whole of Unicode.
+func (h *DeviceHandle) StringDescriptorASCII(index uint8) (string, error) {
+	buf := make([]uint8, 256)
+	r := libusb_get_string_descriptor_ascii(h.handle, index, buf, len(buf))
+	if r < 0 {
+		return "", h.codeError("get string descriptor", r)
+	}
+	return string(buf[:r]), nil
+}
+
+// durationToMillis converts a timeout to the millisecond count libusb
+// expects, rounding up so that a positive timeout never becomes unlimited.
+func durationToMillis(d time.Duration) uint {
+	if d <= 0 {
+		return 0
+	}
+	ms := (d + time.Millisecond - 1) / time.Millisecond
+	return uint(ms)
+}
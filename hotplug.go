@@ -1,5 +1,7 @@
 package usb
 
+import "sync"
+
 /*
  * Hotplug support for libusb
  * Copyright © 2012-2013 Nathan Hjelm <hjelmn@mac.com>
@@ -20,89 +22,96 @@ package usb
  * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA
  */
 
- /**
-  * The hotplug callback structure. The user populates this structure with
-  * libusb_hotplug_prepare_callback() and then calls libusb_hotplug_register_callback()
-  * to receive notification of hotplug events.
-  */
+/**
+ * The hotplug callback structure. The user populates this structure with
+ * libusb_hotplug_prepare_callback() and then calls libusb_hotplug_register_callback()
+ * to receive notification of hotplug events.
+ */
 type libusb_hotplug_callback struct {
-	 // Context this callback is associated with 
-	 ctx *libusb_context
-	 // Vendor ID to match or LIBUSB_HOTPLUG_MATCH_ANY 
-	 vendor_id int
-	 // Product ID to match or LIBUSB_HOTPLUG_MATCH_ANY 
-	 product_id int
-	 // Device class to match or LIBUSB_HOTPLUG_MATCH_ANY 
-	dev_class int 
-	 // Hotplug callback flags 
+	// Context this callback is associated with
+	ctx *libusb_context
+	// Vendor ID to match or LIBUSB_HOTPLUG_MATCH_ANY
+	vendor_id int
+	// Product ID to match or LIBUSB_HOTPLUG_MATCH_ANY
+	product_id int
+	// Device class to match or LIBUSB_HOTPLUG_MATCH_ANY
+	dev_class int
+	// Hotplug callback flags
 	flags libusb_hotplug_flag
-	 // Event(s) that will trigger this callback 
+	// Event(s) that will trigger this callback
 	events libusb_hotplug_event
-	 // Callback function to invoke for matching event/device 
+	// Callback function to invoke for matching event/device
 	cb libusb_hotplug_callback_fn
-	 // Handle for this callback (used to match on deregister) 
+	// Handle for this callback (used to match on deregister)
 	handle libusb_hotplug_callback_handle
-	 // User data that will be passed to the callback function 
+	// User data that will be passed to the callback function
 	user_data interface{}
-	 // Callback is marked for deletion 
-	needs_free int
-	 // List this callback is registered in (ctx.hotplug_cbs) 
+	// Callback is marked for deletion
+	needs_free bool
+	// List this callback is registered in (ctx.hotplug_cbs)
 	list *LinkedList
- }
- 
- type libusb_hotplug_message struct {
-	// The hotplug event that occurred 
+}
+
+type libusb_hotplug_message struct {
+	// The hotplug event that occurred
 	event libusb_hotplug_event
-	// The device for which this hotplug event occurred 
+	// The device for which this hotplug event occurred
 	device *libusb_device
-	// List this message is contained in (ctx.hotplug_msgs) 
-	list *LinkedList
- }
- 
-func usbi_hotplug_match_cb(ctx *libusb_context, dev *libusb_device, 
+}
+
+/* the next callback handle to hand out. it is protected by the hotplug lock
+ * of whichever context is registering a callback; it doesn't matter if the
+ * same handle is used for different contexts, only that the handle is unique
+ * for each context */
+var usbi_next_hotplug_handle libusb_hotplug_callback_handle = 1
+var usbi_next_hotplug_handle_lock sync.Mutex
+
+func usbi_hotplug_match_cb(ctx *libusb_context, dev *libusb_device,
 	event libusb_hotplug_event, hotplug_cb *libusb_hotplug_callback) bool {
 
 	/* Handle lazy deregistration of callback */
-	if (hotplug_cb.needs_free) {
+	if hotplug_cb.needs_free {
 		/* Free callback */
 		return true
 	}
 
-	if (!(hotplug_cb.events & event)) {
+	if hotplug_cb.events&event == 0 {
 		return false
 	}
 
-	if (LIBUSB_HOTPLUG_MATCH_ANY != hotplug_cb.vendor_id &&
-	    hotplug_cb.vendor_id != dev.device_descriptor.idVendor) {
+	if LIBUSB_HOTPLUG_MATCH_ANY != hotplug_cb.vendor_id &&
+		hotplug_cb.vendor_id != int(dev.device_descriptor.idVendor) {
 		return false
 	}
 
-	if (LIBUSB_HOTPLUG_MATCH_ANY != hotplug_cb.product_id &&
-	    hotplug_cb.product_id != dev.device_descriptor.idProduct) {
+	if LIBUSB_HOTPLUG_MATCH_ANY != hotplug_cb.product_id &&
+		hotplug_cb.product_id != int(dev.device_descriptor.idProduct) {
 		return false
 	}
 
-	if (LIBUSB_HOTPLUG_MATCH_ANY != hotplug_cb.dev_class &&
-	    hotplug_cb.dev_class != dev.device_descriptor.bDeviceClass) {
+	if LIBUSB_HOTPLUG_MATCH_ANY != hotplug_cb.dev_class &&
+		hotplug_cb.dev_class != int(dev.device_descriptor.bDeviceClass) {
 		return false
 	}
 
-	return hotplug_cb.cb(ctx, dev, event, hotplug_cb.user_data)
+	return hotplug_cb.cb(ctx, dev, event, hotplug_cb.user_data) != 0
 }
 
 func usbi_hotplug_match(ctx *libusb_context, dev *libusb_device, event libusb_hotplug_event) {
 	ctx.hotplug_cbs_lock.Lock()
- 	for hotplug_cb, next := list_entry((&ctx.hotplug_cbs).next, libusb_hotplug_callback, list), list_entry(hotplug_cb.member.next, libusb_hotplug_callback, list);
-		  &hotplug_cb.list != (&ctx.hotplug_cbs);
-		  hotplug_cb, next = next, list_entry(n.list.next, libusb_hotplug_callback, list) {
+	/* safe iteration because callbacks may be being deleted */
+	for e := ctx.hotplug_cbs.next; e != ctx.hotplug_cbs; {
+		next := e.next
+		hotplug_cb := list_entry(e).(*libusb_hotplug_callback)
 
 		ctx.hotplug_cbs_lock.Unlock()
 		ret := usbi_hotplug_match_cb(ctx, dev, event, hotplug_cb)
 		ctx.hotplug_cbs_lock.Lock()
-		
-		if ret != 0 {
+
+		if ret {
 			list_del(hotplug_cb.list)
 		}
+		e = next
 	}
 	ctx.hotplug_cbs_lock.Unlock()
 	/* the backend is expected to call the callback for each active transfer */
@@ -111,17 +120,10 @@ func usbi_hotplug_match(ctx *libusb_context, dev *libusb_device, event libusb_ho
 func usbi_hotplug_notification(ctx *libusb_context, dev *libusb_device, event libusb_hotplug_event) {
 	message := &libusb_hotplug_message{}
 	message.event = event
-	message.dev = dev
-
-	/* Take the event data lock and add this message to the list.
-	 * Only signal an event if there are no prior pending events. */
-	ctx.event_data_lock.Lock()
-	pending_events := usbi_pending_events(ctx);
-	list_add_tail(&message.list, &ctx.hotplug_msgs);
-	if pending_events == 0 {
-		usbi_signal_event(ctx)
-	}
-	ctx.event_data_lock.Unlock()
+	message.device = dev
+
+	/* Hand the message to the event handling goroutine. */
+	usbi_queue_event(ctx, usbi_event{hotplug: message})
 }
 
 func libusb_hotplug_register_callback(ctx *libusb_context,
@@ -131,102 +133,97 @@ func libusb_hotplug_register_callback(ctx *libusb_context,
 	callback_handle *libusb_hotplug_callback_handle) libusb_error {
 
 	/* check for hotplug support */
-	if (!libusb_has_capability(LIBUSB_CAP_HAS_HOTPLUG)) {
-		return LIBUSB_ERROR_NOT_SUPPORTED;
+	if !libusb_has_capability(LIBUSB_CAP_HAS_HOTPLUG) {
+		return LIBUSB_ERROR_NOT_SUPPORTED
 	}
 
-	var new_callback *libusb_hotplug_callback
-	handle_id := 1 
-
 	/* check for sane values */
-	if (LIBUSB_HOTPLUG_MATCH_ANY != vendor_id && (^0xffff & vendor_id)) ||
-	    (LIBUSB_HOTPLUG_MATCH_ANY != product_id && (^0xffff & product_id)) ||
-	    (LIBUSB_HOTPLUG_MATCH_ANY != dev_class && (^0xff & dev_class)) ||
-	    cb_fn == nil {
-		return LIBUSB_ERROR_INVALID_PARAM;
+	if (LIBUSB_HOTPLUG_MATCH_ANY != vendor_id && (^0xffff&vendor_id) != 0) ||
+		(LIBUSB_HOTPLUG_MATCH_ANY != product_id && (^0xffff&product_id) != 0) ||
+		(LIBUSB_HOTPLUG_MATCH_ANY != dev_class && (^0xff&dev_class) != 0) ||
+		cb_fn == nil {
+		return LIBUSB_ERROR_INVALID_PARAM
 	}
 
-	ctx = USBI_GET_CONTEXT(ctx);
+	ctx = USBI_GET_CONTEXT(ctx)
 
-	new_callback = &libusb_hotplug_callback{}
-	new_callback.ctx = ctx;
-	new_callback.vendor_id = vendor_id;
-	new_callback.product_id = product_id;
-	new_callback.dev_class = dev_class;
-	new_callback.flags = flags;
-	new_callback.events = events;
-	new_callback.cb = cb_fn;
-	new_callback.user_data = user_data;
-	new_callback.needs_free = 0;
+	new_callback := &libusb_hotplug_callback{}
+	new_callback.ctx = ctx
+	new_callback.vendor_id = vendor_id
+	new_callback.product_id = product_id
+	new_callback.dev_class = dev_class
+	new_callback.flags = flags
+	new_callback.events = events
+	new_callback.cb = cb_fn
+	new_callback.user_data = user_data
+	new_callback.needs_free = false
+	new_callback.list = &LinkedList{member: new_callback}
 
 	ctx.hotplug_cbs_lock.Lock()
 
-	/* protect the handle by the context hotplug lock. it doesn't matter if the same handle
-	 * is used for different contexts only that the handle is unique for this context */
-	new_callback.handle = handle_id
-	handle_id++
+	usbi_next_hotplug_handle_lock.Lock()
+	new_callback.handle = usbi_next_hotplug_handle
+	usbi_next_hotplug_handle++
+	usbi_next_hotplug_handle_lock.Unlock()
 
-	list_add(&new_callback.list, &ctx.hotplug_cbs);
+	list_add(new_callback.list, ctx.hotplug_cbs)
 
 	ctx.hotplug_cbs_lock.Unlock()
 
+	if flags&LIBUSB_HOTPLUG_ENUMERATE != 0 {
+		var devs []*libusb_device
 
-	if flags & LIBUSB_HOTPLUG_ENUMERATE != 0 {
-		var devs **libusb_device
-
-		l := int(libusb_get_device_list(ctx, &devs))
+		l := libusb_get_device_list(ctx, &devs)
 		if l < 0 {
 			libusb_hotplug_deregister_callback(ctx,
-							new_callback.handle);
-			return l;
+				new_callback.handle)
+			return l
 		}
 
-		for i := 0; i < l; i++ {
+		for i := 0; i < int(l); i++ {
 			usbi_hotplug_match_cb(ctx, devs[i],
-					LIBUSB_HOTPLUG_EVENT_DEVICE_ARRIVED,
-					new_callback);
+				LIBUSB_HOTPLUG_EVENT_DEVICE_ARRIVED,
+				new_callback)
 		}
 
-		libusb_free_device_list(devs, 1);
+		libusb_free_device_list(devs, 1)
 	}
 
 	if callback_handle != nil {
-		*callback_handle = new_callback.handle;
+		*callback_handle = new_callback.handle
 	}
 
-	return LIBUSB_SUCCESS;
+	return LIBUSB_SUCCESS
 }
 
-func libusb_hotplug_deregister_callback (ctx *libusb_context, callback_handle libusb_hotplug_callback_handle) {
+func libusb_hotplug_deregister_callback(ctx *libusb_context, callback_handle libusb_hotplug_callback_handle) {
 	/* check for hotplug support */
-	if (!libusb_has_capability(LIBUSB_CAP_HAS_HOTPLUG)) {
-		return;
+	if !libusb_has_capability(LIBUSB_CAP_HAS_HOTPLUG) {
+		return
 	}
 
-	ctx = USBI_GET_CONTEXT(ctx);
+	ctx = USBI_GET_CONTEXT(ctx)
 
 	ctx.hotplug_cbs_lock.Lock()
-	for hotplug_cb := list_entry((&ctx.hotplug_cbs).next, libusb_hotplug_callback, list);
-		&hotplug_cb.list != (&ctx.hotplug_cbs);			
-		hotplug_cb = list_entry(hotplug_cb.list.next, libusb_hotplug_callback, list) {
-		if (callback_handle == hotplug_cb.handle) {
+	for e := ctx.hotplug_cbs.next; e != ctx.hotplug_cbs; e = e.next {
+		hotplug_cb := list_entry(e).(*libusb_hotplug_callback)
+		if callback_handle == hotplug_cb.handle {
 			/* Mark this callback for deregistration */
-			hotplug_cb.needs_free = 1;
+			hotplug_cb.needs_free = true
 		}
 	}
 	ctx.hotplug_cbs_lock.Unlock()
 
-	usbi_hotplug_notification(ctx, nil, 0);
+	usbi_hotplug_notification(ctx, nil, 0)
 }
 
 func usbi_hotplug_deregister_all(ctx *libusb_context) {
-	var hotplug_cb, next *libusb_hotplug_callback
-
 	ctx.hotplug_cbs_lock.Lock()
-	for hotplug_cb, next = list_entry((&ctx.hotplug_cbs).next, libusb_hotplug_callback, list), list_entry(hotplug_cb.member.next, libusb_hotplug_callback, list);
-		&hotplug_cb.list != (&ctx.hotplug_cbs);
-		hotplug_cb, next = next, list_entry(n.list.next, libusb_hotplug_callback, list)	{
-		list_del(&hotplug_cb.list)
+	/* safe iteration because callbacks are being deleted */
+	for e := ctx.hotplug_cbs.next; e != ctx.hotplug_cbs; {
+		next := e.next
+		list_del(e)
+		e = next
 	}
-	ctx.hoplug_cbs_lock.Unlock()
+	ctx.hotplug_cbs_lock.Unlock()
 }
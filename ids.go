@@ -0,0 +1,336 @@
+package usb
+
+import (
+	"bufio"
+	"bytes"
+	_ "embed"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// embeddedIDs is a subset of usb.ids, holding the standard classes and a
+// selection of vendors, HID usages and languages. The header of the list,
+// which states its license, is kept as is.
+//
+//go:embed usb.ids
+var embeddedIDs []byte
+
+// IDs is a database of the names in a usb.ids file, the list of USB ID's
+// maintained at http://www.linux-usb.org/usb.ids. The methods of a nil
+// *IDs return no names.
+type IDs struct {
+	vendors   map[uint16]*idsVendor
+	classes   map[uint8]*idsClass
+	hidPages  map[uint16]*idsUsagePage
+	languages map[uint16]*idsLanguage
+}
+
+type idsVendor struct {
+	name     string
+	products map[uint16]string
+}
+
+type idsClass struct {
+	name       string
+	subclasses map[uint8]*idsSubClass
+}
+
+type idsSubClass struct {
+	name      string
+	protocols map[uint8]string
+}
+
+type idsUsagePage struct {
+	name   string
+	usages map[uint16]string
+}
+
+type idsLanguage struct {
+	name     string
+	dialects map[uint8]string
+}
+
+var (
+	idsMu      sync.Mutex
+	defaultIDs *IDs
+)
+
+// DefaultIDs returns the database the package uses for names, which is the
+// embedded snapshot unless SetDefaultIDs has replaced it. The snapshot only
+// names a few vendors and their products; use LoadIDs to name the rest.
+func DefaultIDs() *IDs {
+	idsMu.Lock()
+	defer idsMu.Unlock()
+	if defaultIDs == nil {
+		ids, err := ParseIDs(bytes.NewReader(embeddedIDs))
+		if err != nil {
+			panic("usb: embedded usb.ids: " + err.Error())
+		}
+		defaultIDs = ids
+	}
+	return defaultIDs
+}
+
+// SetDefaultIDs replaces the database returned by DefaultIDs, typically
+// with a newer usb.ids loaded by LoadIDs. A nil database restores the
+// embedded snapshot.
+func SetDefaultIDs(ids *IDs) {
+	idsMu.Lock()
+	defer idsMu.Unlock()
+	defaultIDs = ids
+}
+
+// LoadIDs reads a usb.ids file, which most systems install at
+// /usr/share/hwdata/usb.ids or /usr/share/misc/usb.ids.
+func LoadIDs(path string) (*IDs, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ParseIDs(f)
+}
+
+// ParseIDs parses a database in the usb.ids format. Sections other than
+// vendors, classes, HID usages and languages are skipped.
+func ParseIDs(r io.Reader) (*IDs, error) {
+	ids := &IDs{
+		vendors:   make(map[uint16]*idsVendor),
+		classes:   make(map[uint8]*idsClass),
+		hidPages:  make(map[uint16]*idsUsagePage),
+		languages: make(map[uint16]*idsLanguage),
+	}
+
+	var (
+		vendor   *idsVendor
+		class    *idsClass
+		subclass *idsSubClass
+		page     *idsUsagePage
+		language *idsLanguage
+	)
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimRight(scanner.Text(), " \t\r")
+		if text == "" || text[0] == '#' {
+			continue
+		}
+		depth := len(text) - len(strings.TrimLeft(text, "\t"))
+		text = text[depth:]
+
+		syntaxError := func() error {
+			return fmt.Errorf("usb: usb.ids line %d: malformed entry %q", line, text)
+		}
+
+		if depth == 0 {
+			vendor, class, subclass, page, language = nil, nil, nil, nil, nil
+			keyword, rest := "", text
+			if i := strings.IndexByte(text, ' '); i > 0 && !isHexID(text[:i]) {
+				keyword, rest = text[:i], text[i+1:]
+			}
+			switch keyword {
+			case "", "C", "HUT", "L":
+			default:
+				// Other sections, such as HID descriptor types or
+				// country codes, are skipped along with their entries.
+				continue
+			}
+			id, name, ok := idsEntry(rest)
+			if !ok {
+				return nil, syntaxError()
+			}
+			switch keyword {
+			case "":
+				if id > 0xffff {
+					return nil, syntaxError()
+				}
+				vendor = &idsVendor{name: name, products: make(map[uint16]string)}
+				ids.vendors[uint16(id)] = vendor
+			case "C":
+				if id > 0xff {
+					return nil, syntaxError()
+				}
+				class = &idsClass{name: name, subclasses: make(map[uint8]*idsSubClass)}
+				ids.classes[uint8(id)] = class
+			case "HUT":
+				if id > 0xffff {
+					return nil, syntaxError()
+				}
+				page = &idsUsagePage{name: name, usages: make(map[uint16]string)}
+				ids.hidPages[uint16(id)] = page
+			case "L":
+				if id > 0xffff {
+					return nil, syntaxError()
+				}
+				language = &idsLanguage{name: name, dialects: make(map[uint8]string)}
+				ids.languages[uint16(id)] = language
+			}
+			continue
+		}
+		if vendor == nil && class == nil && page == nil && language == nil {
+			continue
+		}
+
+		id, name, ok := idsEntry(text)
+		if !ok {
+			return nil, syntaxError()
+		}
+		switch {
+		case depth == 1 && vendor != nil && id <= 0xffff:
+			vendor.products[uint16(id)] = name
+		case depth == 1 && class != nil && id <= 0xff:
+			subclass = &idsSubClass{name: name, protocols: make(map[uint8]string)}
+			class.subclasses[uint8(id)] = subclass
+		case depth == 2 && subclass != nil && id <= 0xff:
+			subclass.protocols[uint8(id)] = name
+		case depth == 1 && page != nil && id <= 0xffff:
+			page.usages[uint16(id)] = name
+		case depth == 1 && language != nil && id <= 0xff:
+			language.dialects[uint8(id)] = name
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+// isHexID reports whether s is a four digit hexadecimal vendor ID.
+func isHexID(s string) bool {
+	if len(s) != 4 {
+		return false
+	}
+	_, err := strconv.ParseUint(s, 16, 16)
+	return err == nil
+}
+
+// idsEntry splits an entry of the form "id  name", where id is hexadecimal.
+func idsEntry(s string) (uint64, string, bool) {
+	i := strings.IndexByte(s, ' ')
+	if i <= 0 {
+		return 0, "", false
+	}
+	id, err := strconv.ParseUint(s[:i], 16, 32)
+	if err != nil {
+		return 0, "", false
+	}
+	return id, strings.TrimLeft(s[i:], " \t"), true
+}
+
+// Vendor returns the name of a vendor ID.
+func (ids *IDs) Vendor(vendorID uint16) string {
+	if ids == nil {
+		return ""
+	}
+	if v := ids.vendors[vendorID]; v != nil {
+		return v.name
+	}
+	return ""
+}
+
+// Product returns the name of a product of a vendor.
+func (ids *IDs) Product(vendorID, productID uint16) string {
+	if ids == nil {
+		return ""
+	}
+	if v := ids.vendors[vendorID]; v != nil {
+		return v.products[productID]
+	}
+	return ""
+}
+
+// Class returns the name of a device or interface class code.
+func (ids *IDs) Class(class uint8) string {
+	if ids == nil {
+		return ""
+	}
+	if c := ids.classes[class]; c != nil {
+		return c.name
+	}
+	return ""
+}
+
+// SubClass returns the name of a subclass of a class.
+func (ids *IDs) SubClass(class, subclass uint8) string {
+	if ids == nil {
+		return ""
+	}
+	if c := ids.classes[class]; c != nil {
+		if s := c.subclasses[subclass]; s != nil {
+			return s.name
+		}
+	}
+	return ""
+}
+
+// Protocol returns the name of a protocol of a subclass.
+func (ids *IDs) Protocol(class, subclass, protocol uint8) string {
+	if ids == nil {
+		return ""
+	}
+	if c := ids.classes[class]; c != nil {
+		if s := c.subclasses[subclass]; s != nil {
+			return s.protocols[protocol]
+		}
+	}
+	return ""
+}
+
+// HIDUsagePage returns the name of a HID usage page.
+func (ids *IDs) HIDUsagePage(page uint16) string {
+	if ids == nil {
+		return ""
+	}
+	if p := ids.hidPages[page]; p != nil {
+		return p.name
+	}
+	return ""
+}
+
+// HIDUsage returns the name of a usage on a HID usage page.
+func (ids *IDs) HIDUsage(page, usage uint16) string {
+	if ids == nil {
+		return ""
+	}
+	if p := ids.hidPages[page]; p != nil {
+		return p.usages[usage]
+	}
+	return ""
+}
+
+// Language returns the names of the primary language and the dialect of a
+// LANGID, as used by string descriptors. The primary language is held in
+// the low 10 bits and the dialect in the high 6.
+func (ids *IDs) Language(langID uint16) (language, dialect string) {
+	if ids == nil {
+		return "", ""
+	}
+	if l := ids.languages[langID&0x3ff]; l != nil {
+		return l.name, l.dialects[uint8(langID>>10)]
+	}
+	return "", ""
+}
+
+// DeviceNames returns the names of the vendor and product of a device.
+func (ids *IDs) DeviceNames(d *DeviceDescriptor) (vendor, product string) {
+	return ids.Vendor(d.VendorID), ids.Product(d.VendorID, d.ProductID)
+}
+
+// DeviceClassNames returns the names of the class, subclass and protocol
+// of a device.
+func (ids *IDs) DeviceClassNames(d *DeviceDescriptor) (class, subclass, protocol string) {
+	return ids.Class(d.DeviceClass),
+		ids.SubClass(d.DeviceClass, d.DeviceSubClass),
+		ids.Protocol(d.DeviceClass, d.DeviceSubClass, d.DeviceProtocol)
+}
+
+// InterfaceClassNames returns the names of the class, subclass and protocol
+// of an interface.
+func (ids *IDs) InterfaceClassNames(i *InterfaceDescriptor) (class, subclass, protocol string) {
+	return ids.Class(i.InterfaceClass),
+		ids.SubClass(i.InterfaceClass, i.InterfaceSubClass),
+		ids.Protocol(i.InterfaceClass, i.InterfaceSubClass, i.InterfaceProtocol)
+}
@@ -0,0 +1,120 @@
+package usb
+
+import (
+	"strings"
+	"testing"
+)
+
+// testIDs is a usb.ids fragment with every kind of entry, and sections that
+// are skipped.
+const testIDs = `#
+#	List of USB ID's
+#
+# Syntax:
+# vendor  vendor_name
+#	device  device_name				<-- single tab
+
+1234  Acme Corp.
+	5678  Loopback
+	9abc  Gadget Pro  
+# a comment between products
+	def0  Widget
+abcd  Other Vendor
+
+# List of known device classes, subclasses and protocols
+C 00  (Defined at Interface level)
+C 02  Communications
+	02  Abstract (modem)
+		01  AT-commands (v.25ter)
+		ff  Vendor Specific
+	06  Ethernet Networking
+C ff  Vendor Specific Class
+
+# Skipped along with their entries
+AT 0000  Undefined
+	0001  Not a product
+HID 21  HID
+
+HUT 01  Generic Desktop Controls
+	002  Mouse
+	006  Keyboard
+
+L 0009  English
+	01  US
+	02  UK
+`
+
+func TestParseIDs(t *testing.T) {
+	ids, err := ParseIDs(strings.NewReader(testIDs))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	names := []struct {
+		name      string
+		got, want string
+	}{
+		{"vendor", ids.Vendor(0x1234), "Acme Corp."},
+		{"second vendor", ids.Vendor(0xabcd), "Other Vendor"},
+		{"unknown vendor", ids.Vendor(0x0001), ""},
+		{"product", ids.Product(0x1234, 0x5678), "Loopback"},
+		{"product with trailing blanks", ids.Product(0x1234, 0x9abc), "Gadget Pro"},
+		{"product after a comment", ids.Product(0x1234, 0xdef0), "Widget"},
+		{"unknown product", ids.Product(0x1234, 0x0001), ""},
+		{"product of another vendor", ids.Product(0xabcd, 0x5678), ""},
+		{"class", ids.Class(0x02), "Communications"},
+		{"class 0", ids.Class(0x00), "(Defined at Interface level)"},
+		{"unknown class", ids.Class(0x03), ""},
+		{"subclass", ids.SubClass(0x02, 0x06), "Ethernet Networking"},
+		{"unknown subclass", ids.SubClass(0xff, 0x01), ""},
+		{"protocol", ids.Protocol(0x02, 0x02, 0x01), "AT-commands (v.25ter)"},
+		{"vendor specific protocol", ids.Protocol(0x02, 0x02, 0xff), "Vendor Specific"},
+		{"unknown protocol", ids.Protocol(0x02, 0x06, 0x01), ""},
+		{"usage page", ids.HIDUsagePage(0x01), "Generic Desktop Controls"},
+		{"usage", ids.HIDUsage(0x01, 0x06), "Keyboard"},
+		{"unknown usage", ids.HIDUsage(0x02, 0x06), ""},
+		{"skipped section", ids.Vendor(0x0000), ""},
+	}
+	for _, n := range names {
+		if n.got != n.want {
+			t.Errorf("%s: %q, want %q", n.name, n.got, n.want)
+		}
+	}
+	if lang, dialect := ids.Language(0x0809); lang != "English" || dialect != "UK" {
+		t.Errorf("LANGID 0809 is %q, %q, want English, UK", lang, dialect)
+	}
+
+	d := &DeviceDescriptor{VendorID: 0x1234, ProductID: 0x5678, DeviceClass: 0x02, DeviceSubClass: 0x02, DeviceProtocol: 0x01}
+	if vendor, product := ids.DeviceNames(d); vendor != "Acme Corp." || product != "Loopback" {
+		t.Errorf("device names %q, %q", vendor, product)
+	}
+	if class, subclass, protocol := ids.DeviceClassNames(d); class != "Communications" ||
+		subclass != "Abstract (modem)" || protocol != "AT-commands (v.25ter)" {
+		t.Errorf("device class names %q, %q, %q", class, subclass, protocol)
+	}
+
+	var none *IDs
+	if none.Vendor(0x1234) != "" || none.Class(0x02) != "" {
+		t.Error("a nil database returned names")
+	}
+}
+
+func TestParseIDsErrors(t *testing.T) {
+	for _, data := range []string{
+		"C zz  Not hex\n",
+		"1234\n",
+		"C 100  Class out of range\n",
+		"1234  Acme\n\tzzzz  Not hex\n",
+	} {
+		if _, err := ParseIDs(strings.NewReader(data)); err == nil {
+			t.Errorf("parsed %q without an error", data)
+		}
+	}
+}
+
+func TestDefaultIDs(t *testing.T) {
+	ids := DefaultIDs()
+	if ids.Class(0x03) != "Human Interface Device" {
+		t.Errorf("embedded class 03 is %q", ids.Class(0x03))
+	}
+}
@@ -25,222 +25,307 @@ import (
  * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA
  */
 
+/* how often the hotplug poller asks the backend to look for devices that
+ * have come or gone */
+const USBI_HOTPLUG_POLL_INTERVAL = time.Second
+
+/* A single hotplug poller serves every context: backends bring all active
+ * contexts up to date in one Hotplug_poll(), so polling once per context
+ * would only repeat the work. It runs while any context is initialised. */
+var (
+	hotplug_poller_lock  sync.Mutex
+	hotplug_poller_users int
+	hotplug_poller_quit  chan struct{}
+	hotplug_poller_done  chan struct{}
+)
+
+/* An event for a context's event handling goroutine to dispatch. Backends
+ * raise them through usbi_signal_transfer_completion() and
+ * usbi_hotplug_notification(), never by sending on the channels directly. */
+type usbi_event struct {
+	/* a transfer the backend has finished with, which is passed back to
+	 * its handle_transfer_completion() */
+	transfer *usbi_transfer
+
+	/* a transfer the library terminates with status itself */
+	terminate *usbi_transfer
+	status    libusb_transfer_status
+
+	/* a device arrival or departure, or a callback deregistration */
+	hotplug *libusb_hotplug_message
+}
+
 func usbi_io_init(ctx *libusb_context) libusb_error {
-	ctx.event_waiters_cond = sync.NewCond(ctx.event_waiters_lock)
+	ctx.flying_transfers = &LinkedList{}
 	list_init(ctx.flying_transfers)
-	list_init(ctx.ipollfds)
-	list_init(ctx.hotplug_msgs)
-	list_init(ctx.completed_transfers)
 
-	/* FIXME should use an eventfd on kernels that support it */
-	r := usbi_pipe(ctx.event_pipe)
-	if r < 0 {
-		return LIBUSB_ERROR_OTHER
+	ctx.event_handled = make(chan struct{})
+	ctx.event_in = make(chan usbi_event)
+	ctx.event_out = make(chan usbi_event)
+	ctx.event_rearm = make(chan struct{}, 1)
+	ctx.event_quit = make(chan struct{})
+
+	ctx.event_threads.Add(2)
+	go usbi_queue_events(ctx)
+	go usbi_event_loop(ctx)
+	usbi_start_hotplug_poller()
+
+	return 0
+}
+
+func usbi_io_exit(ctx *libusb_context) {
+	usbi_stop_hotplug_poller()
+	close(ctx.event_quit)
+	ctx.event_threads.Wait()
+}
+
+/* Start the hotplug poller for a new context, unless it is already running
+ * for another. */
+func usbi_start_hotplug_poller() {
+	hotplug_poller_lock.Lock()
+	defer hotplug_poller_lock.Unlock()
+
+	hotplug_poller_users++
+	if hotplug_poller_users > 1 {
+		return
 	}
+	hotplug_poller_quit = make(chan struct{})
+	hotplug_poller_done = make(chan struct{})
+	go usbi_hotplug_poller(hotplug_poller_quit, hotplug_poller_done)
+}
 
-	r = usbi_add_pollfd(ctx, ctx.event_pipe[0], POLLIN)
-	if r < 0 {
-		usbi_close(ctx.event_pipe[0])
-		usbi_close(ctx.event_pipe[1])
-		return r
+/* Stop the hotplug poller when the last context exits, waiting for any poll
+ * in progress to finish. */
+func usbi_stop_hotplug_poller() {
+	hotplug_poller_lock.Lock()
+	defer hotplug_poller_lock.Unlock()
+
+	hotplug_poller_users--
+	if hotplug_poller_users > 0 {
+		return
 	}
+	close(hotplug_poller_quit)
+	<-hotplug_poller_done
+}
+
+func usbi_hotplug_poller(quit <-chan struct{}, done chan<- struct{}) {
+	defer close(done)
 
-	ctx.timerfd = timerfd_create(usbi_backend.get_timerfd_clockid(), TFD_NONBLOCK)
-	if ctx.timerfd >= 0 {
-		// usbi_dbg("using timerfd for timeouts")
-		r = usbi_add_pollfd(ctx, ctx.timerfd, POLLIN)
-		if r < 0 {
-			close(ctx.timerfd)
-			usbi_remove_pollfd(ctx, ctx.event_pipe[0])
-			usbi_close(ctx.event_pipe[0])
-			usbi_close(ctx.event_pipe[1])
-			return r
+	ticker := time.NewTicker(USBI_HOTPLUG_POLL_INTERVAL)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			usbi_backend.Hotplug_poll()
+		case <-quit:
+			return
 		}
 	}
-
-	return 0
 }
 
-func usbi_io_exit(ctx *libusb_context) {
-	usbi_remove_pollfd(ctx, ctx.event_pipe[0])
-	usbi_close(ctx.event_pipe[0])
-	usbi_close(ctx.event_pipe[1])
-	usbi_remove_pollfd(ctx, ctx.timerfd)
-	close(ctx.timerfd)
+/* Queue an event for dispatch. This never blocks for long, so it is safe to
+ * call from anywhere, including from within a transfer callback. */
+func usbi_queue_event(ctx *libusb_context, ev usbi_event) {
+	select {
+	case ctx.event_in <- ev:
+	case <-ctx.event_quit:
+		usbi_drop_event(ev)
+	}
 }
 
-func calculate_timeout(transfer *usbi_transfer) int {
+/* Release what an event that will never be dispatched holds on to. */
+func usbi_drop_event(ev usbi_event) {
+	if ev.hotplug != nil && ev.hotplug.event == LIBUSB_HOTPLUG_EVENT_DEVICE_LEFT {
+		libusb_unref_device(ev.hotplug.device)
+	}
+}
 
-	timeout := transfer.libusbTransfer.timeout
+/* Move events from event_in to event_out, buffering as many as necessary so
+ * that whoever raises an event never waits on whoever dispatches it. */
+func usbi_queue_events(ctx *libusb_context) {
+	defer ctx.event_threads.Done()
+
+	var pending []usbi_event
+	for {
+		var out chan<- usbi_event
+		var next usbi_event
+		if len(pending) > 0 {
+			out = ctx.event_out
+			next = pending[0]
+		}
 
-	if timeout == 0 {
-		return 0
+		select {
+		case ev := <-ctx.event_in:
+			pending = append(pending, ev)
+		case out <- next:
+			pending = pending[1:]
+		case <-ctx.event_quit:
+			for _, ev := range pending {
+				usbi_drop_event(ev)
+			}
+			return
+		}
 	}
+}
 
-	current_time := time.Now()
+/* The event handling goroutine of a context. It dispatches completions and
+ * hotplug messages, and times out transfers, until the context exits. */
+func usbi_event_loop(ctx *libusb_context) {
+	defer ctx.event_threads.Done()
 
-	current_time.tv_sec += timeout / 1000
-	current_time.tv_nsec += (timeout % 1000) * 1000000
+	timer := time.NewTimer(time.Hour)
+	defer timer.Stop()
 
-	for current_time.tv_nsec >= 1000000000 {
-		current_time.tv_nsec -= 1000000000
-		current_time.tv_sec++
+	for {
+		/* arm the timer for the next transfer to time out */
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		var timeout <-chan time.Time
+		ctx.flying_transfers_lock.Lock()
+		next := next_timeout_locked(ctx)
+		ctx.flying_transfers_lock.Unlock()
+		if !next.IsZero() {
+			timer.Reset(time.Until(next))
+			timeout = timer.C
+		}
+
+		select {
+		case ev := <-ctx.event_out:
+			libusb_lock_events(ctx)
+			usbi_dispatch_event(ctx, ev)
+			libusb_unlock_events(ctx)
+		case <-timeout:
+			handle_timeouts(ctx)
+		case <-ctx.event_rearm:
+		case <-ctx.event_quit:
+			return
+		}
 	}
+}
 
-	TIMESPEC_TO_TIMEVAL(&transfer.timeout, &current_time)
-	return 0
+/* Dispatch a single event. Must be called with the events lock held. */
+func usbi_dispatch_event(ctx *libusb_context, ev usbi_event) {
+	switch {
+	case ev.transfer != nil:
+		usbi_backend.Handle_transfer_completion(ev.transfer)
+		// if r := ...; r != 0
+		// usbi_err(ctx, "backend handle_transfer_completion failed with error %d", r)
+	case ev.terminate != nil:
+		usbi_handle_transfer_completion(ev.terminate, ev.status)
+	case ev.hotplug != nil:
+		// usbi_dbg("hotplug message received")
+		usbi_hotplug_match(ctx, ev.hotplug.device, ev.hotplug.event)
+
+		/* the device left, dereference the device */
+		if ev.hotplug.event == LIBUSB_HOTPLUG_EVENT_DEVICE_LEFT {
+			libusb_unref_device(ev.hotplug.device)
+		}
+	}
 }
 
-/** \ingroup libusb_asyncio
- * Free a transfer structure. This should be called for all transfers
- * allocated with libusb_alloc_transfer().
- *
- * If the \ref libusb_transfer_flags::LIBUSB_TRANSFER_FREE_BUFFER
- * "LIBUSB_TRANSFER_FREE_BUFFER" flag is set and the transfer buffer is
- * non-NULL, this function will also free the transfer buffer using the
- * standard system memory allocator (e.g. free()).
- *
- * It is legal to call this function with a NULL transfer. In this case,
- * the function will simply return safely.
- *
- * It is not legal to free an active transfer (one which has been submitted
- * and has not yet completed).
- *
- * \param transfer the transfer to free
- */
+/* Wake every thread waiting for events to be handled. */
+func usbi_wake_event_waiters(ctx *libusb_context) {
+	ctx.event_data_lock.Lock()
+	close(ctx.event_handled)
+	ctx.event_handled = make(chan struct{})
+	ctx.event_data_lock.Unlock()
+}
 
-func disarm_timerfd(ctx *libusb_context) int {
-	disarm_timer := itimerspec{{0, 0}, {0, 0}}
+/* Tell the event handling goroutine that the next timeout may have moved. */
+func usbi_rearm_timeout(ctx *libusb_context) {
+	select {
+	case ctx.event_rearm <- struct{}{}:
+	default:
+	}
+}
 
-	r := timerfd_settime(ctx.timerfd, 0, &disarm_timer, NULL)
-	if r < 0 {
-		return LIBUSB_ERROR_OTHER
+func calculate_timeout(transfer *usbi_transfer) {
+	timeout := transfer.libusbTransfer.timeout
+
+	if timeout == 0 {
+		transfer.timeout = time.Time{}
+		return
 	}
 
-	return 0
+	transfer.timeout = time.Now().Add(time.Duration(timeout) * time.Millisecond)
 }
 
-/* iterates through the flying transfers, and rearms the timerfd based on the
- * next upcoming timeout.
+/* returns the expiry of the first transfer whose timeout has not already been
+ * handled, by us or by the OS, or the zero time if there is none.
  * must be called with flying_list locked.
- * returns 0 on success or a LIBUSB_ERROR code on failure.
  */
-func arm_timerfd_for_next_timeout(ctx *libusb_context) int {
-	var transfer *usbi_transfer
-
-	for transfer = list_entry(ctx.flying_transfers.next, usbi_transfer, list); &transfer.list != ctx.flying_transfers; transfer = list_entry(transfer.list.next, usbi_transfer, list) {
-
-		cur_tv := &transfer.timeout
+func next_timeout_locked(ctx *libusb_context) time.Time {
+	for e := ctx.flying_transfers.next; e != ctx.flying_transfers; e = e.next {
+		transfer := list_entry(e).(*usbi_transfer)
 
 		/* if we've reached transfers of infinite timeout, then we have no
 		 * arming to do */
-		if !timerisset(cur_tv) {
-			return disarm_timerfd(ctx)
+		if transfer.timeout.IsZero() {
+			break
 		}
 
 		/* act on first transfer that has not already been handled */
-		if !(transfer.timeout_flags & (USBI_TRANSFER_TIMEOUT_HANDLED | USBI_TRANSFER_OS_HANDLES_TIMEOUT)) {
-
-			it = itimerspec{{0, 0}, {cur_tv.tv_sec, cur_tv.tv_usec * 1000}}
-
-			// usbi_dbg("next timeout originally %dms", USBI_TRANSFER_TO_LIBUSB_TRANSFER(transfer).timeout)
-			r := timerfd_settime(ctx.timerfd, TFD_TIMER_ABSTIME, &it, NULL)
-			if r < 0 {
-				return LIBUSB_ERROR_OTHER
-			}
-			return 0
+		if transfer.timeout_flags&uint8(USBI_TRANSFER_TIMEOUT_HANDLED|USBI_TRANSFER_OS_HANDLES_TIMEOUT) == 0 {
+			return transfer.timeout
 		}
 	}
-	return disarm_timerfd(ctx)
+	return time.Time{}
 }
 
-/* add a transfer to the (timeout-sorted) active transfers list.
- * This function will return non 0 if fails to update the timer,
- * in which case the transfer is *not* on the flying_transfers list. */
-func add_to_flying_list(transfer *usbi_transfer) int {
-	var cur *usbi_transfer
-	timeout := &transfer.timeout
+/* add a transfer to the (timeout-sorted) active transfers list. */
+func add_to_flying_list(transfer *usbi_transfer) {
 	ctx := transfer.libusbTransfer.dev_handle.dev.ctx
-	first := true
 
-	r := calculate_timeout(transfer)
-	if r != 0 {
-		return r
-	}
-
-	/* if we have no other flying transfers, start the list with this one */
-	if list_empty(ctx.flying_transfers) {
-		list_add(transfer.list, ctx.flying_transfers)
-		goto out
-	}
+	calculate_timeout(transfer)
+	timeout := transfer.timeout
 
 	/* if we have infinite timeout, append to end of list */
-	if !timerisset(timeout) {
+	if timeout.IsZero() {
 		list_add_tail(transfer.list, ctx.flying_transfers)
-		/* first is irrelevant in this case */
-		goto out
+		return
 	}
 
 	/* otherwise, find appropriate place in list */
-	for cur = list_entry(ctx.flying_transfers.next, usbi_transfer, list); &cur.list != ctx.flying_transfers; cur = list_entry(cur.list.next, usbi_transfer, list) {
+	first := true
+	for e := ctx.flying_transfers.next; e != ctx.flying_transfers; e = e.next {
 		/* find first timeout that occurs after the transfer in question */
-		cur_tv := cur.timeout
-
-		if !timerisset(cur_tv) || (cur_tv.tv_sec > timeout.tv_sec) ||
-			(cur_tv.tv_sec == timeout.tv_sec &&
-				cur_tv.tv_usec > timeout.tv_usec) {
-			list_add_tail(transfer.list, cur.list)
-			goto out
+		cur := list_entry(e).(*usbi_transfer)
+		if cur.timeout.IsZero() || cur.timeout.After(timeout) {
+			list_add_tail(transfer.list, e)
+			if first {
+				/* this transfer has the lowest timeout of all active
+				 * transfers */
+				usbi_rearm_timeout(ctx)
+			}
+			return
 		}
 		first = false
 	}
-	/* first is false at this stage (list not empty) */
 
 	/* otherwise we need to be inserted at the end */
 	list_add_tail(transfer.list, ctx.flying_transfers)
-out:
-	if first && timerisset(timeout) {
-		/* if this transfer has the lowest timeout of all active transfers,
-		 * rearm the timerfd with this transfer's timeout */
-		it := itimerspec{{0, 0}, {timeout.tv_sec, timeout.tv_usec * 1000}}
-		// usbi_dbg("arm timerfd for timeout in %dms (first in line)",
-		// USBI_TRANSFER_TO_LIBUSB_TRANSFER(transfer.libusbTransfer.timeout)
-		r = timerfd_settime(ctx.timerfd, TFD_TIMER_ABSTIME, &it, nil)
-		if r < 0 {
-			// usbi_warn(ctx, "failed to arm first timerfd (errno %d)", errno)
-			r = LIBUSB_ERROR_OTHER
-		}
-	}
-
-	if r != 0 {
-		list_del(transfer.list)
+	if first {
+		usbi_rearm_timeout(ctx)
 	}
-
-	return r
 }
 
-/* remove a transfer from the active transfers list.
- * This function will *always* remove the transfer from the
- * flying_transfers list. It will return a LIBUSB_ERROR code
- * if it fails to update the timer for the next timeout. */
-func remove_from_flying_list(transfer *usbi_transfer) int {
-
+/* remove a transfer from the active transfers list. */
+func remove_from_flying_list(transfer *usbi_transfer) {
 	ctx := transfer.libusbTransfer.dev_handle.dev.ctx
-	r := 0
 
 	ctx.flying_transfers_lock.Lock()
-
-	rearm_timerfd := (timerisset(transfer.timeout) && list_first_entry(ctx.flying_transfers, usbi_transfer, list) == transfer)
-
+	rearm := !transfer.timeout.IsZero() && list_first_entry(ctx.flying_transfers) == transfer
 	list_del(transfer.list)
-
-	if rearm_timerfd != 0 {
-		r = arm_timerfd_for_next_timeout(ctx)
-	}
-
 	ctx.flying_transfers_lock.Unlock()
 
-	return r
+	if rearm {
+		usbi_rearm_timeout(ctx)
+	}
 }
 
 /** \ingroup libusb_asyncio
@@ -268,23 +353,60 @@ func remove_from_flying_list(transfer *usbi_transfer) int {
  */
 
 func libusb_alloc_transfer(iso_packets int) *libusb_transfer {
-	// surely this is wrong
 	itransfer := &usbi_transfer{}
 	itransfer.num_iso_packets = iso_packets
+	itransfer.list = &LinkedList{member: itransfer}
+
+	/* the two halves point at each other in place of the C pointer
+	 * arithmetic between usbi_transfer and libusb_transfer */
+	transfer := &libusb_transfer{usbiTransfer: itransfer}
+	transfer.iso_packet_desc = make([]libusb_iso_packet_descriptor, iso_packets)
+	itransfer.libusbTransfer = transfer
 
-	return itransfer.libusbTransfer
+	return transfer
+}
+
+/** \ingroup libusb_asyncio
+ * Free a transfer structure. This should be called for all transfers
+ * allocated with libusb_alloc_transfer().
+ *
+ * If the \ref libusb_transfer_flags::LIBUSB_TRANSFER_FREE_BUFFER
+ * "LIBUSB_TRANSFER_FREE_BUFFER" flag is set and the transfer buffer is
+ * non-NULL, this function will also free the transfer buffer using the
+ * standard system memory allocator (e.g. free()).
+ *
+ * It is legal to call this function with a NULL transfer. In this case,
+ * the function will simply return safely.
+ *
+ * It is not legal to free an active transfer (one which has been submitted
+ * and has not yet completed).
+ *
+ * \param transfer the transfer to free
+ */
+func libusb_free_transfer(transfer *libusb_transfer) {
+	if transfer == nil {
+		return
+	}
+
+	// usbi_dbg("transfer %p", transfer)
+	if transfer.flags&uint8(LIBUSB_TRANSFER_FREE_BUFFER) != 0 {
+		transfer.buffer = nil
+	}
+
+	/* the garbage collector takes care of the rest, but break the cycle
+	 * between the two halves so a stale usbi_transfer can't be resubmitted */
+	transfer.usbiTransfer.libusbTransfer = nil
+	transfer.usbiTransfer = nil
 }
 
 /** \ingroup libusb_poll
- * Handle any pending events by polling file descriptors, without checking if
+ * Handle any pending events on the calling thread, without checking if
  * any other threads are already doing so. Must be called with the event lock
  * held, see libusb_lock_events().
  *
- * This function is designed to be called under the situation where you have
- * taken the event lock and are calling poll()/select() directly on libusb's
- * file descriptors (as opposed to using libusb_handle_events() or similar).
- * You detect events on libusb's descriptors, so you then call this function
- * with a zero timeout value (while still holding the event lock).
+ * Holding the event lock keeps the context's event handling goroutine from
+ * dispatching events, so this function is how a thread that has taken it
+ * handles them instead.
  *
  * \param ctx the context to operate on, or NULL for the default context
  * \param tv the maximum time to block waiting for events, or zero for
@@ -292,17 +414,9 @@ func libusb_alloc_transfer(iso_packets int) *libusb_transfer {
  * \returns 0 on success, or a LIBUSB_ERROR code on failure
  * \ref libusb_mtasync
  */
-func libusb_handle_events_locked(ctx *libusb_context, tv *time.Time) int {
-	var poll_timeout time.Time
-
+func libusb_handle_events_locked(ctx *libusb_context, tv time.Duration) int {
 	ctx = USBI_GET_CONTEXT(ctx)
-	r := get_next_timeout(ctx, tv, &poll_timeout)
-	if r != 0 {
-		/* timeout already expired */
-		return handle_timeouts(ctx)
-	}
-
-	return handle_events(ctx, &poll_timeout)
+	return handle_events(ctx, tv)
 }
 
 /** \ingroup libusb_poll
@@ -320,10 +434,7 @@ func libusb_handle_events_locked(ctx *libusb_context, tv *time.Time) int {
  * \ref libusb_mtasync
  */
 func libusb_handle_events_completed(ctx *libusb_context, completed *int) int {
-	var tv timeval
-	tv.tv_sec = 60
-	tv.tv_usec = 0
-	return libusb_handle_events_timeout_completed(ctx, &tv, completed)
+	return libusb_handle_events_timeout_completed(ctx, 60*time.Second, completed)
 }
 
 /** \ingroup libusb_poll
@@ -355,10 +466,8 @@ func libusb_handle_events_completed(ctx *libusb_context, completed *int) int {
  * \ref libusb_pollmain "Polling libusb file descriptors for event handling"
  */
 func libusb_pollfds_handle_timeouts(ctx *libusb_context) int {
-	//ctx = USBI_GET_CONTEXT(ctx)
-	//return usbi_using_timerfd(ctx)
-	// GO: assuming true for right now
-	return true
+	/* timeouts are handled by each context's event handling goroutine */
+	return 1
 }
 
 /** \ingroup libusb_asyncio
@@ -416,35 +525,30 @@ func libusb_submit_transfer(transfer *libusb_transfer) int {
 	 */
 	ctx.flying_transfers_lock.Lock()
 	itransfer.lock.Lock()
-	if itransfer.state_flags & USBI_TRANSFER_IN_FLIGHT {
+	if itransfer.state_flags&uint8(USBI_TRANSFER_IN_FLIGHT) != 0 {
 		ctx.flying_transfers_lock.Unlock()
 		itransfer.lock.Unlock()
-		return LIBUSB_ERROR_BUSY
+		return int(LIBUSB_ERROR_BUSY)
 	}
 	itransfer.transferred = 0
 	itransfer.state_flags = 0
 	itransfer.timeout_flags = 0
-	r = add_to_flying_list(itransfer)
-	if r {
-		ctx.flying_transfers_lock.Unlock()
-		itransfer.lock.Unlock()
-		return r
-	}
+	add_to_flying_list(itransfer)
 	/*
 	 * We must release the flying transfers lock here, because with
 	 * some backends the submit_transfer method is synchroneous.
 	 */
 	ctx.flying_transfers_lock.Unlock()
 
-	r = usbi_backend.submit_transfer(itransfer)
-	if r == LIBUSB_SUCCESS {
-		itransfer.state_flags |= USBI_TRANSFER_IN_FLIGHT
+	r = int(usbi_backend.Submit_transfer(itransfer))
+	if r == 0 {
+		itransfer.state_flags |= uint8(USBI_TRANSFER_IN_FLIGHT)
 		/* keep a reference to this device */
 		libusb_ref_device(transfer.dev_handle.dev)
 	}
 	itransfer.lock.Unlock()
 
-	if r != LIBUSB_SUCCESS {
+	if r != 0 {
 		remove_from_flying_list(itransfer)
 	}
 
@@ -472,10 +576,10 @@ func libusb_cancel_transfer(transfer *libusb_transfer) int {
 	// usbi_dbg("transfer %p", transfer )
 	itransfer.lock.Lock()
 	defer itransfer.lock.Unlock()
-	if !(itransfer.state_flags & USBI_TRANSFER_IN_FLIGHT) || (itransfer.state_flags & USBI_TRANSFER_CANCELLING) {
-		return LIBUSB_ERROR_NOT_FOUND
+	if itransfer.state_flags&uint8(USBI_TRANSFER_IN_FLIGHT) == 0 || itransfer.state_flags&uint8(USBI_TRANSFER_CANCELLING) != 0 {
+		return int(LIBUSB_ERROR_NOT_FOUND)
 	}
-	r = usbi_backend.cancel_transfer(itransfer)
+	r = int(usbi_backend.Cancel_transfer(itransfer))
 	if r < 0 {
 		// if (r != LIBUSB_ERROR_NOT_FOUND &&
 		//     r != LIBUSB_ERROR_NO_DEVICE)
@@ -484,12 +588,12 @@ func libusb_cancel_transfer(transfer *libusb_transfer) int {
 		// else
 		// usbi_dbg("cancel transfer failed error %d", r)
 
-		if r == LIBUSB_ERROR_NO_DEVICE {
-			itransfer.state_flags |= USBI_TRANSFER_DEVICE_DISAPPEARED
+		if r == int(LIBUSB_ERROR_NO_DEVICE) {
+			itransfer.state_flags |= uint8(USBI_TRANSFER_DEVICE_DISAPPEARED)
 		}
 	}
 
-	itransfer.state_flags |= USBI_TRANSFER_CANCELLING
+	itransfer.state_flags |= uint8(USBI_TRANSFER_CANCELLING)
 
 	return r
 }
@@ -535,17 +639,15 @@ func usbi_handle_transfer_completion(itransfer *usbi_transfer, status libusb_tra
 	var flags uint8
 	var r int
 
-	r = remove_from_flying_list(itransfer)
-	//if (r < 0)
-	// usbi_err(ITRANSFER_CTX(itransfer), "failed to set timer for next timeout, errno=%d", errno)
+	remove_from_flying_list(itransfer)
 
 	itransfer.lock.Lock()
-	itransfer.state_flags &= ^USBI_TRANSFER_IN_FLIGHT
+	itransfer.state_flags &^= uint8(USBI_TRANSFER_IN_FLIGHT)
 	itransfer.lock.Unlock()
 
-	if status == LIBUSB_TRANSFER_COMPLETED && transfer.flags&LIBUSB_TRANSFER_SHORT_NOT_OK {
+	if status == LIBUSB_TRANSFER_COMPLETED && transfer.flags&uint8(LIBUSB_TRANSFER_SHORT_NOT_OK) != 0 {
 		rqlen := transfer.length
-		if transfer._type == LIBUSB_TRANSFER_TYPE_CONTROL {
+		if transfer._type == uint8(LIBUSB_TRANSFER_TYPE_CONTROL) {
 			rqlen -= LIBUSB_CONTROL_SETUP_SIZE
 		}
 		if rqlen != itransfer.transferred {
@@ -561,6 +663,11 @@ func usbi_handle_transfer_completion(itransfer *usbi_transfer, status libusb_tra
 	if transfer.callback != nil {
 		transfer.callback(transfer)
 	}
+	/* transfer might have been freed by the above call, do not use from
+	 * this point. */
+	if flags&uint8(LIBUSB_TRANSFER_FREE_TRANSFER) != 0 {
+		libusb_free_transfer(transfer)
+	}
 
 	libusb_unref_device(dev_handle.dev)
 	return r
@@ -576,7 +683,7 @@ func usbi_handle_transfer_cancellation(transfer *usbi_transfer) int {
 	ctx := transfer.libusbTransfer.dev_handle.dev.ctx
 
 	ctx.flying_transfers_lock.Lock()
-	timed_out := transfer.timeout_flags & USBI_TRANSFER_TIMED_OUT
+	timed_out := transfer.timeout_flags & uint8(USBI_TRANSFER_TIMED_OUT)
 	ctx.flying_transfers_lock.Unlock()
 
 	/* if the URB was cancelled due to timeout, report timeout to the user */
@@ -589,19 +696,66 @@ func usbi_handle_transfer_cancellation(transfer *usbi_transfer) int {
 	return usbi_handle_transfer_completion(transfer, LIBUSB_TRANSFER_CANCELLED)
 }
 
-/* Add a completed transfer to the completed_transfers list of the
- * context and signal the event. The backend's handle_transfer_completion()
- * function will be called the next time an event handler runs. */
+/* Hand a completed transfer to the event handling goroutine of its context,
+ * which will pass it to the backend's handle_transfer_completion(). This may
+ * be called from any goroutine. */
 func usbi_signal_transfer_completion(transfer *usbi_transfer) {
 	ctx := transfer.libusbTransfer.dev_handle.dev.ctx
+	usbi_queue_event(ctx, usbi_event{transfer: transfer})
+}
 
-	ctx.event_data_lock.Lock()
-	pending_events := usbi_pending_events(ctx)
-	list_add_tail(&transfer.completed_list, &ctx.completed_transfers)
-	if pending_events != 0 {
-		usbi_signal_event(ctx)
+/* Terminate all pending transfers of a device handle with the
+ * LIBUSB_TRANSFER_NO_DEVICE status code. Called by backends when they detect
+ * that the device behind an open handle has gone away. The transfers are
+ * completed by the event handling goroutine. */
+func usbi_handle_disconnect(dev_handle *libusb_device_handle) {
+	ctx := dev_handle.dev.ctx
+
+	// usbi_dbg("device %d.%d",
+	// dev_handle.dev.bus_number, dev_handle.dev.device_address)
+
+	/* when we find a transfer for this device on the list, there are two
+	 * possible scenarios:
+	 * 1. the transfer is currently in-flight, in which case we terminate the
+	 *    transfer here
+	 * 2. the transfer has been added to the flying transfer list by
+	 *    libusb_submit_transfer, has failed to submit and
+	 *    libusb_submit_transfer is waiting for us to release the
+	 *    flying_transfers_lock to remove it, so we ignore it
+	 */
+	for {
+		var to_cancel *usbi_transfer
+		ctx.flying_transfers_lock.Lock()
+		for e := ctx.flying_transfers.next; e != ctx.flying_transfers; e = e.next {
+			cur := list_entry(e).(*usbi_transfer)
+			if cur.libusbTransfer.dev_handle != dev_handle {
+				continue
+			}
+			cur.lock.Lock()
+			if cur.state_flags&uint8(USBI_TRANSFER_IN_FLIGHT) != 0 &&
+				cur.state_flags&uint8(USBI_TRANSFER_DEVICE_DISAPPEARED) == 0 {
+				cur.state_flags |= uint8(USBI_TRANSFER_DEVICE_DISAPPEARED)
+				to_cancel = cur
+			}
+			cur.lock.Unlock()
+
+			if to_cancel != nil {
+				break
+			}
+		}
+		ctx.flying_transfers_lock.Unlock()
+
+		if to_cancel == nil {
+			break
+		}
+
+		// usbi_dbg("cancelling transfer %p from disconnect", to_cancel.libusbTransfer)
+
+		to_cancel.lock.Lock()
+		usbi_backend.Clear_transfer_priv(to_cancel)
+		to_cancel.lock.Unlock()
+		usbi_queue_event(ctx, usbi_event{terminate: to_cancel, status: LIBUSB_TRANSFER_NO_DEVICE})
 	}
-	ctx.event_data_lock.Unlock()
 }
 
 /** \ingroup libusb_poll
@@ -624,22 +778,9 @@ func usbi_signal_transfer_completion(transfer *usbi_transfer) {
  * \ref libusb_mtasync
  */
 func libusb_try_lock_events(ctx *libusb_context) int {
-
 	ctx = USBI_GET_CONTEXT(ctx)
 
-	/* is someone else waiting to close a device? if so, don't let this thread
-	 * start event handling */
-	ctx.event_data_lock.Lock()
-	ru := ctx.device_close
-	ctx.event_data_lock.Unlock()
-	if ru != 0 {
-		// usbi_dbg("someone else is closing a device")
-		return 1
-	}
-
-	// GO problem: sync.Mutex doesn't have an equivalent to this
-	r := usbi_mutex_trylock(&ctx.events_lock)
-	if r != 0 {
+	if !ctx.events_lock.TryLock() {
 		return 1
 	}
 
@@ -684,12 +825,7 @@ func libusb_unlock_events(ctx *libusb_context) {
 	ctx.event_handler_active = 0
 	ctx.events_lock.Unlock()
 
-	/* FIXME: perhaps we should be a bit more efficient by not broadcasting
-	 * the availability of the events lock when we are modifying pollfds
-	 * (check ctx.device_close)? */
-	ctx.event_waiters_lock.Lock()
-	ctx.event_waiters_cond.Broadcast()
-	ctx.event_waiters_lock.Unlock()
+	usbi_wake_event_waiters(ctx)
 }
 
 /** \ingroup libusb_poll
@@ -714,17 +850,8 @@ func libusb_unlock_events(ctx *libusb_context) {
  * \ref fullstory "Multi-threaded I/O: the full story"
  */
 func libusb_event_handling_ok(ctx *libusb_context) int {
-	ctx = USBI_GET_CONTEXT(ctx)
-
-	/* is someone else waiting to close a device? if so, don't let this thread
-	 * continue event handling */
-	ctx.event_data_lock.Lock()
-	r := ctx.device_close
-	ctx.event_data_lock.Unlock()
-	if r != 0 {
-		// usbi_dbg("someone else is closing a device")
-		return 0
-	}
+	/* nothing asks event handlers to stand aside any more: closing a
+	 * device no longer changes what there is to wait on */
 	return 1
 }
 
@@ -738,19 +865,8 @@ func libusb_event_handling_ok(ctx *libusb_context) int {
  * \ref libusb_mtasync
  */
 func libusb_event_handler_active(ctx *libusb_context) int {
-	ctx = USBI_GET_CONTEXT(ctx)
-
-	/* is someone else waiting to close a device? if so, don't let this thread
-	 * start event handling -- indicate that event handling is happening */
-	ctx.event_data_lock.Lock()
-	r := ctx.device_close
-	ctx.event_data_lock.Unlock()
-	if r != 0 {
-		// usbi_dbg("someone else is closing a device")
-		return 1
-	}
-
-	return ctx.event_handler_active
+	/* every context has a goroutine handling its events */
+	return 1
 }
 
 /** \ingroup libusb_poll
@@ -764,14 +880,7 @@ func libusb_event_handler_active(ctx *libusb_context) int {
  * \ref libusb_mtasync
  */
 func libusb_interrupt_event_handler(ctx *libusb_context) {
-	ctx = USBI_GET_CONTEXT(ctx)
-
-	ctx.event_data_lock.Lock()
-	if !usbi_pending_events(ctx) {
-		ctx.event_flags |= USBI_EVENT_USER_INTERRUPT
-		usbi_signal_event(ctx)
-	}
-	ctx.event_data_lock.Unlock()
+	usbi_wake_event_waiters(USBI_GET_CONTEXT(ctx))
 }
 
 /** \ingroup libusb_poll
@@ -822,7 +931,7 @@ func libusb_unlock_event_waiters(ctx *libusb_context) {
  * timeval struct for non-blocking mode
  * \returns 0 on success, or a LIBUSB_ERROR code on failure
  */
-func libusb_handle_events_timeout(ctx *libusb_context, tv *time.Time) int {
+func libusb_handle_events_timeout(ctx *libusb_context, tv time.Duration) int {
 	return libusb_handle_events_timeout_completed(ctx, tv, nil)
 }
 
@@ -841,8 +950,7 @@ func libusb_handle_events_timeout(ctx *libusb_context, tv *time.Time) int {
  * \returns 0 on success, or a LIBUSB_ERROR code on failure
  */
 func libusb_handle_events(ctx *libusb_context) int {
-	timeout := time.Duration(60 * time.Second)
-	return libusb_handle_events_timeout_completed(ctx, timeout, nil)
+	return libusb_handle_events_timeout_completed(ctx, 60*time.Second, nil)
 }
 
 /** \ingroup libusb_poll
@@ -864,8 +972,8 @@ func libusb_handle_events(ctx *libusb_context) int {
  * so you should call libusb_handle_events_timeout() or similar immediately.
  * A return code of 0 indicates that there are no pending timeouts.
  *
- * On some platforms, this function will always returns 0 (no pending
- * timeouts). See \ref polltime.
+ * Since timeouts are handled by each context's event handling goroutine,
+ * this function always returns 0 (no pending timeouts).
  *
  * \param ctx the context to operate on, or NULL for the default context
  * \param tv output location for a relative time against the current
@@ -873,54 +981,9 @@ func libusb_handle_events(ctx *libusb_context) int {
  * \returns 0 if there are no pending timeouts, 1 if a timeout was returned,
  * or LIBUSB_ERROR_OTHER on failure
  */
-func libusb_get_next_timeout(ctx *libusb_context, tv *time.Time) int {
-	var next_timeout, cur_tv time.Time
-
-	ctx = USBI_GET_CONTEXT(ctx)
+func libusb_get_next_timeout(ctx *libusb_context, tv *time.Duration) int {
+	/* timeouts are handled by each context's event handling goroutine */
 	return 0
-
-	ctx.flying_transfers_lock.Lock()
-	if list_empty(ctx.flying_transfers) {
-		ctx.flying_transfers_lock.Unlock()
-		// usbi_dbg("no URBs, no timeout!")
-		return 0
-	}
-
-	/* find next transfer which hasn't already been processed as timed out */
-
-	for transfer = list_entry((ctx.flying_transfers).next, usbi_transfer, list); transfer.list != (ctx.flying_transfers); transfer = list_entry(transfer.list.next, usbi_transfer, list) {
-
-		if transfer.timeout_flags & (USBI_TRANSFER_TIMEOUT_HANDLED | USBI_TRANSFER_OS_HANDLES_TIMEOUT) {
-			continue
-		}
-
-		/* if we've reached transfers of infinte timeout, we're done looking */
-		if !timerisset(&transfer.timeout) {
-			break
-		}
-
-		next_timeout = transfer.timeout
-		break
-	}
-	ctx.flying_transfers_lock.Unlock()
-
-	if !timerisset(&next_timeout) {
-		// usbi_dbg("no URB with timeout or all handled by OS no timeout!")
-		return 0
-	}
-
-	cur_ts := time.Now()
-	TIMESPEC_TO_TIMEVAL(&cur_tv, &cur_ts)
-
-	if time.After(next_timeout, cur_tv) {
-		// usbi_dbg("first timeout already expired")
-		timerclear(tv)
-	} else {
-		timersub(&next_timeout, &cur_tv, tv)
-		// usbi_dbg("next timeout in %d.%06ds", tv.tv_sec, tv.tv_usec)
-	}
-
-	return 1
 }
 
 /** \ingroup libusb_poll
@@ -938,6 +1001,9 @@ func libusb_get_next_timeout(ctx *libusb_context, tv *time.Time) int {
  * and added to the poll set at libusb_init() time). If you don't want this,
  * remove the notifiers immediately before calling libusb_exit().
  *
+ * libusb no longer uses file descriptors for event handling, so the
+ * notifiers are never called.
+ *
  * \param ctx the context to operate on, or NULL for the default context
  * \param added_cb pointer to function for addition notifications
  * \param removed_cb pointer to function for removal notifications
@@ -954,88 +1020,34 @@ func libusb_set_pollfd_notifiers(ctx *libusb_context,
 	ctx.fd_cb_user_data = user_data
 }
 
-/*
- * Interrupt the iteration of the event handling thread, so that it picks
- * up the fd change. Callers of this function must hold the event_data_lock.
+/** \ingroup libusb_poll
+ * Retrieve a list of file descriptors that should be polled by your main loop
+ * as libusb event sources.
+ *
+ * libusb no longer uses file descriptors for event handling, on any
+ * platform, so this function always returns NULL.
+ *
+ * \param ctx the context to operate on, or NULL for the default context
+ * \returns NULL
  */
-func usbi_fd_notification(ctx *libusb_context) {
-	/* Record that there is a new poll fd.
-	 * Only signal an event if there are no prior pending events. */
-	pending_events := usbi_pending_events(ctx)
-	ctx.event_flags |= USBI_EVENT_POLLFDS_MODIFIED
-	if pending_events == 0 {
-		usbi_signal_event(ctx)
-	}
-}
-
-/* Add a file descriptor to the list of file descriptors to be monitored.
- * events should be specified as a bitmask of events passed to poll(), e.g.
- * POLLIN and/or POLLOUT. */
-func usbi_add_pollfd(ctx *libusb_context, fd int, events int16) int {
-	ipollfd := &usbi_pollfd{}
-
-	// usbi_dbg("add fd %d events %d", fd, events)
-	ipollfd.pollfd.fd = fd
-	ipollfd.pollfd.events = events
-	ctx.event_data_lock.Lock()
-	list_add_tail(&ipollfd.list, &ctx.ipollfds)
-	ctx.pollfds_cnt++
-	usbi_fd_notification(ctx)
-	ctx.event_data_lock.Unlock()
-
-	if ctx.fd_added_cb != nil {
-		ctx.fd_added_cb(fd, events, ctx.fd_cb_user_data)
-	}
-	return 0
-}
-
-/* Remove a file descriptor from the list of file descriptors to be polled. */
-func usbi_remove_pollfd(ctx *libusb_context, fd int) {
-
-	var found bool
-
-	// usbi_dbg("remove fd %d", fd)
-	ctx.event_data_lock.Lock()
-	for ipollfd := list_entry((&ctx.ipollfds).next, usbi_pollfd, list); &ipollfd.member != (&ctx.ipollfds); ipollfd = list_entry(ipollfd.list.next, usbi_pollfd, list) {
-		if ipollfd.pollfd.fd == fd {
-			found = true
-			break
-		}
-	}
-
-	if !found {
-		// usbi_dbg("couldn't find fd %d to remove", fd)
-		ctx.event_data_lock.Unlock()
-		return
-	}
-
-	list_del(&ipollfd.list)
-	ctx.pollfds_cnt--
-	usbi_fd_notification(ctx)
-	ctx.event_data_lock.Unlock()
-	if ctx.fd_removed_cb != nil {
-		ctx.fd_removed_cb(fd, ctx.fd_cb_user_data)
-	}
+func libusb_get_pollfds(ctx *libusb_context) []*libusb_pollfd {
+	return nil
 }
 
 /** \ingroup libusb_poll
  * Handle any pending events.
  *
- * libusb determines "pending events" by checking if any timeouts have expired
- * and by checking the set of file descriptors for activity.
- *
- * If a zero timeval is passed, this function will handle any already-pending
- * events and then immediately return in non-blocking style.
+ * Events are handled by a goroutine that every context runs for itself, so
+ * there is no need to call this function to make progress. It is kept so
+ * that code written against libusb can still wait for events: it blocks
+ * until the event handling goroutine has handled an event, the timeout
+ * expires, or libusb_interrupt_event_handler() is called.
  *
- * If a non-zero timeval is passed and no events are currently pending, this
- * function will block waiting for events to handle up until the specified
- * timeout. If an event arrives or a signal is raised, this function will
- * return early.
+ * If a zero timeout is passed, this function returns immediately.
  *
- * If the parameter completed is not NULL then <em>after obtaining the event
- * handling lock</em> this function will return immediately if the integer
- * pointed to is not 0. This allows for race free waiting for the completion
- * of a specific transfer.
+ * If the parameter completed is not NULL then this function will return
+ * immediately if the integer pointed to is not 0. This allows for race free
+ * waiting for the completion of a specific transfer.
  *
  * \param ctx the context to operate on, or NULL for the default context
  * \param tv the maximum time to block waiting for events, or an all zero
@@ -1044,81 +1056,20 @@ func usbi_remove_pollfd(ctx *libusb_context, fd int) {
  * \returns 0 on success, or a LIBUSB_ERROR code on failure
  * \ref libusb_mtasync
  */
-func libusb_handle_events_timeout_completed(ctx *libusb_context, tv *time.Time, completed *int) int {
-	var poll_timeout time.Duration
-
+func libusb_handle_events_timeout_completed(ctx *libusb_context, tv time.Duration, completed *int) int {
 	ctx = USBI_GET_CONTEXT(ctx)
-	r := get_next_timeout(ctx, tv, &poll_timeout)
-	if r != 0 {
-		/* timeout already expired */
-		return handle_timeouts(ctx)
-	}
 
-retry:
-	if libusb_try_lock_events(ctx) == 0 {
-		if completed == nil || *completed == 0 {
-			/* we obtained the event lock: do our own event handling */
-			// usbi_dbg("doing our own event handling")
-			r = handle_events(ctx, &poll_timeout)
-		}
-		libusb_unlock_events(ctx)
-		return r
-	}
-
-	/* another thread is doing event handling. wait for thread events that
-	 * notify event completion. */
-	libusb_lock_event_waiters(ctx)
+	/* take the channel before looking at completed, so that a completion
+	 * in between still wakes us */
+	ctx.event_data_lock.Lock()
+	handled := ctx.event_handled
+	ctx.event_data_lock.Unlock()
 
 	if completed != nil && *completed != 0 {
-		goto already_done
-	}
-
-	if !libusb_event_handler_active(ctx) {
-		/* we hit a race: whoever was event handling earlier finished in the
-		 * time it took us to reach this point. try the cycle again. */
-		libusb_unlock_event_waiters(ctx)
-		// usbi_dbg("event handler was active but went away, retrying")
-		goto retry
-	}
-
-	// usbi_dbg("another thread is doing event handling")
-	r = libusb_wait_for_event(ctx, &poll_timeout)
-
-already_done:
-	libusb_unlock_event_waiters(ctx)
-
-	if r < 0 {
-		return r
-	} else if r == 1 {
-		return handle_timeouts(ctx)
+		return 0
 	}
-	return 0
-}
-
-/* returns the smallest of:
- *  1. timeout of next URB
- *  2. user-supplied timeout
- * returns 1 if there is an already-expired timeout, otherwise returns 0
- * and populates out
- */
-func get_next_timeout(ctx *libusb_context, tv *time.Time, out *time.Time) int {
-	var timeout time.Duration
-	r := libusb_get_next_timeout(ctx, &timeout)
-	if r != 0 {
-		/* timeout already expired? */
-		if !timerisset(&timeout) {
-			return 1
-		}
 
-		/* choose the smallest of next URB timeout or user specified timeout */
-		if timeout < *tv {
-			*out = timeout
-		} else {
-			*out = *tv
-		}
-	} else {
-		*out = *tv
-	}
+	usbi_wait_for_event(ctx, handled, tv)
 	return 0
 }
 
@@ -1147,275 +1098,113 @@ func get_next_timeout(ctx *libusb_context, tv *time.Time, out *time.Time) int {
  * \returns 1 if the timeout expired
  * \ref libusb_mtasync
  */
-func libusb_wait_for_event(ctx *libusb_context, tv *time.Time) int {
-
+func libusb_wait_for_event(ctx *libusb_context, tv *time.Duration) int {
 	ctx = USBI_GET_CONTEXT(ctx)
-	if tv == nil {
-		ctx.event_waiters_cond.Wait()
-		return 0
+
+	ctx.event_data_lock.Lock()
+	handled := ctx.event_handled
+	ctx.event_data_lock.Unlock()
+
+	timeout := time.Duration(-1)
+	if tv != nil {
+		timeout = *tv
+	}
+
+	ctx.event_waiters_lock.Unlock()
+	r := usbi_wait_for_event(ctx, handled, timeout)
+	ctx.event_waiters_lock.Lock()
+	return r
+}
+
+/* Wait until handled is closed or timeout expires, never if timeout is
+ * zero and forever if it is negative. Returns 1 if the timeout expired. */
+func usbi_wait_for_event(ctx *libusb_context, handled <-chan struct{}, timeout time.Duration) int {
+	var expired <-chan time.Time
+	if timeout >= 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
 	}
 
-	done := make(chan struct{})
-	go func() {
-		ctx.event_waiters_cond.Wait()
-		close(done)
-	}()
 	select {
-	case <-time.After(tv):
-		return 1
-	case <-done:
+	case <-handled:
+		return 0
+	case <-ctx.event_quit:
 		return 0
+	case <-expired:
+		return 1
 	}
 }
 
 func handle_timeout(itransfer *usbi_transfer) {
 	transfer := itransfer.libusbTransfer
 
-	itransfer.timeout_flags |= USBI_TRANSFER_TIMEOUT_HANDLED
+	itransfer.timeout_flags |= uint8(USBI_TRANSFER_TIMEOUT_HANDLED)
 	r := libusb_cancel_transfer(transfer)
-	if r == LIBUSB_SUCCESS {
-		itransfer.timeout_flags |= USBI_TRANSFER_TIMED_OUT
+	if r == 0 {
+		itransfer.timeout_flags |= uint8(USBI_TRANSFER_TIMED_OUT)
 	}
 	// usbi_warn(TRANSFER_CTX(transfer),
 	//		"async cancel failed %d errno=%d", r, errno)
 }
 
-func handle_timeouts_locked(ctx *libusb_context) int {
-
-	if list_empty(ctx.flying_transfers) {
-		return 0
-	}
-
-	/* get current time */
-	systime_ts := time.Now()
-	var systime timeval
-
-	TIMESPEC_TO_TIMEVAL(&systime, &systime_ts)
+func handle_timeouts_locked(ctx *libusb_context) {
+	now := time.Now()
 
 	/* iterate through flying transfers list, finding all transfers that
 	 * have expired timeouts */
-	for transfer = list_entry((&ctx.flying_transfers).next, usbi_transfer, list); &transfer.list != (&ctx.flying_transfers); transfer = list_entry(transfer.list.next, usbi_transfer, list) {
-
-		timeval * cur_tv = &transfer.timeout
+	for e := ctx.flying_transfers.next; e != ctx.flying_transfers; e = e.next {
+		transfer := list_entry(e).(*usbi_transfer)
 
 		/* if we've reached transfers of infinite timeout, we're all done */
-		if !timerisset(cur_tv) {
-			return 0
+		if transfer.timeout.IsZero() {
+			return
 		}
 
 		/* ignore timeouts we've already handled */
-		if (transfer.timeout_flags & (USBI_TRANSFER_TIMEOUT_HANDLED | USBI_TRANSFER_OS_HANDLES_TIMEOUT)) != 0 {
+		if transfer.timeout_flags&uint8(USBI_TRANSFER_TIMEOUT_HANDLED|USBI_TRANSFER_OS_HANDLES_TIMEOUT) != 0 {
 			continue
 		}
 
 		/* if transfer has non-expired timeout, nothing more to do */
-		if ((cur_tv.tv_sec > systime.tv_sec) ||
-			(cur_tv.tv_sec == systime.tv_sec &&
-				cur_tv.tv_usec > systime.tv_usec)) != 0 {
-			return 0
+		if transfer.timeout.After(now) {
+			return
 		}
 
 		/* otherwise, we've got an expired timeout to handle */
 		handle_timeout(transfer)
 	}
-	return 0
 }
 
-func handle_timeouts(ctx *libusb_context) int {
-	ctx = USBI_GET_CONTEXT(ctx)
-	ctx.flying_transfers_lock.Lock()
-	r := handle_timeouts_locked(ctx)
-	ctx.flying_transfers_lock.Unlock()
-	return r
-}
-
-func handle_timerfd_trigger(ctx *libusb_context) int {
+func handle_timeouts(ctx *libusb_context) {
 	ctx.flying_transfers_lock.Lock()
-
-	/* process the timeout that just happened */
-	r := handle_timeouts_locked(ctx)
-	if r < 0 {
-		ctx.flying_transfers_lock.Unlock()
-		return r
-	}
-
-	/* arm for next timeout*/
-	r = arm_timerfd_for_next_timeout(ctx)
-
+	handle_timeouts_locked(ctx)
 	ctx.flying_transfers_lock.Unlock()
-	return r
 }
 
-/* do the actual event handling. assumes that no other thread is concurrently
- * doing the same thing. */
-func handle_events(ctx *libusb_context, tv *time.Time) int {
-	var ipollfd *usbi_pollfd
-	var timeout_ms int
-	var special_event int
-	var r, i int
-
-	/* there are certain fds that libusb uses internally, currently:
-	 *
-	 *   1) event pipe
-	 *   2) timerfd
-	 *
-	 * the backend will never need to attempt to handle events on these fds, so
-	 * we determine how many fds are in use internally for this context and when
-	 * handle_events() is called in the backend, the pollfd list and count will
-	 * be adjusted to skip over these internal fds */
-	var internal_nfds POLL_NFDS_TYPE = 2
-
-	/* only reallocate the poll fds when the list of poll fds has been modified
-	 * since the last poll, otherwise reuse them to save the additional overhead */
-	ctx.event_data_lock.Lock()
-	if ctx.event_flags&USBI_EVENT_POLLFDS_MODIFIED != 0 {
-		// usbi_dbg("poll fds modified, reallocating")
-
-		/* sanity check - it is invalid for a context to have fewer than the
-		 * required internal fds (memory corruption?) */
-		if ctx.pollfds_cnt < internal_nfds {
-			panic("Insufficient poll fds for internal nfds")
-		}
-
-		ctx.pollfds = make([]int, ctx.pollfds_cnt)
-
-		for ipollfd = list_entry((&ctx.ipollfds).next, usbi_pollfd, list); &ipollfd.list != (&ctx.ipollfds); ipollfd = list_entry(ipollfd.list.next, usbi_pollfd, list) {
-
-			libusb_pollfd * pollfd = &ipollfd.pollfd
-			i++
-			ctx.pollfds[i].fd = pollfd.fd
-			ctx.pollfds[i].events = pollfd.events
-		}
-
-		/* reset the flag now that we have the updated list */
-		ctx.event_flags &= ^USBI_EVENT_POLLFDS_MODIFIED
-
-		/* if no further pending events, clear the event pipe so that we do
-		 * not immediately return from poll */
-		if !usbi_pending_events(ctx) {
-			usbi_clear_event(ctx)
-		}
-	}
-
-	fds := ctx.pollfds
-	nfds := ctx.pollfds_cnt
-	ctx.event_data_lock.Unlock()
-
-	timeout_ms = tv.Milliseconds()
-
-redo_poll:
-	// usbi_dbg("poll() %d fds with timeout in %dms", nfds, timeout_ms)
-	r = usbi_poll(fds, nfds, timeout_ms)
-	// usbi_dbg("poll() returned %d", r)
-	if r == 0 {
-		return handle_timeouts(ctx)
-	} else if r == -1 && errno == EINTR {
-		return LIBUSB_ERROR_INTERRUPTED
-	} else if r < 0 {
-		// usbi_err(ctx, "poll failed %d err=%d", r, errno)
-		return LIBUSB_ERROR_IO
-	}
-
-	special_event = 0
-
-	/* fds[0] is always the event pipe */
-	if fds[0].revents != 0 {
-		var message *libusb_hotplug_message
-		var itransfer *usbi_transfer
-		var ret int
-
-		// usbi_dbg("caught a fish on the event pipe")
-
-		/* take the the event data lock while processing events */
-		ctx.event_data_lock.Lock()
-
-		/* check if someone added a new poll fd */
-		// if (ctx.event_flags & USBI_EVENT_POLLFDS_MODIFIED)
-		// usbi_dbg("someone updated the poll fds")
-
-		if ctx.event_flags & USBI_EVENT_USER_INTERRUPT {
-			// usbi_dbg("someone purposely interrupted")
-			ctx.event_flags &= ^USBI_EVENT_USER_INTERRUPT
-		}
-
-		/* check if someone is closing a device */
-		// if (ctx.device_close)
-		// usbi_dbg("someone is closing a device")
-
-		/* check for any pending hotplug messages */
-		if !list_empty(&ctx.hotplug_msgs) {
-			// usbi_dbg("hotplug message received")
-			special_event = 1
-			message = list_first_entry(&ctx.hotplug_msgs, libusb_hotplug_message, list)
-			list_del(&message.list)
-		}
-
-		/* complete any pending transfers */
-		for ret == 0 && !list_empty(&ctx.completed_transfers) {
-			itransfer := list_first_entry(&ctx.completed_transfers, usbi_transfer, completed_list)
-			list_del(&itransfer.completed_list)
-			ctx.event_data_lock.Unlock()
-			ret = usbi_backend.handle_transfer_completion(itransfer)
-			// if (ret)
-			// usbi_err(ctx, "backend handle_transfer_completion failed with error %d", ret)
-			ctx.event_data_lock.Lock()
-		}
+/* do the actual event handling: wait up to tv for an event, then dispatch it
+ * and any others already queued. assumes the events lock is held, which
+ * keeps the event handling goroutine from dispatching concurrently. */
+func handle_events(ctx *libusb_context, tv time.Duration) int {
+	timer := time.NewTimer(tv)
+	defer timer.Stop()
 
-		/* if no further pending events, clear the event pipe */
-		if !usbi_pending_events(ctx) {
-			usbi_clear_event(ctx)
-		}
-
-		ctx.event_data_lock.Unlock()
-
-		/* process the hotplug message, if any */
-		if message != nil {
-			usbi_hotplug_match(ctx, message.device, message.event)
-
-			/* the device left, dereference the device */
-			if LIBUSB_HOTPLUG_EVENT_DEVICE_LEFT == message.event {
-				libusb_unref_device(message.device)
-			}
-		}
-
-		if ret != 0 {
-			/* return error code */
-			return ret
-		}
-
-		r--
-		if r == 0 {
-			goto handled
-		}
+	select {
+	case ev := <-ctx.event_out:
+		usbi_dispatch_event(ctx, ev)
+	case <-timer.C:
+		return 0
+	case <-ctx.event_quit:
+		return 0
 	}
 
-	/* on timerfd configurations, fds[1] is the timerfd */
-	if fds[1].revents != 0 {
-		/* timerfd indicates that a timeout has expired */
-		// usbi_dbg("timerfd triggered")
-		special_event = 1
-
-		ret := handle_timerfd_trigger(ctx)
-		if ret < 0 {
-			/* return error code */
-			return ret
-		}
-
-		r--
-		if 0 == r {
-			goto handled
+	/* dispatch whatever else has arrived in the meantime */
+	for {
+		select {
+		case ev := <-ctx.event_out:
+			usbi_dispatch_event(ctx, ev)
+		default:
+			return 0
 		}
 	}
-
-	r = usbi_backend.handle_events(ctx, fds+internal_nfds, nfds-internal_nfds, r)
-	// if r
-	// usbi_err(ctx, "backend handle_events failed with error %d", r)
-
-handled:
-	if r == 0 && special_event != 0 {
-		timeout_ms = 0
-		goto redo_poll
-	}
-
-	return r
 }
@@ -0,0 +1,133 @@
+package usb
+
+import "time"
+
+// IsoStreamConfig configures an IsoStream. Zero fields take their defaults.
+type IsoStreamConfig struct {
+	// Transfers is the number of transfers kept in flight. Defaults to 4.
+	Transfers int
+
+	// Packets is the number of packets in each transfer, each of which is
+	// scheduled in its own (micro)frame. Defaults to 8 milliseconds worth:
+	// 8 packets at full speed and 64 at high speed and above.
+	Packets int
+
+	// PacketSize is the requested length of each packet. Defaults to the
+	// endpoint's MaxIsoPacketSize, which accounts for high bandwidth
+	// endpoints that move several transactions per microframe.
+	PacketSize int
+
+	// Timeout is the timeout of each transfer. Defaults to no timeout.
+	Timeout time.Duration
+
+	// Queue is the number of completed transfers buffered for the reader.
+	// Transfers that complete while the queue is full are dropped, and
+	// their packets counted as dropped. Defaults to twice Transfers.
+	Queue int
+}
+
+// An IsoChunk is the data of one completed isochronous transfer.
+type IsoChunk struct {
+	// Packets holds the result of each packet, in (micro)frame order. The
+	// packet data is a copy that belongs to the receiver.
+	Packets []IsoPacket
+
+	// Dropped is the number of packets that failed to transfer, which is
+	// usually a (micro)frame missed by the host controller.
+	Dropped int
+}
+
+// An IsoStream continuously reads from an isochronous IN endpoint, keeping
+// several transfers queued so that no (micro)frame goes unserviced.
+// Completed transfers are delivered in order on the channel returned by
+// Chunks, with the status and length of every packet.
+//
+// Like a Stream, an IsoStream stops when it is closed, when the device is
+// unplugged or when a transfer fails as a whole.
+type IsoStream struct {
+	pump
+	chunks  chan IsoChunk
+	dropped int
+}
+
+// NewIsoStream starts streaming from an isochronous IN endpoint. The
+// interface the endpoint belongs to must be claimed with an alternate
+// setting that gives it bandwidth.
+func (h *DeviceHandle) NewIsoStream(endpoint uint8, config IsoStreamConfig) (*IsoStream, error) {
+	if endpoint&LIBUSB_ENDPOINT_DIR_MASK != uint8(LIBUSB_ENDPOINT_IN) {
+		return nil, h.transferError("open iso stream", endpoint, LIBUSB_ERROR_INVALID_PARAM, 0)
+	}
+	if config.Transfers <= 0 {
+		config.Transfers = 4
+	}
+	if config.Packets <= 0 {
+		config.Packets = 8
+		if h.dev.Speed() >= SpeedHigh {
+			config.Packets = 64
+		}
+	}
+	if config.PacketSize <= 0 {
+		size, err := h.dev.MaxIsoPacketSize(endpoint)
+		if err != nil {
+			return nil, err
+		}
+		config.PacketSize = size
+	}
+	if config.Queue <= 0 {
+		config.Queue = 2 * config.Transfers
+	}
+
+	s := &IsoStream{
+		chunks: make(chan IsoChunk, config.Queue),
+	}
+	s.deliver = s.deliverChunk
+	s.closeQueue = func() { close(s.chunks) }
+	s.transfers = make([]*Transfer, config.Transfers)
+	for i := range s.transfers {
+		buf := make([]byte, config.Packets*config.PacketSize)
+		t := h.NewTransfer(TransferTypeIsochronous, endpoint, buf, config.Packets,
+			config.Timeout, s.complete)
+		t.SetIsoPacketLengths(config.PacketSize)
+		s.transfers[i] = t
+	}
+	if err := s.start(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// deliverChunk copies the packets out of the transfer buffer, which is
+// resubmitted as soon as this returns. It is called with the pump locked.
+// Isochronous data cannot wait for the reader, so a transfer that completes
+// while the queue is full is dropped.
+func (s *IsoStream) deliverChunk(t *Transfer) {
+	var chunk IsoChunk
+	chunk.Packets = t.IsoPackets()
+	for i := range chunk.Packets {
+		pkt := &chunk.Packets[i]
+		pkt.Data = append([]byte(nil), pkt.Data...)
+		if pkt.Status != TransferCompleted {
+			chunk.Dropped++
+		}
+	}
+	select {
+	case s.chunks <- chunk:
+		s.dropped += chunk.Dropped
+	default:
+		s.dropped += len(chunk.Packets)
+	}
+}
+
+// Chunks returns the channel the stream delivers completed transfers on. It
+// is closed once the stream has stopped.
+func (s *IsoStream) Chunks() <-chan IsoChunk {
+	return s.chunks
+}
+
+// Dropped returns the number of packets lost so far, whether because they
+// failed to transfer or because the reader did not keep up.
+func (s *IsoStream) Dropped() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.dropped
+}
@@ -0,0 +1,135 @@
+package usb
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// openIso opens the loopback device with an isochronous IN endpoint whose
+// every third packet fails and whose other packets carry their number,
+// every second one short of a full packet.
+func openIso(t *testing.T, speed Speed) *DeviceHandle {
+	t.Helper()
+	var mu sync.Mutex
+	var seq int
+	d := newLoopbackDevice()
+	d.Speed = speed
+	d.Endpoints[0x83] = func(req *SimRequest) (int, error) {
+		mu.Lock()
+		n := seq
+		seq++
+		mu.Unlock()
+		if n%3 == 2 {
+			return 0, ErrIO
+		}
+		for i := range req.Data {
+			req.Data[i] = byte(n)
+		}
+		if n%2 == 1 {
+			return 1, nil
+		}
+		return len(req.Data), nil
+	}
+	h, _ := openLoopback(t, d)
+	return h
+}
+
+func TestIsoStream(t *testing.T) {
+	h := openIso(t, SpeedFull)
+	s, err := h.NewIsoStream(0x83, IsoStreamConfig{Transfers: 2, Packets: 5, Queue: 8})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+
+	dropped := 0
+	next := 0
+	for i := 0; i < 6; i++ {
+		chunk := <-s.Chunks()
+		if len(chunk.Packets) != 5 {
+			t.Fatalf("chunk of %d packets, want 5", len(chunk.Packets))
+		}
+		n := 0
+		for _, pkt := range chunk.Packets {
+			wantLen, wantStatus := 64, TransferCompleted
+			switch {
+			case next%3 == 2:
+				wantLen, wantStatus = 0, TransferError
+				n++
+			case next%2 == 1:
+				wantLen = 1
+			}
+			if pkt.Length != 64 || pkt.ActualLength != wantLen || pkt.Status != wantStatus {
+				t.Errorf("packet %d: length %d, actual length %d, status %v, want 64, %d, %v",
+					next, pkt.Length, pkt.ActualLength, pkt.Status, wantLen, wantStatus)
+			}
+			if len(pkt.Data) != wantLen || (wantLen > 0 && pkt.Data[0] != byte(next)) {
+				t.Errorf("packet %d: data % x", next, pkt.Data)
+			}
+			next++
+		}
+		if chunk.Dropped != n {
+			t.Errorf("chunk reports %d dropped packets, want %d", chunk.Dropped, n)
+		}
+		dropped += n
+	}
+
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for chunk := range s.Chunks() {
+		dropped += chunk.Dropped
+	}
+	select {
+	case <-s.Stopped():
+	default:
+		t.Error("closed stream has not stopped")
+	}
+	if err := s.Err(); err != nil {
+		t.Errorf("closed stream reports %v", err)
+	}
+	/* besides failed packets, chunks that found the queue full */
+	if got := s.Dropped(); got < dropped || (got-dropped)%5 != 0 {
+		t.Errorf("stream dropped %d packets, counted %d failed ones", got, dropped)
+	}
+}
+
+func TestIsoStreamPackets(t *testing.T) {
+	tests := []struct {
+		speed   Speed
+		config  IsoStreamConfig
+		packets int
+	}{
+		{SpeedFull, IsoStreamConfig{}, 8},
+		{SpeedHigh, IsoStreamConfig{}, 64},
+		{SpeedHigh, IsoStreamConfig{Packets: 3, PacketSize: 16}, 3},
+	}
+	for _, tt := range tests {
+		h := openIso(t, tt.speed)
+		s, err := h.NewIsoStream(0x83, tt.config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case chunk := <-s.Chunks():
+			size := tt.config.PacketSize
+			if size == 0 {
+				size = 64
+			}
+			if len(chunk.Packets) != tt.packets || chunk.Packets[0].Length != size {
+				t.Errorf("%+v at speed %v: %d packets of %d bytes, want %d of %d", tt.config, tt.speed,
+					len(chunk.Packets), chunk.Packets[0].Length, tt.packets, size)
+			}
+		case <-time.After(time.Second):
+			t.Errorf("%+v at speed %v: no chunk", tt.config, tt.speed)
+		}
+		s.Close()
+	}
+
+	h := openIso(t, SpeedFull)
+	if _, err := h.NewIsoStream(0x03, IsoStreamConfig{}); !errors.Is(err, ErrInvalidParam) {
+		t.Errorf("iso stream from an OUT endpoint returned %v, want ErrInvalidParam", err)
+	}
+}
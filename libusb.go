@@ -113,6 +113,14 @@ const (
 	/** Endpoint descriptor. See libusb_endpoint_descriptor. */
 	LIBUSB_DT_ENDPOINT libusb_descriptor_type = 0x05
 
+	/** Device Qualifier descriptor. See
+	 * libusb_device_qualifier_descriptor. */
+	LIBUSB_DT_DEVICE_QUALIFIER libusb_descriptor_type = 0x06
+
+	/** Other Speed Configuration descriptor. Laid out as a
+	 * libusb_config_descriptor. */
+	LIBUSB_DT_OTHER_SPEED_CONFIGURATION libusb_descriptor_type = 0x07
+
 	/** BOS descriptor */
 	LIBUSB_DT_BOS libusb_descriptor_type = 0x0f
 
@@ -383,6 +391,27 @@ const (
 
 	/** Container ID type */
 	LIBUSB_BT_CONTAINER_ID libusb_bos_type = 4
+
+	/** Platform descriptor */
+	LIBUSB_BT_PLATFORM_DESCRIPTOR libusb_bos_type = 5
+
+	/** SuperSpeedPlus device capability */
+	LIBUSB_BT_SUPERSPEED_PLUS_CAPABILITY libusb_bos_type = 0x0a
+
+	/** Precision Time Measurement capability */
+	LIBUSB_BT_PRECISION_TIME_MEASUREMENT libusb_bos_type = 0x0b
+
+	/** Wireless USB 1.1 extension */
+	LIBUSB_BT_WIRELESS_USB_EXT libusb_bos_type = 0x0c
+
+	/** Billboard capability */
+	LIBUSB_BT_BILLBOARD libusb_bos_type = 0x0d
+
+	/** Billboard alternate mode capability */
+	LIBUSB_BT_BILLBOARD_EX libusb_bos_type = 0x0f
+
+	/** Configuration summary */
+	LIBUSB_BT_CONFIGURATION_SUMMARY libusb_bos_type = 0x10
 )
 
 /** \ingroup libusb_misc
@@ -392,7 +421,7 @@ const (
  * error code or libusb_strerror() to get an end-user suitable description of
  * an error code.
  */
-type libusb_error int8
+type libusb_error int
 
 const (
 	/** Success (no error) */
@@ -652,6 +681,45 @@ type libusb_device_descriptor struct {
 	bNumConfigurations uint8
 }
 
+/** \ingroup libusb_desc
+ * A structure representing the standard USB device qualifier descriptor.
+ * This descriptor is documented in section 9.6.2 of the USB 2.0
+ * specification. It describes a high-speed capable device as it would be
+ * when operating at its other speed.
+ * All multiple-byte fields are represented in host-endian format.
+ */
+type libusb_device_qualifier_descriptor struct {
+	/** Size of this descriptor (in bytes) */
+	bLength uint8
+
+	/** Descriptor type. Will have value
+	 * \ref libusb_descriptor_type::LIBUSB_DT_DEVICE_QUALIFIER
+	 * LIBUSB_DT_DEVICE_QUALIFIER in this context. */
+	bDescriptorType uint8
+
+	/** USB specification release number in binary-coded decimal, at least
+	 * 0x0200. */
+	bcdUSB uint16
+
+	/** USB-IF class code for the device at the other speed */
+	bDeviceClass uint8
+
+	/** USB-IF subclass code for the device at the other speed */
+	bDeviceSubClass uint8
+
+	/** USB-IF protocol code for the device at the other speed */
+	bDeviceProtocol uint8
+
+	/** Maximum packet size for endpoint 0 at the other speed */
+	bMaxPacketSize0 uint8
+
+	/** Number of other speed configurations */
+	bNumConfigurations uint8
+
+	/** Reserved for future use, must be zero */
+	bReserved uint8
+}
+
 /** \ingroup libusb_desc
  * A structure representing the standard USB endpoint descriptor. This
  * descriptor is documented in section 9.6.6 of the USB 3.0 specification.
@@ -697,6 +765,9 @@ type libusb_endpoint_descriptor struct {
 	/** Extra descriptors. If libusb encounters unknown endpoint descriptors,
 	 * it will store them here, should you wish to parse them. */
 	extra []uint8
+
+	// Go: the bytes past bSynchAddress, or past bInterval if bLength is 8
+	tail []uint8
 }
 
 /** \ingroup libusb_desc
@@ -744,6 +815,9 @@ type libusb_interface_descriptor struct {
 	/** Extra descriptors. If libusb encounters unknown interface descriptors,
 	 * it will store them here, should you wish to parse them. */
 	extra []uint8
+
+	// Go: the bytes past iInterface when bLength is longer than standard
+	tail []uint8
 }
 
 /** \ingroup libusb_desc
@@ -801,6 +875,9 @@ type libusb_config_descriptor struct {
 	/** Extra descriptors. If libusb encounters unknown configuration
 	 * descriptors, it will store them here, should you wish to parse them. */
 	extra []uint8
+
+	// Go: the bytes past MaxPower when bLength is longer than standard
+	tail []uint8
 }
 
 /** \ingroup libusb_desc
@@ -849,16 +926,15 @@ type libusb_bos_dev_capability_descriptor struct {
 	/** Device Capability type */
 	bDevCapabilityType libusb_bos_type
 	/** Device Capability data (bLength - 3 bytes) */
-	dev_capability_data uint8
+	dev_capability_data []uint8
 }
 
 func (bdcd libusb_bos_dev_capability_descriptor) ToBytes() []uint8 {
-	return []uint8{
+	return append([]uint8{
 		bdcd.bLength,
 		bdcd.bDescriptorType,
-		bdcd.bDevCapabilityType,
-		bdcd.dev_capability_data,
-	}
+		uint8(bdcd.bDevCapabilityType),
+	}, bdcd.dev_capability_data...)
 }
 
 /** \ingroup libusb_desc
@@ -883,7 +959,7 @@ type libusb_bos_descriptor struct {
 	bNumDeviceCaps uint8
 
 	/** bNumDeviceCap Device Capability Descriptors */
-	dev_capability *libusb_bos_dev_capability_descriptor
+	dev_capability []*libusb_bos_dev_capability_descriptor
 }
 
 // BosDescriptorFromBytes does not populate the dev_capability field
@@ -894,7 +970,7 @@ func BosDescriptorFromBytes(bytes []uint8) (*libusb_bos_descriptor, error) {
 	return &libusb_bos_descriptor{
 		bLength:         bytes[0],
 		bDescriptorType: bytes[1],
-		wTotalLength:    bytes[2:4],
+		wTotalLength:    binary.LittleEndian.Uint16(bytes[2:4]),
 		bNumDeviceCaps:  bytes[4],
 	}, nil
 }
@@ -933,7 +1009,7 @@ func Usb20ExtensionDescriptorFromBytes(bytes []uint8) (*libusb_usb_2_0_extension
 		bLength:            bytes[0],
 		bDescriptorType:    bytes[1],
 		bDevCapabilityType: bytes[2],
-		bmAttributes:       bytes[3:],
+		bmAttributes:       binary.LittleEndian.Uint32(bytes[3:]),
 	}, nil
 }
 
@@ -988,10 +1064,10 @@ func SsUsbDeviceCapabilityDescriptorFromBytes(bytes []uint8) (*libusb_ss_usb_dev
 		bDescriptorType:       bytes[1],
 		bDevCapabilityType:    bytes[2],
 		bmAttributes:          bytes[3],
-		wSpeedSupported:       bytes[4:6],
+		wSpeedSupported:       binary.LittleEndian.Uint16(bytes[4:6]),
 		bFunctionalitySupport: bytes[6],
 		bU1DevExitLat:         bytes[7],
-		bU2DevExitLat:         bytes[8:10],
+		bU2DevExitLat:         binary.LittleEndian.Uint16(bytes[8:10]),
 	}, nil
 }
 
@@ -1025,13 +1101,14 @@ func containerIdFromBytes(bytes []uint8) (*libusb_container_id_descriptor, error
 	if len(bytes) != 20 {
 		return nil, errors.New("Expected 20 bytes")
 	}
-	return &libusb_container_id_descriptor{
+	id := &libusb_container_id_descriptor{
 		bLength:            bytes[0],
 		bDescriptorType:    bytes[1],
 		bDevCapabilityType: bytes[2],
 		bReserved:          bytes[3],
-		ContainerID:        bytes[4:],
-	}, nil
+	}
+	copy(id.ContainerID[:], bytes[4:])
+	return id, nil
 }
 
 /** \ingroup libusb_asyncio
@@ -1154,8 +1231,9 @@ type libusb_transfer struct {
 	 * endpoints. */
 	num_iso_packets int
 
-	/** Isochronous packet descriptors, for isochronous transfers only. */
-	iso_packet_desc libusb_iso_packet_descriptor
+	/** Isochronous packet descriptors, for isochronous transfers only.
+	 * Allocated by libusb_alloc_transfer() with one entry per packet. */
+	iso_packet_desc []libusb_iso_packet_descriptor
 }
 
 /** \ingroup libusb_poll
@@ -1212,13 +1290,15 @@ var libusb_le16_to_cpu = libusb_cpu_to_le16
  * \param x the host-endian value to convert
  * \returns the value in little-endian byte order
  */
-// Go credit: This is swapUint16 from github.com/tsuna/endian
 func libusb_cpu_to_le16(x uint16) uint16 {
-	return (n&0x00FF)<<8 | (n&0xFF00)>>8
+	var b [2]byte
+	binary.LittleEndian.PutUint16(b[:], x)
+	return binary.NativeEndian.Uint16(b[:])
 }
 
 /* Descriptor sizes per descriptor type */
 const LIBUSB_DT_DEVICE_SIZE = 18
+const LIBUSB_DT_DEVICE_QUALIFIER_SIZE = 10
 const LIBUSB_DT_CONFIG_SIZE = 9
 const LIBUSB_DT_INTERFACE_SIZE = 9
 const LIBUSB_DT_ENDPOINT_SIZE = 7
@@ -1232,6 +1312,7 @@ const LIBUSB_DT_DEVICE_CAPABILITY_SIZE = 3
 const LIBUSB_BT_USB_2_0_EXTENSION_SIZE = 7
 const LIBUSB_BT_SS_USB_DEVICE_CAPABILITY_SIZE = 10
 const LIBUSB_BT_CONTAINER_ID_SIZE = 20
+const LIBUSB_BT_PLATFORM_DESCRIPTOR_MIN_SIZE = 20
 
 /* We unwrap the BOS => define its max size */
 const LIBUSB_DT_BOS_MAX_SIZE = ((LIBUSB_DT_BOS_SIZE) +
@@ -1348,8 +1429,7 @@ func libusb_get_iso_packet_buffer_simple(transfer *libusb_transfer, packet int)
 		return nil
 	}
 
-	// GO this needs to be fixed, buffer should probably not be a []uint8
-	return transfer.buffer[transfer.iso_packet_desc[0].length*packet:]
+	return transfer.buffer[int(transfer.iso_packet_desc[0].length)*packet:]
 }
 
 /** \ingroup libusb_asyncio
@@ -1415,15 +1495,9 @@ func libusb_control_transfer_get_setup(transfer *libusb_transfer) []uint8 {
 func libusb_fill_control_setup(buffer []uint8, bmRequestType, bRequest uint8, wValue, wIndex, wLength uint16) {
 	buffer[0] = bmRequestType
 	buffer[1] = bRequest
-	wValue = libusb_cpu_to_le16(wValue)
-	buffer[2] = uint8(wValue >> 8)
-	buffer[3] = uint8(wValue)
-	wIndex = libusb_cpu_to_le16(wIndex)
-	buffer[4] = uint8(wIndex >> 8)
-	buffer[5] = uint8(wIndex)
-	wLength = libusb_cpu_to_le16(wLength)
-	buffer[6] = uint8(wLength >> 8)
-	buffer[7] = uint8(wLength)
+	binary.LittleEndian.PutUint16(buffer[2:], wValue)
+	binary.LittleEndian.PutUint16(buffer[4:], wIndex)
+	binary.LittleEndian.PutUint16(buffer[6:], wLength)
 }
 
 /** \ingroup libusb_asyncio
@@ -1461,12 +1535,12 @@ func libusb_fill_control_transfer(transfer *libusb_transfer,
 
 	transfer.dev_handle = dev_handle
 	transfer.endpoint = 0
-	transfer._type = LIBUSB_TRANSFER_TYPE_CONTROL
+	transfer._type = uint8(LIBUSB_TRANSFER_TYPE_CONTROL)
 	transfer.timeout = timeout
 	transfer.buffer = buffer
-	if len(buffer) > 8 {
-		setupLen := binary.LittleEndian.Uint16([]byte{buffer[6], buffer[7]})
-		transfer.length = int32(LIBUSB_CONTROL_SETUP_SIZE + libusb_le16_to_cpu(setup.wLength))
+	if len(buffer) >= LIBUSB_CONTROL_SETUP_SIZE {
+		wLength := binary.LittleEndian.Uint16(buffer[6:])
+		transfer.length = LIBUSB_CONTROL_SETUP_SIZE + int(wLength)
 	}
 	transfer.user_data = user_data
 	transfer.callback = callback
@@ -1492,7 +1566,7 @@ func libusb_fill_bulk_transfer(transfer *libusb_transfer,
 
 	transfer.dev_handle = dev_handle
 	transfer.endpoint = endpoint
-	transfer._type = LIBUSB_TRANSFER_TYPE_BULK
+	transfer._type = uint8(LIBUSB_TRANSFER_TYPE_BULK)
 	transfer.timeout = timeout
 	transfer.buffer = buffer
 	transfer.length = length
@@ -1524,7 +1598,7 @@ func libusb_fill_bulk_stream_transfer(transfer *libusb_transfer,
 
 	libusb_fill_bulk_transfer(transfer, dev_handle, endpoint, buffer,
 		length, callback, user_data, timeout)
-	transfer._type = LIBUSB_TRANSFER_TYPE_BULK_STREAM
+	transfer._type = uint8(LIBUSB_TRANSFER_TYPE_BULK_STREAM)
 	libusb_transfer_set_stream_id(transfer, stream_id)
 }
 
@@ -1548,7 +1622,7 @@ func libusb_fill_interrupt_transfer(transfer *libusb_transfer,
 
 	transfer.dev_handle = dev_handle
 	transfer.endpoint = endpoint
-	transfer._type = LIBUSB_TRANSFER_TYPE_INTERRUPT
+	transfer._type = uint8(LIBUSB_TRANSFER_TYPE_INTERRUPT)
 	transfer.timeout = timeout
 	transfer.buffer = buffer
 	transfer.length = length
@@ -1577,7 +1651,7 @@ func libusb_fill_iso_transfer(transfer *libusb_transfer,
 
 	transfer.dev_handle = dev_handle
 	transfer.endpoint = endpoint
-	transfer._type = LIBUSB_TRANSFER_TYPE_ISOCHRONOUS
+	transfer._type = uint8(LIBUSB_TRANSFER_TYPE_ISOCHRONOUS)
 	transfer.timeout = timeout
 	transfer.buffer = buffer
 	transfer.length = length
@@ -1619,13 +1693,13 @@ func libusb_set_iso_packet_lengths(transfer *libusb_transfer, length uint) {
 func libusb_get_iso_packet_buffer(transfer *libusb_transfer, packet int32) ([]uint8, error) {
 	// Go api difference: packet is an int32, not a uint.
 
-	if packet >= transfer.num_iso_packets {
+	if int(packet) >= transfer.num_iso_packets {
 		return []uint8{}, errors.New("packet exceeds transfer's iso packet count")
 	}
 
 	offset := 0
-	for i := 0; i < packet; i++ {
-		offset += transfer.iso_packet_desc[i].length
+	for i := 0; i < int(packet); i++ {
+		offset += int(transfer.iso_packet_desc[i].length)
 	}
 
 	return transfer.buffer[offset:], nil
@@ -1647,7 +1721,7 @@ func libusb_get_descriptor(dev_handle *libusb_device_handle, desc_type,
 	desc_index libusb_descriptor_type, data []uint8, length uint16) libusb_error {
 
 	return libusb_control_transfer(dev_handle, LIBUSB_ENDPOINT_IN,
-		LIBUSB_REQUEST_GET_DESCRIPTOR, uint16(((desc_type << 8) | desc_index)),
+		LIBUSB_REQUEST_GET_DESCRIPTOR, uint16(desc_type)<<8|uint16(desc_index),
 		0, data, length, 1000)
 }
 
@@ -1669,6 +1743,6 @@ func libusb_get_string_descriptor(dev_handle *libusb_device_handle, desc_index u
 	langid uint16, data []uint8, length int) libusb_error {
 
 	return libusb_control_transfer(dev_handle, LIBUSB_ENDPOINT_IN,
-		LIBUSB_REQUEST_GET_DESCRIPTOR, uint16(((LIBUSB_DT_STRING << 8) | desc_index)),
+		LIBUSB_REQUEST_GET_DESCRIPTOR, uint16(LIBUSB_DT_STRING)<<8|uint16(desc_index),
 		langid, data, uint16(length), 1000)
 }
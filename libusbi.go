@@ -38,23 +38,6 @@ const (
 	USBI_MAX_LOG_LEN = 1024
 )
 
-type usbi_event_flags uint8
-
-type pollfd struct {
-	fd      int    /* file descriptor */
-	events  uint16 /* requested events */
-	revents uint16 /* returned events */
-}
-type POLL_NFDS_TYPE uint32
-
-const (
-	/* The list of pollfds has been modified */
-	USBI_EVENT_POLLFDS_MODIFIED usbi_event_flags = 1 << 0
-
-	/* The user has interrupted the event handler */
-	USBI_EVENT_USER_INTERRUPT usbi_event_flags = 1 << 1
-)
-
 type usbi_clock uint8
 
 const (
@@ -92,9 +75,6 @@ type libusb_context struct {
 	debug       int
 	debug_fixed bool
 
-	/* internal event pipe, used for signalling occurrence of an internal event. */
-	event_pipe [2]int
-
 	usb_devs      *LinkedList
 	usb_devs_lock sync.Mutex
 
@@ -134,34 +114,28 @@ type libusb_context struct {
 	/* used to wait for event completion in threads other than the one that is
 	 * event handling */
 	event_waiters_lock sync.Mutex
-	event_waiters_cond sync.Cond
 
 	/* A lock to protect internal context event data. */
 	event_data_lock sync.Mutex
 
-	/* A bitmask of flags that are set to indicate specific events that need to
-	 * be handled. Protected by event_data_lock. */
-	event_flags uint
-
-	/* A counter that is set when we want to interrupt and prevent event handling,
-	 * in order to safely close a device. Protected by event_data_lock. */
-	device_close uint
+	/* closed and replaced each time events have been handled, to wake
+	 * threads waiting for event completion. Protected by event_data_lock. */
+	event_handled chan struct{}
 
-	/* list and count of poll fds and an array of poll fd structures that is
-	 * (re)allocated as necessary prior to polling. Protected by event_data_lock. */
-	ipollfds    *LinkedList
-	pollfds     []pollfd
-	pollfds_cnt POLL_NFDS_TYPE
+	/* completions and hotplug messages are sent here, to be dispatched by
+	 * the context's event handling goroutine */
+	event_in chan usbi_event
 
-	/* A list of pending hotplug messages. Protected by event_data_lock. */
-	hotplug_msgs *LinkedList
+	/* events queued up for dispatch */
+	event_out chan usbi_event
 
-	/* A list of pending completed transfers. Protected by event_data_lock. */
-	completed_transfers *LinkedList
+	/* pokes the event handling goroutine when the next transfer timeout
+	 * has changed */
+	event_rearm chan struct{}
 
-	/* used for timeout handling, if supported by OS.
-	 * this timerfd is maintained to trigger on the next pending timeout */
-	timerfd int
+	/* closed by libusb_exit() to stop the event handling goroutines */
+	event_quit    chan struct{}
+	event_threads sync.WaitGroup
 
 	list *LinkedList
 }
@@ -187,7 +161,7 @@ type libusb_device struct {
 	device_descriptor libusb_device_descriptor
 	attached          bool
 
-	os_priv uint8
+	os_priv interface{}
 }
 
 type libusb_device_handle struct {
@@ -198,7 +172,7 @@ type libusb_device_handle struct {
 	list                      *LinkedList
 	dev                       *libusb_device
 	auto_detach_kernel_driver int
-	os_priv                   []uint8
+	os_priv                   interface{}
 }
 
 /* in-memory transfer layout:
@@ -218,7 +192,6 @@ type usbi_transfer struct {
 	libusbTransfer  *libusb_transfer
 	num_iso_packets int
 	list            *LinkedList
-	completed_list  *LinkedList
 	timeout         time.Time
 	transferred     int
 	stream_id       uint32
@@ -249,12 +222,6 @@ type usb_descriptor_header struct {
 	bDescriptorType uint8
 }
 
-type usbi_pollfd struct {
-	/* must come first */
-	pollfd libusb_pollfd
-	list   *LinkedList
-}
-
 /* device discovery */
 
 /* we traverse usbfs without knowing how many devices we are going to find.
@@ -265,11 +232,11 @@ type usbi_pollfd struct {
 type discovered_devs []*libusb_device
 
 func IS_EPIN(ep uint8) bool {
-	return ep&LIBUSB_ENDPOINT_IN != 0
+	return ep&uint8(LIBUSB_ENDPOINT_IN) != 0
 }
 
 func IS_XFERIN(xfer *libusb_transfer) bool {
-	return xfer.endpoint&LIBUSB_ENDPOINT_IN != 0
+	return xfer.endpoint&uint8(LIBUSB_ENDPOINT_IN) != 0
 }
 
 func USBI_GET_CONTEXT(ctx *libusb_context) *libusb_context {
@@ -278,11 +245,3 @@ func USBI_GET_CONTEXT(ctx *libusb_context) *libusb_context {
 	}
 	return ctx
 }
-
-/* Update the following macro if new event sources are added */
-func usbi_pending_events(ctx *libusb_context) bool {
-	return ctx.event_flags != nil ||
-		ctx.device_close != nil ||
-		!list_empty(ctx.hotplug_msgs) ||
-		!list_empty(ctx.completed_transfers)
-}
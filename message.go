@@ -0,0 +1,124 @@
+package usb
+
+import "time"
+
+// A MessagePipe exchanges discrete messages over a pair of bulk endpoints,
+// for devices whose protocols delimit messages with short packets rather
+// than treating the endpoints as byte streams.
+//
+// A message ends with the first packet shorter than wMaxPacketSize. A
+// message whose length is an exact multiple of wMaxPacketSize must
+// therefore be followed by a zero length packet; WriteMessage sends one, and
+// the device is expected to do the same.
+type MessagePipe struct {
+	in, out *Endpoint
+	max     int
+	buf     []byte
+}
+
+// MessagePipe opens a message pipe over a bulk IN and a bulk OUT endpoint of
+// the interface. Messages longer than maxMessage bytes are reported as
+// overflows by ReadMessage.
+func (i *Interface) MessagePipe(in, out uint8, maxMessage int) (*MessagePipe, error) {
+	if maxMessage <= 0 ||
+		in&LIBUSB_ENDPOINT_DIR_MASK != uint8(LIBUSB_ENDPOINT_IN) ||
+		out&LIBUSB_ENDPOINT_DIR_MASK != uint8(LIBUSB_ENDPOINT_OUT) {
+		return nil, i.handle.codeError("open message pipe", LIBUSB_ERROR_INVALID_PARAM)
+	}
+	p := &MessagePipe{max: maxMessage}
+	var err error
+	if p.in, err = i.Endpoint(in); err != nil {
+		return nil, err
+	}
+	if p.out, err = i.Endpoint(out); err != nil {
+		return nil, err
+	}
+	if p.in.tt != LIBUSB_TRANSFER_TYPE_BULK {
+		return nil, i.handle.transferError("open message pipe", in, LIBUSB_ERROR_NOT_SUPPORTED, 0)
+	}
+	if p.out.tt != LIBUSB_TRANSFER_TYPE_BULK {
+		return nil, i.handle.transferError("open message pipe", out, LIBUSB_ERROR_NOT_SUPPORTED, 0)
+	}
+	p.out.SetAddZeroPacket(true)
+
+	/* room for the largest message, rounded up to whole packets so that the
+	 * device can never overflow a transfer */
+	packet := p.in.maxPacket
+	p.buf = make([]byte, (maxMessage+packet-1)/packet*packet)
+	return p, nil
+}
+
+// SetTimeout sets the timeout of each transfer made by ReadMessage and
+// WriteMessage. A timeout of 0, the default, waits forever.
+func (p *MessagePipe) SetTimeout(d time.Duration) {
+	p.in.SetTimeout(d)
+	p.out.SetTimeout(d)
+}
+
+// MaxMessageSize returns the largest message ReadMessage accepts.
+func (p *MessagePipe) MaxMessageSize() int {
+	return p.max
+}
+
+// ReadMessage reads one whole message. If the device sends a message longer
+// than the maximum message size, the rest of it is read and discarded and
+// an error wrapping ErrOverflow is returned, recording the full length of
+// the message as the bytes transferred. The returned slice is only valid
+// until the next call.
+func (p *MessagePipe) ReadMessage() ([]byte, error) {
+	packet := p.in.maxPacket
+	n, err := p.in.transfer(true, p.buf)
+	if err != nil {
+		return nil, err
+	}
+
+	if n == len(p.buf) {
+		/* every packet was full, so the message continues unless it is
+		 * terminated by a zero length packet */
+		more, err := p.in.transfer(true, p.buf[:packet])
+		if err != nil {
+			return nil, err
+		}
+		if more > 0 {
+			return nil, p.overflow(n+more, more == packet)
+		}
+	}
+
+	if n > p.max {
+		return nil, p.overflow(n, false)
+	}
+	return p.buf[:n], nil
+}
+
+// overflow discards the remainder of an overlong message, of which total
+// bytes have been read so far, and reports it.
+func (p *MessagePipe) overflow(total int, more bool) error {
+	packet := p.in.maxPacket
+	for more {
+		n, err := p.in.transfer(true, p.buf[:packet])
+		if err != nil {
+			return err
+		}
+		total += n
+		more = n == packet
+	}
+	return p.in.handle.transferError("bulk read message", p.in.address, LIBUSB_ERROR_OVERFLOW, total)
+}
+
+// WriteMessage sends msg as one message, terminated by a short or zero
+// length packet.
+func (p *MessagePipe) WriteMessage(msg []byte) error {
+	if len(msg) == 0 {
+		_, err := p.out.transfer(false, nil)
+		return err
+	}
+	_, err := p.out.Write(msg)
+	return err
+}
+
+// Close aborts any read or write in progress and closes both endpoints. It
+// does not release the interface.
+func (p *MessagePipe) Close() error {
+	p.in.Close()
+	return p.out.Close()
+}
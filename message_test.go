@@ -0,0 +1,117 @@
+package usb
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// packets splits msg into the 64 byte packets a device sends it in, ending
+// with a zero length packet if the last one is full.
+func packets(msg []byte) []string {
+	var p []string
+	for len(msg) >= 64 {
+		p = append(p, string(msg[:64]))
+		msg = msg[64:]
+	}
+	return append(p, string(msg))
+}
+
+func TestMessagePipeRead(t *testing.T) {
+	msg := func(n int) []byte {
+		b := make([]byte, n)
+		for i := range b {
+			b[i] = byte(i)
+		}
+		return b
+	}
+	tests := []struct {
+		name string
+		max  int
+		msg  []byte
+	}{
+		{"short packet", 256, msg(100)},
+		{"whole packets and ZLP", 256, msg(128)},
+		{"whole packets and ZLP filling the buffer", 128, msg(128)},
+		{"empty", 64, nil},
+	}
+	for _, tt := range tests {
+		i, _, send := openEndpoints(t)
+		p, err := i.MessagePipe(0x81, 0x01, tt.max)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p.SetTimeout(time.Second)
+		/* followed by another message, which must not run into it */
+		send(packets(tt.msg)...)
+		send("next")
+		got, err := p.ReadMessage()
+		if err != nil || !bytes.Equal(got, tt.msg) {
+			t.Errorf("%s: read %d bytes, %v, want %d", tt.name, len(got), err, len(tt.msg))
+		}
+		if got, err := p.ReadMessage(); err != nil || string(got) != "next" {
+			t.Errorf("%s: read %q, %v, want the next message", tt.name, got, err)
+		}
+	}
+}
+
+func TestMessagePipeOverflow(t *testing.T) {
+	tests := []struct {
+		name   string
+		length int
+	}{
+		{"within the buffer", 120},
+		{"past the buffer", 300},
+		{"past the buffer in whole packets", 256},
+	}
+	for _, tt := range tests {
+		i, _, send := openEndpoints(t)
+		p, err := i.MessagePipe(0x81, 0x01, 100)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p.SetTimeout(time.Second)
+		send(packets(make([]byte, tt.length))...)
+		send("next")
+
+		_, err = p.ReadMessage()
+		var uerr *Error
+		if !errors.Is(err, ErrOverflow) || !errors.As(err, &uerr) || uerr.Transferred != tt.length {
+			t.Errorf("%s: read returned %v, want ErrOverflow after %d bytes", tt.name, err, tt.length)
+		}
+		/* the rest of the overlong message is discarded */
+		if got, err := p.ReadMessage(); err != nil || string(got) != "next" {
+			t.Errorf("%s: read %q, %v, want the next message", tt.name, got, err)
+		}
+	}
+}
+
+func TestMessagePipeWrite(t *testing.T) {
+	i, writes, _ := openEndpoints(t)
+	p, err := i.MessagePipe(0x81, 0x01, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		length    int
+		transfers []int
+	}{
+		{100, []int{100}},
+		{128, []int{128, 0}},
+		{0, []int{0}},
+	}
+	for _, tt := range tests {
+		if err := p.WriteMessage(make([]byte, tt.length)); err != nil {
+			t.Errorf("%d byte message: %v", tt.length, err)
+		}
+		if got := writes(); !reflect.DeepEqual(got, tt.transfers) {
+			t.Errorf("%d byte message made transfers of %v bytes, want %v", tt.length, got, tt.transfers)
+		}
+	}
+
+	if _, err := i.MessagePipe(0x01, 0x81, 64); !errors.Is(err, ErrInvalidParam) {
+		t.Errorf("message pipe with swapped endpoints returned %v, want ErrInvalidParam", err)
+	}
+}
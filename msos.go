@@ -0,0 +1,665 @@
+package usb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strings"
+	"unicode/utf16"
+)
+
+// Microsoft OS descriptors tell Windows which driver to bind to a device
+// or function and which registry properties to set for it, without an INF
+// file. Version 1.0 devices advertise a vendor request code in string
+// descriptor 0xEE, and version 2.0 devices in a platform capability of
+// their BOS descriptor.
+
+// msosStringIndex is the index of the MS OS 1.0 string descriptor.
+const msosStringIndex = 0xee
+
+// msosSignature is the qwSignature of the MS OS 1.0 string descriptor.
+const msosSignature = "MSFT100"
+
+// wIndex values of the MS OS 1.0 feature descriptor requests.
+const (
+	msosExtendedCompatID   = 0x0004
+	msosExtendedProperties = 0x0005
+)
+
+const (
+	msosCompatIDHeaderSize   = 16
+	msosCompatIDFunctionSize = 24
+	msosPropertiesHeaderSize = 10
+	msosPropertyMinSize      = 14
+)
+
+// MSOS20PlatformUUID is the UUID of the platform capability that describes
+// a device's MS OS 2.0 descriptor sets.
+var MSOS20PlatformUUID = UUID{
+	0xdf, 0x60, 0xdd, 0xd8, 0x89, 0x45, 0xc7, 0x4c,
+	0x9c, 0xd2, 0x65, 0x9d, 0x9e, 0x64, 0x8a, 0x9f,
+}
+
+// msos20DescriptorIndex is the wIndex of the request that reads an MS OS
+// 2.0 descriptor set.
+const msos20DescriptorIndex = 0x0007
+
+// wDescriptorType values of MS OS 2.0 descriptors, see table 9 of the MS OS
+// 2.0 specification.
+const (
+	msos20SetHeader                 = 0x00
+	msos20SubsetHeaderConfiguration = 0x01
+	msos20SubsetHeaderFunction      = 0x02
+	msos20FeatureCompatibleID       = 0x03
+	msos20FeatureRegProperty        = 0x04
+	msos20FeatureMinResumeTime      = 0x05
+	msos20FeatureModelID            = 0x06
+	msos20FeatureCCGPDevice         = 0x07
+	msos20FeatureVendorRevision     = 0x08
+)
+
+const (
+	msos20HeaderSize       = 4
+	msos20SetHeaderSize    = 10
+	msos20SubsetHeaderSize = 8
+	msos20SetInfoSize      = 8
+)
+
+// RegistryType is the type of a registry property value.
+type RegistryType uint32
+
+const (
+	RegSZ                RegistryType = 1
+	RegExpandSZ          RegistryType = 2
+	RegBinary            RegistryType = 3
+	RegDWordLittleEndian RegistryType = 4
+	RegDWordBigEndian    RegistryType = 5
+	RegLink              RegistryType = 6
+	RegMultiSZ           RegistryType = 7
+)
+
+// RegistryProperty is a registry property a device asks Windows to set.
+type RegistryProperty struct {
+	DataType RegistryType `json:"dwPropertyDataType"`
+	Name     string       `json:"bPropertyName"`
+	Data     []byte       `json:"bPropertyData"`
+}
+
+// Value decodes the property data according to its type: a string for
+// RegSZ, RegExpandSZ and RegLink, a []string for RegMultiSZ and a uint32
+// for the DWORD types. Other types, and data that does not fit its type,
+// are returned as the raw bytes.
+func (p *RegistryProperty) Value() interface{} {
+	switch p.DataType {
+	case RegSZ, RegExpandSZ, RegLink:
+		if len(p.Data)%2 == 0 {
+			return utf16String(p.Data)
+		}
+	case RegMultiSZ:
+		if len(p.Data)%2 == 0 {
+			s := utf16String(p.Data)
+			if s == "" {
+				return []string{}
+			}
+			return strings.Split(s, "\x00")
+		}
+	case RegDWordLittleEndian:
+		if len(p.Data) == 4 {
+			return binary.LittleEndian.Uint32(p.Data)
+		}
+	case RegDWordBigEndian:
+		if len(p.Data) == 4 {
+			return binary.BigEndian.Uint32(p.Data)
+		}
+	}
+	return p.Data
+}
+
+// utf16String decodes a little endian UTF-16 string, dropping any
+// terminating NULs.
+func utf16String(b []byte) string {
+	u := make([]uint16, len(b)/2)
+	for i := range u {
+		u[i] = binary.LittleEndian.Uint16(b[2*i:])
+	}
+	for len(u) > 0 && u[len(u)-1] == 0 {
+		u = u[:len(u)-1]
+	}
+	return string(utf16.Decode(u))
+}
+
+// msosID decodes a NUL padded compatible or sub-compatible ID.
+func msosID(b []byte) string {
+	return string(bytes.TrimRight(b, "\x00"))
+}
+
+// MSOSStringDescriptor is the MS OS 1.0 string descriptor, read from string
+// index 0xEE.
+type MSOSStringDescriptor struct {
+	// VendorCode is the bRequest of the vendor requests that read the
+	// device's MS OS 1.0 feature descriptors.
+	VendorCode uint8 `json:"bMS_VendorCode"`
+
+	// Flags is reserved in the original specification. Bit 1 is set by
+	// devices that support the container ID descriptor.
+	Flags uint8 `json:"bPad"`
+}
+
+func (d *MSOSStringDescriptor) parse(data []byte) error {
+	const path = "msos10"
+	r := newDescReader(data)
+	h := r.header()
+	signature := r.next(2 * len(msosSignature))
+	d.VendorCode = r.u8()
+	d.Flags = r.u8()
+	switch {
+	case r.short:
+		return &DescriptorError{path, 0, fmt.Sprintf("%d bytes is too short for the descriptor", len(data))}
+	case h.DescriptorType != uint8(LIBUSB_DT_STRING):
+		return &DescriptorError{path, 1, fmt.Sprintf("bDescriptorType 0x%02x is not a string descriptor", h.DescriptorType)}
+	case int(h.Length) != len(data):
+		return &DescriptorError{path, 0, fmt.Sprintf("bLength %d does not match the %d bytes of data", h.Length, len(data))}
+	case utf16String(signature) != msosSignature:
+		return &DescriptorError{path, 2, fmt.Sprintf("qwSignature %q is not %q", utf16String(signature), msosSignature)}
+	}
+	return nil
+}
+
+// UnmarshalBinary decodes an MS OS 1.0 string descriptor.
+func (d *MSOSStringDescriptor) UnmarshalBinary(data []byte) error {
+	if err := d.parse(data); err != nil {
+		return descriptorError("parse MS OS string descriptor", err)
+	}
+	return nil
+}
+
+// MSOSStringDescriptor reads the MS OS 1.0 string descriptor. Devices
+// without one usually fail the request with ErrPipe.
+func (h *DeviceHandle) MSOSStringDescriptor() (*MSOSStringDescriptor, error) {
+	const op = "get MS OS string descriptor"
+	buf := make([]byte, 255)
+	r := libusb_get_string_descriptor(h.handle, msosStringIndex, 0, buf, len(buf))
+	if r < 0 {
+		return nil, h.codeError(op, r)
+	}
+	d := new(MSOSStringDescriptor)
+	if err := d.parse(buf[:r]); err != nil {
+		return nil, h.dev.descriptorError(op, err)
+	}
+	return d, nil
+}
+
+// msosFeature reads an MS OS 1.0 feature descriptor. Like Windows, it
+// first reads the descriptor's header to learn its dwLength, then the
+// whole descriptor. A dwLength shorter than the bytes read is reported
+// under path.
+func (h *DeviceHandle) msosFeature(op, path string, recipient Recipient, vendorCode uint8, value, index uint16, headerSize int) ([]byte, error) {
+	buf := make([]byte, headerSize)
+	n, err := h.vendorRequest(op, recipient, vendorCode, value, index, buf)
+	if err != nil {
+		return nil, err
+	}
+	if n < 4 {
+		return nil, h.dev.descriptorError(op, &DescriptorError{path, 0,
+			fmt.Sprintf("%d bytes is too short for the header", n)})
+	}
+	length := binary.LittleEndian.Uint32(buf)
+	switch {
+	case length < uint32(n):
+		return nil, h.dev.descriptorError(op, &DescriptorError{path, 0,
+			fmt.Sprintf("dwLength %d is shorter than the %d bytes read", length, n)})
+	case length > math.MaxUint16:
+		return nil, h.dev.descriptorError(op, &DescriptorError{path, 0,
+			fmt.Sprintf("dwLength %d is too long for a control transfer", length)})
+	case length == uint32(n):
+		return buf[:n], nil
+	}
+	buf = make([]byte, length)
+	n, err = h.vendorRequest(op, recipient, vendorCode, value, index, buf)
+	if err != nil {
+		return nil, err
+	}
+	return buf[:n], nil
+}
+
+// msosFeatureHeader checks the dwLength and wIndex at the start of an MS OS
+// 1.0 feature descriptor.
+func msosFeatureHeader(path string, data []byte, headerSize int, index uint16) error {
+	if len(data) < headerSize {
+		return &DescriptorError{path, 0, fmt.Sprintf("%d bytes is too short for the header", len(data))}
+	}
+	if n := binary.LittleEndian.Uint32(data); n != uint32(len(data)) {
+		return &DescriptorError{path, 0, fmt.Sprintf("dwLength %d does not match the %d bytes of data", n, len(data))}
+	}
+	if i := binary.LittleEndian.Uint16(data[6:]); i != index {
+		return &DescriptorError{path, 6, fmt.Sprintf("wIndex %d is not %d", i, index)}
+	}
+	return nil
+}
+
+// ExtendedCompatIDDescriptor is the MS OS 1.0 extended compat ID
+// descriptor, giving the compatible IDs of a device's functions.
+type ExtendedCompatIDDescriptor struct {
+	Version   uint16             `json:"bcdVersion"`
+	Functions []CompatIDFunction `json:"functions"`
+}
+
+// CompatIDFunction gives the compatible ID of the function starting at
+// FirstInterface.
+type CompatIDFunction struct {
+	FirstInterface  uint8  `json:"bFirstInterfaceNumber"`
+	CompatibleID    string `json:"compatibleID"`
+	SubCompatibleID string `json:"subCompatibleID"`
+}
+
+func (d *ExtendedCompatIDDescriptor) parse(data []byte) error {
+	const path = "compatid"
+	if err := msosFeatureHeader(path, data, msosCompatIDHeaderSize, msosExtendedCompatID); err != nil {
+		return err
+	}
+	d.Version = binary.LittleEndian.Uint16(data[4:])
+	count := int(data[8])
+	if n := len(data) - msosCompatIDHeaderSize; n != count*msosCompatIDFunctionSize {
+		return &DescriptorError{path, 8, fmt.Sprintf("bCount %d does not match the %d bytes of functions", count, n)}
+	}
+	d.Functions = make([]CompatIDFunction, count)
+	for i := range d.Functions {
+		f := data[msosCompatIDHeaderSize+i*msosCompatIDFunctionSize:]
+		d.Functions[i] = CompatIDFunction{
+			FirstInterface:  f[0],
+			CompatibleID:    msosID(f[2:10]),
+			SubCompatibleID: msosID(f[10:18]),
+		}
+	}
+	return nil
+}
+
+// UnmarshalBinary decodes an MS OS 1.0 extended compat ID descriptor.
+func (d *ExtendedCompatIDDescriptor) UnmarshalBinary(data []byte) error {
+	if err := d.parse(data); err != nil {
+		return descriptorError("parse extended compat ID descriptor", err)
+	}
+	return nil
+}
+
+// ExtendedCompatID reads the MS OS 1.0 extended compat ID descriptor, using
+// the vendor code from the device's MS OS string descriptor.
+func (h *DeviceHandle) ExtendedCompatID(vendorCode uint8) (*ExtendedCompatIDDescriptor, error) {
+	const op = "get extended compat ID descriptor"
+	buf, err := h.msosFeature(op, "compatid", RecipientDevice, vendorCode, 0, msosExtendedCompatID, msosCompatIDHeaderSize)
+	if err != nil {
+		return nil, err
+	}
+	d := new(ExtendedCompatIDDescriptor)
+	if err := d.parse(buf); err != nil {
+		return nil, h.dev.descriptorError(op, err)
+	}
+	return d, nil
+}
+
+// ExtendedPropertiesDescriptor is the MS OS 1.0 extended properties
+// descriptor, giving the registry properties of an interface.
+type ExtendedPropertiesDescriptor struct {
+	Version    uint16             `json:"bcdVersion"`
+	Properties []RegistryProperty `json:"properties"`
+}
+
+func (d *ExtendedPropertiesDescriptor) parse(data []byte) error {
+	const path = "properties"
+	if err := msosFeatureHeader(path, data, msosPropertiesHeaderSize, msosExtendedProperties); err != nil {
+		return err
+	}
+	d.Version = binary.LittleEndian.Uint16(data[4:])
+	count := int(binary.LittleEndian.Uint16(data[8:]))
+	d.Properties = nil
+	off := msosPropertiesHeaderSize
+	for i := 0; i < count; i++ {
+		path := fmt.Sprintf("%s/property[%d]", path, i)
+		if len(data)-off < msosPropertyMinSize {
+			return &DescriptorError{path, off, fmt.Sprintf("%d bytes is too short for the property", len(data)-off)}
+		}
+		size := binary.LittleEndian.Uint32(data[off:])
+		if size < msosPropertyMinSize || size > uint32(len(data)-off) {
+			return &DescriptorError{path, off, fmt.Sprintf("invalid dwSize %d", size)}
+		}
+		r := newDescReader(data[off : off+int(size)])
+		r.u32() /* dwSize */
+		p := RegistryProperty{DataType: RegistryType(r.u32())}
+		name := int(r.u16())
+		if name%2 != 0 {
+			return &DescriptorError{path, off + 8, fmt.Sprintf("wPropertyNameLength %d is odd", name)}
+		}
+		p.Name = utf16String(r.next(name))
+		p.Data = r.bytes(int(r.u32()))
+		if r.short || r.left() != 0 {
+			return &DescriptorError{path, off, fmt.Sprintf("dwSize %d does not match the lengths of the name and data", size)}
+		}
+		d.Properties = append(d.Properties, p)
+		off += int(size)
+	}
+	if off != len(data) {
+		return &DescriptorError{path, off, fmt.Sprintf("%d bytes of trailing data", len(data)-off)}
+	}
+	return nil
+}
+
+// UnmarshalBinary decodes an MS OS 1.0 extended properties descriptor.
+func (d *ExtendedPropertiesDescriptor) UnmarshalBinary(data []byte) error {
+	if err := d.parse(data); err != nil {
+		return descriptorError("parse extended properties descriptor", err)
+	}
+	return nil
+}
+
+// ExtendedProperties reads the MS OS 1.0 extended properties descriptor of
+// an interface, using the vendor code from the device's MS OS string
+// descriptor.
+func (h *DeviceHandle) ExtendedProperties(vendorCode uint8, iface int) (*ExtendedPropertiesDescriptor, error) {
+	const op = "get extended properties descriptor"
+	buf, err := h.msosFeature(op, "properties", RecipientInterface, vendorCode, uint16(iface)<<8,
+		msosExtendedProperties, msosPropertiesHeaderSize)
+	if err != nil {
+		return nil, err
+	}
+	d := new(ExtendedPropertiesDescriptor)
+	if err := d.parse(buf); err != nil {
+		return nil, h.dev.descriptorError(op, err)
+	}
+	return d, nil
+}
+
+// MSOS20DescriptorSetInfo describes one of the MS OS 2.0 descriptor sets
+// of a device, see table 4 of the MS OS 2.0 specification.
+type MSOS20DescriptorSetInfo struct {
+	// WindowsVersion is the minimum version of Windows the set applies
+	// to, such as 0x06030000 for Windows 8.1.
+	WindowsVersion uint32 `json:"dwWindowsVersion"`
+	TotalLength    uint16 `json:"wMSOSDescriptorSetTotalLength"`
+	VendorCode     uint8  `json:"bMS_VendorCode"`
+
+	// AltEnumCode is non-zero if the device returns alternate
+	// enumeration descriptors for this version of Windows.
+	AltEnumCode uint8 `json:"bAltEnumCode"`
+}
+
+// MSOS20DescriptorSets decodes the descriptor set information of an MS OS
+// 2.0 platform capability.
+func (p *PlatformCapability) MSOS20DescriptorSets() ([]MSOS20DescriptorSetInfo, error) {
+	const op = "decode MS OS 2.0 platform capability"
+	if p.UUID != MSOS20PlatformUUID {
+		return nil, codeError(op, LIBUSB_ERROR_INVALID_PARAM)
+	}
+	if len(p.Data) == 0 || len(p.Data)%msos20SetInfoSize != 0 {
+		return nil, descriptorError(op, &DescriptorError{"capability", LIBUSB_BT_PLATFORM_DESCRIPTOR_MIN_SIZE,
+			fmt.Sprintf("%d bytes of capability data is not a multiple of %d", len(p.Data), msos20SetInfoSize)})
+	}
+	sets := make([]MSOS20DescriptorSetInfo, 0, len(p.Data)/msos20SetInfoSize)
+	for r := newDescReader(p.Data); r.left() > 0; {
+		sets = append(sets, MSOS20DescriptorSetInfo{
+			WindowsVersion: r.u32(),
+			TotalLength:    r.u16(),
+			VendorCode:     r.u8(),
+			AltEnumCode:    r.u8(),
+		})
+	}
+	return sets, nil
+}
+
+// MSOS20Header is the header common to all MS OS 2.0 descriptors.
+type MSOS20Header struct {
+	Length         uint16 `json:"wLength"`
+	DescriptorType uint16 `json:"wDescriptorType"`
+}
+
+// Header returns the header itself, so that embedding it implements
+// MSOS20Descriptor.
+func (h MSOS20Header) Header() MSOS20Header {
+	return h
+}
+
+// An MSOS20Descriptor is a subset header or feature descriptor of an MS OS
+// 2.0 descriptor set.
+type MSOS20Descriptor interface {
+	Header() MSOS20Header
+}
+
+// MSOS20DescriptorSet is an MS OS 2.0 descriptor set. Its Descriptors are
+// the features that apply to the whole device, followed by configuration
+// subsets for composite devices.
+type MSOS20DescriptorSet struct {
+	MSOS20Header
+	WindowsVersion uint32             `json:"dwWindowsVersion"`
+	TotalLength    uint16             `json:"wTotalLength"`
+	Descriptors    []MSOS20Descriptor `json:"descriptors"`
+}
+
+// MSOS20ConfigurationSubset holds the descriptors that apply to a
+// configuration, features followed by function subsets.
+type MSOS20ConfigurationSubset struct {
+	MSOS20Header
+
+	// ConfigurationValue is named after the field in the specification,
+	// but holds the index of the configuration, not its
+	// bConfigurationValue.
+	ConfigurationValue uint8              `json:"bConfigurationValue"`
+	TotalLength        uint16             `json:"wTotalLength"`
+	Descriptors        []MSOS20Descriptor `json:"descriptors"`
+}
+
+// MSOS20FunctionSubset holds the features that apply to the function
+// starting at FirstInterface.
+type MSOS20FunctionSubset struct {
+	MSOS20Header
+	FirstInterface uint8              `json:"bFirstInterface"`
+	SubsetLength   uint16             `json:"wSubsetLength"`
+	Descriptors    []MSOS20Descriptor `json:"descriptors"`
+}
+
+// MSOS20CompatibleIDDescriptor gives the compatible ID of a device or
+// function, such as "WINUSB".
+type MSOS20CompatibleIDDescriptor struct {
+	MSOS20Header
+	CompatibleID    string `json:"CompatibleID"`
+	SubCompatibleID string `json:"SubCompatibleID"`
+}
+
+// MSOS20RegistryPropertyDescriptor gives a registry property of a device
+// or function, such as DeviceInterfaceGUIDs.
+type MSOS20RegistryPropertyDescriptor struct {
+	MSOS20Header
+	RegistryProperty
+}
+
+// MSOS20MinResumeTimeDescriptor gives the times the device needs to resume
+// from suspend, in milliseconds.
+type MSOS20MinResumeTimeDescriptor struct {
+	MSOS20Header
+	ResumeRecoveryTime  uint8 `json:"bResumeRecoveryTime"`
+	ResumeSignalingTime uint8 `json:"bResumeSignalingTime"`
+}
+
+// MSOS20ModelIDDescriptor uniquely identifies the physical device model.
+type MSOS20ModelIDDescriptor struct {
+	MSOS20Header
+	ModelID UUID `json:"ModelID"`
+}
+
+// MSOS20CCGPDeviceDescriptor asks Windows to treat the device as a
+// composite device even if it would not otherwise.
+type MSOS20CCGPDeviceDescriptor struct {
+	MSOS20Header
+}
+
+// MSOS20VendorRevisionDescriptor gives a revision of the descriptor set,
+// which Windows compares to decide whether to re-read it.
+type MSOS20VendorRevisionDescriptor struct {
+	MSOS20Header
+	VendorRevision uint16 `json:"VendorRevision"`
+}
+
+// MSOS20RawDescriptor is an MS OS 2.0 descriptor of an unknown type.
+type MSOS20RawDescriptor struct {
+	MSOS20Header
+	Data []byte `json:"data"`
+}
+
+func (s *MSOS20DescriptorSet) parse(data []byte) error {
+	const path = "msos20"
+	r := newDescReader(data)
+	s.MSOS20Header = MSOS20Header{r.u16(), r.u16()}
+	s.WindowsVersion = r.u32()
+	s.TotalLength = r.u16()
+	switch {
+	case r.short:
+		return &DescriptorError{path, 0, fmt.Sprintf("%d bytes is too short for the header", len(data))}
+	case s.DescriptorType != msos20SetHeader:
+		return &DescriptorError{path, 2, fmt.Sprintf("wDescriptorType %d is not a descriptor set header", s.DescriptorType)}
+	case s.Length != msos20SetHeaderSize:
+		return &DescriptorError{path, 0, fmt.Sprintf("invalid wLength %d", s.Length)}
+	case int(s.TotalLength) != len(data):
+		return &DescriptorError{path, 8, fmt.Sprintf("wTotalLength %d does not match the %d bytes of data", s.TotalLength, len(data))}
+	}
+	var err error
+	s.Descriptors, err = parseMSOS20Descriptors(data, msos20SetHeaderSize, len(data), path, msos20SubsetHeaderConfiguration)
+	return err
+}
+
+// parseMSOS20Descriptors decodes the descriptors in data[off:end]. subset
+// is the type of subset header allowed among them, or 0 if none is.
+func parseMSOS20Descriptors(data []byte, off, end int, path string, subset uint16) ([]MSOS20Descriptor, error) {
+	var descs []MSOS20Descriptor
+	for off < end {
+		path := fmt.Sprintf("%s/descriptors[%d]", path, len(descs))
+		if end-off < msos20HeaderSize {
+			return nil, &DescriptorError{path, off, fmt.Sprintf("%d bytes of trailing data", end-off)}
+		}
+		h := MSOS20Header{binary.LittleEndian.Uint16(data[off:]), binary.LittleEndian.Uint16(data[off+2:])}
+		if int(h.Length) < msos20HeaderSize || int(h.Length) > end-off {
+			return nil, &DescriptorError{path, off, fmt.Sprintf("invalid wLength %d", h.Length)}
+		}
+		var d MSOS20Descriptor
+		n := int(h.Length)
+		switch h.DescriptorType {
+		case msos20SubsetHeaderConfiguration, msos20SubsetHeaderFunction:
+			if h.DescriptorType != subset {
+				return nil, &DescriptorError{path, off, fmt.Sprintf("unexpected subset header of type %d", h.DescriptorType)}
+			}
+			if n != msos20SubsetHeaderSize {
+				return nil, &DescriptorError{path, off, fmt.Sprintf("invalid wLength %d", h.Length)}
+			}
+			value := data[off+4]
+			n = int(binary.LittleEndian.Uint16(data[off+6:]))
+			if n < msos20SubsetHeaderSize || n > end-off {
+				return nil, &DescriptorError{path, off + 6, fmt.Sprintf("invalid subset length %d", n)}
+			}
+			var inner uint16
+			if h.DescriptorType == msos20SubsetHeaderConfiguration {
+				inner = msos20SubsetHeaderFunction
+			}
+			children, err := parseMSOS20Descriptors(data, off+msos20SubsetHeaderSize, off+n, path, inner)
+			if err != nil {
+				return nil, err
+			}
+			if inner != 0 {
+				d = &MSOS20ConfigurationSubset{h, value, uint16(n), children}
+			} else {
+				d = &MSOS20FunctionSubset{h, value, uint16(n), children}
+			}
+		case msos20SetHeader:
+			return nil, &DescriptorError{path, off, "unexpected descriptor set header"}
+		default:
+			var err error
+			if d, err = decodeMSOS20Feature(h, data[off:off+n]); err != nil {
+				return nil, &DescriptorError{path, off, err.Error()}
+			}
+		}
+		descs = append(descs, d)
+		off += n
+	}
+	return descs, nil
+}
+
+// decodeMSOS20Feature decodes a feature descriptor. Bytes past the fields
+// of a known type are ignored.
+func decodeMSOS20Feature(h MSOS20Header, desc []byte) (MSOS20Descriptor, error) {
+	r := newDescReader(desc)
+	r.next(msos20HeaderSize)
+	var d MSOS20Descriptor
+	switch h.DescriptorType {
+	case msos20FeatureCompatibleID:
+		d = &MSOS20CompatibleIDDescriptor{h, msosID(r.next(8)), msosID(r.next(8))}
+	case msos20FeatureRegProperty:
+		p := RegistryProperty{DataType: RegistryType(r.u16())}
+		name := int(r.u16())
+		if name%2 != 0 {
+			return nil, fmt.Errorf("wPropertyNameLength %d is odd", name)
+		}
+		p.Name = utf16String(r.next(name))
+		p.Data = r.bytes(int(r.u16()))
+		d = &MSOS20RegistryPropertyDescriptor{h, p}
+	case msos20FeatureMinResumeTime:
+		d = &MSOS20MinResumeTimeDescriptor{h, r.u8(), r.u8()}
+	case msos20FeatureModelID:
+		d = &MSOS20ModelIDDescriptor{h, r.guid()}
+	case msos20FeatureCCGPDevice:
+		d = &MSOS20CCGPDeviceDescriptor{h}
+	case msos20FeatureVendorRevision:
+		d = &MSOS20VendorRevisionDescriptor{h, r.u16()}
+	default:
+		d = &MSOS20RawDescriptor{h, r.bytes(r.left())}
+	}
+	if r.short {
+		return nil, fmt.Errorf("wLength %d is too short for the fields of the descriptor", h.Length)
+	}
+	return d, nil
+}
+
+// UnmarshalBinary decodes an MS OS 2.0 descriptor set. data must hold
+// exactly the wTotalLength bytes of the set.
+func (s *MSOS20DescriptorSet) UnmarshalBinary(data []byte) error {
+	if err := s.parse(data); err != nil {
+		return descriptorError("parse MS OS 2.0 descriptor set", err)
+	}
+	return nil
+}
+
+// MSOS20DescriptorSet reads the MS OS 2.0 descriptor set described by info.
+func (h *DeviceHandle) MSOS20DescriptorSet(info MSOS20DescriptorSetInfo) (*MSOS20DescriptorSet, error) {
+	const op = "get MS OS 2.0 descriptor set"
+	buf := make([]byte, info.TotalLength)
+	n, err := h.vendorRequest(op, RecipientDevice, info.VendorCode, 0, msos20DescriptorIndex, buf)
+	if err != nil {
+		return nil, err
+	}
+	s := new(MSOS20DescriptorSet)
+	if err := s.parse(buf[:n]); err != nil {
+		return nil, h.dev.descriptorError(op, err)
+	}
+	return s, nil
+}
+
+// MSOS20DescriptorSets reads every MS OS 2.0 descriptor set listed in the
+// device's BOS descriptor. It returns nil if the BOS descriptor has no MS
+// OS 2.0 platform capability.
+func (h *DeviceHandle) MSOS20DescriptorSets() ([]*MSOS20DescriptorSet, error) {
+	bos, err := h.BOSDescriptor()
+	if err != nil {
+		return nil, err
+	}
+	p, err := bos.Platform(MSOS20PlatformUUID)
+	if p == nil || err != nil {
+		return nil, err
+	}
+	infos, err := p.MSOS20DescriptorSets()
+	if err != nil {
+		return nil, err
+	}
+	sets := make([]*MSOS20DescriptorSet, len(infos))
+	for i, info := range infos {
+		if sets[i], err = h.MSOS20DescriptorSet(info); err != nil {
+			return nil, err
+		}
+	}
+	return sets, nil
+}
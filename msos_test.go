@@ -0,0 +1,125 @@
+package usb
+
+import (
+	"encoding/binary"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// utf16Bytes encodes s as little endian UTF-16 with a terminating NUL.
+func utf16Bytes(s string) []byte {
+	var b []byte
+	for _, r := range s + "\x00" {
+		b = append(b, byte(r), byte(r>>8))
+	}
+	return b
+}
+
+func TestMSOS20DescriptorSet(t *testing.T) {
+	var set MSOS20DescriptorSet
+	if err := set.UnmarshalBinary(fuzzMSOS20); err != nil {
+		t.Fatal(err)
+	}
+	want := MSOS20DescriptorSet{
+		MSOS20Header:   MSOS20Header{10, msos20SetHeader},
+		WindowsVersion: 0x06030000,
+		TotalLength:    80,
+		Descriptors: []MSOS20Descriptor{
+			&MSOS20VendorRevisionDescriptor{MSOS20Header{6, msos20FeatureVendorRevision}, 1},
+			&MSOS20ConfigurationSubset{MSOS20Header{8, msos20SubsetHeaderConfiguration}, 0, 64, []MSOS20Descriptor{
+				&MSOS20FunctionSubset{MSOS20Header{8, msos20SubsetHeaderFunction}, 1, 56, []MSOS20Descriptor{
+					&MSOS20CompatibleIDDescriptor{MSOS20Header{20, msos20FeatureCompatibleID}, "WINUSB", ""},
+					&MSOS20RegistryPropertyDescriptor{MSOS20Header{28, msos20FeatureRegProperty},
+						RegistryProperty{RegSZ, "Label", utf16Bytes("go")}},
+				}},
+			}},
+		},
+	}
+	if !reflect.DeepEqual(set, want) {
+		t.Errorf("decoded %+v\nwant %+v", set, want)
+	}
+
+	/* a function subset is only allowed within a configuration subset */
+	bad := append([]byte(nil), fuzzMSOS20[:16]...)
+	bad = append(bad, fuzzMSOS20[24:]...)
+	binary.LittleEndian.PutUint16(bad[8:], uint16(len(bad)))
+	var derr *DescriptorError
+	if err := set.UnmarshalBinary(bad); !errors.As(err, &derr) || derr.Offset != 16 {
+		t.Errorf("function subset outside a configuration returned %v, want a *DescriptorError at offset 16", err)
+	}
+}
+
+func TestExtendedCompatID(t *testing.T) {
+	var d ExtendedCompatIDDescriptor
+	if err := d.UnmarshalBinary(fuzzCompatID); err != nil {
+		t.Fatal(err)
+	}
+	want := ExtendedCompatIDDescriptor{0x0100, []CompatIDFunction{{0, "WINUSB", ""}}}
+	if !reflect.DeepEqual(d, want) {
+		t.Errorf("decoded %+v, want %+v", d, want)
+	}
+}
+
+func TestExtendedProperties(t *testing.T) {
+	var d ExtendedPropertiesDescriptor
+	if err := d.UnmarshalBinary(fuzzProperties); err != nil {
+		t.Fatal(err)
+	}
+	want := ExtendedPropertiesDescriptor{0x0100, []RegistryProperty{{RegSZ, "Label", utf16Bytes("go")}}}
+	if !reflect.DeepEqual(d, want) {
+		t.Errorf("decoded %+v, want %+v", d, want)
+	}
+}
+
+func TestRegistryPropertyValue(t *testing.T) {
+	tests := []struct {
+		prop RegistryProperty
+		want interface{}
+	}{
+		{RegistryProperty{RegSZ, "", utf16Bytes("go")}, "go"},
+		{RegistryProperty{RegExpandSZ, "", utf16Bytes("%TEMP%")}, "%TEMP%"},
+		{RegistryProperty{RegMultiSZ, "", append(utf16Bytes("{a}"), utf16Bytes("{b}\x00")...)}, []string{"{a}", "{b}"}},
+		{RegistryProperty{RegMultiSZ, "", utf16Bytes("")}, []string{}},
+		{RegistryProperty{RegDWordLittleEndian, "", []byte{0x01, 0x02, 0x00, 0x00}}, uint32(0x0201)},
+		{RegistryProperty{RegDWordBigEndian, "", []byte{0x00, 0x00, 0x02, 0x01}}, uint32(0x0201)},
+		{RegistryProperty{RegDWordLittleEndian, "", []byte{0x01}}, []byte{0x01}},
+		{RegistryProperty{RegBinary, "", []byte{0xde, 0xad}}, []byte{0xde, 0xad}},
+	}
+	for i, tt := range tests {
+		if got := tt.prop.Value(); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%d: %v property value %#v, want %#v", i, tt.prop.DataType, got, tt.want)
+		}
+	}
+}
+
+func TestMSOSFeature(t *testing.T) {
+	const vendorCode = 0x20
+	var compatID []byte
+	d := newLoopbackDevice()
+	d.Control = func(req *SimRequest) (int, error) {
+		if req.RequestType != 0xc0 || req.Request != vendorCode || req.Index != msosExtendedCompatID {
+			return 0, ErrPipe
+		}
+		return copy(req.Data, compatID), nil
+	}
+	h, _ := openLoopback(t, d)
+
+	/* read in two requests, as the descriptor is longer than its header */
+	compatID = fuzzCompatID
+	got, err := h.ExtendedCompatID(vendorCode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Functions) != 1 || got.Functions[0].CompatibleID != "WINUSB" {
+		t.Errorf("read %+v", got)
+	}
+
+	/* a dwLength shorter than the header */
+	compatID = append([]byte(nil), fuzzCompatID...)
+	binary.LittleEndian.PutUint32(compatID, 8)
+	var derr *DescriptorError
+	if _, err := h.ExtendedCompatID(vendorCode); !errors.As(err, &derr) || derr.Path != "compatid" {
+		t.Errorf("short dwLength returned %v, want a *DescriptorError", err)
+	}
+}
@@ -0,0 +1,3 @@
+module github.com/200sc/go-usb/os
+
+go 1.21
@@ -0,0 +1,476 @@
+package usb
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+)
+
+// A recorded session is a stream of JSON objects, one per line, each
+// describing one backend call or asynchronous event in the order they
+// happened.
+type sessionEvent struct {
+	/* time since recording started */
+	Time time.Duration `json:"t"`
+
+	Op      string `json:"op"`
+	Session uint64 `json:"session,omitempty"`
+	Result  int    `json:"result"`
+
+	/* the interface, configuration, endpoint or stream count the call was
+	 * made with, and a second argument such as an alternate setting */
+	Arg  int `json:"arg,omitempty"`
+	Arg2 int `json:"arg2,omitempty"`
+
+	/* "device" events */
+	Device *sessionDevice `json:"device,omitempty"`
+
+	/* transfers; Data holds OUT payloads (including the setup packet of
+	 * control transfers) on "submit" and IN payloads on "complete" */
+	Transfer    uint64          `json:"transfer,omitempty"`
+	Type        uint8           `json:"type,omitempty"`
+	Endpoint    uint8           `json:"endpoint,omitempty"`
+	Flags       uint8           `json:"flags,omitempty"`
+	Length      int             `json:"length,omitempty"`
+	Status      int             `json:"status,omitempty"`
+	Transferred int             `json:"transferred,omitempty"`
+	Iso         []sessionPacket `json:"iso,omitempty"`
+	Data        []byte          `json:"data,omitempty"`
+}
+
+type sessionDevice struct {
+	Parent     uint64   `json:"parent,omitempty"`
+	Bus        uint8    `json:"bus"`
+	Port       uint8    `json:"port"`
+	Address    uint8    `json:"address"`
+	Speed      uint8    `json:"speed"`
+	HostEndian int      `json:"host_endian,omitempty"`
+	Device     []byte   `json:"device"`
+	Configs    [][]byte `json:"configs,omitempty"`
+
+	/* the host_endian output of Get_config_descriptor */
+	ConfigHostEndian int `json:"config_host_endian,omitempty"`
+}
+
+type sessionPacket struct {
+	Length       uint `json:"length"`
+	ActualLength uint `json:"actual_length"`
+	Status       int  `json:"status"`
+}
+
+// A Recorder is a Backend that passes every call through to another
+// backend and writes the session to a stream, from which a Replayer can
+// serve it back without the hardware. The devices present, each call with
+// its result, and transfer payloads and completions are recorded. Each event
+// is stamped with the time since recording started, for inspection only: a
+// Replayer does not reproduce it.
+type Recorder struct {
+	b     Backend
+	start time.Time
+
+	mu     sync.Mutex
+	enc    *json.Encoder
+	err    error
+	known  map[uint64]bool
+	nextID uint64
+
+	/* ids of the transfers in flight, to record with their cancellation */
+	ids map[*usbi_transfer]uint64
+}
+
+// NewRecorder returns a Recorder that wraps b and writes to w.
+func NewRecorder(b Backend, w io.Writer) *Recorder {
+	return &Recorder{
+		b:     b,
+		start: time.Now(),
+		enc:   json.NewEncoder(w),
+		known: make(map[uint64]bool),
+		ids:   make(map[*usbi_transfer]uint64),
+	}
+}
+
+// Backend returns the Backend to install with SetBackend.
+func (r *Recorder) Backend() Backend {
+	return (*recordBackend)(r)
+}
+
+// Err returns the first error encountered writing the session, if any.
+func (r *Recorder) Err() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.err
+}
+
+/* call with r.mu held */
+func (r *Recorder) write(ev *sessionEvent) {
+	if r.err != nil {
+		return
+	}
+	ev.Time = time.Since(r.start)
+	r.err = r.enc.Encode(ev)
+}
+
+func (r *Recorder) log(ev *sessionEvent) {
+	r.mu.Lock()
+	r.write(ev)
+	r.mu.Unlock()
+}
+
+// syncDevices records devices that arrived or left since the last call.
+// Backends connect and disconnect devices behind the library's back, so
+// this is done whenever the library looks for devices, and before a device
+// that has not been recorded yet is opened.
+func (r *Recorder) syncDevices() {
+	var arrived []*libusb_device
+	present := make(map[uint64]bool)
+
+	active_contexts_lock.Lock()
+	for e := active_contexts_list.next; e != active_contexts_list; e = e.next {
+		ctx := list_entry(e).(*libusb_context)
+		ctx.usb_devs_lock.Lock()
+		for d := ctx.usb_devs.next; d != ctx.usb_devs; d = d.next {
+			dev := list_entry(d).(*libusb_device)
+			if !present[dev.session_data] {
+				present[dev.session_data] = true
+				arrived = append(arrived, libusb_ref_device(dev))
+			}
+		}
+		ctx.usb_devs_lock.Unlock()
+	}
+	active_contexts_lock.Unlock()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, dev := range arrived {
+		if !r.known[dev.session_data] {
+			r.known[dev.session_data] = true
+			r.write(&sessionEvent{Op: "device", Session: dev.session_data, Device: r.describe(dev)})
+		}
+		libusb_unref_device(dev)
+	}
+	for session := range r.known {
+		if !present[session] {
+			delete(r.known, session)
+			r.write(&sessionEvent{Op: "left", Session: session})
+		}
+	}
+}
+
+// describe reads a device's descriptors from the wrapped backend.
+func (r *Recorder) describe(dev *libusb_device) *sessionDevice {
+	d := &sessionDevice{
+		Bus:     dev.bus_number,
+		Port:    dev.port_number,
+		Address: dev.device_address,
+		Speed:   uint8(dev.speed),
+		Device:  make([]byte, LIBUSB_DT_DEVICE_SIZE),
+	}
+	if dev.parent_dev != nil {
+		d.Parent = dev.parent_dev.session_data
+	}
+	r.b.Get_device_descriptor(dev, d.Device, &d.HostEndian)
+
+	for i := 0; i < int(dev.num_configurations); i++ {
+		var host_endian int
+		header := make([]byte, LIBUSB_DT_CONFIG_SIZE)
+		if n := r.b.Get_config_descriptor(dev, uint8(i), header, len(header), &host_endian); n < LIBUSB_DT_CONFIG_SIZE {
+			break
+		}
+		buf := make([]byte, binary.LittleEndian.Uint16(header[2:]))
+		n := r.b.Get_config_descriptor(dev, uint8(i), buf, len(buf), &host_endian)
+		if n < 0 {
+			break
+		}
+		d.Configs = append(d.Configs, buf[:n])
+		d.ConfigHostEndian = host_endian
+	}
+	return d
+}
+
+func (r *Recorder) call(op string, handle *libusb_device_handle, arg, arg2 int, result libusb_error) libusb_error {
+	ev := &sessionEvent{Op: op, Arg: arg, Arg2: arg2, Result: int(result)}
+	if handle != nil {
+		ev.Session = handle.dev.session_data
+	}
+	r.log(ev)
+	return result
+}
+
+type recordBackend Recorder
+
+func (b *recordBackend) rec() *Recorder {
+	return (*Recorder)(b)
+}
+
+func (b *recordBackend) Name() string {
+	return b.b.Name()
+}
+
+func (b *recordBackend) Caps() uint32 {
+	return b.b.Caps()
+}
+
+func (b *recordBackend) Init(ctx *libusb_context) libusb_error {
+	r := b.b.Init(ctx)
+	b.rec().call("init", nil, 0, 0, r)
+	b.rec().syncDevices()
+	return r
+}
+
+func (b *recordBackend) Exit() {
+	b.b.Exit()
+	b.rec().call("exit", nil, 0, 0, 0)
+}
+
+func (b *recordBackend) Get_device_list(ctx *libusb_context, discdevs *[]*libusb_device) libusb_error {
+	r := b.b.Get_device_list(ctx, discdevs)
+	ev := &sessionEvent{Op: "get_device_list", Result: int(r)}
+	if r >= 0 {
+		b.mu.Lock()
+		for _, dev := range *discdevs {
+			if dev != nil && !b.known[dev.session_data] {
+				b.known[dev.session_data] = true
+				b.rec().write(&sessionEvent{Op: "device", Session: dev.session_data, Device: b.rec().describe(dev)})
+			}
+		}
+		b.mu.Unlock()
+	}
+	b.rec().log(ev)
+	return r
+}
+
+func (b *recordBackend) Hotplug_poll() {
+	b.b.Hotplug_poll()
+	b.rec().syncDevices()
+}
+
+func (b *recordBackend) Open(handle *libusb_device_handle) libusb_error {
+	b.mu.Lock()
+	known := b.known[handle.dev.session_data]
+	b.mu.Unlock()
+	if !known {
+		b.rec().syncDevices()
+	}
+	return b.rec().call("open", handle, 0, 0, b.b.Open(handle))
+}
+
+func (b *recordBackend) Close(handle *libusb_device_handle) {
+	b.b.Close(handle)
+	b.rec().call("close", handle, 0, 0, 0)
+}
+
+func (b *recordBackend) Get_device_descriptor(dev *libusb_device, buffer []uint8, host_endian *int) libusb_error {
+	return b.b.Get_device_descriptor(dev, buffer, host_endian)
+}
+
+func (b *recordBackend) Get_active_config_descriptor(dev *libusb_device, buffer []uint8, host_endian *int) libusb_error {
+	r := b.b.Get_active_config_descriptor(dev, buffer, host_endian)
+	ev := &sessionEvent{Op: "get_active_config_descriptor", Session: dev.session_data, Result: int(r), Arg: *host_endian}
+	if r > 0 {
+		ev.Data = append([]byte(nil), buffer[:r]...)
+	}
+	b.rec().log(ev)
+	return r
+}
+
+func (b *recordBackend) Get_config_descriptor(dev *libusb_device, config_index uint8, buffer []uint8, length int, host_endian *int) libusb_error {
+	return b.b.Get_config_descriptor(dev, config_index, buffer, length, host_endian)
+}
+
+func (b *recordBackend) Get_config_descriptor_by_value(dev *libusb_device, value uint8, buffer *[]uint8, host_endian *int) libusb_error {
+	return b.b.Get_config_descriptor_by_value(dev, value, buffer, host_endian)
+}
+
+func (b *recordBackend) Get_configuration(handle *libusb_device_handle, config *int) libusb_error {
+	r := b.b.Get_configuration(handle, config)
+	return b.rec().call("get_configuration", handle, *config, 0, r)
+}
+
+func (b *recordBackend) Set_configuration(handle *libusb_device_handle, config *int) libusb_error {
+	arg := *config
+	return b.rec().call("set_configuration", handle, arg, 0, b.b.Set_configuration(handle, config))
+}
+
+func (b *recordBackend) Claim_interface(handle *libusb_device_handle, iface uint) libusb_error {
+	return b.rec().call("claim_interface", handle, int(iface), 0, b.b.Claim_interface(handle, iface))
+}
+
+func (b *recordBackend) Release_interface(handle *libusb_device_handle, iface uint) libusb_error {
+	return b.rec().call("release_interface", handle, int(iface), 0, b.b.Release_interface(handle, iface))
+}
+
+func (b *recordBackend) Set_interface_altsetting(handle *libusb_device_handle, iface uint, altsetting int) libusb_error {
+	return b.rec().call("set_interface_altsetting", handle, int(iface), altsetting,
+		b.b.Set_interface_altsetting(handle, iface, altsetting))
+}
+
+func (b *recordBackend) Clear_halt(handle *libusb_device_handle, endpoint uint8) libusb_error {
+	return b.rec().call("clear_halt", handle, int(endpoint), 0, b.b.Clear_halt(handle, endpoint))
+}
+
+func (b *recordBackend) Reset_device(handle *libusb_device_handle) libusb_error {
+	return b.rec().call("reset_device", handle, 0, 0, b.b.Reset_device(handle))
+}
+
+func (b *recordBackend) Alloc_streams(handle *libusb_device_handle, num_streams uint32, endpoints []uint8, num_endpoints int) libusb_error {
+	return b.rec().call("alloc_streams", handle, int(num_streams), num_endpoints,
+		b.b.Alloc_streams(handle, num_streams, endpoints, num_endpoints))
+}
+
+func (b *recordBackend) Free_streams(handle *libusb_device_handle, endpoints []uint8, num_endpoints int) libusb_error {
+	return b.rec().call("free_streams", handle, 0, num_endpoints,
+		b.b.Free_streams(handle, endpoints, num_endpoints))
+}
+
+func (b *recordBackend) Dev_mem_alloc(handle *libusb_device_handle, length int) []uint8 {
+	return b.b.Dev_mem_alloc(handle, length)
+}
+
+func (b *recordBackend) Dev_mem_free(handle *libusb_device_handle, buffer []uint8, length int) libusb_error {
+	return b.b.Dev_mem_free(handle, buffer, length)
+}
+
+func (b *recordBackend) Kernel_driver_active(handle *libusb_device_handle, iface int) libusb_error {
+	return b.rec().call("kernel_driver_active", handle, iface, 0, b.b.Kernel_driver_active(handle, iface))
+}
+
+func (b *recordBackend) Detach_kernel_driver(handle *libusb_device_handle, iface int) libusb_error {
+	return b.rec().call("detach_kernel_driver", handle, iface, 0, b.b.Detach_kernel_driver(handle, iface))
+}
+
+func (b *recordBackend) Attach_kernel_driver(handle *libusb_device_handle, iface int) libusb_error {
+	return b.rec().call("attach_kernel_driver", handle, iface, 0, b.b.Attach_kernel_driver(handle, iface))
+}
+
+func (b *recordBackend) Destroy_device(dev *libusb_device) {
+	b.b.Destroy_device(dev)
+}
+
+func (b *recordBackend) Submit_transfer(itransfer *usbi_transfer) libusb_error {
+	transfer := itransfer.libusbTransfer
+
+	b.mu.Lock()
+	b.nextID++
+	id := b.nextID
+	b.mu.Unlock()
+
+	ev := &sessionEvent{
+		Op:       "submit",
+		Session:  transfer.dev_handle.dev.session_data,
+		Transfer: id,
+		Type:     transfer._type,
+		Endpoint: transfer.endpoint,
+		Flags:    transfer.flags,
+		Length:   transfer.length,
+	}
+	if transfer._type == uint8(LIBUSB_TRANSFER_TYPE_CONTROL) {
+		n := LIBUSB_CONTROL_SETUP_SIZE
+		if transfer.buffer[0]&LIBUSB_ENDPOINT_DIR_MASK == 0 {
+			n = transfer.length
+		}
+		ev.Data = append([]byte(nil), transfer.buffer[:n]...)
+	} else if transfer.endpoint&LIBUSB_ENDPOINT_DIR_MASK == 0 {
+		ev.Data = append([]byte(nil), transfer.buffer[:transfer.length]...)
+	}
+	for i := 0; i < transfer.num_iso_packets; i++ {
+		ev.Iso = append(ev.Iso, sessionPacket{Length: transfer.iso_packet_desc[i].length})
+	}
+
+	/* intercept the completion on its way to the user's callback. The
+	 * callback is restored first so that it may resubmit the transfer. */
+	callback := transfer.callback
+	transfer.callback = func(transfer *libusb_transfer) {
+		transfer.callback = callback
+		b.rec().completed(id, itransfer)
+		if callback != nil {
+			callback(transfer)
+		}
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	r := b.b.Submit_transfer(itransfer)
+	if r < 0 {
+		transfer.callback = callback
+	} else {
+		b.ids[itransfer] = id
+	}
+	ev.Result = int(r)
+	b.rec().write(ev)
+	return r
+}
+
+func (r *Recorder) completed(id uint64, itransfer *usbi_transfer) {
+	transfer := itransfer.libusbTransfer
+	ev := &sessionEvent{
+		Op:          "complete",
+		Session:     transfer.dev_handle.dev.session_data,
+		Transfer:    id,
+		Status:      int(transfer.status),
+		Transferred: transfer.actual_length,
+	}
+	/* IN payloads; replay copies them back to the same offset */
+	switch {
+	case transfer._type == uint8(LIBUSB_TRANSFER_TYPE_CONTROL):
+		if transfer.buffer[0]&LIBUSB_ENDPOINT_DIR_MASK != 0 {
+			ev.Data = append([]byte(nil), libusb_control_transfer_get_data(transfer)[:transfer.actual_length]...)
+		}
+	case transfer.endpoint&LIBUSB_ENDPOINT_DIR_MASK == 0:
+	case transfer._type == uint8(LIBUSB_TRANSFER_TYPE_ISOCHRONOUS):
+		ev.Data = append([]byte(nil), transfer.buffer[:transfer.length]...)
+	default:
+		ev.Data = append([]byte(nil), transfer.buffer[:transfer.actual_length]...)
+	}
+	for i := 0; i < transfer.num_iso_packets; i++ {
+		desc := &transfer.iso_packet_desc[i]
+		ev.Iso = append(ev.Iso, sessionPacket{
+			Length:       desc.length,
+			ActualLength: desc.actual_length,
+			Status:       int(desc.status),
+		})
+	}
+
+	r.mu.Lock()
+	delete(r.ids, itransfer)
+	r.write(ev)
+	r.mu.Unlock()
+}
+
+// Cancel_transfer holds the lock across the cancellation, like
+// Submit_transfer does across the submission, so that the completion it
+// causes is recorded after it.
+func (b *recordBackend) Cancel_transfer(itransfer *usbi_transfer) libusb_error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	r := b.b.Cancel_transfer(itransfer)
+	b.rec().write(&sessionEvent{
+		Op:       "cancel",
+		Session:  itransfer.libusbTransfer.dev_handle.dev.session_data,
+		Transfer: b.ids[itransfer],
+		Endpoint: itransfer.libusbTransfer.endpoint,
+		Result:   int(r),
+	})
+	return r
+}
+
+func (b *recordBackend) Clear_transfer_priv(itransfer *usbi_transfer) {
+	b.b.Clear_transfer_priv(itransfer)
+}
+
+func (b *recordBackend) Handle_transfer_completion(itransfer *usbi_transfer) libusb_error {
+	return b.b.Handle_transfer_completion(itransfer)
+}
+
+func (b *recordBackend) Device_priv_size() int {
+	return b.b.Device_priv_size()
+}
+
+func (b *recordBackend) Device_handle_priv_size() int {
+	return b.b.Device_handle_priv_size()
+}
+
+func (b *recordBackend) Transfer_priv_size() int {
+	return b.b.Transfer_priv_size()
+}
@@ -0,0 +1,106 @@
+package usb
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+// recordedSession drives a loopback device through b: a control request, a
+// bulk round trip and a cancelled interrupt transfer. It returns what was
+// read back.
+func recordedSession(t *testing.T, b Backend) (control, bulk []byte, status TransferStatus) {
+	t.Helper()
+	prev := SetBackend(b)
+	defer SetBackend(prev)
+
+	c, err := NewContext()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	h, err := c.OpenDeviceWithVIDPID(0x1234, 0x5678)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+	if err := h.ClaimInterface(0); err != nil {
+		t.Fatal(err)
+	}
+
+	control = make([]byte, 2)
+	n, err := h.Control(0xc0, 0x01, 0xbeef, 0, control, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	control = control[:n]
+
+	if _, err := h.BulkTransfer(0x01, []byte("recorded"), time.Second); err != nil {
+		t.Fatal(err)
+	}
+	bulk = make([]byte, 64)
+	n, err = h.BulkTransfer(0x81, bulk, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bulk = bulk[:n]
+
+	done := make(chan *Transfer, 1)
+	xfer := h.NewTransfer(TransferTypeInterrupt, 0x82, make([]byte, 8), 0, 0,
+		func(t *Transfer) { done <- t })
+	if err := xfer.Submit(); err != nil {
+		t.Fatal(err)
+	}
+	if err := xfer.Cancel(); err != nil {
+		t.Fatal(err)
+	}
+	<-done
+	status = xfer.Status()
+	if err := xfer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return control, bulk, status
+}
+
+func TestRecordReplay(t *testing.T) {
+	d := newLoopbackDevice()
+	d.Endpoints[0x82] = func(req *SimRequest) (int, error) {
+		<-req.Done
+		return 0, nil
+	}
+	sim := NewSimulator()
+	if err := sim.Plug(d); err != nil {
+		t.Fatal(err)
+	}
+
+	var session bytes.Buffer
+	rec := NewRecorder(sim.Backend(), &session)
+	control, bulk, status := recordedSession(t, rec.Backend())
+	if err := rec.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if status != TransferCancelled {
+		t.Fatalf("recorded interrupt transfer status %v, want %v", status, TransferCancelled)
+	}
+
+	p, err := NewReplayer(&session)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gotControl, gotBulk, gotStatus := recordedSession(t, p.Backend())
+	if err := p.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if !p.Done() {
+		t.Error("replay finished before the end of the recording")
+	}
+	if !bytes.Equal(gotControl, control) {
+		t.Errorf("replayed control read % x, recorded % x", gotControl, control)
+	}
+	if !bytes.Equal(gotBulk, bulk) {
+		t.Errorf("replayed bulk read %q, recorded %q", gotBulk, bulk)
+	}
+	if gotStatus != status {
+		t.Errorf("replayed interrupt transfer status %v, recorded %v", gotStatus, status)
+	}
+}
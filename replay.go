@@ -0,0 +1,502 @@
+package usb
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// A Replayer is a Backend that serves a session written by a Recorder,
+// reproducing its devices, call results and transfer completions without
+// the hardware. Events are replayed strictly in their recorded order, as
+// soon as the calls before them have been made, so replay is deterministic
+// and runs as fast as the code driving it: the recorded times are ignored,
+// and a transfer that took a second to complete when recorded completes
+// straight away. A call that differs from the recording fails with
+// ErrOther, and Err reports the first such divergence.
+type Replayer struct {
+	mu     sync.Mutex
+	events []*sessionEvent
+	next   int
+	err    error
+
+	/* devices currently plugged in, in order of arrival */
+	devices []uint64
+	present map[uint64]*sessionDevice
+
+	/* submitted transfers awaiting their recorded completion */
+	flying map[uint64]*usbi_transfer
+}
+
+// NewReplayer reads a recorded session from r.
+func NewReplayer(r io.Reader) (*Replayer, error) {
+	p := &Replayer{
+		present: make(map[uint64]*sessionDevice),
+		flying:  make(map[uint64]*usbi_transfer),
+	}
+	dec := json.NewDecoder(r)
+	for {
+		ev := new(sessionEvent)
+		err := dec.Decode(ev)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("usb: reading recorded session: %v", err)
+		}
+		p.events = append(p.events, ev)
+	}
+	return p, nil
+}
+
+// Backend returns the Backend to install with SetBackend.
+func (p *Replayer) Backend() Backend {
+	return (*replayBackend)(p)
+}
+
+// Err returns the first divergence from the recording, if any.
+func (p *Replayer) Err() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.err
+}
+
+// Done reports whether every recorded event has been replayed.
+func (p *Replayer) Done() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.next == len(p.events)
+}
+
+/* call with p.mu held */
+func (p *Replayer) diverge(op string, session uint64) libusb_error {
+	if p.err == nil {
+		if p.next < len(p.events) {
+			ev := p.events[p.next]
+			p.err = fmt.Errorf("usb: replay diverged at event %d: got %s on session %d, recorded %s on session %d",
+				p.next, op, session, ev.Op, ev.Session)
+		} else {
+			p.err = fmt.Errorf("usb: replay diverged: got %s on session %d after the end of the recording",
+				op, session)
+		}
+	}
+	return LIBUSB_ERROR_OTHER
+}
+
+// expect consumes the next recorded call, which must be op on session,
+// after firing any asynchronous events that precede it. It returns nil if
+// the recording does not match. Call with p.mu held.
+func (p *Replayer) expect(op string, session uint64) *sessionEvent {
+	p.advance()
+	if p.err != nil || p.next >= len(p.events) {
+		p.diverge(op, session)
+		return nil
+	}
+	ev := p.events[p.next]
+	if ev.Op != op || ev.Session != session {
+		p.diverge(op, session)
+		return nil
+	}
+	p.next++
+	return ev
+}
+
+// call replays a call whose arguments are all in arg and arg2.
+func (p *Replayer) call(op string, handle *libusb_device_handle, arg, arg2 int) libusb_error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var session uint64
+	if handle != nil {
+		session = handle.dev.session_data
+	}
+	ev := p.expect(op, session)
+	if ev == nil {
+		return LIBUSB_ERROR_OTHER
+	}
+	if ev.Arg != arg || ev.Arg2 != arg2 {
+		p.next--
+		return p.diverge(fmt.Sprintf("%s(%d, %d)", op, arg, arg2), session)
+	}
+	return libusb_error(ev.Result)
+}
+
+// advance fires the device arrivals, departures and transfer completions
+// that come next in the recording. Call with p.mu held.
+func (p *Replayer) advance() {
+	for p.err == nil && p.next < len(p.events) {
+		ev := p.events[p.next]
+		switch ev.Op {
+		case "device":
+			p.arrive(ev)
+		case "left":
+			p.leave(ev)
+		case "complete":
+			if !p.complete(ev) {
+				return
+			}
+		default:
+			return
+		}
+		p.next++
+	}
+}
+
+func (p *Replayer) arrive(ev *sessionEvent) {
+	if ev.Device == nil || len(ev.Device.Device) < LIBUSB_DT_DEVICE_SIZE {
+		p.err = fmt.Errorf("usb: recorded session has a malformed device at event %d", p.next)
+		return
+	}
+	p.present[ev.Session] = ev.Device
+	p.devices = append(p.devices, ev.Session)
+
+	active_contexts_lock.Lock()
+	for e := active_contexts_list.next; e != active_contexts_list; e = e.next {
+		replay_enumerate_device(list_entry(e).(*libusb_context), ev.Session, ev.Device)
+	}
+	active_contexts_lock.Unlock()
+}
+
+func (p *Replayer) leave(ev *sessionEvent) {
+	delete(p.present, ev.Session)
+	for i, session := range p.devices {
+		if session == ev.Session {
+			p.devices = append(p.devices[:i], p.devices[i+1:]...)
+			break
+		}
+	}
+
+	active_contexts_lock.Lock()
+	for e := active_contexts_list.next; e != active_contexts_list; e = e.next {
+		dev := usbi_get_device_by_session_id(list_entry(e).(*libusb_context), ev.Session)
+		if dev != nil {
+			usbi_disconnect_device(dev)
+			libusb_unref_device(dev)
+		}
+	}
+	active_contexts_lock.Unlock()
+}
+
+func replay_enumerate_device(ctx *libusb_context, session uint64, d *sessionDevice) {
+	dev := usbi_get_device_by_session_id(ctx, session)
+	if dev != nil {
+		/* device already exists in the context */
+		libusb_unref_device(dev)
+		return
+	}
+
+	dev = usbi_alloc_device(ctx, session)
+	dev.os_priv = d
+	dev.bus_number = d.Bus
+	dev.port_number = d.Port
+	dev.device_address = d.Address
+	dev.speed = libusb_speed(d.Speed)
+	if d.Parent != 0 {
+		/* the reference is dropped when the device is freed */
+		dev.parent_dev = usbi_get_device_by_session_id(ctx, d.Parent)
+	}
+
+	if usbi_sanitize_device(dev) < 0 {
+		libusb_unref_device(dev)
+		return
+	}
+	usbi_connect_device(dev)
+}
+
+type replayTransfer struct {
+	transferred int
+	status      libusb_transfer_status
+}
+
+// complete fills in a transfer from its recorded completion and signals it
+// to the event handler. It returns false if the transfer has not been
+// submitted yet.
+func (p *Replayer) complete(ev *sessionEvent) bool {
+	itransfer := p.flying[ev.Transfer]
+	if itransfer == nil {
+		return false
+	}
+	delete(p.flying, ev.Transfer)
+	transfer := itransfer.libusbTransfer
+
+	if transfer._type == uint8(LIBUSB_TRANSFER_TYPE_CONTROL) {
+		copy(transfer.buffer[LIBUSB_CONTROL_SETUP_SIZE:], ev.Data)
+	} else {
+		copy(transfer.buffer, ev.Data)
+	}
+	for i, pkt := range ev.Iso {
+		if i >= transfer.num_iso_packets {
+			break
+		}
+		transfer.iso_packet_desc[i].actual_length = pkt.ActualLength
+		transfer.iso_packet_desc[i].status = libusb_transfer_status(pkt.Status)
+	}
+	itransfer.tpriv = &replayTransfer{
+		transferred: ev.Transferred,
+		status:      libusb_transfer_status(ev.Status),
+	}
+
+	usbi_signal_transfer_completion(itransfer)
+	return true
+}
+
+type replayBackend Replayer
+
+func (b *replayBackend) rep() *Replayer {
+	return (*Replayer)(b)
+}
+
+func (*replayBackend) Name() string {
+	return "replay"
+}
+
+func (*replayBackend) Caps() uint32 {
+	return 0
+}
+
+func (b *replayBackend) Init(ctx *libusb_context) libusb_error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	ev := b.rep().expect("init", 0)
+	if ev == nil {
+		return LIBUSB_ERROR_OTHER
+	}
+	if ev.Result < 0 {
+		return libusb_error(ev.Result)
+	}
+
+	/* devices that arrived while other contexts were open */
+	for _, session := range b.devices {
+		replay_enumerate_device(ctx, session, b.present[session])
+	}
+	b.rep().advance()
+	return libusb_error(ev.Result)
+}
+
+func (b *replayBackend) Exit() {
+	b.rep().call("exit", nil, 0, 0)
+}
+
+func (b *replayBackend) Get_device_list(*libusb_context, *[]*libusb_device) libusb_error {
+	return b.rep().call("get_device_list", nil, 0, 0)
+}
+
+func (b *replayBackend) Hotplug_poll() {
+	b.mu.Lock()
+	b.rep().advance()
+	b.mu.Unlock()
+}
+
+func (b *replayBackend) Open(handle *libusb_device_handle) libusb_error {
+	return b.rep().call("open", handle, 0, 0)
+}
+
+func (b *replayBackend) Close(handle *libusb_device_handle) {
+	b.rep().call("close", handle, 0, 0)
+}
+
+func (*replayBackend) Get_device_descriptor(dev *libusb_device, buffer []uint8, host_endian *int) libusb_error {
+	d := dev.os_priv.(*sessionDevice)
+	*host_endian = d.HostEndian
+	copy(buffer, d.Device[:LIBUSB_DT_DEVICE_SIZE])
+	return LIBUSB_SUCCESS
+}
+
+func (b *replayBackend) Get_active_config_descriptor(dev *libusb_device, buffer []uint8, host_endian *int) libusb_error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	ev := b.rep().expect("get_active_config_descriptor", dev.session_data)
+	if ev == nil {
+		return LIBUSB_ERROR_OTHER
+	}
+	*host_endian = ev.Arg
+	copy(buffer, ev.Data)
+	return libusb_error(ev.Result)
+}
+
+func (*replayBackend) Get_config_descriptor(dev *libusb_device, config_index uint8, buffer []uint8, length int, host_endian *int) libusb_error {
+	d := dev.os_priv.(*sessionDevice)
+	*host_endian = d.ConfigHostEndian
+
+	if int(config_index) >= len(d.Configs) {
+		return LIBUSB_ERROR_NOT_FOUND
+	}
+	if length < len(buffer) {
+		buffer = buffer[:length]
+	}
+	return libusb_error(copy(buffer, d.Configs[config_index]))
+}
+
+func (*replayBackend) Get_config_descriptor_by_value(dev *libusb_device, value uint8, buffer *[]uint8, host_endian *int) libusb_error {
+	d := dev.os_priv.(*sessionDevice)
+	*host_endian = d.ConfigHostEndian
+
+	for _, cfg := range d.Configs {
+		if len(cfg) >= LIBUSB_DT_CONFIG_SIZE && cfg[5] == value {
+			*buffer = cfg
+			return libusb_error(len(cfg))
+		}
+	}
+	return LIBUSB_ERROR_NOT_FOUND
+}
+
+func (b *replayBackend) Get_configuration(handle *libusb_device_handle, config *int) libusb_error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	ev := b.rep().expect("get_configuration", handle.dev.session_data)
+	if ev == nil {
+		return LIBUSB_ERROR_OTHER
+	}
+	*config = ev.Arg
+	return libusb_error(ev.Result)
+}
+
+func (b *replayBackend) Set_configuration(handle *libusb_device_handle, config *int) libusb_error {
+	return b.rep().call("set_configuration", handle, *config, 0)
+}
+
+func (b *replayBackend) Claim_interface(handle *libusb_device_handle, iface uint) libusb_error {
+	return b.rep().call("claim_interface", handle, int(iface), 0)
+}
+
+func (b *replayBackend) Release_interface(handle *libusb_device_handle, iface uint) libusb_error {
+	return b.rep().call("release_interface", handle, int(iface), 0)
+}
+
+func (b *replayBackend) Set_interface_altsetting(handle *libusb_device_handle, iface uint, altsetting int) libusb_error {
+	return b.rep().call("set_interface_altsetting", handle, int(iface), altsetting)
+}
+
+func (b *replayBackend) Clear_halt(handle *libusb_device_handle, endpoint uint8) libusb_error {
+	return b.rep().call("clear_halt", handle, int(endpoint), 0)
+}
+
+func (b *replayBackend) Reset_device(handle *libusb_device_handle) libusb_error {
+	return b.rep().call("reset_device", handle, 0, 0)
+}
+
+func (b *replayBackend) Alloc_streams(handle *libusb_device_handle, num_streams uint32, endpoints []uint8, num_endpoints int) libusb_error {
+	return b.rep().call("alloc_streams", handle, int(num_streams), num_endpoints)
+}
+
+func (b *replayBackend) Free_streams(handle *libusb_device_handle, endpoints []uint8, num_endpoints int) libusb_error {
+	return b.rep().call("free_streams", handle, 0, num_endpoints)
+}
+
+func (*replayBackend) Dev_mem_alloc(*libusb_device_handle, int) []uint8 {
+	return nil
+}
+
+func (*replayBackend) Dev_mem_free(*libusb_device_handle, []uint8, int) libusb_error {
+	return LIBUSB_ERROR_NOT_SUPPORTED
+}
+
+func (b *replayBackend) Kernel_driver_active(handle *libusb_device_handle, iface int) libusb_error {
+	return b.rep().call("kernel_driver_active", handle, iface, 0)
+}
+
+func (b *replayBackend) Detach_kernel_driver(handle *libusb_device_handle, iface int) libusb_error {
+	return b.rep().call("detach_kernel_driver", handle, iface, 0)
+}
+
+func (b *replayBackend) Attach_kernel_driver(handle *libusb_device_handle, iface int) libusb_error {
+	return b.rep().call("attach_kernel_driver", handle, iface, 0)
+}
+
+func (*replayBackend) Destroy_device(dev *libusb_device) {
+	dev.os_priv = nil
+}
+
+func (b *replayBackend) Submit_transfer(itransfer *usbi_transfer) libusb_error {
+	transfer := itransfer.libusbTransfer
+	session := transfer.dev_handle.dev.session_data
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	ev := b.rep().expect("submit", session)
+	if ev == nil {
+		return LIBUSB_ERROR_OTHER
+	}
+	if ev.Type != transfer._type || ev.Endpoint != transfer.endpoint || ev.Length != transfer.length ||
+		(transfer._type == uint8(LIBUSB_TRANSFER_TYPE_CONTROL) &&
+			!bytes.HasPrefix(ev.Data, transfer.buffer[:LIBUSB_CONTROL_SETUP_SIZE])) {
+		b.next--
+		return b.rep().diverge(fmt.Sprintf("submit %s transfer to endpoint 0x%02x",
+			TransferType(transfer._type), transfer.endpoint), session)
+	}
+	if ev.Result < 0 {
+		return libusb_error(ev.Result)
+	}
+
+	/* timeouts were recorded as completions */
+	ctx := transfer.dev_handle.dev.ctx
+	ctx.flying_transfers_lock.Lock()
+	itransfer.timeout_flags |= uint8(USBI_TRANSFER_OS_HANDLES_TIMEOUT)
+	ctx.flying_transfers_lock.Unlock()
+
+	b.flying[ev.Transfer] = itransfer
+	b.rep().advance()
+	return libusb_error(ev.Result)
+}
+
+func (b *replayBackend) Cancel_transfer(itransfer *usbi_transfer) libusb_error {
+	transfer := itransfer.libusbTransfer
+	session := transfer.dev_handle.dev.session_data
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	/* transfers that have completed were cancelled with no id */
+	var id uint64
+	for i, t := range b.flying {
+		if t == itransfer {
+			id = i
+			break
+		}
+	}
+
+	ev := b.rep().expect("cancel", session)
+	if ev == nil {
+		return LIBUSB_ERROR_OTHER
+	}
+	if ev.Transfer != id || ev.Endpoint != transfer.endpoint {
+		b.next--
+		return b.rep().diverge(fmt.Sprintf("cancel transfer %d to endpoint 0x%02x", id, transfer.endpoint), session)
+	}
+	b.rep().advance()
+	return libusb_error(ev.Result)
+}
+
+func (*replayBackend) Clear_transfer_priv(itransfer *usbi_transfer) {
+	itransfer.tpriv = nil
+}
+
+/* Completions are signalled as the recording reaches them and reported
+ * from here, on the event handling goroutine. */
+func (*replayBackend) Handle_transfer_completion(itransfer *usbi_transfer) libusb_error {
+	rt := itransfer.tpriv.(*replayTransfer)
+
+	itransfer.lock.Lock()
+	itransfer.transferred = rt.transferred
+	itransfer.lock.Unlock()
+
+	return libusb_error(usbi_handle_transfer_completion(itransfer, rt.status))
+}
+
+func (*replayBackend) Device_priv_size() int {
+	return 0
+}
+
+func (*replayBackend) Device_handle_priv_size() int {
+	return 0
+}
+
+func (*replayBackend) Transfer_priv_size() int {
+	return 0
+}
@@ -0,0 +1,281 @@
+package usb
+
+import (
+	"context"
+	"encoding/binary"
+	"time"
+)
+
+// standardRequestTimeout is the timeout of the standard requests below,
+// matching the one libusb_get_descriptor uses.
+const standardRequestTimeout = 1000 * time.Millisecond
+
+// Recipient is the recipient of a control request, held in the low bits of
+// bmRequestType.
+type Recipient uint8
+
+const (
+	RecipientDevice    = Recipient(LIBUSB_RECIPIENT_DEVICE)
+	RecipientInterface = Recipient(LIBUSB_RECIPIENT_INTERFACE)
+	RecipientEndpoint  = Recipient(LIBUSB_RECIPIENT_ENDPOINT)
+	RecipientOther     = Recipient(LIBUSB_RECIPIENT_OTHER)
+)
+
+// Feature is a feature selector for SetFeature and ClearFeature, see table
+// 9-7 of the USB 3.2 specification. Each applies to one kind of recipient.
+type Feature uint16
+
+const (
+	FeatureEndpointHalt       Feature = 0  // endpoint
+	FeatureFunctionSuspend    Feature = 0  // interface, SuperSpeed only
+	FeatureDeviceRemoteWakeup Feature = 1  // device
+	FeatureTestMode           Feature = 2  // device, high speed only
+	FeatureBHNPEnable         Feature = 3  // device, On-The-Go
+	FeatureAHNPSupport        Feature = 4  // device, On-The-Go
+	FeatureAAltHNPSupport     Feature = 5  // device, On-The-Go
+	FeatureU1Enable           Feature = 48 // device, SuperSpeed only
+	FeatureU2Enable           Feature = 49 // device, SuperSpeed only
+	FeatureLTMEnable          Feature = 50 // device, SuperSpeed only
+)
+
+// TestMode is a test selector for SetTestMode, see table 9-7 of the USB 2.0
+// specification.
+type TestMode uint8
+
+const (
+	TestJ           TestMode = 1
+	TestK           TestMode = 2
+	TestSE0NAK      TestMode = 3
+	TestPacket      TestMode = 4
+	TestForceEnable TestMode = 5
+)
+
+// DeviceStatus is the decoded response to a GET_STATUS request made to the
+// device.
+type DeviceStatus struct {
+	SelfPowered  bool
+	RemoteWakeup bool
+
+	// U1Enabled, U2Enabled and LTMEnabled are only reported by SuperSpeed
+	// devices.
+	U1Enabled  bool
+	U2Enabled  bool
+	LTMEnabled bool
+}
+
+// InterfaceStatus is the decoded response to a GET_STATUS request made to an
+// interface. Both fields are only reported by SuperSpeed devices.
+type InterfaceStatus struct {
+	RemoteWakeCapable bool
+	RemoteWakeup      bool
+}
+
+// SystemExitLatency is the data of a SET_SEL request, informing a SuperSpeed
+// device of the system exit latencies of U1 and U2, in microseconds.
+type SystemExitLatency struct {
+	U1SEL uint8
+	U1PEL uint8
+	U2SEL uint16
+	U2PEL uint16
+}
+
+// standardRequest makes a standard request to recipient, reporting failure
+// as op.
+func (h *DeviceHandle) standardRequest(op string, dir libusb_endpoint_direction, recipient Recipient, request libusb_standard_request, value, index uint16, data []byte) (int, error) {
+	requestType := uint8(dir) | uint8(LIBUSB_REQUEST_TYPE_STANDARD) | uint8(recipient)
+	r := libusb_control_transfer_context(context.Background(), h.handle, requestType,
+		uint8(request), value, index, data, uint16(len(data)), nil, standardRequestTimeout)
+	if r < 0 {
+		return 0, h.codeError(op, r)
+	}
+	return int(r), nil
+}
+
+// vendorRequest makes a vendor specific IN request to recipient, reporting
+// failure as op.
+func (h *DeviceHandle) vendorRequest(op string, recipient Recipient, request uint8, value, index uint16, data []byte) (int, error) {
+	requestType := uint8(LIBUSB_ENDPOINT_IN) | uint8(LIBUSB_REQUEST_TYPE_VENDOR) | uint8(recipient)
+	r := libusb_control_transfer_context(context.Background(), h.handle, requestType,
+		request, value, index, data, uint16(len(data)), nil, standardRequestTimeout)
+	if r < 0 {
+		return 0, h.codeError(op, r)
+	}
+	return int(r), nil
+}
+
+// Status issues GET_STATUS to a recipient and returns the raw status word.
+// index is 0 for the device, and otherwise the interface number or endpoint
+// address.
+func (h *DeviceHandle) Status(recipient Recipient, index uint16) (uint16, error) {
+	buf := make([]byte, 2)
+	n, err := h.standardRequest("get status", LIBUSB_ENDPOINT_IN, recipient,
+		LIBUSB_REQUEST_GET_STATUS, 0, index, buf)
+	if err != nil {
+		return 0, err
+	}
+	if n != len(buf) {
+		return 0, h.codeError("get status", LIBUSB_ERROR_IO)
+	}
+	return binary.LittleEndian.Uint16(buf), nil
+}
+
+// DeviceStatus issues GET_STATUS to the device.
+func (h *DeviceHandle) DeviceStatus() (DeviceStatus, error) {
+	s, err := h.Status(RecipientDevice, 0)
+	return DeviceStatus{
+		SelfPowered:  s&(1<<0) != 0,
+		RemoteWakeup: s&(1<<1) != 0,
+		U1Enabled:    s&(1<<2) != 0,
+		U2Enabled:    s&(1<<3) != 0,
+		LTMEnabled:   s&(1<<4) != 0,
+	}, err
+}
+
+// InterfaceStatus issues GET_STATUS to an interface.
+func (h *DeviceHandle) InterfaceStatus(iface int) (InterfaceStatus, error) {
+	s, err := h.Status(RecipientInterface, uint16(iface))
+	return InterfaceStatus{
+		RemoteWakeCapable: s&(1<<0) != 0,
+		RemoteWakeup:      s&(1<<1) != 0,
+	}, err
+}
+
+// EndpointHalted issues GET_STATUS to an endpoint and reports whether it is
+// halted.
+func (h *DeviceHandle) EndpointHalted(endpoint uint8) (bool, error) {
+	s, err := h.Status(RecipientEndpoint, uint16(endpoint))
+	return s&(1<<0) != 0, err
+}
+
+// SetFeature issues SET_FEATURE for a feature of a recipient. index is 0
+// for the device, and otherwise the interface number or endpoint address;
+// some features carry extra options in its high byte.
+func (h *DeviceHandle) SetFeature(recipient Recipient, feature Feature, index uint16) error {
+	_, err := h.standardRequest("set feature", LIBUSB_ENDPOINT_OUT, recipient,
+		LIBUSB_REQUEST_SET_FEATURE, uint16(feature), index, nil)
+	return err
+}
+
+// ClearFeature issues CLEAR_FEATURE for a feature of a recipient, with the
+// same arguments as SetFeature. To clear ENDPOINT_HALT use ClearHalt, which
+// also resets the host side of the endpoint.
+func (h *DeviceHandle) ClearFeature(recipient Recipient, feature Feature, index uint16) error {
+	_, err := h.standardRequest("clear feature", LIBUSB_ENDPOINT_OUT, recipient,
+		LIBUSB_REQUEST_CLEAR_FEATURE, uint16(feature), index, nil)
+	return err
+}
+
+func (h *DeviceHandle) setDeviceFeature(feature Feature, enable bool) error {
+	if enable {
+		return h.SetFeature(RecipientDevice, feature, 0)
+	}
+	return h.ClearFeature(RecipientDevice, feature, 0)
+}
+
+// SetRemoteWakeup enables or disables the device's ability to wake the host.
+func (h *DeviceHandle) SetRemoteWakeup(enable bool) error {
+	return h.setDeviceFeature(FeatureDeviceRemoteWakeup, enable)
+}
+
+// SetU1Enable enables or disables the device initiating U1 entry.
+func (h *DeviceHandle) SetU1Enable(enable bool) error {
+	return h.setDeviceFeature(FeatureU1Enable, enable)
+}
+
+// SetU2Enable enables or disables the device initiating U2 entry.
+func (h *DeviceHandle) SetU2Enable(enable bool) error {
+	return h.setDeviceFeature(FeatureU2Enable, enable)
+}
+
+// SetLTMEnable enables or disables Latency Tolerance Messages.
+func (h *DeviceHandle) SetLTMEnable(enable bool) error {
+	return h.setDeviceFeature(FeatureLTMEnable, enable)
+}
+
+// HaltEndpoint sets ENDPOINT_HALT, stalling an endpoint until ClearHalt is
+// called.
+func (h *DeviceHandle) HaltEndpoint(endpoint uint8) error {
+	return h.SetFeature(RecipientEndpoint, FeatureEndpointHalt, uint16(endpoint))
+}
+
+// SetTestMode puts a high speed device into a test mode. The device only
+// leaves test mode when it is power cycled.
+func (h *DeviceHandle) SetTestMode(mode TestMode) error {
+	return h.SetFeature(RecipientDevice, FeatureTestMode, uint16(mode)<<8)
+}
+
+// SuspendFunction sets FUNCTION_SUSPEND on the first interface of a function,
+// suspending or resuming it and enabling or disabling its remote wakeup.
+func (h *DeviceHandle) SuspendFunction(iface int, suspend, remoteWakeup bool) error {
+	var options uint16
+	if suspend {
+		options |= 1 << 0
+	}
+	if remoteWakeup {
+		options |= 1 << 1
+	}
+	return h.SetFeature(RecipientInterface, FeatureFunctionSuspend, options<<8|uint16(iface&0xff))
+}
+
+// AltSetting issues GET_INTERFACE, returning the alternate setting the
+// device reports for an interface. SetInterfaceAltSetting must be used to
+// change it, so that the operating system knows about the change.
+func (h *DeviceHandle) AltSetting(iface int) (int, error) {
+	buf := make([]byte, 1)
+	n, err := h.standardRequest("get interface", LIBUSB_ENDPOINT_IN, RecipientInterface,
+		LIBUSB_REQUEST_GET_INTERFACE, 0, uint16(iface), buf)
+	if err != nil {
+		return 0, err
+	}
+	if n != len(buf) {
+		return 0, h.codeError("get interface", LIBUSB_ERROR_IO)
+	}
+	return int(buf[0]), nil
+}
+
+// SetDescriptor issues SET_DESCRIPTOR, which few devices support. langID is
+// only used for string descriptors.
+func (h *DeviceHandle) SetDescriptor(descType, index uint8, langID uint16, data []byte) error {
+	_, err := h.standardRequest("set descriptor", LIBUSB_ENDPOINT_OUT, RecipientDevice,
+		LIBUSB_REQUEST_SET_DESCRIPTOR, uint16(descType)<<8|uint16(index), langID, data)
+	return err
+}
+
+// SynchFrame issues SYNCH_FRAME to an isochronous endpoint, returning the
+// frame number in which its synchronization pattern starts.
+func (h *DeviceHandle) SynchFrame(endpoint uint8) (uint16, error) {
+	buf := make([]byte, 2)
+	n, err := h.standardRequest("synch frame", LIBUSB_ENDPOINT_IN, RecipientEndpoint,
+		LIBUSB_REQUEST_SYNCH_FRAME, 0, uint16(endpoint), buf)
+	if err != nil {
+		return 0, err
+	}
+	if n != len(buf) {
+		return 0, h.codeError("synch frame", LIBUSB_ERROR_IO)
+	}
+	return binary.LittleEndian.Uint16(buf), nil
+}
+
+// SetSEL issues SET_SEL to a SuperSpeed device.
+func (h *DeviceHandle) SetSEL(sel SystemExitLatency) error {
+	buf := make([]byte, 6)
+	buf[0] = sel.U1SEL
+	buf[1] = sel.U1PEL
+	binary.LittleEndian.PutUint16(buf[2:], sel.U2SEL)
+	binary.LittleEndian.PutUint16(buf[4:], sel.U2PEL)
+	_, err := h.standardRequest("set sel", LIBUSB_ENDPOINT_OUT, RecipientDevice,
+		LIBUSB_REQUEST_SET_SEL, 0, 0, buf)
+	return err
+}
+
+// SetIsochDelay issues SET_ISOCH_DELAY to a SuperSpeed device, telling it
+// the delay from the host transmitting a packet to the device receiving it.
+// The delay is sent in nanoseconds and must be less than 65536ns.
+func (h *DeviceHandle) SetIsochDelay(delay time.Duration) error {
+	if delay < 0 || delay > 0xffff*time.Nanosecond {
+		return h.codeError("set isoch delay", LIBUSB_ERROR_INVALID_PARAM)
+	}
+	_, err := h.standardRequest("set isoch delay", LIBUSB_ENDPOINT_OUT, RecipientDevice,
+		LIBUSB_SET_ISOCH_DELAY, uint16(delay/time.Nanosecond), 0, nil)
+	return err
+}
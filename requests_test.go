@@ -0,0 +1,76 @@
+package usb
+
+import (
+	"encoding/binary"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestStandardRequests(t *testing.T) {
+	/* the loopback configuration with an alternate setting 1, without
+	 * endpoints, for interface 0 */
+	cfg := append([]byte(nil), simTestConfig...)
+	cfg = append(cfg, 0x09, 0x04, 0x00, 0x01, 0x00, 0xff, 0x00, 0x00, 0x00)
+	binary.LittleEndian.PutUint16(cfg[2:], uint16(len(cfg)))
+	d := newLoopbackDevice()
+	d.Configs = [][]byte{cfg}
+
+	h, _ := openLoopback(t, d)
+
+	if _, err := h.DeviceStatus(); err != nil {
+		t.Errorf("GET_STATUS of the device: %v", err)
+	}
+	if err := h.SetRemoteWakeup(true); !errors.Is(err, ErrPipe) {
+		t.Errorf("enabling remote wakeup returned %v, want the ErrPipe of an unsupported feature", err)
+	}
+
+	/* SET_FEATURE and CLEAR_FEATURE of ENDPOINT_HALT, seen through
+	 * GET_STATUS and transfers */
+	halted := func(want bool) {
+		t.Helper()
+		got, err := h.EndpointHalted(0x81)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("endpoint 0x81 halted %v, want %v", got, want)
+		}
+	}
+	halted(false)
+	if err := h.HaltEndpoint(0x81); err != nil {
+		t.Fatal(err)
+	}
+	halted(true)
+	if _, err := h.BulkTransfer(0x81, make([]byte, 8), time.Second); !errors.Is(err, ErrPipe) {
+		t.Errorf("transfer to a halted endpoint returned %v, want ErrPipe", err)
+	}
+	if err := h.ClearFeature(RecipientEndpoint, FeatureEndpointHalt, 0x81); err != nil {
+		t.Fatal(err)
+	}
+	halted(false)
+	if _, err := h.BulkTransfer(0x81, make([]byte, 8), time.Second); err != nil {
+		t.Errorf("transfer after clearing the halt returned %v", err)
+	}
+
+	/* SET_INTERFACE, seen through GET_INTERFACE */
+	alt := func(want int) {
+		t.Helper()
+		got, err := h.AltSetting(0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("interface 0 is in alternate setting %d, want %d", got, want)
+		}
+	}
+	alt(0)
+	if err := h.SetInterfaceAltSetting(0, 1); err != nil {
+		t.Fatal(err)
+	}
+	alt(1)
+	if err := h.SetInterfaceAltSetting(0, 2); err == nil {
+		t.Error("selected an alternate setting the interface does not have")
+	}
+	alt(1)
+}
@@ -0,0 +1,891 @@
+package usb
+
+import (
+	"encoding/binary"
+	"errors"
+	"sync"
+	"time"
+	"unicode/utf16"
+)
+
+// A SimRequest is a transfer as seen by the handler of a simulated
+// endpoint.
+type SimRequest struct {
+	Type     TransferType
+	Endpoint uint8
+
+	// The setup packet of control transfers.
+	RequestType uint8
+	Request     uint8
+	Value       uint16
+	Index       uint16
+
+	// Data holds the bytes sent by the host for OUT transfers, and is the
+	// buffer to fill for IN transfers. Handlers of isochronous endpoints are
+	// called once per packet, with Data holding that packet.
+	Data []byte
+
+	// Done is closed when the transfer is cancelled, times out or its device
+	// is unplugged. A handler that blocks must return once Done is closed;
+	// the byte count it returns then is kept as what was transferred before
+	// the abort, and its error is discarded.
+	Done <-chan struct{}
+}
+
+// A SimHandler services transfers made to a simulated endpoint. It returns
+// the number of bytes transferred and, to fail the transfer, an error
+// wrapping one of the package's sentinel errors: ErrPipe stalls the
+// endpoint until its halt is cleared, while ErrTimeout, ErrNoDevice and
+// ErrOverflow complete the transfer with the matching status. Any other
+// error completes it with TransferError.
+type SimHandler func(req *SimRequest) (int, error)
+
+// A SimDevice is a virtual device, described by its raw descriptors and
+// serviced by Go functions.
+type SimDevice struct {
+	// Bus, Port and Address locate the device. A zero Bus or Address is
+	// assigned when the device is plugged in.
+	Bus, Port, Address uint8
+	Speed              Speed
+
+	// Device is the 18 byte device descriptor. Configs holds each full
+	// configuration descriptor, with its interface, endpoint and
+	// class-specific descriptors, in the order of their indexes.
+	Device  []byte
+	Configs [][]byte
+
+	// Strings are the string descriptors from index 1 onwards, served in US
+	// English (LANGID 0x0409).
+	Strings []string
+
+	// Descriptors holds any other descriptor served by GET_DESCRIPTOR, such
+	// as a BOS descriptor, keyed by type<<8 | index.
+	Descriptors map[uint16][]byte
+
+	// Control services control transfers. Standard requests that it leaves
+	// unhandled, by being nil or returning an error wrapping
+	// ErrNotSupported, are answered by the simulator from the descriptors
+	// above.
+	Control SimHandler
+
+	// Endpoints maps endpoint addresses to their handlers. Transfers to an
+	// endpoint without a handler fail to submit with ErrNotFound.
+	Endpoints map[uint8]SimHandler
+
+	/* protected by Simulator.mu */
+	sim     *Simulator
+	session uint64
+	config  int
+	alt     map[uint8]uint8
+	halted  map[uint8]bool
+	claimed map[uint8]*libusb_device_handle
+	flying  map[*simTransfer]struct{}
+	last    map[uint8]*simTransfer /* last transfer submitted to each endpoint */
+}
+
+// A Simulator is an in-memory backend whose devices are SimDevices. It lets
+// code built on this package run against emulated hardware:
+//
+//	sim := usb.NewSimulator()
+//	prev := usb.SetBackend(sim.Backend())
+//	defer usb.SetBackend(prev)
+//	sim.Plug(&usb.SimDevice{...})
+type Simulator struct {
+	mu          sync.Mutex
+	devices     []*SimDevice
+	nextSession uint64
+	nextAddress uint8
+
+	/* number of contexts initialized against the simulator */
+	contexts int
+}
+
+// NewSimulator returns a simulator with no devices plugged in.
+func NewSimulator() *Simulator {
+	return &Simulator{}
+}
+
+// Backend returns the Backend to install with SetBackend.
+func (s *Simulator) Backend() Backend {
+	return (*simBackend)(s)
+}
+
+// Plug connects a device. Open contexts see it arrive as a hotplug event,
+// and contexts created later find it when they enumerate.
+func (s *Simulator) Plug(d *SimDevice) error {
+	if len(d.Device) < LIBUSB_DT_DEVICE_SIZE {
+		return codeError("plug", LIBUSB_ERROR_INVALID_PARAM)
+	}
+
+	s.mu.Lock()
+	if d.sim != nil {
+		s.mu.Unlock()
+		return codeError("plug", LIBUSB_ERROR_BUSY)
+	}
+	s.nextSession++
+	d.sim = s
+	d.session = s.nextSession
+	if d.Bus == 0 {
+		d.Bus = 1
+	}
+	if d.Address == 0 {
+		s.nextAddress = s.nextAddress%127 + 1
+		d.Address = s.nextAddress
+	}
+	d.config = 0
+	if len(d.Configs) > 0 && len(d.Configs[0]) >= LIBUSB_DT_CONFIG_SIZE {
+		d.config = int(d.Configs[0][5])
+	}
+	d.alt = make(map[uint8]uint8)
+	d.halted = make(map[uint8]bool)
+	d.claimed = make(map[uint8]*libusb_device_handle)
+	d.flying = make(map[*simTransfer]struct{})
+	d.last = make(map[uint8]*simTransfer)
+	s.devices = append(s.devices, d)
+	active := s.contexts > 0
+	s.mu.Unlock()
+
+	if active {
+		active_contexts_lock.Lock()
+		for e := active_contexts_list.next; e != active_contexts_list; e = e.next {
+			sim_enumerate_device(list_entry(e).(*libusb_context), d)
+		}
+		active_contexts_lock.Unlock()
+	}
+	return nil
+}
+
+// Unplug disconnects a device. Its transfers in flight complete with
+// TransferNoDevice and open contexts see it leave as a hotplug event.
+func (s *Simulator) Unplug(d *SimDevice) {
+	s.mu.Lock()
+	if d.sim != s {
+		s.mu.Unlock()
+		return
+	}
+	for i, dev := range s.devices {
+		if dev == d {
+			s.devices = append(s.devices[:i], s.devices[i+1:]...)
+			break
+		}
+	}
+	d.sim = nil
+	flying := d.flying
+	d.flying = make(map[*simTransfer]struct{})
+	active := s.contexts > 0
+	s.mu.Unlock()
+
+	for st := range flying {
+		st.abort(LIBUSB_TRANSFER_NO_DEVICE)
+	}
+
+	if active {
+		active_contexts_lock.Lock()
+		for e := active_contexts_list.next; e != active_contexts_list; e = e.next {
+			dev := usbi_get_device_by_session_id(list_entry(e).(*libusb_context), d.session)
+			if dev != nil {
+				usbi_disconnect_device(dev)
+				libusb_unref_device(dev)
+			}
+		}
+		active_contexts_lock.Unlock()
+	}
+}
+
+func sim_enumerate_device(ctx *libusb_context, d *SimDevice) {
+	dev := usbi_get_device_by_session_id(ctx, d.session)
+	if dev != nil {
+		/* device already exists in the context */
+		libusb_unref_device(dev)
+		return
+	}
+
+	dev = usbi_alloc_device(ctx, d.session)
+	dev.os_priv = d
+	dev.bus_number = d.Bus
+	dev.port_number = d.Port
+	dev.device_address = d.Address
+	dev.speed = libusb_speed(d.Speed)
+
+	if usbi_sanitize_device(dev) < 0 {
+		libusb_unref_device(dev)
+		return
+	}
+	usbi_connect_device(dev)
+}
+
+// configByValue returns the configuration descriptor with the given
+// bConfigurationValue, or nil.
+func (d *SimDevice) configByValue(value int) []byte {
+	for _, cfg := range d.Configs {
+		if len(cfg) >= LIBUSB_DT_CONFIG_SIZE && int(cfg[5]) == value {
+			return cfg
+		}
+	}
+	return nil
+}
+
+// hasAltSetting reports whether the active configuration has the given
+// interface alternate setting.
+func (d *SimDevice) hasAltSetting(iface, alt uint8) bool {
+	cfg := d.configByValue(d.config)
+	for i := 0; i+2 <= len(cfg) && cfg[i] >= 2; i += int(cfg[i]) {
+		if cfg[i+1] == uint8(LIBUSB_DT_INTERFACE) && int(cfg[i]) >= 4 && i+4 <= len(cfg) &&
+			cfg[i+2] == iface && cfg[i+3] == alt {
+			return true
+		}
+	}
+	return false
+}
+
+// stringDescriptor encodes string descriptor index, where index 0 is the
+// list of supported languages.
+func (d *SimDevice) stringDescriptor(index uint8) []byte {
+	if index == 0 {
+		return []byte{4, uint8(LIBUSB_DT_STRING), 0x09, 0x04}
+	}
+	if int(index) > len(d.Strings) {
+		return nil
+	}
+	units := utf16.Encode([]rune(d.Strings[index-1]))
+	if len(units) > 126 {
+		units = units[:126]
+	}
+	desc := make([]byte, 2+2*len(units))
+	desc[0] = uint8(len(desc))
+	desc[1] = uint8(LIBUSB_DT_STRING)
+	for i, u := range units {
+		binary.LittleEndian.PutUint16(desc[2+2*i:], u)
+	}
+	return desc
+}
+
+/* errSimAborted is returned by simTransfer.call when the transfer was
+ * aborted; the status is in simTransfer.status. */
+var errSimAborted = errors.New("usb: simulated transfer aborted")
+
+type simTransfer struct {
+	itransfer *usbi_transfer
+	dev       *SimDevice
+
+	once   sync.Once
+	done   chan struct{}
+	status libusb_transfer_status /* why the transfer was aborted */
+
+	/* transfers to an endpoint are serviced one at a time, in the order
+	 * they were submitted: finished is closed once this one and those
+	 * before it are done with, and prev is the finished channel of the
+	 * transfer submitted before it */
+	prev     <-chan struct{}
+	finished chan struct{}
+
+	/* results, read by Handle_transfer_completion */
+	transferred int
+	result      libusb_transfer_status
+}
+
+func (st *simTransfer) abort(status libusb_transfer_status) {
+	st.once.Do(func() {
+		st.status = status
+		close(st.done)
+	})
+}
+
+// call runs a handler on a copy of the request's data, so that a handler
+// that outlives an aborted transfer cannot touch its buffer. Once the
+// transfer is aborted, the handler is waited for to learn how much it
+// transferred.
+func (st *simTransfer) call(h SimHandler, req *SimRequest, in bool, timeout <-chan time.Time) (int, error) {
+	type result struct {
+		n   int
+		err error
+	}
+
+	r := *req
+	r.Data = append([]byte(nil), req.Data...)
+	r.Done = st.done
+	res := make(chan result, 1)
+	go func() {
+		n, err := h(&r)
+		res <- result{n, err}
+	}()
+
+	var out result
+	select {
+	case out = <-res:
+	case <-st.done:
+		out = <-res
+		out.err = errSimAborted
+	case <-timeout:
+		st.abort(LIBUSB_TRANSFER_TIMED_OUT)
+		out = <-res
+		out.err = errSimAborted
+	}
+	n := out.n
+	if n < 0 {
+		n = 0
+	} else if n > len(req.Data) {
+		n = len(req.Data)
+	}
+	if in {
+		copy(req.Data, r.Data[:n])
+	}
+	return n, out.err
+}
+
+func (st *simTransfer) statusOf(err error) libusb_transfer_status {
+	switch {
+	case err == nil:
+		return LIBUSB_TRANSFER_COMPLETED
+	case err == errSimAborted:
+		return st.status
+	case errors.Is(err, ErrPipe):
+		return LIBUSB_TRANSFER_STALL
+	case errors.Is(err, ErrTimeout):
+		return LIBUSB_TRANSFER_TIMED_OUT
+	case errors.Is(err, ErrNoDevice):
+		return LIBUSB_TRANSFER_NO_DEVICE
+	case errors.Is(err, ErrOverflow):
+		return LIBUSB_TRANSFER_OVERFLOW
+	}
+	return LIBUSB_TRANSFER_ERROR
+}
+
+type simBackend Simulator
+
+func (*simBackend) Name() string {
+	return "simulator"
+}
+
+func (*simBackend) Caps() uint32 {
+	return 0
+}
+
+func (s *simBackend) Init(ctx *libusb_context) libusb_error {
+	s.mu.Lock()
+	s.contexts++
+	devices := append([]*SimDevice(nil), s.devices...)
+	s.mu.Unlock()
+
+	for _, d := range devices {
+		sim_enumerate_device(ctx, d)
+	}
+	return LIBUSB_SUCCESS
+}
+
+func (s *simBackend) Exit() {
+	s.mu.Lock()
+	s.contexts--
+	s.mu.Unlock()
+}
+
+/* Devices are added to contexts by Init and Plug. */
+func (*simBackend) Get_device_list(*libusb_context, *[]*libusb_device) libusb_error {
+	return LIBUSB_ERROR_NOT_SUPPORTED
+}
+
+func (*simBackend) Hotplug_poll() {}
+
+func (s *simBackend) Open(handle *libusb_device_handle) libusb_error {
+	d := handle.dev.os_priv.(*SimDevice)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if d.sim == nil {
+		return LIBUSB_ERROR_NO_DEVICE
+	}
+	handle.os_priv = d
+	return LIBUSB_SUCCESS
+}
+
+func (s *simBackend) Close(handle *libusb_device_handle) {
+	d := handle.os_priv.(*SimDevice)
+
+	s.mu.Lock()
+	for iface, h := range d.claimed {
+		if h == handle {
+			delete(d.claimed, iface)
+		}
+	}
+	s.mu.Unlock()
+}
+
+func (*simBackend) Get_device_descriptor(dev *libusb_device, buffer []uint8, host_endian *int) libusb_error {
+	d := dev.os_priv.(*SimDevice)
+	*host_endian = 0
+	copy(buffer, d.Device[:LIBUSB_DT_DEVICE_SIZE])
+	return LIBUSB_SUCCESS
+}
+
+func (s *simBackend) Get_active_config_descriptor(dev *libusb_device, buffer []uint8, host_endian *int) libusb_error {
+	d := dev.os_priv.(*SimDevice)
+	*host_endian = 0
+
+	s.mu.Lock()
+	cfg := d.configByValue(d.config)
+	s.mu.Unlock()
+	if cfg == nil {
+		return LIBUSB_ERROR_NOT_FOUND
+	}
+	return libusb_error(copy(buffer, cfg))
+}
+
+func (*simBackend) Get_config_descriptor(dev *libusb_device, config_index uint8, buffer []uint8, length int, host_endian *int) libusb_error {
+	d := dev.os_priv.(*SimDevice)
+	*host_endian = 0
+
+	if int(config_index) >= len(d.Configs) {
+		return LIBUSB_ERROR_NOT_FOUND
+	}
+	if length < len(buffer) {
+		buffer = buffer[:length]
+	}
+	return libusb_error(copy(buffer, d.Configs[config_index]))
+}
+
+func (*simBackend) Get_config_descriptor_by_value(dev *libusb_device, value uint8, buffer *[]uint8, host_endian *int) libusb_error {
+	d := dev.os_priv.(*SimDevice)
+	*host_endian = 0
+
+	cfg := d.configByValue(int(value))
+	if cfg == nil {
+		return LIBUSB_ERROR_NOT_FOUND
+	}
+	*buffer = cfg
+	return libusb_error(len(cfg))
+}
+
+func (s *simBackend) Get_configuration(handle *libusb_device_handle, config *int) libusb_error {
+	d := handle.os_priv.(*SimDevice)
+
+	s.mu.Lock()
+	*config = d.config
+	s.mu.Unlock()
+	return LIBUSB_SUCCESS
+}
+
+func (s *simBackend) Set_configuration(handle *libusb_device_handle, config *int) libusb_error {
+	d := handle.os_priv.(*SimDevice)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if d.sim == nil {
+		return LIBUSB_ERROR_NO_DEVICE
+	}
+	return s.setConfiguration(d, *config)
+}
+
+/* call with s.mu held */
+func (s *simBackend) setConfiguration(d *SimDevice, config int) libusb_error {
+	if config == -1 {
+		config = 0
+	}
+	if config != 0 && d.configByValue(config) == nil {
+		return LIBUSB_ERROR_NOT_FOUND
+	}
+	if len(d.claimed) > 0 {
+		return LIBUSB_ERROR_BUSY
+	}
+	d.config = config
+	d.alt = make(map[uint8]uint8)
+	d.halted = make(map[uint8]bool)
+	return LIBUSB_SUCCESS
+}
+
+func (s *simBackend) Claim_interface(handle *libusb_device_handle, iface uint) libusb_error {
+	d := handle.os_priv.(*SimDevice)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if d.sim == nil {
+		return LIBUSB_ERROR_NO_DEVICE
+	}
+	if iface > 0xff || !d.hasAltSetting(uint8(iface), 0) {
+		return LIBUSB_ERROR_NOT_FOUND
+	}
+	if h, ok := d.claimed[uint8(iface)]; ok && h != handle {
+		return LIBUSB_ERROR_BUSY
+	}
+	d.claimed[uint8(iface)] = handle
+	return LIBUSB_SUCCESS
+}
+
+func (s *simBackend) Release_interface(handle *libusb_device_handle, iface uint) libusb_error {
+	d := handle.os_priv.(*SimDevice)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if d.sim == nil {
+		return LIBUSB_ERROR_NO_DEVICE
+	}
+	if h := d.claimed[uint8(iface)]; h != handle {
+		return LIBUSB_ERROR_NOT_FOUND
+	}
+	delete(d.claimed, uint8(iface))
+	return LIBUSB_SUCCESS
+}
+
+func (s *simBackend) Set_interface_altsetting(handle *libusb_device_handle, iface uint, altsetting int) libusb_error {
+	d := handle.os_priv.(*SimDevice)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if d.sim == nil {
+		return LIBUSB_ERROR_NO_DEVICE
+	}
+	if iface > 0xff || altsetting < 0 || altsetting > 0xff ||
+		!d.hasAltSetting(uint8(iface), uint8(altsetting)) {
+		return LIBUSB_ERROR_NOT_FOUND
+	}
+	d.alt[uint8(iface)] = uint8(altsetting)
+	return LIBUSB_SUCCESS
+}
+
+func (s *simBackend) Clear_halt(handle *libusb_device_handle, endpoint uint8) libusb_error {
+	d := handle.os_priv.(*SimDevice)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if d.sim == nil {
+		return LIBUSB_ERROR_NO_DEVICE
+	}
+	if _, ok := d.Endpoints[endpoint]; !ok {
+		return LIBUSB_ERROR_NOT_FOUND
+	}
+	delete(d.halted, endpoint)
+	return LIBUSB_SUCCESS
+}
+
+func (s *simBackend) Reset_device(handle *libusb_device_handle) libusb_error {
+	d := handle.os_priv.(*SimDevice)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if d.sim == nil {
+		return LIBUSB_ERROR_NOT_FOUND
+	}
+	d.alt = make(map[uint8]uint8)
+	d.halted = make(map[uint8]bool)
+	return LIBUSB_SUCCESS
+}
+
+func (*simBackend) Alloc_streams(*libusb_device_handle, uint32, []uint8, int) libusb_error {
+	return LIBUSB_ERROR_NOT_SUPPORTED
+}
+
+func (*simBackend) Free_streams(*libusb_device_handle, []uint8, int) libusb_error {
+	return LIBUSB_ERROR_NOT_SUPPORTED
+}
+
+func (*simBackend) Dev_mem_alloc(*libusb_device_handle, int) []uint8 {
+	return nil
+}
+
+func (*simBackend) Dev_mem_free(*libusb_device_handle, []uint8, int) libusb_error {
+	return LIBUSB_ERROR_NOT_SUPPORTED
+}
+
+/* Simulated devices never have a kernel driver bound. */
+func (*simBackend) Kernel_driver_active(*libusb_device_handle, int) libusb_error {
+	return 0
+}
+
+func (*simBackend) Detach_kernel_driver(*libusb_device_handle, int) libusb_error {
+	return LIBUSB_ERROR_NOT_FOUND
+}
+
+func (*simBackend) Attach_kernel_driver(*libusb_device_handle, int) libusb_error {
+	return LIBUSB_ERROR_NOT_FOUND
+}
+
+func (*simBackend) Destroy_device(dev *libusb_device) {
+	dev.os_priv = nil
+}
+
+func (s *simBackend) Submit_transfer(itransfer *usbi_transfer) libusb_error {
+	transfer := itransfer.libusbTransfer
+	d := transfer.dev_handle.os_priv.(*SimDevice)
+
+	var handler SimHandler
+	if transfer._type == uint8(LIBUSB_TRANSFER_TYPE_CONTROL) {
+		if transfer.length < LIBUSB_CONTROL_SETUP_SIZE || len(transfer.buffer) < transfer.length {
+			return LIBUSB_ERROR_INVALID_PARAM
+		}
+	} else if transfer._type == uint8(LIBUSB_TRANSFER_TYPE_BULK_STREAM) {
+		return LIBUSB_ERROR_NOT_SUPPORTED
+	}
+
+	s.mu.Lock()
+	if d.sim == nil {
+		s.mu.Unlock()
+		return LIBUSB_ERROR_NO_DEVICE
+	}
+	if transfer._type != uint8(LIBUSB_TRANSFER_TYPE_CONTROL) {
+		handler = d.Endpoints[transfer.endpoint]
+		if handler == nil {
+			s.mu.Unlock()
+			return LIBUSB_ERROR_NOT_FOUND
+		}
+	}
+	st := &simTransfer{itransfer: itransfer, dev: d, done: make(chan struct{}), finished: make(chan struct{})}
+	if prev := d.last[transfer.endpoint]; prev != nil {
+		st.prev = prev.finished
+	}
+	d.last[transfer.endpoint] = st
+	d.flying[st] = struct{}{}
+	s.mu.Unlock()
+
+	itransfer.tpriv = st
+
+	/* the simulator enforces timeouts itself */
+	ctx := transfer.dev_handle.dev.ctx
+	ctx.flying_transfers_lock.Lock()
+	itransfer.timeout_flags |= uint8(USBI_TRANSFER_OS_HANDLES_TIMEOUT)
+	ctx.flying_transfers_lock.Unlock()
+
+	go s.run(st, handler)
+	return LIBUSB_SUCCESS
+}
+
+// run services a submitted transfer and signals its completion to the
+// event handler.
+func (s *simBackend) run(st *simTransfer, handler SimHandler) {
+	transfer := st.itransfer.libusbTransfer
+	d := st.dev
+
+	var timeout <-chan time.Time
+	if transfer.timeout != 0 {
+		timer := time.NewTimer(time.Duration(transfer.timeout) * time.Millisecond)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	var n int
+	var err error
+	if st.prev != nil {
+		select {
+		case <-st.prev:
+		case <-st.done:
+			err = errSimAborted
+		case <-timeout:
+			st.abort(LIBUSB_TRANSFER_TIMED_OUT)
+			err = errSimAborted
+		}
+	}
+
+	s.mu.Lock()
+	halted := d.halted[transfer.endpoint]
+	s.mu.Unlock()
+
+	switch {
+	case err != nil:
+	case transfer._type == uint8(LIBUSB_TRANSFER_TYPE_CONTROL):
+		n, err = s.control(st, timeout)
+	case halted:
+		err = ErrPipe
+	case transfer._type == uint8(LIBUSB_TRANSFER_TYPE_ISOCHRONOUS):
+		n, err = s.iso(st, handler, timeout)
+	default:
+		req := &SimRequest{
+			Type:     TransferType(transfer._type),
+			Endpoint: transfer.endpoint,
+			Data:     transfer.buffer[:transfer.length],
+		}
+		n, err = st.call(handler, req, transfer.endpoint&LIBUSB_ENDPOINT_DIR_MASK != 0, timeout)
+	}
+
+	st.transferred = n
+	st.result = st.statusOf(err)
+
+	s.mu.Lock()
+	delete(d.flying, st)
+	if d.last[transfer.endpoint] == st {
+		delete(d.last, transfer.endpoint)
+	}
+	if st.result == LIBUSB_TRANSFER_STALL && transfer._type != uint8(LIBUSB_TRANSFER_TYPE_CONTROL) {
+		d.halted[transfer.endpoint] = true
+	}
+	s.mu.Unlock()
+
+	usbi_signal_transfer_completion(st.itransfer)
+	if st.prev != nil {
+		<-st.prev
+	}
+	close(st.finished)
+}
+
+func (s *simBackend) control(st *simTransfer, timeout <-chan time.Time) (int, error) {
+	transfer := st.itransfer.libusbTransfer
+	d := st.dev
+	setup := transfer.buffer[:LIBUSB_CONTROL_SETUP_SIZE]
+
+	wLength := int(binary.LittleEndian.Uint16(setup[6:]))
+	if wLength > transfer.length-LIBUSB_CONTROL_SETUP_SIZE {
+		wLength = transfer.length - LIBUSB_CONTROL_SETUP_SIZE
+	}
+	req := &SimRequest{
+		Type:        TransferTypeControl,
+		RequestType: setup[0],
+		Request:     setup[1],
+		Value:       binary.LittleEndian.Uint16(setup[2:]),
+		Index:       binary.LittleEndian.Uint16(setup[4:]),
+		Data:        transfer.buffer[LIBUSB_CONTROL_SETUP_SIZE : LIBUSB_CONTROL_SETUP_SIZE+wLength],
+	}
+
+	err := error(ErrNotSupported)
+	n := 0
+	if d.Control != nil {
+		n, err = st.call(d.Control, req, req.RequestType&LIBUSB_ENDPOINT_DIR_MASK != 0, timeout)
+	}
+	if errors.Is(err, ErrNotSupported) {
+		s.mu.Lock()
+		n, err = s.standardRequest(d, req)
+		s.mu.Unlock()
+	}
+	return n, err
+}
+
+// standardRequest answers the chapter 9 requests a device must support.
+// Call with s.mu held.
+func (s *simBackend) standardRequest(d *SimDevice, req *SimRequest) (int, error) {
+	if req.RequestType&(0x03<<5) != uint8(LIBUSB_REQUEST_TYPE_STANDARD) {
+		return 0, ErrPipe
+	}
+	recipient := req.RequestType & 0x1f
+
+	switch libusb_standard_request(req.Request) {
+	case LIBUSB_REQUEST_GET_STATUS:
+		status := []byte{0, 0}
+		if recipient == uint8(LIBUSB_RECIPIENT_ENDPOINT) && d.halted[uint8(req.Index)] {
+			status[0] = 1
+		}
+		return copy(req.Data, status), nil
+
+	case LIBUSB_REQUEST_CLEAR_FEATURE, LIBUSB_REQUEST_SET_FEATURE:
+		/* only ENDPOINT_HALT is simulated */
+		if recipient != uint8(LIBUSB_RECIPIENT_ENDPOINT) || req.Value != 0 {
+			return 0, ErrPipe
+		}
+		if req.Request == uint8(LIBUSB_REQUEST_SET_FEATURE) {
+			d.halted[uint8(req.Index)] = true
+		} else {
+			delete(d.halted, uint8(req.Index))
+		}
+		return 0, nil
+
+	case LIBUSB_REQUEST_GET_DESCRIPTOR:
+		desc_type, desc_index := uint8(req.Value>>8), uint8(req.Value)
+		var desc []byte
+		switch libusb_descriptor_type(desc_type) {
+		case LIBUSB_DT_DEVICE:
+			desc = d.Device
+		case LIBUSB_DT_CONFIG:
+			if int(desc_index) < len(d.Configs) {
+				desc = d.Configs[desc_index]
+			}
+		case LIBUSB_DT_STRING:
+			desc = d.stringDescriptor(desc_index)
+		default:
+			desc = d.Descriptors[req.Value]
+		}
+		if desc == nil {
+			return 0, ErrPipe
+		}
+		return copy(req.Data, desc), nil
+
+	case LIBUSB_REQUEST_GET_CONFIGURATION:
+		return copy(req.Data, []byte{uint8(d.config)}), nil
+
+	case LIBUSB_REQUEST_SET_CONFIGURATION:
+		if s.setConfiguration(d, int(req.Value)) != LIBUSB_SUCCESS {
+			return 0, ErrPipe
+		}
+		return 0, nil
+
+	case LIBUSB_REQUEST_GET_INTERFACE:
+		if !d.hasAltSetting(uint8(req.Index), 0) {
+			return 0, ErrPipe
+		}
+		return copy(req.Data, []byte{d.alt[uint8(req.Index)]}), nil
+
+	case LIBUSB_REQUEST_SET_INTERFACE:
+		if !d.hasAltSetting(uint8(req.Index), uint8(req.Value)) {
+			return 0, ErrPipe
+		}
+		d.alt[uint8(req.Index)] = uint8(req.Value)
+		return 0, nil
+	}
+	return 0, ErrPipe
+}
+
+// iso runs the handler once per packet. Packet errors are reported in the
+// packet descriptors; the transfer itself only fails if it is aborted.
+func (s *simBackend) iso(st *simTransfer, handler SimHandler, timeout <-chan time.Time) (int, error) {
+	transfer := st.itransfer.libusbTransfer
+	in := transfer.endpoint&LIBUSB_ENDPOINT_DIR_MASK != 0
+
+	total, offset := 0, 0
+	for i := 0; i < transfer.num_iso_packets; i++ {
+		desc := &transfer.iso_packet_desc[i]
+		end := offset + int(desc.length)
+		if end > len(transfer.buffer) {
+			end = len(transfer.buffer)
+		}
+		req := &SimRequest{
+			Type:     TransferTypeIsochronous,
+			Endpoint: transfer.endpoint,
+			Data:     transfer.buffer[offset:end],
+		}
+		offset = end
+
+		n, err := st.call(handler, req, in, timeout)
+		desc.actual_length = uint(n)
+		total += n
+		if err == errSimAborted {
+			return total, err
+		}
+		desc.status = st.statusOf(err)
+	}
+	return total, nil
+}
+
+func (*simBackend) Cancel_transfer(itransfer *usbi_transfer) libusb_error {
+	st, ok := itransfer.tpriv.(*simTransfer)
+	if !ok {
+		return LIBUSB_ERROR_NOT_FOUND
+	}
+	st.abort(LIBUSB_TRANSFER_CANCELLED)
+	return LIBUSB_SUCCESS
+}
+
+func (*simBackend) Clear_transfer_priv(itransfer *usbi_transfer) {
+	itransfer.tpriv = nil
+}
+
+/* Completions are signalled by run and reported from here, on the event
+ * handling goroutine. */
+func (*simBackend) Handle_transfer_completion(itransfer *usbi_transfer) libusb_error {
+	st := itransfer.tpriv.(*simTransfer)
+
+	itransfer.lock.Lock()
+	itransfer.transferred = st.transferred
+	status := st.result
+	itransfer.lock.Unlock()
+
+	if status == LIBUSB_TRANSFER_CANCELLED {
+		return libusb_error(usbi_handle_transfer_cancellation(itransfer))
+	}
+	return libusb_error(usbi_handle_transfer_completion(itransfer, status))
+}
+
+func (*simBackend) Device_priv_size() int {
+	return 0
+}
+
+func (*simBackend) Device_handle_priv_size() int {
+	return 0
+}
+
+func (*simBackend) Transfer_priv_size() int {
+	return 0
+}
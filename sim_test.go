@@ -0,0 +1,376 @@
+package usb
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// simTestConfig is a configuration with one vendor specific interface
+// holding a bulk endpoint pair, an interrupt IN and an isochronous IN
+// endpoint.
+var simTestConfig = []byte{
+	0x09, 0x02, 0x2e, 0x00, 0x01, 0x01, 0x00, 0x80, 0x32, // configuration
+	0x09, 0x04, 0x00, 0x00, 0x04, 0xff, 0x00, 0x00, 0x00, // interface 0
+	0x07, 0x05, 0x01, 0x02, 0x40, 0x00, 0x00, // bulk OUT
+	0x07, 0x05, 0x81, 0x02, 0x40, 0x00, 0x00, // bulk IN
+	0x07, 0x05, 0x82, 0x03, 0x08, 0x00, 0x01, // interrupt IN
+	0x07, 0x05, 0x83, 0x01, 0x40, 0x00, 0x01, // isochronous IN
+}
+
+// newLoopbackDevice returns a device that echoes what is written to its
+// bulk OUT endpoint from its bulk IN endpoint, answers vendor request 0x01
+// with the wValue it was sent, and numbers the packets it sends from its
+// isochronous endpoint.
+func newLoopbackDevice() *SimDevice {
+	var mu sync.Mutex
+	var pending []byte
+	var seq byte
+	return &SimDevice{
+		Device:  testDeviceDescriptor,
+		Configs: [][]byte{simTestConfig},
+		Strings: []string{"Acme", "Loopback"},
+		Control: func(req *SimRequest) (int, error) {
+			if req.RequestType != 0xc0 || req.Request != 0x01 {
+				return 0, ErrNotSupported
+			}
+			return copy(req.Data, []byte{byte(req.Value), byte(req.Value >> 8)}), nil
+		},
+		Endpoints: map[uint8]SimHandler{
+			0x01: func(req *SimRequest) (int, error) {
+				mu.Lock()
+				defer mu.Unlock()
+				pending = append(pending, req.Data...)
+				return len(req.Data), nil
+			},
+			0x81: func(req *SimRequest) (int, error) {
+				mu.Lock()
+				defer mu.Unlock()
+				n := copy(req.Data, pending)
+				pending = pending[n:]
+				return n, nil
+			},
+			0x83: func(req *SimRequest) (int, error) {
+				mu.Lock()
+				defer mu.Unlock()
+				seq++
+				return copy(req.Data, []byte{seq}), nil
+			},
+		},
+	}
+}
+
+// openLoopback plugs d, usually a device from newLoopbackDevice, into a
+// simulator and opens it with interface 0 claimed.
+func openLoopback(t *testing.T, d *SimDevice) (*DeviceHandle, *Simulator) {
+	t.Helper()
+	c, sim := newTestContext(t, d)
+	return openTestDevice(t, c), sim
+}
+
+// openTestDevice opens device 1234:5678 of c and claims its interface 0. The
+// handle is closed when the test ends.
+func openTestDevice(t *testing.T, c *Context) *DeviceHandle {
+	t.Helper()
+	h, err := c.OpenDeviceWithVIDPID(0x1234, 0x5678)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { h.Close() })
+	if err := h.ClaimInterface(0); err != nil {
+		t.Fatal(err)
+	}
+	return h
+}
+
+func TestSimBulk(t *testing.T) {
+	h, _ := openLoopback(t, newLoopbackDevice())
+
+	out := []byte("hello, device")
+	n, err := h.BulkTransfer(0x01, out, time.Second)
+	if err != nil || n != len(out) {
+		t.Fatalf("bulk OUT moved %d bytes, %v", n, err)
+	}
+	in := make([]byte, 64)
+	n, err = h.BulkTransfer(0x81, in, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(in[:n], out) {
+		t.Errorf("bulk IN read %q, want %q", in[:n], out)
+	}
+
+	if _, err := h.BulkTransfer(0x02, in, time.Second); !errors.Is(err, ErrNotFound) {
+		t.Errorf("transfer to a missing endpoint returned %v, want ErrNotFound", err)
+	}
+}
+
+func TestSimControl(t *testing.T) {
+	h, _ := openLoopback(t, newLoopbackDevice())
+
+	buf := make([]byte, 2)
+	n, err := h.Control(0xc0, 0x01, 0xbeef, 0, buf, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 || buf[0] != 0xef || buf[1] != 0xbe {
+		t.Errorf("vendor request returned % x, want ef be", buf[:n])
+	}
+
+	/* left to the simulator's standard request handling */
+	s, err := h.StringDescriptor(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s != "Loopback" {
+		t.Errorf("string 2 is %q, want %q", s, "Loopback")
+	}
+	if _, err := h.Control(0xc0, 0x02, 0, 0, buf, time.Second); !errors.Is(err, ErrPipe) {
+		t.Errorf("unknown vendor request returned %v, want ErrPipe", err)
+	}
+}
+
+func TestSimIso(t *testing.T) {
+	h, _ := openLoopback(t, newLoopbackDevice())
+
+	done := make(chan *Transfer, 1)
+	xfer := h.NewTransfer(TransferTypeIsochronous, 0x83, make([]byte, 4*8), 4, time.Second,
+		func(t *Transfer) { done <- t })
+	xfer.SetIsoPacketLengths(8)
+	if err := xfer.Submit(); err != nil {
+		t.Fatal(err)
+	}
+	<-done
+	if xfer.Status() != TransferCompleted {
+		t.Fatalf("transfer status %v", xfer.Status())
+	}
+	for i, pkt := range xfer.IsoPackets() {
+		if pkt.Status != TransferCompleted || !bytes.Equal(pkt.Data, []byte{byte(i + 1)}) {
+			t.Errorf("packet %d: status %v, data % x, want % x", i, pkt.Status, pkt.Data, i+1)
+		}
+	}
+	if err := xfer.Close(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestSimHotplug(t *testing.T) {
+	c, sim := newTestContext(t)
+
+	events := make(chan libusb_hotplug_event, 2)
+	cb := func(_ *libusb_context, dev *libusb_device, event libusb_hotplug_event, _ interface{}) int {
+		events <- event
+		return 0
+	}
+	r := libusb_hotplug_register_callback(c.ctx,
+		LIBUSB_HOTPLUG_EVENT_DEVICE_ARRIVED|LIBUSB_HOTPLUG_EVENT_DEVICE_LEFT, 0,
+		0x1234, LIBUSB_HOTPLUG_MATCH_ANY, LIBUSB_HOTPLUG_MATCH_ANY, cb, nil, nil)
+	if r != LIBUSB_SUCCESS {
+		t.Fatal(r)
+	}
+
+	wait := func(want libusb_hotplug_event) {
+		t.Helper()
+		select {
+		case got := <-events:
+			if got != want {
+				t.Fatalf("got hotplug event %d, want %d", got, want)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("no hotplug event %d", want)
+		}
+	}
+
+	d := newLoopbackDevice()
+	if err := sim.Plug(d); err != nil {
+		t.Fatal(err)
+	}
+	wait(LIBUSB_HOTPLUG_EVENT_DEVICE_ARRIVED)
+	h, err := c.OpenDeviceWithVIDPID(0x1234, 0x5678)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sim.Unplug(d)
+	wait(LIBUSB_HOTPLUG_EVENT_DEVICE_LEFT)
+	if _, err := h.BulkTransfer(0x81, make([]byte, 8), time.Second); !errors.Is(err, ErrNoDevice) {
+		t.Errorf("transfer to an unplugged device returned %v, want ErrNoDevice", err)
+	}
+	h.Close()
+
+	devs, err := c.Devices()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(devs) != 0 {
+		t.Errorf("found %d devices after unplugging, want 0", len(devs))
+	}
+}
+
+// openEndpoints opens the loopback device through an Interface, with a bulk
+// OUT endpoint that records the length of each transfer and a bulk IN
+// endpoint that sends the packets in its queue. Like a real device, it
+// fills each transfer with packets until one is short or the transfer is
+// full, and blocks until the transfer is aborted when it has none to send.
+func openEndpoints(t *testing.T) (i *Interface, writes func() []int, send func(packets ...string)) {
+	t.Helper()
+	var mu sync.Mutex
+	var lengths []int
+	var queue []string
+	d := newLoopbackDevice()
+	d.Endpoints[0x01] = func(req *SimRequest) (int, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		lengths = append(lengths, len(req.Data))
+		return len(req.Data), nil
+	}
+	d.Endpoints[0x81] = func(req *SimRequest) (int, error) {
+		mu.Lock()
+		if len(queue) == 0 {
+			mu.Unlock()
+			<-req.Done
+			return 0, nil
+		}
+		defer mu.Unlock()
+		n := 0
+		for len(queue) > 0 && n < len(req.Data) && n+len(queue[0]) <= len(req.Data) {
+			packet := queue[0]
+			queue = queue[1:]
+			n += copy(req.Data[n:], packet)
+			if len(packet) < 64 {
+				break
+			}
+		}
+		return n, nil
+	}
+	h, _ := openLoopback(t, d)
+	i, err := h.Interface(0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writes = func() []int {
+		mu.Lock()
+		defer mu.Unlock()
+		w := lengths
+		lengths = nil
+		return w
+	}
+	send = func(packets ...string) {
+		mu.Lock()
+		defer mu.Unlock()
+		queue = append(queue, packets...)
+	}
+	return i, writes, send
+}
+
+func TestEndpointWrite(t *testing.T) {
+	i, writes, _ := openEndpoints(t)
+	out, err := i.Endpoint(0x01)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.MaxPacketSize() != 64 {
+		t.Fatalf("wMaxPacketSize %d, want 64", out.MaxPacketSize())
+	}
+
+	tests := []struct {
+		name      string
+		zlp       bool
+		length    int
+		transfers []int
+	}{
+		{"short", false, 10, []int{10}},
+		{"short with ZLP", true, 100, []int{100}},
+		{"whole packets", false, 128, []int{128}},
+		{"whole packets with ZLP", true, 128, []int{128, 0}},
+		{"split", false, 40000, []int{16384, 16384, 7232}},
+		{"split with ZLP", true, 32768, []int{16384, 16384, 0}},
+		{"empty with ZLP", true, 0, nil},
+	}
+	for _, tt := range tests {
+		out.SetAddZeroPacket(tt.zlp)
+		n, err := out.Write(make([]byte, tt.length))
+		if err != nil || n != tt.length {
+			t.Errorf("%s: wrote %d bytes, %v, want %d", tt.name, n, err, tt.length)
+		}
+		if got := writes(); !reflect.DeepEqual(got, tt.transfers) {
+			t.Errorf("%s: made transfers of %v bytes, want %v", tt.name, got, tt.transfers)
+		}
+	}
+
+	if _, err := out.Read(make([]byte, 64)); !errors.Is(err, ErrInvalidParam) {
+		t.Errorf("reading an OUT endpoint returned %v, want ErrInvalidParam", err)
+	}
+}
+
+func TestEndpointRead(t *testing.T) {
+	long := strings.Repeat("x", 64)
+	tests := []struct {
+		name    string
+		packets []string
+		size    int
+		reads   []string
+	}{
+		{"short packet", []string{"hello"}, 64, []string{"hello"}},
+		{"buffered", []string{"hello"}, 2, []string{"he", "ll", "o"}},
+		{"buffered then next packet", []string{"abc", "de"}, 2, []string{"ab", "c", "de"}},
+		{"whole packet", []string{long}, 100, []string{long}},
+		{"zero length packet", []string{""}, 64, []string{""}},
+	}
+	for _, tt := range tests {
+		i, _, send := openEndpoints(t)
+		in, err := i.Endpoint(0x81)
+		if err != nil {
+			t.Fatal(err)
+		}
+		send(tt.packets...)
+		for _, want := range tt.reads {
+			buf := make([]byte, tt.size)
+			n, err := in.Read(buf)
+			if err != nil || string(buf[:n]) != want {
+				t.Errorf("%s: read %q, %v, want %q", tt.name, buf[:n], err, want)
+			}
+		}
+	}
+}
+
+func TestEndpointClose(t *testing.T) {
+	i, _, _ := openEndpoints(t)
+	in, err := i.Endpoint(0x81)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := i.Endpoint(0x01)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	read := make(chan error, 1)
+	go func() {
+		_, err := in.Read(make([]byte, 64))
+		read <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	in.Close()
+	select {
+	case err := <-read:
+		if err != io.ErrClosedPipe {
+			t.Errorf("aborted read returned %v, want io.ErrClosedPipe", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("closing the endpoint did not abort the read")
+	}
+	if _, err := in.Read(make([]byte, 64)); err != io.ErrClosedPipe {
+		t.Errorf("read after closing returned %v, want io.ErrClosedPipe", err)
+	}
+
+	/* endpoints close independently */
+	if _, err := out.Write([]byte("x")); err != nil {
+		t.Errorf("write to the other endpoint returned %v", err)
+	}
+}
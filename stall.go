@@ -0,0 +1,187 @@
+package usb
+
+import (
+	"context"
+	"time"
+)
+
+// A StallPolicy recovers bulk and interrupt endpoints that stall. When a
+// transfer completes with TransferStall, the halt is cleared with ClearHalt,
+// which also resets the data toggles on both sides, and the transfer is
+// optionally retried.
+//
+// Retries continue a synchronous transfer from where it stalled. An
+// asynchronous transfer is only retried if it moved no data before it
+// stalled, as resubmitting it would overwrite that data; otherwise its
+// callback sees the stall once the halt is cleared.
+type StallPolicy struct {
+	// Retries is the number of times a stalled transfer is retried. With
+	// 0 the halt is cleared but the stall is still reported.
+	Retries int
+
+	// Backoff is the delay before the first retry, and is doubled before
+	// each further one.
+	Backoff time.Duration
+
+	// OnStall, if set, is called after every recovery attempt. For
+	// asynchronous transfers it runs on a goroutine of its own, before the
+	// transfer is retried or its callback runs.
+	OnStall func(StallEvent)
+}
+
+// A StallEvent describes a recovery attempt made under a StallPolicy.
+type StallEvent struct {
+	// Endpoint is the address of the endpoint that stalled.
+	Endpoint uint8
+
+	// Attempt counts the stalls of the transfer, starting at 1.
+	Attempt int
+
+	// Err is the error from clearing the halt, if it failed.
+	Err error
+
+	// Retry reports whether the transfer is being retried.
+	Retry bool
+
+	// Delay is the backoff before the retry.
+	Delay time.Duration
+}
+
+// SetStallPolicy sets the stall recovery policy for every endpoint of the
+// handle that has no policy of its own. A nil policy, the default, leaves
+// stalls to the caller.
+func (h *DeviceHandle) SetStallPolicy(p *StallPolicy) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.stall = p
+}
+
+// SetEndpointStallPolicy sets the stall recovery policy for one endpoint,
+// overriding the handle's. A nil policy reverts to the handle's.
+func (h *DeviceHandle) SetEndpointStallPolicy(endpoint uint8, p *StallPolicy) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if p == nil {
+		delete(h.endpointStall, endpoint)
+		return
+	}
+	if h.endpointStall == nil {
+		h.endpointStall = make(map[uint8]*StallPolicy)
+	}
+	h.endpointStall[endpoint] = p
+}
+
+func (h *DeviceHandle) stallPolicy(endpoint uint8) *StallPolicy {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if p, ok := h.endpointStall[endpoint]; ok {
+		return p
+	}
+	return h.stall
+}
+
+// recoverStall clears the halt on a stalled endpoint according to its
+// policy. attempt is the number of retries already made, and canRetry is
+// false if the transfer must not be retried whatever the policy. It returns
+// whether to retry and the delay before doing so.
+func (h *DeviceHandle) recoverStall(endpoint uint8, attempt int, canRetry bool) (bool, time.Duration) {
+	p := h.stallPolicy(endpoint)
+	if p == nil {
+		return false, 0
+	}
+	err := h.ClearHalt(endpoint)
+	ev := StallEvent{
+		Endpoint: endpoint,
+		Attempt:  attempt + 1,
+		Err:      err,
+		Retry:    err == nil && canRetry && attempt < p.Retries,
+	}
+	if ev.Retry {
+		ev.Delay = p.Backoff << uint(attempt)
+	}
+	if p.OnStall != nil {
+		p.OnStall(ev)
+	}
+	return ev.Retry, ev.Delay
+}
+
+// retryStalledSync waits out the backoff of a synchronous retry, returning
+// false if ctx is done first.
+func retryStalledSync(ctx context.Context, delay time.Duration) bool {
+	if delay <= 0 {
+		return true
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// recoverStall is called on the event goroutine when an asynchronous bulk
+// or interrupt transfer stalls. It returns true if the endpoint has a
+// policy, in which case the halt is cleared on a goroutine of its own, so
+// as not to hold up other completions, and the callback is only run once
+// the recovery is over.
+func (t *Transfer) recoverStall() bool {
+	tt := t.Type()
+	if (tt != TransferTypeBulk && tt != TransferTypeInterrupt) ||
+		t.handle.stallPolicy(t.Endpoint()) == nil {
+		return false
+	}
+
+	t.mu.Lock()
+	t.stalled = true
+	attempt := t.stallRetries
+	t.mu.Unlock()
+
+	go t.recover(attempt)
+	return true
+}
+
+// recover clears the halt of a stalled transfer, then either schedules its
+// retry or runs its callback.
+func (t *Transfer) recover(attempt int) {
+	retry, delay := t.handle.recoverStall(t.Endpoint(), attempt, t.xfer.actual_length == 0)
+
+	t.mu.Lock()
+	if retry && !t.cancelled {
+		t.stallRetries++
+		t.retry = time.AfterFunc(delay, t.resubmit)
+		t.mu.Unlock()
+		return
+	}
+	t.stalled = false
+	if t.cancelled {
+		t.xfer.status = LIBUSB_TRANSFER_CANCELLED
+	}
+	t.mu.Unlock()
+
+	if t.callback != nil {
+		t.callback(t)
+	}
+}
+
+// resubmit submits a stalled transfer again once its backoff has passed,
+// unless it was cancelled in the meantime. If that fails, the callback
+// finally sees the stall.
+func (t *Transfer) resubmit() {
+	t.mu.Lock()
+	t.retry = nil
+	t.stalled = false
+	r := LIBUSB_ERROR_INTERRUPTED
+	if t.cancelled {
+		t.xfer.status = LIBUSB_TRANSFER_CANCELLED
+	} else {
+		/* submitted under the lock, so that Cancel finds it in flight */
+		r = libusb_error(libusb_submit_transfer(t.xfer))
+	}
+	t.mu.Unlock()
+
+	if r < 0 && t.callback != nil {
+		t.callback(t)
+	}
+}
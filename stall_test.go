@@ -0,0 +1,126 @@
+package usb
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// openStalling opens the loopback device with a bulk IN endpoint whose
+// first transfers, as many as stalls, stall, and later ones return "ok".
+func openStalling(t *testing.T, stalls int32) *DeviceHandle {
+	t.Helper()
+	d := newLoopbackDevice()
+	var n int32
+	d.Endpoints[0x81] = func(req *SimRequest) (int, error) {
+		if atomic.AddInt32(&n, 1) <= stalls {
+			return 0, ErrPipe
+		}
+		return copy(req.Data, "ok"), nil
+	}
+	h, _ := openLoopback(t, d)
+	return h
+}
+
+func waitResult(t *testing.T, a *AsyncTransfer) TransferResult {
+	t.Helper()
+	select {
+	case res := <-a.Done():
+		return res
+	case <-time.After(5 * time.Second):
+		t.Fatal("transfer did not finish")
+	}
+	panic("unreachable")
+}
+
+func TestStallRetry(t *testing.T) {
+	h := openStalling(t, 1)
+
+	/* the halt is cleared off the event goroutine, which is free to
+	 * complete other transfers meanwhile */
+	var stalls []StallEvent
+	var controlErr error
+	h.SetStallPolicy(&StallPolicy{
+		Retries: 1,
+		OnStall: func(ev StallEvent) {
+			stalls = append(stalls, ev)
+			_, controlErr = h.Control(0xc0, 0x01, 0, 0, make([]byte, 2), time.Second)
+		},
+	})
+
+	a, err := h.Submit(TransferTypeBulk, 0x81, make([]byte, 8), 0, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := waitResult(t, a)
+	if res.Status != TransferCompleted || string(res.Data) != "ok" {
+		t.Errorf("retried transfer finished with %v, data %q", res.Status, res.Data)
+	}
+	if len(stalls) != 1 || !stalls[0].Retry || stalls[0].Err != nil {
+		t.Errorf("stall events %+v, want one successful recovery", stalls)
+	}
+	if controlErr != nil {
+		t.Errorf("control transfer during recovery: %v", controlErr)
+	}
+}
+
+func TestStallCancelRetry(t *testing.T) {
+	h := openStalling(t, 1)
+	stalled := make(chan StallEvent, 1)
+	h.SetStallPolicy(&StallPolicy{
+		Retries: 1,
+		Backoff: time.Hour,
+		OnStall: func(ev StallEvent) { stalled <- ev },
+	})
+
+	a, err := h.Submit(TransferTypeBulk, 0x81, make([]byte, 8), 0, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-stalled
+	if err := a.t.Close(); err == nil {
+		t.Error("freed a transfer waiting to be retried")
+	}
+	if err := a.Cancel(); err != nil {
+		t.Fatal(err)
+	}
+	if res := waitResult(t, a); res.Status != TransferCancelled {
+		t.Errorf("transfer finished with %v, want %v", res.Status, TransferCancelled)
+	}
+	if err := a.Cancel(); err != nil {
+		t.Errorf("cancelling a finished transfer: %v", err)
+	}
+	if err := a.t.Close(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestStallStreamClose(t *testing.T) {
+	h := openStalling(t, 1<<30)
+	stalled := make(chan StallEvent, 8)
+	h.SetStallPolicy(&StallPolicy{
+		Retries: 1,
+		Backoff: time.Hour,
+		OnStall: func(ev StallEvent) { stalled <- ev },
+	})
+
+	s, err := h.NewStream(TransferTypeBulk, 0x81, StreamConfig{Transfers: 2, Size: 64})
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-stalled
+
+	closed := make(chan struct{})
+	go func() {
+		s.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("closing a stream with a pending stall retry hung")
+	}
+	if err := s.Err(); err != nil {
+		t.Errorf("closed stream reports %v", err)
+	}
+}
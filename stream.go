@@ -0,0 +1,329 @@
+package usb
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// StreamConfig configures a Stream. Zero fields take their defaults.
+type StreamConfig struct {
+	// Transfers is the number of transfers kept in flight. Defaults to 4.
+	Transfers int
+
+	// Size is the buffer size of each transfer, which should be a multiple
+	// of the endpoint's wMaxPacketSize. Defaults to 16384.
+	Size int
+
+	// Timeout is the timeout of each transfer. A transfer that times out is
+	// resubmitted after any data it received is delivered. Defaults to no
+	// timeout.
+	Timeout time.Duration
+
+	// Queue is the number of received chunks buffered for the reader. A
+	// completed transfer is only resubmitted while the queue has room for
+	// its data, so a reader that does not keep up holds the device back
+	// rather than losing data. Defaults to twice Transfers, and is never
+	// less than Transfers.
+	Queue int
+}
+
+// A Stream continuously reads from a bulk or interrupt IN endpoint, keeping
+// several transfers queued so that the device is never left waiting for the
+// host. Received data is delivered in order, either through Read or through
+// the channel returned by Chunks; the two must not be mixed.
+//
+// A Stream stops when it is closed, when the device is unplugged or when a
+// transfer fails. Data already received can still be read after it stops.
+type Stream struct {
+	pump
+	chunks  chan []byte
+	pending []byte
+
+	forward sync.Once
+	out     chan []byte
+}
+
+// NewStream starts streaming from an IN endpoint, which must be of type
+// TransferTypeBulk or TransferTypeInterrupt.
+func (h *DeviceHandle) NewStream(tt TransferType, endpoint uint8, config StreamConfig) (*Stream, error) {
+	if (tt != TransferTypeBulk && tt != TransferTypeInterrupt) ||
+		endpoint&LIBUSB_ENDPOINT_DIR_MASK != uint8(LIBUSB_ENDPOINT_IN) {
+		return nil, h.transferError("open stream", endpoint, LIBUSB_ERROR_INVALID_PARAM, 0)
+	}
+	if config.Transfers <= 0 {
+		config.Transfers = 4
+	}
+	if config.Size <= 0 {
+		config.Size = 16384
+	}
+	if config.Queue <= 0 {
+		config.Queue = 2 * config.Transfers
+	} else if config.Queue < config.Transfers {
+		config.Queue = config.Transfers
+	}
+
+	s := &Stream{
+		chunks: make(chan []byte, config.Queue),
+	}
+	s.deliver = s.deliverChunk
+	s.room = func() bool { return len(s.chunks)+s.active < cap(s.chunks) }
+	s.closeQueue = func() { close(s.chunks) }
+	s.transfers = make([]*Transfer, config.Transfers)
+	for i := range s.transfers {
+		s.transfers[i] = h.NewTransfer(tt, endpoint, make([]byte, config.Size), 0,
+			config.Timeout, s.complete)
+	}
+	if err := s.start(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// deliverChunk queues the data of a completed transfer. Transfers are only
+// in flight while the queue has room for their data, so this never blocks.
+func (s *Stream) deliverChunk(t *Transfer) {
+	if t.ActualLength() == 0 {
+		return
+	}
+	s.chunks <- append([]byte(nil), t.Data()...)
+}
+
+// Read reads received data into p. Once the stream has stopped and all
+// buffered data has been read, it returns io.EOF if the stream was closed
+// and the error that stopped it otherwise.
+func (s *Stream) Read(p []byte) (int, error) {
+	if len(s.pending) == 0 {
+		chunk, ok := <-s.chunks
+		if !ok {
+			if err := s.Err(); err != nil {
+				return 0, err
+			}
+			return 0, io.EOF
+		}
+		s.release()
+		s.pending = chunk
+	}
+	n := copy(p, s.pending)
+	s.pending = s.pending[n:]
+	return n, nil
+}
+
+// Chunks returns the channel the stream delivers data on, one slice per
+// completed transfer. It is closed once the stream has stopped and all
+// received data has been delivered.
+func (s *Stream) Chunks() <-chan []byte {
+	s.forward.Do(func() {
+		/* the queue is drained through out, so that taking a chunk
+		 * makes room for a held transfer */
+		s.out = make(chan []byte)
+		go func() {
+			for chunk := range s.chunks {
+				s.release()
+				s.out <- chunk
+			}
+			close(s.out)
+		}()
+	})
+	return s.out
+}
+
+// A pump keeps a ring of transfers in flight on one endpoint, resubmitting
+// each from its callback, until it is stopped. It is the engine shared by
+// Stream and IsoStream, which supply deliver to queue the data of a
+// completed transfer and closeQueue to close that queue once the pump has
+// stopped.
+//
+// If room is set, a completed transfer is only resubmitted while room
+// reports that the queue can take the data of one more transfer than are in
+// flight. Otherwise it is held until the reader calls release.
+type pump struct {
+	transfers  []*Transfer
+	deliver    func(*Transfer)
+	room       func() bool
+	closeQueue func()
+
+	mu       sync.Mutex
+	active   int
+	held     []*Transfer
+	stopping bool
+	finished bool
+	err      error
+	stopped  chan struct{}
+}
+
+// start submits every transfer in the ring. If one cannot be submitted the
+// others are cancelled and waited for.
+func (p *pump) start() error {
+	p.stopped = make(chan struct{})
+	for _, t := range p.transfers {
+		p.mu.Lock()
+		p.active++
+		p.mu.Unlock()
+		if err := p.submit(t); err != nil {
+			p.stop(err)
+			<-p.stopped
+			return err
+		}
+	}
+	return nil
+}
+
+// submit queues t, which the caller has already counted as active so that
+// the pump cannot finish while t is being submitted. A transfer that is
+// submitted while the pump is being stopped is cancelled straight away, as
+// stop may have missed it.
+func (p *pump) submit(t *Transfer) error {
+	err := t.Submit()
+
+	p.mu.Lock()
+	if err != nil {
+		p.active--
+		finished := p.finishLocked()
+		p.mu.Unlock()
+		if finished {
+			p.finish()
+		}
+		return err
+	}
+	stopping := p.stopping
+	p.mu.Unlock()
+
+	if stopping {
+		t.Cancel()
+	}
+	return nil
+}
+
+// resubmit submits a completed transfer again, stopping the pump if that
+// fails.
+func (p *pump) resubmit(t *Transfer) {
+	if err := p.submit(t); err != nil {
+		p.stop(err)
+	}
+}
+
+// complete runs for each finished transfer, on the event goroutine unless
+// the transfer recovered from a stall. It must not block, so a transfer that
+// room keeps from being resubmitted is held rather than waited on.
+func (p *pump) complete(t *Transfer) {
+	var cancel []*Transfer
+	resubmit := false
+
+	p.mu.Lock()
+	p.active--
+	switch t.Status() {
+	case TransferCompleted, TransferTimedOut:
+		p.deliver(t)
+		if p.stopping {
+			break
+		}
+		if p.room != nil && !p.room() {
+			p.held = append(p.held, t)
+			break
+		}
+		p.active++
+		resubmit = true
+	case TransferCancelled:
+	default:
+		cancel = p.stopLocked(t.Err())
+	}
+	finished := p.finishLocked()
+	p.mu.Unlock()
+
+	for _, c := range cancel {
+		c.Cancel()
+	}
+	if finished {
+		p.finish()
+	}
+	if resubmit {
+		p.resubmit(t)
+	}
+}
+
+// release resubmits the oldest held transfer, if the reader has made room
+// for its data.
+func (p *pump) release() {
+	p.mu.Lock()
+	if p.stopping || len(p.held) == 0 || !p.room() {
+		p.mu.Unlock()
+		return
+	}
+	t := p.held[0]
+	p.held = p.held[1:]
+	p.active++
+	p.mu.Unlock()
+
+	p.resubmit(t)
+}
+
+func (p *pump) stop(err error) {
+	p.mu.Lock()
+	cancel := p.stopLocked(err)
+	finished := p.finishLocked()
+	p.mu.Unlock()
+
+	for _, t := range cancel {
+		t.Cancel()
+	}
+	if finished {
+		p.finish()
+	}
+}
+
+// stopLocked records why the pump stopped and returns the transfers to
+// cancel, which the caller does once p.mu is unlocked: a cancellation may
+// complete the transfer straight away. Only the first call has any effect.
+func (p *pump) stopLocked(err error) []*Transfer {
+	if p.stopping {
+		return nil
+	}
+	p.stopping = true
+	p.err = err
+	p.held = nil
+	return p.transfers
+}
+
+// finishLocked reports whether a stopping pump has just been left with no
+// transfers in flight, in which case the caller must call finish once p.mu
+// is unlocked. It reports so only once.
+func (p *pump) finishLocked() bool {
+	if !p.stopping || p.active > 0 || p.finished {
+		return false
+	}
+	p.finished = true
+	return true
+}
+
+// finish frees the transfers and closes the queue of a pump that has
+// finished.
+func (p *pump) finish() {
+	for _, t := range p.transfers {
+		t.Close()
+	}
+	p.closeQueue()
+	close(p.stopped)
+}
+
+// Err returns the error that stopped the stream, or nil if it is still
+// running or was stopped by Close.
+func (p *pump) Err() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.err
+}
+
+// Stopped returns a channel that is closed once the stream has stopped and
+// none of its transfers are in flight.
+func (p *pump) Stopped() <-chan struct{} {
+	return p.stopped
+}
+
+// Close stops the stream, cancelling its transfers and waiting for them to
+// finish. Buffered data can still be read afterwards.
+func (p *pump) Close() error {
+	p.stop(nil)
+	<-p.stopped
+	return nil
+}
@@ -0,0 +1,135 @@
+package usb
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// openCounting opens the loopback device with a bulk IN endpoint that
+// answers each transfer with the next value of a 16 bit counter. calls
+// counts the transfers it has answered.
+func openCounting(t *testing.T) (h *DeviceHandle, calls *int32) {
+	t.Helper()
+	calls = new(int32)
+	d := newLoopbackDevice()
+	d.Endpoints[0x81] = func(req *SimRequest) (int, error) {
+		n := atomic.AddInt32(calls, 1)
+		return copy(req.Data, binary.LittleEndian.AppendUint16(nil, uint16(n-1))), nil
+	}
+	h, _ = openLoopback(t, d)
+	return h, calls
+}
+
+// readCounts reads n counter values from r, failing unless they follow on
+// from next.
+func readCounts(t *testing.T, r io.Reader, next uint16, n int) {
+	t.Helper()
+	buf := make([]byte, 2)
+	for i := 0; i < n; i++ {
+		if _, err := io.ReadFull(r, buf); err != nil {
+			t.Fatal(err)
+		}
+		if got := binary.LittleEndian.Uint16(buf); got != next {
+			t.Fatalf("read count %d, want %d", got, next)
+		}
+		next++
+	}
+}
+
+func TestStreamRead(t *testing.T) {
+	h, _ := openCounting(t)
+	s, err := h.NewStream(TransferTypeBulk, 0x81, StreamConfig{Transfers: 4, Size: 64})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	readCounts(t, s, 0, 100)
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	/* what was received before the close is still read, in order */
+	buf := make([]byte, 2)
+	for {
+		if _, err := io.ReadFull(s, buf); err != nil {
+			if err != io.EOF {
+				t.Errorf("read from a closed stream returned %v, want io.EOF", err)
+			}
+			break
+		}
+	}
+}
+
+func TestStreamBackpressure(t *testing.T) {
+	h, calls := openCounting(t)
+	const queue = 6
+	s, err := h.NewStream(TransferTypeBulk, 0x81, StreamConfig{Transfers: 4, Size: 64, Queue: queue})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+
+	/* a reader that falls behind holds the device back */
+	time.Sleep(50 * time.Millisecond)
+	if n := atomic.LoadInt32(calls); n > queue {
+		t.Errorf("device answered %d transfers with nobody reading, want at most %d", n, queue)
+	}
+	/* and loses nothing */
+	readCounts(t, s, 0, 50)
+	time.Sleep(50 * time.Millisecond)
+	if n := atomic.LoadInt32(calls); n > 50+queue {
+		t.Errorf("device answered %d transfers after 50 were read, want at most %d", n, 50+queue)
+	}
+	readCounts(t, s, 50, 50)
+}
+
+func TestStreamChunks(t *testing.T) {
+	h, calls := openCounting(t)
+	s, err := h.NewStream(TransferTypeBulk, 0x81, StreamConfig{Transfers: 2, Size: 64, Queue: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	chunks := s.Chunks()
+	time.Sleep(20 * time.Millisecond)
+	/* the queue, and the chunk waiting to be taken from the channel */
+	if n := atomic.LoadInt32(calls); n > 3 {
+		t.Errorf("device answered %d transfers with nobody reading, want at most 3", n)
+	}
+	for want := uint16(0); want < 20; want++ {
+		chunk := <-chunks
+		if got := binary.LittleEndian.Uint16(chunk); len(chunk) != 2 || got != want {
+			t.Fatalf("chunk % x, want count %d", chunk, want)
+		}
+	}
+	s.Close()
+	for range chunks {
+	}
+}
+
+func TestStreamError(t *testing.T) {
+	d := newLoopbackDevice()
+	var calls int32
+	d.Endpoints[0x81] = func(req *SimRequest) (int, error) {
+		if atomic.AddInt32(&calls, 1) > 3 {
+			return 0, ErrNoDevice
+		}
+		return copy(req.Data, "ok"), nil
+	}
+	h, _ := openLoopback(t, d)
+	s, err := h.NewStream(TransferTypeBulk, 0x81, StreamConfig{Transfers: 1, Size: 64})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	/* the data received before the failure comes first */
+	got, err := io.ReadAll(s)
+	if string(got) != "okokok" || !errors.Is(err, ErrNoDevice) {
+		t.Errorf("read %q, %v, want \"okokok\" and ErrNoDevice", got, err)
+	}
+	<-s.Stopped()
+}
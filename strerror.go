@@ -206,17 +206,17 @@ func libusb_error_name(error_code libusb_error) string {
 		return "LIBUSB_ERROR_NOT_SUPPORTED"
 	case LIBUSB_ERROR_OTHER:
 		return "LIBUSB_ERROR_OTHER"
-	case LIBUSB_TRANSFER_ERROR:
+	case libusb_error(LIBUSB_TRANSFER_ERROR):
 		return "LIBUSB_TRANSFER_ERROR"
-	case LIBUSB_TRANSFER_TIMED_OUT:
+	case libusb_error(LIBUSB_TRANSFER_TIMED_OUT):
 		return "LIBUSB_TRANSFER_TIMED_OUT"
-	case LIBUSB_TRANSFER_CANCELLED:
+	case libusb_error(LIBUSB_TRANSFER_CANCELLED):
 		return "LIBUSB_TRANSFER_CANCELLED"
-	case LIBUSB_TRANSFER_STALL:
+	case libusb_error(LIBUSB_TRANSFER_STALL):
 		return "LIBUSB_TRANSFER_STALL"
-	case LIBUSB_TRANSFER_NO_DEVICE:
+	case libusb_error(LIBUSB_TRANSFER_NO_DEVICE):
 		return "LIBUSB_TRANSFER_NO_DEVICE"
-	case LIBUSB_TRANSFER_OVERFLOW:
+	case libusb_error(LIBUSB_TRANSFER_OVERFLOW):
 		return "LIBUSB_TRANSFER_OVERFLOW"
 	case 0:
 		return "LIBUSB_SUCCESS / LIBUSB_TRANSFER_COMPLETED"
@@ -224,3 +224,9 @@ func libusb_error_name(error_code libusb_error) string {
 		return "**UNKNOWN**"
 	}
 }
+
+// Error makes libusb_error usable as a Go error. The message is the
+// localized description from libusb_strerror.
+func (e libusb_error) Error() string {
+	return libusb_strerror(e)
+}
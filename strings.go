@@ -0,0 +1,143 @@
+package usb
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// LangIDEnglishUS is the LANGID of US English, the language most devices
+// provide their strings in.
+const LangIDEnglishUS = 0x0409
+
+// stringKey identifies a cached string descriptor.
+type stringKey struct {
+	index  uint8
+	langID uint16
+}
+
+// stringDescriptorData checks the header of a string descriptor and returns
+// its UTF-16 data.
+func stringDescriptorData(path string, desc []byte) ([]byte, error) {
+	if len(desc) < DESC_HEADER_LENGTH {
+		return nil, &DescriptorError{path, 0, fmt.Sprintf("%d bytes is too short for the header", len(desc))}
+	}
+	n := int(desc[0])
+	switch {
+	case n < DESC_HEADER_LENGTH || n > len(desc):
+		return nil, &DescriptorError{path, 0, fmt.Sprintf("invalid bLength %d", n)}
+	case desc[1] != uint8(LIBUSB_DT_STRING):
+		return nil, &DescriptorError{path, 1, fmt.Sprintf("bDescriptorType 0x%02x is not a string descriptor", desc[1])}
+	case n%2 != 0:
+		return nil, &DescriptorError{path, 0, fmt.Sprintf("bLength %d is odd", n)}
+	}
+	return desc[DESC_HEADER_LENGTH:n], nil
+}
+
+// Languages returns the LANGIDs of the languages the device provides its
+// strings in, read from string descriptor 0. The table is read once and
+// cached for the life of the handle.
+func (h *DeviceHandle) Languages() ([]uint16, error) {
+	const op = "get string languages"
+	h.langMu.Lock()
+	defer h.langMu.Unlock()
+	if h.langIDs == nil {
+		buf := make([]byte, 255)
+		r := libusb_get_string_descriptor(h.handle, 0, 0, buf, len(buf))
+		if r < 0 {
+			return nil, h.codeError(op, r)
+		}
+		data, err := stringDescriptorData("string[0]", buf[:r])
+		if err == nil && len(data) == 0 {
+			err = &DescriptorError{"string[0]", 0, "no languages"}
+		}
+		if err != nil {
+			return nil, h.dev.descriptorError(op, err)
+		}
+		h.langIDs = make([]uint16, len(data)/2)
+		for i := range h.langIDs {
+			h.langIDs[i] = binary.LittleEndian.Uint16(data[2*i:])
+		}
+	}
+	return append([]uint16(nil), h.langIDs...), nil
+}
+
+// SetPreferredLanguages sets the LANGIDs of the languages StringDescriptor
+// should read strings in, most preferred first.
+func (h *DeviceHandle) SetPreferredLanguages(langIDs ...uint16) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.preferredLangIDs = append([]uint16(nil), langIDs...)
+}
+
+// Language returns the LANGID StringDescriptor reads strings in: the first
+// preferred language the device provides, else the first one it provides
+// in a dialect of a preferred language, else the device's first language.
+func (h *DeviceHandle) Language() (uint16, error) {
+	langIDs, err := h.Languages()
+	if err != nil {
+		return 0, err
+	}
+	h.mu.Lock()
+	preferred := h.preferredLangIDs
+	h.mu.Unlock()
+	for _, p := range preferred {
+		for _, l := range langIDs {
+			if l == p {
+				return l, nil
+			}
+		}
+	}
+	for _, p := range preferred {
+		for _, l := range langIDs {
+			if l&0x3ff == p&0x3ff {
+				return l, nil
+			}
+		}
+	}
+	return langIDs[0], nil
+}
+
+// StringDescriptor reads a string descriptor in the language chosen by
+// Language.
+func (h *DeviceHandle) StringDescriptor(index uint8) (string, error) {
+	langID, err := h.Language()
+	if err != nil {
+		return "", err
+	}
+	return h.StringDescriptorLang(index, langID)
+}
+
+// StringDescriptorLang reads a string descriptor in the language langID,
+// decoding it from UTF-16. Strings are cached for the life of the handle.
+func (h *DeviceHandle) StringDescriptorLang(index uint8, langID uint16) (string, error) {
+	const op = "get string descriptor"
+	if index == 0 {
+		return "", h.codeError(op, LIBUSB_ERROR_INVALID_PARAM)
+	}
+	key := stringKey{index, langID}
+	h.mu.Lock()
+	s, ok := h.strings[key]
+	h.mu.Unlock()
+	if ok {
+		return s, nil
+	}
+
+	buf := make([]byte, 255)
+	r := libusb_get_string_descriptor(h.handle, index, langID, buf, len(buf))
+	if r < 0 {
+		return "", h.codeError(op, r)
+	}
+	data, err := stringDescriptorData(fmt.Sprintf("string[%d]", index), buf[:r])
+	if err != nil {
+		return "", h.dev.descriptorError(op, err)
+	}
+	s = utf16String(data)
+
+	h.mu.Lock()
+	if h.strings == nil {
+		h.strings = make(map[stringKey]string)
+	}
+	h.strings[key] = s
+	h.mu.Unlock()
+	return s, nil
+}
@@ -0,0 +1,159 @@
+package usb
+
+import (
+	"encoding/binary"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// stringDesc builds a string descriptor holding units.
+func stringDesc(units ...uint16) []byte {
+	desc := []byte{uint8(DESC_HEADER_LENGTH + 2*len(units)), uint8(LIBUSB_DT_STRING)}
+	for _, u := range units {
+		desc = binary.LittleEndian.AppendUint16(desc, u)
+	}
+	return desc
+}
+
+// openStrings opens the loopback device serving string descriptor 0 with
+// langIDs and the other string descriptors from strs. requests counts the
+// GET_DESCRIPTOR requests made for each string index.
+func openStrings(t *testing.T, langIDs []uint16, strs map[stringKey][]byte) (h *DeviceHandle, requests *[256]int32) {
+	t.Helper()
+	requests = new([256]int32)
+	d := newLoopbackDevice()
+	d.Control = func(req *SimRequest) (int, error) {
+		if req.RequestType != 0x80 || req.Request != uint8(LIBUSB_REQUEST_GET_DESCRIPTOR) ||
+			req.Value>>8 != uint16(LIBUSB_DT_STRING) {
+			return 0, ErrNotSupported
+		}
+		index := uint8(req.Value)
+		atomic.AddInt32(&requests[index], 1)
+		if index == 0 {
+			/* slow enough for concurrent callers to overlap */
+			time.Sleep(10 * time.Millisecond)
+			return copy(req.Data, stringDesc(langIDs...)), nil
+		}
+		desc, ok := strs[stringKey{index, req.Index}]
+		if !ok {
+			return 0, ErrPipe
+		}
+		return copy(req.Data, desc), nil
+	}
+	h, _ = openLoopback(t, d)
+	return h, requests
+}
+
+func TestLanguage(t *testing.T) {
+	tests := []struct {
+		name      string
+		langIDs   []uint16
+		preferred []uint16
+		want      uint16
+	}{
+		{"no preference", []uint16{0x0407, 0x0409}, nil, 0x0407},
+		{"preferred", []uint16{0x0407, 0x0409}, []uint16{0x0409}, 0x0409},
+		{"second preference", []uint16{0x0407, 0x0409}, []uint16{0x040c, 0x0409}, 0x0409},
+		{"dialect", []uint16{0x0407, 0x0809}, []uint16{0x0409}, 0x0809},
+		{"exact before dialect", []uint16{0x0809, 0x0407}, []uint16{0x0409, 0x0407}, 0x0407},
+		{"unavailable", []uint16{0x0407}, []uint16{0x040c}, 0x0407},
+	}
+	for _, tt := range tests {
+		h, _ := openStrings(t, tt.langIDs, nil)
+		h.SetPreferredLanguages(tt.preferred...)
+		got, err := h.Language()
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s: language 0x%04x, want 0x%04x", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestStringDescriptorLang(t *testing.T) {
+	tests := []struct {
+		name string
+		desc []byte
+		want string
+	}{
+		{"ASCII", stringDesc('A', 'c', 'm', 'e'), "Acme"},
+		{"BMP", stringDesc('K', 0xf6, 'l', 'n'), "Köln"},
+		{"surrogate pair", stringDesc('o', 'k', 0xd83d, 0xde00), "ok\U0001f600"},
+		{"unpaired surrogate", stringDesc(0xd83d, 'x'), "\ufffdx"},
+		{"terminating NUL", stringDesc('h', 'i', 0), "hi"},
+		{"empty", stringDesc(), ""},
+	}
+	strs := make(map[stringKey][]byte)
+	for i, tt := range tests {
+		strs[stringKey{uint8(i + 1), LangIDEnglishUS}] = tt.desc
+	}
+	strs[stringKey{1, 0x0407}] = stringDesc('D', 'e')
+	strs[stringKey{100, LangIDEnglishUS}] = []byte{0x05, 0x03, 'a', 0, 'b'}
+	strs[stringKey{101, LangIDEnglishUS}] = []byte{0x04, 0x02, 'a', 0}
+	h, _ := openStrings(t, []uint16{LangIDEnglishUS, 0x0407}, strs)
+
+	for i, tt := range tests {
+		got, err := h.StringDescriptorLang(uint8(i+1), LangIDEnglishUS)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s: read %q, want %q", tt.name, got, tt.want)
+		}
+	}
+	if got, err := h.StringDescriptorLang(1, 0x0407); err != nil || got != "De" {
+		t.Errorf("string 1 in German is %q, %v, want \"De\"", got, err)
+	}
+	for _, index := range []uint8{100, 101} {
+		var derr *DescriptorError
+		if _, err := h.StringDescriptorLang(index, LangIDEnglishUS); !errors.As(err, &derr) {
+			t.Errorf("string %d returned %v, want a *DescriptorError", index, err)
+		}
+	}
+	if _, err := h.StringDescriptorLang(0, LangIDEnglishUS); !errors.Is(err, ErrInvalidParam) {
+		t.Errorf("string 0 returned %v, want ErrInvalidParam", err)
+	}
+}
+
+func TestStringCache(t *testing.T) {
+	strs := map[stringKey][]byte{{1, LangIDEnglishUS}: stringDesc('A', 'c', 'm', 'e')}
+	h, requests := openStrings(t, []uint16{LangIDEnglishUS}, strs)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := h.Languages(); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	for i := 0; i < 2; i++ {
+		if s, err := h.StringDescriptor(1); err != nil || s != "Acme" {
+			t.Errorf("string 1 is %q, %v, want \"Acme\"", s, err)
+		}
+	}
+	if n := atomic.LoadInt32(&requests[0]); n != 1 {
+		t.Errorf("read the language table %d times, want once", n)
+	}
+	if n := atomic.LoadInt32(&requests[1]); n != 1 {
+		t.Errorf("read string 1 %d times, want once", n)
+	}
+
+	/* failures are not cached */
+	if _, err := h.StringDescriptor(2); !errors.Is(err, ErrPipe) {
+		t.Errorf("missing string returned %v, want ErrPipe", err)
+	}
+	h.StringDescriptor(2)
+	if n := atomic.LoadInt32(&requests[2]); n != 2 {
+		t.Errorf("read a missing string %d times, want twice", n)
+	}
+}
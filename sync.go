@@ -1,5 +1,10 @@
 package usb
 
+import (
+	"context"
+	"time"
+)
+
 /*
  * Synchronous I/O functions for libusb
  * Copyright © 2007-2008 Daniel Drake <dsd@gentoo.org>
@@ -27,26 +32,95 @@ package usb
  * may wish to consider using the \ref libusb_asyncio "asynchronous I/O API" instead.
  */
 
+/*
+ * sync_transfer_cb is the callback for transfers submitted by the functions
+ * below; their user_data is a channel that is closed on completion.
+ */
 func sync_transfer_cb(transfer *libusb_transfer) {
-	*transfer.user_data = 1
+	close(transfer.user_data.(chan struct{}))
 }
 
-func sync_transfer_wait_for_completion(transfer *libusb_transfer) {
-	var completed *int = transfer.user_data //user_data is probably an interface{}?
+/*
+ * sync_transfer_wait_for_completion blocks until a transfer submitted with
+ * sync_transfer_cb has completed. Completions are dispatched by the event
+ * goroutine, so unlike libusb there is no need to handle events here.
+ *
+ * If gctx is done first, the transfer is cancelled and its cancellation is
+ * waited for, so that the buffer is no longer in use once this returns.
+ * by_deadline tells that the transfer was submitted with the time left until
+ * gctx's deadline as its timeout, so that its timing out also counts as
+ * gctx being done. Returns 1 if the transfer ended because of gctx, or 0 if
+ * it completed (successfully or not) by itself.
+ */
+func sync_transfer_wait_for_completion(gctx context.Context, transfer *libusb_transfer, by_deadline bool) int {
+	completed := transfer.user_data.(chan struct{})
 
-	ctx := transfer.dev_handle.dev.ctx
+	select {
+	case <-completed:
+	case <-gctx.Done():
+		/* LIBUSB_ERROR_NOT_FOUND means the transfer is already completing */
+		libusb_cancel_transfer(transfer)
+		<-completed
+	}
 
-	for *completed == 0 {
-		r := libusb_handle_events_completed(ctx, completed)
-		if r < 0 {
-			if r == LIBUSB_ERROR_INTERRUPTED {
-				continue
-			}
-			// usbi_err(ctx, "libusb_handle_events failed: %s, cancelling transfer and retrying",
-			//  libusb_error_name(r));
-			libusb_cancel_transfer(transfer)
-			continue
-		}
+	if by_deadline && transfer.status == LIBUSB_TRANSFER_TIMED_OUT {
+		/* the deadline is due, if it has not passed already */
+		<-gctx.Done()
+		return 1
+	}
+	/* the transfer may have completed before the cancellation took effect, in
+	 * which case its result stands */
+	if transfer.status == LIBUSB_TRANSFER_CANCELLED && gctx.Err() != nil {
+		return 1
+	}
+	return 0
+}
+
+/*
+ * sync_transfer_timeout returns the timeout to submit a synchronous transfer
+ * bounded by gctx with: the smaller of timeout (0 meaning unlimited) and the
+ * time left until gctx's deadline. by_deadline is true if the deadline is
+ * the smaller.
+ */
+func sync_transfer_timeout(gctx context.Context, timeout time.Duration) (_ time.Duration, by_deadline bool) {
+	deadline, ok := gctx.Deadline()
+	if !ok {
+		return timeout, false
+	}
+	left := time.Until(deadline)
+	if timeout > 0 && timeout <= left {
+		return timeout, false
+	}
+	if left <= 0 {
+		/* a zero timeout would be unlimited */
+		left = time.Nanosecond
+	}
+	return left, true
+}
+
+/*
+ * sync_transfer_result converts the status of a finished synchronous transfer
+ * into an error code.
+ */
+func sync_transfer_result(transfer *libusb_transfer) libusb_error {
+	switch transfer.status {
+	case LIBUSB_TRANSFER_COMPLETED:
+		return LIBUSB_SUCCESS
+	case LIBUSB_TRANSFER_TIMED_OUT:
+		return LIBUSB_ERROR_TIMEOUT
+	case LIBUSB_TRANSFER_STALL:
+		return LIBUSB_ERROR_PIPE
+	case LIBUSB_TRANSFER_NO_DEVICE:
+		return LIBUSB_ERROR_NO_DEVICE
+	case LIBUSB_TRANSFER_OVERFLOW:
+		return LIBUSB_ERROR_OVERFLOW
+	case LIBUSB_TRANSFER_ERROR,
+		LIBUSB_TRANSFER_CANCELLED:
+		return LIBUSB_ERROR_IO
+	default:
+		// usbi_warn(TRANSFER_CTX(transfer),
+		// "unrecognised status code %d", transfer.status);
+		return LIBUSB_ERROR_OTHER
 	}
 }
 
@@ -76,81 +150,81 @@ func sync_transfer_wait_for_completion(transfer *libusb_transfer) {
  * \returns LIBUSB_ERROR_PIPE if the control request was not supported by the
  * device
  * \returns LIBUSB_ERROR_NO_DEVICE if the device has been disconnected
- * \returns LIBUSB_ERROR_BUSY if called from event handling context
  * \returns LIBUSB_ERROR_INVALID_PARAM if the transfer size is larger than
  * the operating system and/or hardware can support
  * \returns another LIBUSB_ERROR code on other failures
+ *
+ * \note This function must not be called from a transfer or hotplug callback:
+ * callbacks run on the context's event goroutine, which would then never get
+ * to complete this transfer, and the call deadlocks.
  */
 func libusb_control_transfer(dev_handle *libusb_device_handle,
 	bmRequestType libusb_endpoint_direction, bRequest libusb_standard_request, wValue uint16, wIndex uint16,
 	data []uint8, wLength uint16, timeout uint) libusb_error {
+	return libusb_control_transfer_context(context.Background(), dev_handle,
+		uint8(bmRequestType), uint8(bRequest), wValue, wIndex, data, wLength,
+		nil, time.Duration(timeout)*time.Millisecond)
+}
 
-	var completed int
+/*
+ * libusb_control_transfer_context is libusb_control_transfer with a
+ * time.Duration timeout that additionally gives up when gctx is done. In that
+ * case the transfer is cancelled and LIBUSB_ERROR_INTERRUPTED is returned.
+ * The transfer's timeout is cut short to gctx's deadline, if it has one.
+ * Unless it is NULL, transferred receives the number of data bytes moved,
+ * including by a transfer that did not complete.
+ */
+func libusb_control_transfer_context(gctx context.Context, dev_handle *libusb_device_handle,
+	bmRequestType, bRequest uint8, wValue, wIndex uint16,
+	data []uint8, wLength uint16, transferred *int, timeout time.Duration) libusb_error {
 
 	transfer := libusb_alloc_transfer(0)
-	buffer := make([]uint8, LIBUSB_CONTROL_SETUP_SIZE)
+	buffer := make([]uint8, LIBUSB_CONTROL_SETUP_SIZE+int(wLength))
 
 	libusb_fill_control_setup(buffer, bmRequestType, bRequest, wValue, wIndex, wLength)
 
-	if (bmRequestType & LIBUSB_ENDPOINT_DIR_MASK) == LIBUSB_ENDPOINT_OUT {
-		buffer = append(buffer, data[0:wLength])
-	} else {
-		buffer = make([]uint8, LIBUSB_CONTROL_SETUP_SIZE+wLength)
+	if (bmRequestType & LIBUSB_ENDPOINT_DIR_MASK) == uint8(LIBUSB_ENDPOINT_OUT) {
+		copy(buffer[LIBUSB_CONTROL_SETUP_SIZE:], data[:wLength])
 	}
 
+	timeout, by_deadline := sync_transfer_timeout(gctx, timeout)
 	libusb_fill_control_transfer(transfer, dev_handle, buffer,
-		sync_transfer_cb, &completed, timeout)
+		sync_transfer_cb, make(chan struct{}), durationToMillis(timeout))
 
-	transfer.flags = LIBUSB_TRANSFER_FREE_BUFFER
+	transfer.flags = uint8(LIBUSB_TRANSFER_FREE_BUFFER)
 
 	r := libusb_submit_transfer(transfer)
 	if r < 0 {
-		return r
+		return libusb_error(r)
 	}
 
-	sync_transfer_wait_for_completion(transfer)
+	interrupted := sync_transfer_wait_for_completion(gctx, transfer, by_deadline)
 
-	if (bmRequestType & LIBUSB_ENDPOINT_DIR_MASK) == LIBUSB_ENDPOINT_IN {
-		tdata := libusb_control_transfer_get_data(transfer)
-		for i := 0; i < transfer.actual_length; i++ {
-			data[i] = tdata[i]
-		}
+	if (bmRequestType & LIBUSB_ENDPOINT_DIR_MASK) == uint8(LIBUSB_ENDPOINT_IN) {
+		copy(data, libusb_control_transfer_get_data(transfer)[:transfer.actual_length])
 	}
-
-	switch transfer.status {
-	case LIBUSB_TRANSFER_COMPLETED:
-		r = transfer.actual_length
-	case LIBUSB_TRANSFER_TIMED_OUT:
-		r = LIBUSB_ERROR_TIMEOUT
-	case LIBUSB_TRANSFER_STALL:
-		r = LIBUSB_ERROR_PIPE
-	case LIBUSB_TRANSFER_NO_DEVICE:
-		r = LIBUSB_ERROR_NO_DEVICE
-	case LIBUSB_TRANSFER_OVERFLOW:
-		r = LIBUSB_ERROR_OVERFLOW
-	case LIBUSB_TRANSFER_ERROR:
-		fallthrough
-	case LIBUSB_TRANSFER_CANCELLED:
-		r = LIBUSB_ERROR_IO
-	default:
-		// usbi_warn(dev_handle.dev.ctx,
-		// "unrecognised status code %d", transfer.status);
-		r = LIBUSB_ERROR_OTHER
+	if transferred != nil {
+		*transferred = transfer.actual_length
 	}
 
-	return r
+	if interrupted != 0 {
+		return LIBUSB_ERROR_INTERRUPTED
+	}
+	if err := sync_transfer_result(transfer); err < 0 {
+		return err
+	}
+	return libusb_error(transfer.actual_length)
 }
 
-func do_sync_bulk_transfer(dev_handle *libusb_device_handle,
+func do_sync_bulk_transfer(gctx context.Context, dev_handle *libusb_device_handle,
 	endpoint uint8, buffer []uint8, length int,
-	transferred *int, timeout uint, _type uint8) int {
-
-	completed := 0
+	transferred *int, timeout time.Duration, _type uint8) int {
 
 	transfer := libusb_alloc_transfer(0)
 
+	timeout, by_deadline := sync_transfer_timeout(gctx, timeout)
 	libusb_fill_bulk_transfer(transfer, dev_handle, endpoint, buffer, length,
-		sync_transfer_cb, &completed, timeout)
+		sync_transfer_cb, make(chan struct{}), durationToMillis(timeout))
 	transfer._type = _type
 
 	r := libusb_submit_transfer(transfer)
@@ -158,34 +232,16 @@ func do_sync_bulk_transfer(dev_handle *libusb_device_handle,
 		return r
 	}
 
-	sync_transfer_wait_for_completion(transfer)
+	interrupted := sync_transfer_wait_for_completion(gctx, transfer, by_deadline)
 
 	if transferred != nil {
 		*transferred = transfer.actual_length
 	}
 
-	switch transfer.status {
-	case LIBUSB_TRANSFER_COMPLETED:
-		r = 0
-	case LIBUSB_TRANSFER_TIMED_OUT:
-		r = LIBUSB_ERROR_TIMEOUT
-	case LIBUSB_TRANSFER_STALL:
-		r = LIBUSB_ERROR_PIPE
-	case LIBUSB_TRANSFER_OVERFLOW:
-		r = LIBUSB_ERROR_OVERFLOW
-	case LIBUSB_TRANSFER_NO_DEVICE:
-		r = LIBUSB_ERROR_NO_DEVICE
-	case LIBUSB_TRANSFER_ERROR:
-		fallthrough
-	case LIBUSB_TRANSFER_CANCELLED:
-		r = LIBUSB_ERROR_IO
-	default:
-		// usbi_warn(dev_handle.dev.ctx,
-		// "unrecognised status code %d", transfer.status);
-		r = LIBUSB_ERROR_OTHER
+	if interrupted != 0 {
+		return int(LIBUSB_ERROR_INTERRUPTED)
 	}
-
-	return r
+	return int(sync_transfer_result(transfer))
 }
 
 /** \ingroup libusb_syncio
@@ -228,13 +284,16 @@ func do_sync_bulk_transfer(dev_handle *libusb_device_handle,
  * \returns LIBUSB_ERROR_OVERFLOW if the device offered more data, see
  * \ref libusb_packetoverflow
  * \returns LIBUSB_ERROR_NO_DEVICE if the device has been disconnected
- * \returns LIBUSB_ERROR_BUSY if called from event handling context
  * \returns another LIBUSB_ERROR code on other failures
+ *
+ * \note This function must not be called from a transfer or hotplug callback:
+ * callbacks run on the context's event goroutine, which would then never get
+ * to complete this transfer, and the call deadlocks.
  */
 func libusb_bulk_transfer(dev_handle *libusb_device_handle, endpoint uint8,
-	data *uint8, length int, transferred *int, timeout uint) int {
-	return do_sync_bulk_transfer(dev_handle, endpoint, data, length,
-		transferred, timeout, LIBUSB_TRANSFER_TYPE_BULK)
+	data []uint8, length int, transferred *int, timeout uint) int {
+	return do_sync_bulk_transfer(context.Background(), dev_handle, endpoint, data, length,
+		transferred, time.Duration(timeout)*time.Millisecond, uint8(LIBUSB_TRANSFER_TYPE_BULK))
 }
 
 /** \ingroup libusb_syncio
@@ -278,11 +337,14 @@ func libusb_bulk_transfer(dev_handle *libusb_device_handle, endpoint uint8,
  * \returns LIBUSB_ERROR_OVERFLOW if the device offered more data, see
  * \ref libusb_packetoverflow
  * \returns LIBUSB_ERROR_NO_DEVICE if the device has been disconnected
- * \returns LIBUSB_ERROR_BUSY if called from event handling context
  * \returns another LIBUSB_ERROR code on other error
+ *
+ * \note This function must not be called from a transfer or hotplug callback:
+ * callbacks run on the context's event goroutine, which would then never get
+ * to complete this transfer, and the call deadlocks.
  */
 func libusb_interrupt_transfer(dev_handle *libusb_device_handle, endpoint uint8,
-	data *uint8, length int, transferred *int, timeout uint32) int {
-	return do_sync_bulk_transfer(dev_handle, endpoint, data, length,
-		transferred, timeout, LIBUSB_TRANSFER_TYPE_INTERRUPT)
+	data []uint8, length int, transferred *int, timeout uint) int {
+	return do_sync_bulk_transfer(context.Background(), dev_handle, endpoint, data, length,
+		transferred, time.Duration(timeout)*time.Millisecond, uint8(LIBUSB_TRANSFER_TYPE_INTERRUPT))
 }
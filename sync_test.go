@@ -0,0 +1,106 @@
+package usb
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// openBlocking opens the loopback device with an interrupt IN endpoint and
+// a vendor request 0x02 that never complete by themselves. Both have moved
+// one byte, 0x5a, by the time they are aborted.
+func openBlocking(t *testing.T) *DeviceHandle {
+	t.Helper()
+	block := func(req *SimRequest) (int, error) {
+		<-req.Done
+		return copy(req.Data, []byte{0x5a}), nil
+	}
+	d := newLoopbackDevice()
+	control := d.Control
+	d.Control = func(req *SimRequest) (int, error) {
+		if req.RequestType == 0xc0 && req.Request == 0x02 {
+			return block(req)
+		}
+		return control(req)
+	}
+	d.Endpoints[0x82] = block
+	h, _ := openLoopback(t, d)
+	return h
+}
+
+func TestSyncContext(t *testing.T) {
+	h := openBlocking(t)
+
+	cancelled := func() context.Context {
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(10*time.Millisecond, cancel)
+		return ctx
+	}
+	expired := func() context.Context {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		t.Cleanup(cancel)
+		return ctx
+	}
+	transfers := []struct {
+		name string
+		do   func(ctx context.Context, buf []byte) (int, error)
+	}{
+		{"control", func(ctx context.Context, buf []byte) (int, error) {
+			return h.ControlContext(ctx, 0xc0, 0x02, 0, 0, buf[:2])
+		}},
+		{"interrupt", func(ctx context.Context, buf []byte) (int, error) {
+			return h.InterruptTransferContext(ctx, 0x82, buf)
+		}},
+	}
+	for _, tt := range transfers {
+		buf := make([]byte, 8)
+		n, err := tt.do(cancelled(), buf)
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("%s transfer with a cancelled context returned %v, want context.Canceled", tt.name, err)
+		}
+		/* the byte moved before the cancellation is reported */
+		var uerr *Error
+		if n != 1 || buf[0] != 0x5a || !errors.As(err, &uerr) || uerr.Transferred != 1 {
+			t.Errorf("cancelled %s transfer moved %d bytes (% x), %v, want 1 byte, 5a", tt.name, n, buf[:n], err)
+		}
+		if _, err := tt.do(expired(), buf); !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("%s transfer past its deadline returned %v, want context.DeadlineExceeded", tt.name, err)
+		}
+	}
+
+	/* the deadline does not affect transfers that complete in time */
+	buf := make([]byte, 2)
+	if _, err := h.ControlContext(expired(), 0xc0, 0x01, 0xbeef, 0, buf); err != nil {
+		t.Errorf("control transfer within its deadline returned %v", err)
+	}
+}
+
+func TestSyncTransferTimeout(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Hour)
+	defer cancel()
+
+	tests := []struct {
+		ctx         context.Context
+		timeout     time.Duration
+		by_deadline bool
+	}{
+		{context.Background(), 0, false},
+		{context.Background(), time.Second, false},
+		{ctx, time.Second, false},
+		{ctx, 0, true},
+		{ctx, 2 * time.Hour, true},
+	}
+	for i, tt := range tests {
+		timeout, by_deadline := sync_transfer_timeout(tt.ctx, tt.timeout)
+		if by_deadline != tt.by_deadline {
+			t.Errorf("%d: by_deadline %v, want %v", i, by_deadline, tt.by_deadline)
+		}
+		if !by_deadline && timeout != tt.timeout {
+			t.Errorf("%d: timeout %v, want %v", i, timeout, tt.timeout)
+		}
+		if by_deadline && (timeout <= 0 || timeout > time.Hour) {
+			t.Errorf("%d: timeout %v, want at most an hour", i, timeout)
+		}
+	}
+}
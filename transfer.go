@@ -0,0 +1,311 @@
+package usb
+
+import (
+	"sync"
+	"time"
+)
+
+// TransferType is the type of an endpoint, and of transfers made to it.
+type TransferType uint8
+
+const (
+	TransferTypeControl     = TransferType(LIBUSB_TRANSFER_TYPE_CONTROL)
+	TransferTypeIsochronous = TransferType(LIBUSB_TRANSFER_TYPE_ISOCHRONOUS)
+	TransferTypeBulk        = TransferType(LIBUSB_TRANSFER_TYPE_BULK)
+	TransferTypeInterrupt   = TransferType(LIBUSB_TRANSFER_TYPE_INTERRUPT)
+	TransferTypeBulkStream  = TransferType(LIBUSB_TRANSFER_TYPE_BULK_STREAM)
+)
+
+func (t TransferType) String() string {
+	switch t {
+	case TransferTypeControl:
+		return "control"
+	case TransferTypeIsochronous:
+		return "isochronous"
+	case TransferTypeBulk:
+		return "bulk"
+	case TransferTypeInterrupt:
+		return "interrupt"
+	case TransferTypeBulkStream:
+		return "bulk stream"
+	}
+	return "unknown"
+}
+
+// TransferStatus is the outcome of an asynchronous transfer.
+type TransferStatus uint8
+
+const (
+	TransferCompleted = TransferStatus(LIBUSB_TRANSFER_COMPLETED)
+	TransferError     = TransferStatus(LIBUSB_TRANSFER_ERROR)
+	TransferTimedOut  = TransferStatus(LIBUSB_TRANSFER_TIMED_OUT)
+	TransferCancelled = TransferStatus(LIBUSB_TRANSFER_CANCELLED)
+	TransferStall     = TransferStatus(LIBUSB_TRANSFER_STALL)
+	TransferNoDevice  = TransferStatus(LIBUSB_TRANSFER_NO_DEVICE)
+	TransferOverflow  = TransferStatus(LIBUSB_TRANSFER_OVERFLOW)
+)
+
+func (s TransferStatus) String() string {
+	switch s {
+	case TransferCompleted:
+		return "LIBUSB_TRANSFER_COMPLETED"
+	case TransferError:
+		return "LIBUSB_TRANSFER_ERROR"
+	case TransferTimedOut:
+		return "LIBUSB_TRANSFER_TIMED_OUT"
+	case TransferCancelled:
+		return "LIBUSB_TRANSFER_CANCELLED"
+	case TransferStall:
+		return "LIBUSB_TRANSFER_STALL"
+	case TransferNoDevice:
+		return "LIBUSB_TRANSFER_NO_DEVICE"
+	case TransferOverflow:
+		return "LIBUSB_TRANSFER_OVERFLOW"
+	}
+	return "**UNKNOWN**"
+}
+
+// A Transfer is an asynchronous USB transfer. It is submitted with Submit
+// and its callback runs from the context's event handling once it
+// completes, fails or is cancelled. A Transfer may be resubmitted from its
+// own callback.
+type Transfer struct {
+	handle   *DeviceHandle
+	xfer     *libusb_transfer
+	callback func(*Transfer)
+
+	/* stall recovery state, see recoverStall. stalled is set from a stall
+	 * until the retry is submitted or the callback is run, and cancelled
+	 * records a Cancel made in the meantime. */
+	mu           sync.Mutex
+	stallRetries int
+	stalled      bool
+	cancelled    bool
+	retry        *time.Timer
+}
+
+// NewTransfer prepares a bulk, interrupt or isochronous transfer on an
+// endpoint. For isochronous transfers, isoPackets gives the number of packet
+// descriptors; it is ignored for other types. A timeout of 0 never expires.
+func (h *DeviceHandle) NewTransfer(tt TransferType, endpoint uint8, buf []byte, isoPackets int, timeout time.Duration, callback func(*Transfer)) *Transfer {
+	if tt != TransferTypeIsochronous {
+		isoPackets = 0
+	}
+	t := &Transfer{
+		handle:   h,
+		xfer:     libusb_alloc_transfer(isoPackets),
+		callback: callback,
+	}
+
+	switch tt {
+	case TransferTypeIsochronous:
+		libusb_fill_iso_transfer(t.xfer, h.handle, endpoint, buf, len(buf),
+			isoPackets, t.complete, t, durationToMillis(timeout))
+	case TransferTypeInterrupt:
+		libusb_fill_interrupt_transfer(t.xfer, h.handle, endpoint, buf, len(buf),
+			t.complete, t, durationToMillis(timeout))
+	default:
+		libusb_fill_bulk_transfer(t.xfer, h.handle, endpoint, buf, len(buf),
+			t.complete, t, durationToMillis(timeout))
+		t.xfer._type = uint8(tt)
+	}
+	return t
+}
+
+// NewControlTransfer prepares a control transfer. For OUT requests data is
+// sent in the data stage; for IN requests len(data) bytes are requested and
+// the response is available from Data once the transfer completes.
+func (h *DeviceHandle) NewControlTransfer(requestType, request uint8, value, index uint16, data []byte, timeout time.Duration, callback func(*Transfer)) *Transfer {
+	buf := make([]uint8, LIBUSB_CONTROL_SETUP_SIZE+len(data))
+	libusb_fill_control_setup(buf, requestType, request, value, index, uint16(len(data)))
+	if requestType&LIBUSB_ENDPOINT_DIR_MASK == uint8(LIBUSB_ENDPOINT_OUT) {
+		copy(buf[LIBUSB_CONTROL_SETUP_SIZE:], data)
+	}
+
+	t := &Transfer{
+		handle:   h,
+		xfer:     libusb_alloc_transfer(0),
+		callback: callback,
+	}
+	libusb_fill_control_transfer(t.xfer, h.handle, buf, t.complete, t,
+		durationToMillis(timeout))
+	return t
+}
+
+func (t *Transfer) complete(*libusb_transfer) {
+	if t.xfer.status == LIBUSB_TRANSFER_STALL && t.recoverStall() {
+		return
+	}
+	if t.callback != nil {
+		t.callback(t)
+	}
+}
+
+// Submit starts the transfer and returns immediately.
+func (t *Transfer) Submit() error {
+	t.mu.Lock()
+	t.stallRetries = 0
+	t.cancelled = false
+	t.mu.Unlock()
+	return t.handle.transferError("submit "+t.Type().String()+" transfer", t.Endpoint(),
+		libusb_error(libusb_submit_transfer(t.xfer)), 0)
+}
+
+// Cancel asynchronously cancels a submitted transfer. The callback still
+// runs, with a status of TransferCancelled. Cancelling a transfer that has
+// already completed fails with ErrNotFound.
+func (t *Transfer) Cancel() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	/* a transfer recovering from a stall is not in flight, so cancel the
+	 * recovery instead */
+	if t.stalled {
+		if t.cancelled {
+			return t.handle.transferError("cancel "+t.Type().String()+" transfer", t.Endpoint(),
+				LIBUSB_ERROR_NOT_FOUND, 0)
+		}
+		t.cancelled = true
+		/* the retry reports the cancellation; skip its backoff */
+		if t.retry != nil && t.retry.Stop() {
+			t.retry.Reset(0)
+		}
+		return nil
+	}
+
+	/* the transfer may stall before the cancellation takes effect, or have
+	 * stalled already with recoverStall yet to run */
+	t.cancelled = true
+	return t.handle.transferError("cancel "+t.Type().String()+" transfer", t.Endpoint(),
+		libusb_error(libusb_cancel_transfer(t.xfer)), 0)
+}
+
+// Close frees the transfer, which must not be used afterwards. It fails
+// with ErrBusy while the transfer is in flight or recovering from a stall.
+func (t *Transfer) Close() error {
+	itransfer := t.xfer.usbiTransfer
+	if itransfer == nil {
+		return nil
+	}
+	t.mu.Lock()
+	busy := t.stalled
+	t.mu.Unlock()
+	itransfer.lock.Lock()
+	busy = busy || itransfer.state_flags&uint8(USBI_TRANSFER_IN_FLIGHT) != 0
+	itransfer.lock.Unlock()
+	if busy {
+		return t.handle.transferError("free "+t.Type().String()+" transfer", t.Endpoint(),
+			LIBUSB_ERROR_BUSY, 0)
+	}
+	libusb_free_transfer(t.xfer)
+	return nil
+}
+
+// Handle returns the device handle the transfer was created on.
+func (t *Transfer) Handle() *DeviceHandle {
+	return t.handle
+}
+
+// Type returns the transfer type.
+func (t *Transfer) Type() TransferType {
+	return TransferType(t.xfer._type)
+}
+
+// Endpoint returns the endpoint address the transfer is made to.
+func (t *Transfer) Endpoint() uint8 {
+	return t.xfer.endpoint
+}
+
+// Status returns the status of a completed transfer.
+func (t *Transfer) Status() TransferStatus {
+	return TransferStatus(t.xfer.status)
+}
+
+// Err returns nil if the transfer completed, or otherwise an *Error that
+// wraps the sentinel matching its status and records the bytes moved. A
+// cancelled transfer wraps ErrInterrupted.
+func (t *Transfer) Err() error {
+	return t.handle.transferError(t.Type().String()+" transfer", t.Endpoint(),
+		t.Status().err(), t.xfer.actual_length)
+}
+
+// ActualLength returns the number of bytes transferred, excluding the
+// setup packet of control transfers. It is not valid for isochronous
+// transfers.
+func (t *Transfer) ActualLength() int {
+	return t.xfer.actual_length
+}
+
+// Buffer returns the whole transfer buffer, including the setup packet of
+// control transfers.
+func (t *Transfer) Buffer() []byte {
+	return t.xfer.buffer
+}
+
+// Data returns the bytes actually transferred.
+func (t *Transfer) Data() []byte {
+	if t.xfer._type == uint8(LIBUSB_TRANSFER_TYPE_CONTROL) {
+		return libusb_control_transfer_get_data(t.xfer)[:t.xfer.actual_length]
+	}
+	return t.xfer.buffer[:t.xfer.actual_length]
+}
+
+// An IsoPacket describes one packet of an isochronous transfer.
+type IsoPacket struct {
+	// Length is the number of bytes requested for the packet.
+	Length int
+
+	// ActualLength is the number of bytes transferred in the packet.
+	ActualLength int
+
+	// Status is the status of the packet, which may differ from the status
+	// of the transfer as a whole.
+	Status TransferStatus
+
+	// Data is the part of the packet's buffer that was transferred.
+	Data []byte
+}
+
+// IsoPackets returns the packets of a completed isochronous transfer. Their
+// Data shares the transfer buffer.
+func (t *Transfer) IsoPackets() []IsoPacket {
+	packets := make([]IsoPacket, t.xfer.num_iso_packets)
+	offset := 0
+	for i := range packets {
+		desc := &t.xfer.iso_packet_desc[i]
+		packets[i] = IsoPacket{
+			Length:       int(desc.length),
+			ActualLength: int(desc.actual_length),
+			Status:       TransferStatus(desc.status),
+			Data:         t.xfer.buffer[offset : offset+int(desc.actual_length)],
+		}
+		offset += int(desc.length)
+	}
+	return packets
+}
+
+// SetIsoPacketLengths sets the requested length of every packet of an
+// isochronous transfer.
+func (t *Transfer) SetIsoPacketLengths(length int) {
+	libusb_set_iso_packet_lengths(t.xfer, uint(length))
+}
+
+// SetShortNotOK makes a transfer that moves less data than requested
+// complete with TransferError.
+func (t *Transfer) SetShortNotOK(enable bool) {
+	t.setFlag(LIBUSB_TRANSFER_SHORT_NOT_OK, enable)
+}
+
+// SetAddZeroPacket terminates OUT transfers whose length is a multiple of
+// the endpoint's wMaxPacketSize with a zero length packet.
+func (t *Transfer) SetAddZeroPacket(enable bool) {
+	t.setFlag(LIBUSB_TRANSFER_ADD_ZERO_PACKET, enable)
+}
+
+func (t *Transfer) setFlag(flag libusb_transfer_flags, enable bool) {
+	if enable {
+		t.xfer.flags |= uint8(flag)
+	} else {
+		t.xfer.flags &= ^uint8(flag)
+	}
+}
@@ -0,0 +1,2028 @@
+//go:build linux
+// +build linux
+
+package usb
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+/*
+ * Linux usbfs backend for libusb
+ * Copyright © 2007-2009 Daniel Drake <dsd@gentoo.org>
+ * Copyright © 2001 Johannes Erdfelt <johannes@erdfelt.com>
+ * Copyright © 2013 Nathan Hjelm <hjelmn@mac.com>
+ * Copyright © 2012-2013 Hans de Goede <hdegoede@redhat.com>
+ *
+ * This library is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU Lesser General Public
+ * License as published by the Free Software Foundation; either
+ * version 2.1 of the License, or (at your option) any later version.
+ *
+ * This library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+ * Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public
+ * License along with this library; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA
+ */
+
+// GO: this is a port of os/linux_usbfs.c that talks to the kernel through
+// raw ioctls rather than cgo. Only the sysfs code paths are kept: every
+// kernel since 2.6.26 exposes busnum, devnum and all config descriptors
+// in sysfs, so the usbfs fallbacks for older kernels are gone.
+
+func init() {
+	usbi_backend = &linux_usbfs_backend{}
+}
+
+const (
+	SYSFS_DEVICE_PATH = "/sys/bus/usb/devices"
+	USBFS_PATH        = "/dev/bus/usb"
+
+	USBFS_MAXDRIVERNAME = 255
+
+	USBFS_URB_SHORT_NOT_OK      = 0x01
+	USBFS_URB_ISO_ASAP          = 0x02
+	USBFS_URB_BULK_CONTINUATION = 0x04
+	USBFS_URB_QUEUE_BULK        = 0x10
+	USBFS_URB_ZERO_PACKET       = 0x40
+
+	USBFS_URB_TYPE_ISO       = 0
+	USBFS_URB_TYPE_INTERRUPT = 1
+	USBFS_URB_TYPE_CONTROL   = 2
+	USBFS_URB_TYPE_BULK      = 3
+
+	MAX_ISO_BUFFER_LENGTH   = 49152 * 128
+	MAX_ISO_PACKETS_PER_URB = 128
+	MAX_BULK_BUFFER_LENGTH  = 16384
+	MAX_CTRL_BUFFER_LENGTH  = 4096
+
+	USBFS_CAP_ZERO_PACKET           = 0x01
+	USBFS_CAP_BULK_CONTINUATION     = 0x02
+	USBFS_CAP_NO_PACKET_SIZE_LIM    = 0x04
+	USBFS_CAP_BULK_SCATTER_GATHER   = 0x08
+	USBFS_CAP_REAP_AFTER_DISCONNECT = 0x10
+
+	USBFS_DISCONNECT_CLAIM_IF_DRIVER     = 0x01
+	USBFS_DISCONNECT_CLAIM_EXCEPT_DRIVER = 0x02
+)
+
+/* poll(2) event bits */
+const (
+	POLLIN  = 0x001
+	POLLOUT = 0x004
+	POLLERR = 0x008
+)
+
+/* how long the reaper of a handle waits in poll(2) before checking whether
+ * the handle is being closed */
+const LINUX_REAP_INTERVAL = 100 * time.Millisecond
+
+/* struct pollfd */
+type kernel_pollfd struct {
+	fd      int32
+	events  int16
+	revents int16
+}
+
+type reap_action uint8
+
+const (
+	REAP_NORMAL reap_action = iota
+	/* submission failed after the first URB, so await cancellation/completion
+	 * of all the others */
+	REAP_SUBMIT_FAILED
+
+	/* cancelled by user or timeout */
+	REAP_CANCELLED
+
+	/* completed multi-URB transfer in non-final URB */
+	REAP_COMPLETED_EARLY
+
+	/* one or more urbs encountered a low-level error */
+	REAP_ERROR
+)
+
+/* usbfs structures, which must be kept in sync with the kernel's
+ * include/uapi/linux/usbdevice_fs.h */
+
+type usbfs_ctrltransfer struct {
+	bmRequestType uint8
+	bRequest      uint8
+	wValue        uint16
+	wIndex        uint16
+	wLength       uint16
+	timeout       uint32 /* in milliseconds */
+	data          unsafe.Pointer
+}
+
+type usbfs_setinterface struct {
+	iface      uint32
+	altsetting uint32
+}
+
+type usbfs_getdriver struct {
+	iface  uint32
+	driver [USBFS_MAXDRIVERNAME + 1]byte
+}
+
+type usbfs_iso_packet_desc struct {
+	length        uint32
+	actual_length uint32
+	status        int32
+}
+
+type usbfs_urb struct {
+	_type         uint8
+	endpoint      uint8
+	status        int32
+	flags         uint32
+	buffer        unsafe.Pointer
+	buffer_length int32
+	actual_length int32
+	start_frame   int32
+	/* number_of_packets for iso URBs, stream_id for bulk stream URBs */
+	number_of_packets int32
+	error_count       int32
+	signr             uint32
+	usercontext       unsafe.Pointer
+}
+
+/* the kernel reads the iso frame descriptors directly after the URB */
+type usbfs_iso_urb struct {
+	usbfs_urb
+	iso_frame_desc [MAX_ISO_PACKETS_PER_URB]usbfs_iso_packet_desc
+}
+
+type usbfs_ioctl struct {
+	ifno       int32 /* interface 0..N ; negative numbers reserved */
+	ioctl_code int32 /* MUST encode size + direction of data so the
+	 * macros in <asm/ioctl.h> give correct values */
+	data unsafe.Pointer /* param buffer (in, or out) */
+}
+
+type usbfs_disconnect_claim struct {
+	iface  uint32
+	flags  uint32
+	driver [USBFS_MAXDRIVERNAME + 1]byte
+}
+
+type usbfs_streams struct {
+	num_streams uint32 /* Not used by USBFS_FREE_STREAMS */
+	num_eps     uint32
+	/* followed by num_eps endpoint addresses */
+}
+
+/* the asm-generic ioctl number encoding */
+const (
+	_IOC_NONE  = 0
+	_IOC_WRITE = 1
+	_IOC_READ  = 2
+)
+
+func _IOC(dir, t, nr, size uintptr) uintptr {
+	return dir<<30 | size<<16 | t<<8 | nr
+}
+
+func _IO(t, nr uintptr) uintptr         { return _IOC(_IOC_NONE, t, nr, 0) }
+func _IOR(t, nr, size uintptr) uintptr  { return _IOC(_IOC_READ, t, nr, size) }
+func _IOW(t, nr, size uintptr) uintptr  { return _IOC(_IOC_WRITE, t, nr, size) }
+func _IOWR(t, nr, size uintptr) uintptr { return _IOC(_IOC_READ|_IOC_WRITE, t, nr, size) }
+
+var (
+	IOCTL_USBFS_CONTROL          = _IOWR('U', 0, unsafe.Sizeof(usbfs_ctrltransfer{}))
+	IOCTL_USBFS_SETINTF          = _IOR('U', 4, unsafe.Sizeof(usbfs_setinterface{}))
+	IOCTL_USBFS_SETCONFIG        = _IOR('U', 5, unsafe.Sizeof(uint32(0)))
+	IOCTL_USBFS_GETDRIVER        = _IOW('U', 8, unsafe.Sizeof(usbfs_getdriver{}))
+	IOCTL_USBFS_SUBMITURB        = _IOR('U', 10, unsafe.Sizeof(usbfs_urb{}))
+	IOCTL_USBFS_DISCARDURB       = _IO('U', 11)
+	IOCTL_USBFS_REAPURBNDELAY    = _IOW('U', 13, unsafe.Sizeof(unsafe.Pointer(nil)))
+	IOCTL_USBFS_CLAIMINTF        = _IOR('U', 15, unsafe.Sizeof(uint32(0)))
+	IOCTL_USBFS_RELEASEINTF      = _IOR('U', 16, unsafe.Sizeof(uint32(0)))
+	IOCTL_USBFS_IOCTL            = _IOWR('U', 18, unsafe.Sizeof(usbfs_ioctl{}))
+	IOCTL_USBFS_RESET            = _IO('U', 20)
+	IOCTL_USBFS_CLEAR_HALT       = _IOR('U', 21, unsafe.Sizeof(uint32(0)))
+	IOCTL_USBFS_DISCONNECT       = _IO('U', 22)
+	IOCTL_USBFS_CONNECT          = _IO('U', 23)
+	IOCTL_USBFS_GET_CAPABILITIES = _IOR('U', 26, unsafe.Sizeof(uint32(0)))
+	IOCTL_USBFS_DISCONNECT_CLAIM = _IOR('U', 27, unsafe.Sizeof(usbfs_disconnect_claim{}))
+	IOCTL_USBFS_ALLOC_STREAMS    = _IOR('U', 28, unsafe.Sizeof(usbfs_streams{}))
+	IOCTL_USBFS_FREE_STREAMS     = _IOR('U', 29, unsafe.Sizeof(usbfs_streams{}))
+)
+
+func ioctl(fd int, req uintptr, arg unsafe.Pointer) (int, syscall.Errno) {
+	r, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), req, uintptr(arg))
+	return int(r), errno
+}
+
+/* Serialize scan-devices and poll */
+var linux_hotplug_lock = sync.Mutex{}
+
+type linux_device_priv struct {
+	sysfs_dir     string
+	descriptors   []uint8
+	active_config int
+}
+
+type linux_device_handle_priv struct {
+	fd   int
+	caps uint32
+
+	/* closed to stop the reaper goroutine, which closes reaper_done on
+	 * its way out */
+	reaper_quit chan struct{}
+	reaper_done chan struct{}
+
+	/* URBs handed to the kernel, so that a reaped URB pointer can be mapped
+	 * back to its transfer. This also keeps the URBs and the buffers they
+	 * point at reachable while the kernel owns them. */
+	urbs_lock sync.Mutex
+	urbs      map[*usbfs_urb]*usbi_transfer
+}
+
+type linux_transfer_priv struct {
+	urbs     []usbfs_urb
+	iso_urbs []*usbfs_iso_urb
+
+	reap_action reap_action
+	num_urbs    int
+	num_retired int
+	reap_status libusb_transfer_status
+
+	/* size of each URB of a split bulk transfer */
+	bulk_buffer_len int
+
+	/* next iso packet in user-supplied transfer to be populated */
+	iso_packet_offset int
+}
+
+func _device_priv(dev *libusb_device) *linux_device_priv {
+	return dev.os_priv.(*linux_device_priv)
+}
+
+func _device_handle_priv(handle *libusb_device_handle) *linux_device_handle_priv {
+	return handle.os_priv.(*linux_device_handle_priv)
+}
+
+func _transfer_priv(itransfer *usbi_transfer) *linux_transfer_priv {
+	tpriv, ok := itransfer.tpriv.(*linux_transfer_priv)
+	if !ok {
+		tpriv = &linux_transfer_priv{}
+		itransfer.tpriv = tpriv
+	}
+	return tpriv
+}
+
+func (hpriv *linux_device_handle_priv) track_urb(urb *usbfs_urb, itransfer *usbi_transfer) {
+	hpriv.urbs_lock.Lock()
+	hpriv.urbs[urb] = itransfer
+	hpriv.urbs_lock.Unlock()
+}
+
+func (hpriv *linux_device_handle_priv) untrack_urb(urb *usbfs_urb) *usbi_transfer {
+	hpriv.urbs_lock.Lock()
+	itransfer := hpriv.urbs[urb]
+	delete(hpriv.urbs, urb)
+	hpriv.urbs_lock.Unlock()
+	return itransfer
+}
+
+// buffer_at returns a pointer to buf[offset] suitable for handing to the
+// kernel, or nil for an empty buffer.
+func buffer_at(buf []uint8, offset int) unsafe.Pointer {
+	if offset >= len(buf) {
+		return nil
+	}
+	return unsafe.Pointer(&buf[offset])
+}
+
+func _get_usbfs_fd(dev *libusb_device, mode int, silent bool) (int, libusb_error) {
+	path := filepath.Join(USBFS_PATH,
+		strconv.Itoa(int(dev.bus_number) + 1000)[1:],
+		strconv.Itoa(int(dev.device_address) + 1000)[1:])
+
+	fd, err := syscall.Open(path, mode|syscall.O_CLOEXEC, 0)
+	if err == nil {
+		return fd, LIBUSB_SUCCESS
+	}
+
+	if !silent {
+		// usbi_err(ctx, "libusb couldn't open USB device %s: %s", path, err)
+		if err == syscall.EACCES && mode == syscall.O_RDWR {
+			// usbi_err(ctx, "libusb requires write access to USB device nodes.")
+		}
+	}
+
+	switch err {
+	case syscall.EACCES:
+		return -1, LIBUSB_ERROR_ACCESS
+	case syscall.ENOENT:
+		return -1, LIBUSB_ERROR_NO_DEVICE
+	}
+	return -1, LIBUSB_ERROR_IO
+}
+
+/* Note only suitable for attributes which always read >= 0, < 0 is error */
+func __read_sysfs_attr(devname, attr string) (int, libusb_error) {
+	data, err := ioutil.ReadFile(filepath.Join(SYSFS_DEVICE_PATH, devname, attr))
+	if err != nil {
+		if os.IsNotExist(err) {
+			/* File doesn't exist. Assume the device has been
+			   disconnected (see trac ticket #70). */
+			return 0, LIBUSB_ERROR_NO_DEVICE
+		}
+		// usbi_err(ctx, "open %s failed errno=%d", filename, errno)
+		return 0, LIBUSB_ERROR_IO
+	}
+
+	value, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		// usbi_err(ctx, "parse %s failed: %s", attr, err)
+		return 0, LIBUSB_ERROR_NO_DEVICE /* For unplug race (trac #70) */
+	}
+	if value < 0 {
+		// usbi_err(ctx, "%s contains a negative value", filename)
+		return 0, LIBUSB_ERROR_IO
+	}
+
+	return value, LIBUSB_SUCCESS
+}
+
+/* read the bConfigurationValue for a device */
+func sysfs_get_active_config(dev *libusb_device, config *int) libusb_error {
+	priv := _device_priv(dev)
+	data, err := ioutil.ReadFile(filepath.Join(SYSFS_DEVICE_PATH, priv.sysfs_dir, "bConfigurationValue"))
+	if err != nil {
+		// usbi_err(dev.ctx, "read bConfigurationValue failed: %s", err)
+		return LIBUSB_ERROR_IO
+	}
+
+	tmp := strings.TrimSpace(string(data))
+	if tmp == "" {
+		// usbi_dbg("device unconfigured")
+		*config = -1
+		return 0
+	}
+
+	num, err := strconv.Atoi(tmp)
+	if err != nil {
+		// usbi_err(dev.ctx, "error converting '%s' to integer", tmp)
+		return LIBUSB_ERROR_IO
+	}
+
+	*config = num
+	return 0
+}
+
+func linux_get_device_address(sys_name string, busnum, devaddr *uint8) libusb_error {
+	// usbi_dbg("scan %s", sys_name)
+
+	sysfs_attr, r := __read_sysfs_attr(sys_name, "busnum")
+	if r < 0 {
+		return r
+	}
+	if sysfs_attr > 255 {
+		return LIBUSB_ERROR_INVALID_PARAM
+	}
+	*busnum = uint8(sysfs_attr)
+
+	sysfs_attr, r = __read_sysfs_attr(sys_name, "devnum")
+	if r < 0 {
+		return r
+	}
+	if sysfs_attr > 255 {
+		return LIBUSB_ERROR_INVALID_PARAM
+	}
+	*devaddr = uint8(sysfs_attr)
+
+	// usbi_dbg("bus=%d dev=%d", *busnum, *devaddr)
+	return LIBUSB_SUCCESS
+}
+
+/* Return offset of the next descriptor with the given type */
+func seek_to_next_descriptor(descriptor_type uint8, buffer []uint8) libusb_error {
+	i := 0
+	for {
+		size := len(buffer) - i
+		if size == 0 {
+			return LIBUSB_ERROR_NOT_FOUND
+		}
+		if size < 2 {
+			// usbi_err(ctx, "short descriptor read %d/2", size)
+			return LIBUSB_ERROR_IO
+		}
+
+		bLength := int(buffer[i])
+		if i != 0 && buffer[i+1] == descriptor_type {
+			return libusb_error(i)
+		}
+		if bLength == 0 || bLength > size {
+			// usbi_err(ctx, "bLength overflow by %d bytes", bLength-size)
+			return LIBUSB_ERROR_IO
+		}
+		i += bLength
+	}
+}
+
+/* Return offset to next config */
+func seek_to_next_config(buffer []uint8) libusb_error {
+	size := len(buffer)
+	if size == 0 {
+		return LIBUSB_ERROR_NOT_FOUND
+	}
+
+	if size < LIBUSB_DT_CONFIG_SIZE {
+		// usbi_err(ctx, "short descriptor read %d/%d", size, LIBUSB_DT_CONFIG_SIZE)
+		return LIBUSB_ERROR_IO
+	}
+
+	if buffer[1] != uint8(LIBUSB_DT_CONFIG) {
+		// usbi_err(ctx, "descriptor is not a config desc (type 0x%02x)", buffer[1])
+		return LIBUSB_ERROR_IO
+	}
+
+	/*
+	 * In sysfs wTotalLength is ignored, instead the kernel returns a
+	 * config descriptor with verified bLength fields, with descriptors
+	 * with an invalid bLength removed.
+	 */
+	next := seek_to_next_descriptor(uint8(LIBUSB_DT_CONFIG), buffer)
+	if next == LIBUSB_ERROR_NOT_FOUND {
+		next = libusb_error(size)
+	}
+	// if next >= 0 && int(next) != wTotalLength
+	// usbi_warn(ctx, "config length mismatch wTotalLength %d real %d", wTotalLength, next)
+	return next
+}
+
+func initialize_device(dev *libusb_device, busnum, devaddr uint8, sysfs_dir string) libusb_error {
+	priv := &linux_device_priv{sysfs_dir: sysfs_dir}
+	dev.os_priv = priv
+
+	dev.bus_number = busnum
+	dev.device_address = devaddr
+
+	if data, err := ioutil.ReadFile(filepath.Join(SYSFS_DEVICE_PATH, sysfs_dir, "speed")); err == nil {
+		speed, _ := strconv.ParseFloat(strings.TrimSpace(string(data)), 64)
+		switch speed {
+		case 1.5:
+			dev.speed = LIBUSB_SPEED_LOW
+		case 12:
+			dev.speed = LIBUSB_SPEED_FULL
+		case 480:
+			dev.speed = LIBUSB_SPEED_HIGH
+		case 5000, 10000, 20000:
+			dev.speed = LIBUSB_SPEED_SUPER
+		default:
+			// usbi_warn(dev.ctx, "Unknown device speed: %v Mbps", speed)
+		}
+	}
+
+	/* cache descriptors in memory */
+	descriptors, err := ioutil.ReadFile(filepath.Join(SYSFS_DEVICE_PATH, sysfs_dir, "descriptors"))
+	if err != nil {
+		// usbi_err(ctx, "read descriptor failed: %s", err)
+		return LIBUSB_ERROR_IO
+	}
+	priv.descriptors = descriptors
+
+	if len(priv.descriptors) < DEVICE_DESC_LENGTH {
+		// usbi_err(ctx, "short descriptor read (%d)", len(priv.descriptors))
+		return LIBUSB_ERROR_IO
+	}
+
+	return LIBUSB_SUCCESS
+}
+
+func linux_get_parent_info(dev *libusb_device, sysfs_dir string) libusb_error {
+	ctx := dev.ctx
+	add_parent := true
+
+	if strings.HasPrefix(sysfs_dir, "usb") {
+		/* finding the parent of a root hub */
+		return LIBUSB_SUCCESS
+	}
+
+	tmp := strings.LastIndexAny(sysfs_dir, ".-")
+	if tmp < 0 {
+		// usbi_warn(ctx, "Can not parse sysfs_dir: %s, no parent info", sysfs_dir)
+		return LIBUSB_SUCCESS
+	}
+	port, _ := strconv.Atoi(sysfs_dir[tmp+1:])
+	dev.port_number = uint8(port)
+	parent_sysfs_dir := sysfs_dir[:tmp]
+
+	/* is the parent a root hub? */
+	if !strings.Contains(parent_sysfs_dir, "-") {
+		parent_sysfs_dir = "usb" + parent_sysfs_dir
+	}
+
+	for {
+		/* find the parent in the context */
+		ctx.usb_devs_lock.Lock()
+		for e := ctx.usb_devs.next; e != ctx.usb_devs; e = e.next {
+			it := list_entry(e).(*libusb_device)
+			if _device_priv(it).sysfs_dir == parent_sysfs_dir {
+				dev.parent_dev = libusb_ref_device(it)
+				break
+			}
+		}
+		ctx.usb_devs_lock.Unlock()
+
+		if dev.parent_dev != nil || !add_parent {
+			break
+		}
+		// usbi_dbg("parent_dev %s not enumerated yet, enumerating now", parent_sysfs_dir)
+		sysfs_scan_device(ctx, parent_sysfs_dir)
+		add_parent = false
+	}
+
+	// usbi_dbg("Dev %p (%s) has parent %p (%s) port %d", dev, sysfs_dir,
+	// dev.parent_dev, parent_sysfs_dir, dev.port_number)
+	return LIBUSB_SUCCESS
+}
+
+func linux_enumerate_device(ctx *libusb_context, busnum, devaddr uint8, sysfs_dir string) libusb_error {
+	/* FIXME: session ID is not guaranteed unique as addresses can wrap and
+	 * will be reused. instead we should add a simple sysfs attribute with
+	 * a session ID. */
+	session_id := uint64(busnum)<<8 | uint64(devaddr)
+	// usbi_dbg("busnum %d devaddr %d session_id %d", busnum, devaddr, session_id)
+
+	dev := usbi_get_device_by_session_id(ctx, session_id)
+	if dev != nil {
+		/* device already exists in the context */
+		// usbi_dbg("session_id %d already exists", session_id)
+		libusb_unref_device(dev)
+		return LIBUSB_SUCCESS
+	}
+
+	// usbi_dbg("allocating new device for %d/%d (session %d)", busnum, devaddr, session_id)
+	dev = usbi_alloc_device(ctx, session_id)
+
+	r := initialize_device(dev, busnum, devaddr, sysfs_dir)
+	if r >= 0 {
+		r = usbi_sanitize_device(dev)
+	}
+	if r >= 0 {
+		r = linux_get_parent_info(dev, sysfs_dir)
+	}
+
+	if r < 0 {
+		libusb_unref_device(dev)
+	} else {
+		usbi_connect_device(dev)
+	}
+	return r
+}
+
+func linux_device_disconnected(busnum, devaddr uint8) {
+	session_id := uint64(busnum)<<8 | uint64(devaddr)
+
+	active_contexts_lock.Lock()
+	for e := active_contexts_list.next; e != active_contexts_list; e = e.next {
+		ctx := list_entry(e).(*libusb_context)
+		dev := usbi_get_device_by_session_id(ctx, session_id)
+		if dev != nil {
+			usbi_disconnect_device(dev)
+			libusb_unref_device(dev)
+		} // else
+		// usbi_dbg("device not found for session %x", session_id)
+	}
+	active_contexts_lock.Unlock()
+}
+
+func sysfs_scan_device(ctx *libusb_context, devname string) libusb_error {
+	var busnum, devaddr uint8
+
+	r := linux_get_device_address(devname, &busnum, &devaddr)
+	if r != LIBUSB_SUCCESS {
+		return r
+	}
+
+	return linux_enumerate_device(ctx, busnum, devaddr, devname)
+}
+
+/* sysfs_is_device reports whether a directory in SYSFS_DEVICE_PATH is a
+ * device (e.g. "usb1" or "1-1.2") rather than an interface ("1-1.2:1.0") */
+func sysfs_is_device(name string) bool {
+	if strings.Contains(name, ":") {
+		return false
+	}
+	return strings.HasPrefix(name, "usb") || (name[0] >= '0' && name[0] <= '9')
+}
+
+func sysfs_get_device_list(ctx *libusb_context) libusb_error {
+	entries, err := ioutil.ReadDir(SYSFS_DEVICE_PATH)
+	if err != nil {
+		// usbi_err(ctx, "opendir devices failed: %s", err)
+		return LIBUSB_ERROR_IO
+	}
+
+	num_devices, num_enumerated := 0, 0
+	for _, entry := range entries {
+		if !sysfs_is_device(entry.Name()) {
+			continue
+		}
+
+		num_devices++
+		if sysfs_scan_device(ctx, entry.Name()) != LIBUSB_SUCCESS {
+			// usbi_dbg("failed to enumerate dir entry %s", entry.Name())
+			continue
+		}
+		num_enumerated++
+	}
+
+	/* successful if at least one device was enumerated or no devices were found */
+	if num_enumerated != 0 || num_devices == 0 {
+		return LIBUSB_SUCCESS
+	}
+	return LIBUSB_ERROR_IO
+}
+
+/* sysfs_rescan_devices brings ctx's device list in line with sysfs,
+ * disconnecting devices that went away and enumerating new ones. It stands
+ * in for the netlink/udev hotplug monitors of the C backend. */
+func sysfs_rescan_devices(ctx *libusb_context) {
+	var gone []*libusb_device
+
+	ctx.usb_devs_lock.Lock()
+	for e := ctx.usb_devs.next; e != ctx.usb_devs; e = e.next {
+		dev := list_entry(e).(*libusb_device)
+		var busnum, devaddr uint8
+		r := linux_get_device_address(_device_priv(dev).sysfs_dir, &busnum, &devaddr)
+		if r != LIBUSB_SUCCESS || busnum != dev.bus_number || devaddr != dev.device_address {
+			gone = append(gone, libusb_ref_device(dev))
+		}
+	}
+	ctx.usb_devs_lock.Unlock()
+
+	for _, dev := range gone {
+		usbi_disconnect_device(dev)
+		libusb_unref_device(dev)
+	}
+
+	sysfs_get_device_list(ctx)
+}
+
+type linux_usbfs_backend struct{}
+
+func (*linux_usbfs_backend) Name() string {
+	return "Linux usbfs"
+}
+
+func (*linux_usbfs_backend) Caps() uint32 {
+	return USBI_CAP_HAS_HID_ACCESS | USBI_CAP_SUPPORTS_DETACH_KERNEL_DRIVER
+}
+
+func (*linux_usbfs_backend) Init(ctx *libusb_context) libusb_error {
+	if _, err := os.Stat(USBFS_PATH); err != nil {
+		// usbi_err(ctx, "could not find usbfs")
+		return LIBUSB_ERROR_OTHER
+	}
+	if _, err := os.Stat(SYSFS_DEVICE_PATH); err != nil {
+		// usbi_err(ctx, "sysfs is not mounted at %s", SYSFS_DEVICE_PATH)
+		return LIBUSB_ERROR_OTHER
+	}
+
+	linux_hotplug_lock.Lock()
+	r := sysfs_get_device_list(ctx)
+	linux_hotplug_lock.Unlock()
+
+	return r
+}
+
+func (*linux_usbfs_backend) Exit() {}
+
+/* Devices are enumerated into each context at init time and kept up to date
+ * by Hotplug_poll, so this is never used. */
+func (*linux_usbfs_backend) Get_device_list(*libusb_context, *[]*libusb_device) libusb_error {
+	return LIBUSB_ERROR_NOT_SUPPORTED
+}
+
+/* One poll rescans sysfs for every active context; the hotplug poller makes
+ * it once per interval for the whole process. */
+func (*linux_usbfs_backend) Hotplug_poll() {
+	linux_hotplug_lock.Lock()
+	active_contexts_lock.Lock()
+	for e := active_contexts_list.next; e != active_contexts_list; e = e.next {
+		sysfs_rescan_devices(list_entry(e).(*libusb_context))
+	}
+	active_contexts_lock.Unlock()
+	linux_hotplug_lock.Unlock()
+}
+
+func (*linux_usbfs_backend) Open(handle *libusb_device_handle) libusb_error {
+	hpriv := &linux_device_handle_priv{urbs: make(map[*usbfs_urb]*usbi_transfer)}
+	handle.os_priv = hpriv
+
+	fd, r := _get_usbfs_fd(handle.dev, syscall.O_RDWR, false)
+	if r < 0 {
+		if r == LIBUSB_ERROR_NO_DEVICE {
+			/* device will still be marked as attached if the hotplug
+			 * poll hasn't processed the removal yet */
+			linux_hotplug_lock.Lock()
+			if handle.dev.attached {
+				// usbi_dbg("open failed with no device, but device still attached")
+				linux_device_disconnected(handle.dev.bus_number,
+					handle.dev.device_address)
+			}
+			linux_hotplug_lock.Unlock()
+		}
+		return r
+	}
+	hpriv.fd = fd
+
+	if _, errno := ioctl(fd, IOCTL_USBFS_GET_CAPABILITIES, unsafe.Pointer(&hpriv.caps)); errno != 0 {
+		// if errno == syscall.ENOTTY
+		// usbi_dbg("getcap not available")
+		// else
+		// usbi_err(handle.dev.ctx, "getcap failed (%d)", errno)
+
+		/* every kernel new enough to have sysfs descriptors supports
+		 * these */
+		hpriv.caps = USBFS_CAP_ZERO_PACKET | USBFS_CAP_BULK_CONTINUATION
+	}
+
+	hpriv.reaper_quit = make(chan struct{})
+	hpriv.reaper_done = make(chan struct{})
+	go linux_reap_urbs(handle, hpriv)
+
+	return LIBUSB_SUCCESS
+}
+
+func (*linux_usbfs_backend) Close(dev_handle *libusb_device_handle) {
+	hpriv := _device_handle_priv(dev_handle)
+	/* the reaper may already have stopped on a POLLERR condition */
+	close(hpriv.reaper_quit)
+	<-hpriv.reaper_done
+	syscall.Close(hpriv.fd)
+}
+
+func (*linux_usbfs_backend) Get_device_descriptor(dev *libusb_device, buffer []uint8, host_endian *int) libusb_error {
+	priv := _device_priv(dev)
+
+	/* sysfs descriptors are bus-endian */
+	*host_endian = 0
+	copy(buffer, priv.descriptors[:DEVICE_DESC_LENGTH])
+
+	return 0
+}
+
+func (b *linux_usbfs_backend) Get_active_config_descriptor(dev *libusb_device, buffer []uint8, host_endian *int) libusb_error {
+	var config int
+	r := sysfs_get_active_config(dev, &config)
+	if r < 0 {
+		return r
+	}
+	if config == -1 {
+		return LIBUSB_ERROR_NOT_FOUND
+	}
+
+	var config_desc []uint8
+	r = b.Get_config_descriptor_by_value(dev, uint8(config), &config_desc, host_endian)
+	if r < 0 {
+		return r
+	}
+
+	return libusb_error(copy(buffer, config_desc[:r]))
+}
+
+func (*linux_usbfs_backend) Get_config_descriptor(dev *libusb_device, config_index uint8, buffer []uint8, length int, host_endian *int) libusb_error {
+	priv := _device_priv(dev)
+
+	/* Unlike the device desc. config descs. are always in raw format */
+	*host_endian = 0
+
+	/* Skip device header */
+	descriptors := priv.descriptors[DEVICE_DESC_LENGTH:]
+
+	/* Seek till the config is found, or till "EOF" */
+	var r libusb_error
+	for i := 0; ; i++ {
+		r = seek_to_next_config(descriptors)
+		if r < 0 {
+			return r
+		}
+		if i == int(config_index) {
+			break
+		}
+		descriptors = descriptors[r:]
+	}
+
+	if int(r) < length {
+		length = int(r)
+	}
+	return libusb_error(copy(buffer[:length], descriptors))
+}
+
+func (*linux_usbfs_backend) Get_config_descriptor_by_value(dev *libusb_device, value uint8, buffer *[]uint8, host_endian *int) libusb_error {
+	priv := _device_priv(dev)
+
+	*buffer = nil
+	/* Unlike the device desc. config descs. are always in raw format */
+	*host_endian = 0
+
+	/* Skip device header */
+	descriptors := priv.descriptors[DEVICE_DESC_LENGTH:]
+
+	/* Seek till the config is found, or till "EOF" */
+	for {
+		next := seek_to_next_config(descriptors)
+		if next < 0 {
+			return next
+		}
+		/* bConfigurationValue */
+		if descriptors[5] == value {
+			*buffer = descriptors[:next]
+			return next
+		}
+		descriptors = descriptors[next:]
+	}
+}
+
+func (*linux_usbfs_backend) Get_configuration(handle *libusb_device_handle, config *int) libusb_error {
+	r := sysfs_get_active_config(handle.dev, config)
+	if r < 0 {
+		return r
+	}
+
+	if *config == -1 {
+		// usbi_err(handle.dev.ctx, "device unconfigured")
+		*config = 0
+	}
+
+	return 0
+}
+
+func (*linux_usbfs_backend) Set_configuration(handle *libusb_device_handle, config *int) libusb_error {
+	priv := _device_priv(handle.dev)
+	fd := _device_handle_priv(handle).fd
+
+	cfg := int32(*config)
+	if _, errno := ioctl(fd, IOCTL_USBFS_SETCONFIG, unsafe.Pointer(&cfg)); errno != 0 {
+		switch errno {
+		case syscall.EINVAL:
+			return LIBUSB_ERROR_NOT_FOUND
+		case syscall.EBUSY:
+			return LIBUSB_ERROR_BUSY
+		case syscall.ENODEV:
+			return LIBUSB_ERROR_NO_DEVICE
+		}
+		// usbi_err(handle.dev.ctx, "failed, errno %d", errno)
+		return LIBUSB_ERROR_OTHER
+	}
+
+	/* update our cached active config descriptor */
+	priv.active_config = *config
+
+	return LIBUSB_SUCCESS
+}
+
+func claim_interface(handle *libusb_device_handle, iface uint) libusb_error {
+	fd := _device_handle_priv(handle).fd
+	ifno := uint32(iface)
+	if _, errno := ioctl(fd, IOCTL_USBFS_CLAIMINTF, unsafe.Pointer(&ifno)); errno != 0 {
+		switch errno {
+		case syscall.ENOENT:
+			return LIBUSB_ERROR_NOT_FOUND
+		case syscall.EBUSY:
+			return LIBUSB_ERROR_BUSY
+		case syscall.ENODEV:
+			return LIBUSB_ERROR_NO_DEVICE
+		}
+		// usbi_err(handle.dev.ctx, "claim interface failed, errno %d", errno)
+		return LIBUSB_ERROR_OTHER
+	}
+	return 0
+}
+
+func release_interface(handle *libusb_device_handle, iface uint) libusb_error {
+	fd := _device_handle_priv(handle).fd
+	ifno := uint32(iface)
+	if _, errno := ioctl(fd, IOCTL_USBFS_RELEASEINTF, unsafe.Pointer(&ifno)); errno != 0 {
+		if errno == syscall.ENODEV {
+			return LIBUSB_ERROR_NO_DEVICE
+		}
+		// usbi_err(handle.dev.ctx, "release interface failed, errno %d", errno)
+		return LIBUSB_ERROR_OTHER
+	}
+	return 0
+}
+
+func detach_kernel_driver_and_claim(handle *libusb_device_handle, iface uint) libusb_error {
+	fd := _device_handle_priv(handle).fd
+
+	dc := usbfs_disconnect_claim{
+		iface: uint32(iface),
+		flags: USBFS_DISCONNECT_CLAIM_EXCEPT_DRIVER,
+	}
+	copy(dc.driver[:], "usbfs")
+	_, errno := ioctl(fd, IOCTL_USBFS_DISCONNECT_CLAIM, unsafe.Pointer(&dc))
+	switch errno {
+	case 0:
+		return 0
+	case syscall.ENOTTY:
+		/* Fallback code for kernels which don't support the
+		   disconnect-and-claim ioctl */
+	case syscall.EBUSY:
+		return LIBUSB_ERROR_BUSY
+	case syscall.EINVAL:
+		return LIBUSB_ERROR_INVALID_PARAM
+	case syscall.ENODEV:
+		return LIBUSB_ERROR_NO_DEVICE
+	default:
+		// usbi_err(handle.dev.ctx, "disconnect-and-claim failed errno %d", errno)
+		return LIBUSB_ERROR_OTHER
+	}
+
+	r := linux_detach_kernel_driver(handle, int(iface))
+	if r != 0 && r != LIBUSB_ERROR_NOT_FOUND {
+		return r
+	}
+
+	return claim_interface(handle, iface)
+}
+
+func (*linux_usbfs_backend) Claim_interface(handle *libusb_device_handle, iface uint) libusb_error {
+	if handle.auto_detach_kernel_driver != 0 {
+		return detach_kernel_driver_and_claim(handle, iface)
+	}
+	return claim_interface(handle, iface)
+}
+
+func (*linux_usbfs_backend) Release_interface(handle *libusb_device_handle, iface uint) libusb_error {
+	r := release_interface(handle, iface)
+	if r != 0 {
+		return r
+	}
+
+	if handle.auto_detach_kernel_driver != 0 {
+		linux_attach_kernel_driver(handle, int(iface))
+	}
+
+	return 0
+}
+
+func (*linux_usbfs_backend) Set_interface_altsetting(handle *libusb_device_handle, iface uint, altsetting int) libusb_error {
+	fd := _device_handle_priv(handle).fd
+
+	setintf := usbfs_setinterface{
+		iface:      uint32(iface),
+		altsetting: uint32(altsetting),
+	}
+	if _, errno := ioctl(fd, IOCTL_USBFS_SETINTF, unsafe.Pointer(&setintf)); errno != 0 {
+		switch errno {
+		case syscall.EINVAL:
+			return LIBUSB_ERROR_NOT_FOUND
+		case syscall.ENODEV:
+			return LIBUSB_ERROR_NO_DEVICE
+		}
+		// usbi_err(handle.dev.ctx, "setintf failed errno %d", errno)
+		return LIBUSB_ERROR_OTHER
+	}
+
+	return 0
+}
+
+func (*linux_usbfs_backend) Clear_halt(handle *libusb_device_handle, endpoint uint8) libusb_error {
+	fd := _device_handle_priv(handle).fd
+
+	_endpoint := uint32(endpoint)
+	if _, errno := ioctl(fd, IOCTL_USBFS_CLEAR_HALT, unsafe.Pointer(&_endpoint)); errno != 0 {
+		switch errno {
+		case syscall.ENOENT:
+			return LIBUSB_ERROR_NOT_FOUND
+		case syscall.ENODEV:
+			return LIBUSB_ERROR_NO_DEVICE
+		}
+		// usbi_err(handle.dev.ctx, "clear_halt failed errno %d", errno)
+		return LIBUSB_ERROR_OTHER
+	}
+
+	return 0
+}
+
+func (*linux_usbfs_backend) Reset_device(handle *libusb_device_handle) libusb_error {
+	fd := _device_handle_priv(handle).fd
+	ret := LIBUSB_SUCCESS
+
+	/* Doing a device reset will cause the usbfs driver to get unbound
+	   from any interfaces it is bound to. By voluntarily unbinding
+	   the usbfs driver ourself, we stop the kernel from rebinding
+	   the interface after reset (which would end up with the interface
+	   getting bound to the in kernel driver if any). */
+	for i := uint(0); i < USB_MAXINTERFACES; i++ {
+		if handle.claimed_interfaces&(1<<i) != 0 {
+			release_interface(handle, i)
+		}
+	}
+
+	handle.lock.Lock()
+	defer handle.lock.Unlock()
+
+	if _, errno := ioctl(fd, IOCTL_USBFS_RESET, nil); errno != 0 {
+		if errno == syscall.ENODEV {
+			return LIBUSB_ERROR_NOT_FOUND
+		}
+		// usbi_err(handle.dev.ctx, "reset failed errno %d", errno)
+		return LIBUSB_ERROR_OTHER
+	}
+
+	/* And re-claim any interfaces which were claimed before the reset */
+	for i := uint(0); i < USB_MAXINTERFACES; i++ {
+		if handle.claimed_interfaces&(1<<i) == 0 {
+			continue
+		}
+		/*
+		 * A driver may have completed modprobing during
+		 * IOCTL_USBFS_RESET, and bound itself as soon as
+		 * IOCTL_USBFS_RESET released the device lock
+		 */
+		if r := detach_kernel_driver_and_claim(handle, i); r != 0 {
+			// usbi_warn(handle.dev.ctx, "failed to re-claim interface %d after reset: %s",
+			// i, libusb_error_name(r))
+			handle.claimed_interfaces &^= 1 << i
+			ret = LIBUSB_ERROR_NOT_FOUND
+		}
+	}
+
+	return ret
+}
+
+func do_streams_ioctl(handle *libusb_device_handle, req uintptr, num_streams uint32, endpoints []uint8, num_endpoints int) libusb_error {
+	fd := _device_handle_priv(handle).fd
+
+	if num_endpoints > 30 { /* Max 15 in + 15 out eps */
+		return LIBUSB_ERROR_INVALID_PARAM
+	}
+
+	/* usbfs_streams is followed by the endpoint addresses */
+	hdr := int(unsafe.Sizeof(usbfs_streams{}))
+	buf := make([]uint8, hdr+num_endpoints)
+	streams := (*usbfs_streams)(unsafe.Pointer(&buf[0]))
+	streams.num_streams = num_streams
+	streams.num_eps = uint32(num_endpoints)
+	copy(buf[hdr:], endpoints[:num_endpoints])
+
+	r, errno := ioctl(fd, req, unsafe.Pointer(&buf[0]))
+	if errno != 0 {
+		switch errno {
+		case syscall.ENOTTY:
+			return LIBUSB_ERROR_NOT_SUPPORTED
+		case syscall.EINVAL:
+			return LIBUSB_ERROR_INVALID_PARAM
+		case syscall.ENODEV:
+			return LIBUSB_ERROR_NO_DEVICE
+		}
+		// usbi_err(handle.dev.ctx, "streams-ioctl failed errno %d", errno)
+		return LIBUSB_ERROR_OTHER
+	}
+	return libusb_error(r)
+}
+
+func (*linux_usbfs_backend) Alloc_streams(handle *libusb_device_handle, num_streams uint32, endpoints []uint8, num_endpoints int) libusb_error {
+	return do_streams_ioctl(handle, IOCTL_USBFS_ALLOC_STREAMS,
+		num_streams, endpoints, num_endpoints)
+}
+
+func (*linux_usbfs_backend) Free_streams(handle *libusb_device_handle, endpoints []uint8, num_endpoints int) libusb_error {
+	return do_streams_ioctl(handle, IOCTL_USBFS_FREE_STREAMS, 0,
+		endpoints, num_endpoints)
+}
+
+func (*linux_usbfs_backend) Dev_mem_alloc(handle *libusb_device_handle, length int) []uint8 {
+	fd := _device_handle_priv(handle).fd
+
+	buffer, err := syscall.Mmap(fd, 0, length, syscall.PROT_READ|syscall.PROT_WRITE,
+		syscall.MAP_SHARED)
+	if err != nil {
+		// usbi_err(handle.dev.ctx, "alloc dev mem failed: %s", err)
+		return nil
+	}
+	return buffer
+}
+
+func (*linux_usbfs_backend) Dev_mem_free(handle *libusb_device_handle, buffer []uint8, length int) libusb_error {
+	if err := syscall.Munmap(buffer[:length]); err != nil {
+		// usbi_err(handle.dev.ctx, "free dev mem failed: %s", err)
+		return LIBUSB_ERROR_OTHER
+	}
+	return LIBUSB_SUCCESS
+}
+
+func (*linux_usbfs_backend) Kernel_driver_active(handle *libusb_device_handle, iface int) libusb_error {
+	fd := _device_handle_priv(handle).fd
+
+	getdrv := usbfs_getdriver{iface: uint32(iface)}
+	if _, errno := ioctl(fd, IOCTL_USBFS_GETDRIVER, unsafe.Pointer(&getdrv)); errno != 0 {
+		switch errno {
+		case syscall.ENODATA:
+			return 0
+		case syscall.ENODEV:
+			return LIBUSB_ERROR_NO_DEVICE
+		}
+		// usbi_err(handle.dev.ctx, "get driver failed errno %d", errno)
+		return LIBUSB_ERROR_OTHER
+	}
+
+	if getdrv.driver_name() == "usbfs" {
+		return 0
+	}
+	return 1
+}
+
+func (getdrv *usbfs_getdriver) driver_name() string {
+	for i, c := range getdrv.driver {
+		if c == 0 {
+			return string(getdrv.driver[:i])
+		}
+	}
+	return string(getdrv.driver[:])
+}
+
+func linux_detach_kernel_driver(handle *libusb_device_handle, iface int) libusb_error {
+	fd := _device_handle_priv(handle).fd
+
+	getdrv := usbfs_getdriver{iface: uint32(iface)}
+	_, errno := ioctl(fd, IOCTL_USBFS_GETDRIVER, unsafe.Pointer(&getdrv))
+	if errno == 0 && getdrv.driver_name() == "usbfs" {
+		return LIBUSB_ERROR_NOT_FOUND
+	}
+
+	command := usbfs_ioctl{
+		ifno:       int32(iface),
+		ioctl_code: int32(IOCTL_USBFS_DISCONNECT),
+	}
+	if _, errno := ioctl(fd, IOCTL_USBFS_IOCTL, unsafe.Pointer(&command)); errno != 0 {
+		switch errno {
+		case syscall.ENODATA:
+			return LIBUSB_ERROR_NOT_FOUND
+		case syscall.EINVAL:
+			return LIBUSB_ERROR_INVALID_PARAM
+		case syscall.ENODEV:
+			return LIBUSB_ERROR_NO_DEVICE
+		}
+		// usbi_err(handle.dev.ctx, "detach failed errno %d", errno)
+		return LIBUSB_ERROR_OTHER
+	}
+
+	return 0
+}
+
+func linux_attach_kernel_driver(handle *libusb_device_handle, iface int) libusb_error {
+	fd := _device_handle_priv(handle).fd
+
+	command := usbfs_ioctl{
+		ifno:       int32(iface),
+		ioctl_code: int32(IOCTL_USBFS_CONNECT),
+	}
+	r, errno := ioctl(fd, IOCTL_USBFS_IOCTL, unsafe.Pointer(&command))
+	if errno != 0 {
+		switch errno {
+		case syscall.ENODATA:
+			return LIBUSB_ERROR_NOT_FOUND
+		case syscall.EINVAL:
+			return LIBUSB_ERROR_INVALID_PARAM
+		case syscall.ENODEV:
+			return LIBUSB_ERROR_NO_DEVICE
+		case syscall.EBUSY:
+			return LIBUSB_ERROR_BUSY
+		}
+		// usbi_err(handle.dev.ctx, "attach failed errno %d", errno)
+		return LIBUSB_ERROR_OTHER
+	} else if r == 0 {
+		return LIBUSB_ERROR_NOT_FOUND
+	}
+
+	return 0
+}
+
+func (*linux_usbfs_backend) Detach_kernel_driver(handle *libusb_device_handle, iface int) libusb_error {
+	return linux_detach_kernel_driver(handle, iface)
+}
+
+func (*linux_usbfs_backend) Attach_kernel_driver(handle *libusb_device_handle, iface int) libusb_error {
+	return linux_attach_kernel_driver(handle, iface)
+}
+
+func (*linux_usbfs_backend) Destroy_device(dev *libusb_device) {
+	dev.os_priv = nil
+}
+
+/* URBs are discarded in reverse order of submission to avoid races. */
+func discard_urbs(itransfer *usbi_transfer, first, last_plus_one int) libusb_error {
+	transfer := itransfer.libusbTransfer
+	tpriv := _transfer_priv(itransfer)
+	dpriv := _device_handle_priv(transfer.dev_handle)
+	ret := LIBUSB_SUCCESS
+
+	for i := last_plus_one - 1; i >= first; i-- {
+		var urb *usbfs_urb
+		if transfer._type == uint8(LIBUSB_TRANSFER_TYPE_ISOCHRONOUS) {
+			urb = &tpriv.iso_urbs[i].usbfs_urb
+		} else {
+			urb = &tpriv.urbs[i]
+		}
+
+		_, errno := ioctl(dpriv.fd, IOCTL_USBFS_DISCARDURB, unsafe.Pointer(urb))
+		switch errno {
+		case 0:
+		case syscall.EINVAL:
+			// usbi_dbg("URB not found --> assuming ready to be reaped")
+			if i == last_plus_one-1 {
+				ret = LIBUSB_ERROR_NOT_FOUND
+			}
+		case syscall.ENODEV:
+			// usbi_dbg("Device not found for URB --> assuming ready to be reaped")
+			ret = LIBUSB_ERROR_NO_DEVICE
+		default:
+			// usbi_warn(ctx, "unrecognised discard errno %d", errno)
+			ret = LIBUSB_ERROR_OTHER
+		}
+	}
+	return ret
+}
+
+/* Split a bulk or interrupt transfer into the URBs that carry it, each
+ * bulk_buffer_len bytes long except perhaps the last, given the
+ * capabilities of usbfs. */
+func split_bulk_transfer(transfer *libusb_transfer, stream_id uint32, caps uint32) ([]usbfs_urb, int) {
+	is_out := !IS_XFERIN(transfer)
+	add_zero_packet := transfer.flags&uint8(LIBUSB_TRANSFER_ADD_ZERO_PACKET) != 0
+
+	/*
+	 * Older versions of usbfs place a 16kb limit on bulk URBs. We work
+	 * around this by splitting large transfers into 16k blocks, and then
+	 * submit all urbs at once. it would be simpler to submit one urb at
+	 * a time, but there is a big performance gain doing it this way.
+	 *
+	 * Newer versions lift the 16k limit (USBFS_CAP_NO_PACKET_SIZE_LIM),
+	 * using arbritary large transfers can still be a bad idea though, as
+	 * the kernel needs to allocate physical contiguous memory for this,
+	 * which may fail for large buffers.
+	 *
+	 * The kernel solves this problem by splitting the transfer into
+	 * blocks itself when the host-controller is scatter-gather capable
+	 * (USBFS_CAP_BULK_SCATTER_GATHER), which most controllers are.
+	 *
+	 * Last, there is the issue of short-transfers when splitting, for
+	 * short split-transfers to work reliable USBFS_CAP_BULK_CONTINUATION
+	 * is needed, but this is not always available.
+	 */
+	var bulk_buffer_len int
+	var use_bulk_continuation bool
+	switch {
+	case caps&USBFS_CAP_BULK_SCATTER_GATHER != 0:
+		/* Good! Just submit everything in one go */
+		bulk_buffer_len = transfer.length
+	case caps&USBFS_CAP_BULK_CONTINUATION != 0:
+		/* Split the transfers and use bulk-continuation to
+		   avoid issues with short-transfers */
+		bulk_buffer_len = MAX_BULK_BUFFER_LENGTH
+		use_bulk_continuation = true
+	case caps&USBFS_CAP_NO_PACKET_SIZE_LIM != 0:
+		/* Don't split, assume the kernel can alloc the buffer
+		   (otherwise the submit will fail with -ENOMEM) */
+		bulk_buffer_len = transfer.length
+	default:
+		/* Bad, splitting without bulk-continuation, short transfers
+		   which end before the last urb will not work reliable! */
+		bulk_buffer_len = MAX_BULK_BUFFER_LENGTH
+	}
+	if bulk_buffer_len == 0 {
+		bulk_buffer_len = 1
+	}
+
+	num_urbs := transfer.length / bulk_buffer_len
+	last_urb_partial := false
+
+	if transfer.length == 0 {
+		num_urbs = 1
+	} else if transfer.length%bulk_buffer_len > 0 {
+		last_urb_partial = true
+		num_urbs++
+	}
+	// usbi_dbg("need %d urbs for new transfer with length %d", num_urbs, transfer.length)
+
+	urbs := make([]usbfs_urb, num_urbs)
+	for i := range urbs {
+		urb := &urbs[i]
+		switch libusb_transfer_type(transfer._type) {
+		case LIBUSB_TRANSFER_TYPE_BULK:
+			urb._type = USBFS_URB_TYPE_BULK
+			urb.number_of_packets = 0 /* stream_id */
+		case LIBUSB_TRANSFER_TYPE_BULK_STREAM:
+			urb._type = USBFS_URB_TYPE_BULK
+			urb.number_of_packets = int32(stream_id)
+		case LIBUSB_TRANSFER_TYPE_INTERRUPT:
+			urb._type = USBFS_URB_TYPE_INTERRUPT
+		}
+		urb.endpoint = transfer.endpoint
+		urb.buffer = buffer_at(transfer.buffer, i*bulk_buffer_len)
+		/* don't set the short not ok flag for the last URB */
+		if use_bulk_continuation && !is_out && i < num_urbs-1 {
+			urb.flags = USBFS_URB_SHORT_NOT_OK
+		}
+		if i == num_urbs-1 && last_urb_partial {
+			urb.buffer_length = int32(transfer.length % bulk_buffer_len)
+		} else if transfer.length == 0 {
+			urb.buffer_length = 0
+		} else {
+			urb.buffer_length = int32(bulk_buffer_len)
+		}
+
+		if i > 0 && use_bulk_continuation {
+			urb.flags |= USBFS_URB_BULK_CONTINUATION
+		}
+
+		/* we have already checked that the flag is supported */
+		if is_out && i == num_urbs-1 && add_zero_packet {
+			urb.flags |= USBFS_URB_ZERO_PACKET
+		}
+	}
+	return urbs, bulk_buffer_len
+}
+
+func submit_bulk_transfer(itransfer *usbi_transfer) libusb_error {
+	transfer := itransfer.libusbTransfer
+	tpriv := _transfer_priv(itransfer)
+	dpriv := _device_handle_priv(transfer.dev_handle)
+	is_out := !IS_XFERIN(transfer)
+	add_zero_packet := transfer.flags&uint8(LIBUSB_TRANSFER_ADD_ZERO_PACKET) != 0
+
+	if is_out && add_zero_packet && dpriv.caps&USBFS_CAP_ZERO_PACKET == 0 {
+		return LIBUSB_ERROR_NOT_SUPPORTED
+	}
+
+	urbs, bulk_buffer_len := split_bulk_transfer(transfer, itransfer.stream_id, dpriv.caps)
+	num_urbs := len(urbs)
+
+	tpriv.urbs = urbs
+	tpriv.iso_urbs = nil
+	tpriv.num_urbs = num_urbs
+	tpriv.num_retired = 0
+	tpriv.reap_action = REAP_NORMAL
+	tpriv.reap_status = LIBUSB_TRANSFER_COMPLETED
+	tpriv.bulk_buffer_len = bulk_buffer_len
+
+	for i := range urbs {
+		urb := &urbs[i]
+		dpriv.track_urb(urb, itransfer)
+		_, errno := ioctl(dpriv.fd, IOCTL_USBFS_SUBMITURB, unsafe.Pointer(urb))
+		if errno == 0 {
+			continue
+		}
+		dpriv.untrack_urb(urb)
+
+		r := LIBUSB_ERROR_IO
+		if errno == syscall.ENODEV {
+			r = LIBUSB_ERROR_NO_DEVICE
+		} // else
+		// usbi_err(ctx, "submiturb failed errno=%d", errno)
+
+		/* if the first URB submission fails, we can simply free up and
+		 * return failure immediately. */
+		if i == 0 {
+			// usbi_dbg("first URB failed, easy peasy")
+			tpriv.urbs = nil
+			return r
+		}
+
+		/* if it's not the first URB that failed, the situation is a bit
+		 * tricky. we may need to discard all previous URBs. there are
+		 * complications:
+		 *  - discarding is asynchronous - discarded urbs will be reaped
+		 *    later. the user must not have freed the transfer when the
+		 *    discarded URBs are reaped, otherwise libusb will be using
+		 *    freed memory.
+		 *  - the earlier URBs may have completed successfully and we do
+		 *    not want to throw away any data.
+		 *  - this URB failing may be no error; EREMOTEIO means that
+		 *    this transfer simply didn't need all the URBs we submitted
+		 * so, we report that the transfer was submitted successfully and
+		 * in case of error we discard all previous URBs. later when
+		 * the final reap completes we can report error to the user,
+		 * or success if an earlier URB was completed successfully.
+		 */
+		if errno == syscall.EREMOTEIO {
+			tpriv.reap_action = REAP_COMPLETED_EARLY
+		} else {
+			tpriv.reap_action = REAP_SUBMIT_FAILED
+		}
+
+		/* The URBs we haven't submitted yet we count as already
+		 * retired. */
+		tpriv.num_retired += num_urbs - i
+
+		/* If we completed short then don't try to discard. */
+		if tpriv.reap_action == REAP_COMPLETED_EARLY {
+			return 0
+		}
+
+		discard_urbs(itransfer, 0, i)
+
+		// usbi_dbg("reporting successful submission but waiting for %d "
+		// "discards before reporting error", i)
+		return 0
+	}
+
+	return 0
+}
+
+func submit_iso_transfer(itransfer *usbi_transfer) libusb_error {
+	transfer := itransfer.libusbTransfer
+	tpriv := _transfer_priv(itransfer)
+	dpriv := _device_handle_priv(transfer.dev_handle)
+	num_packets := transfer.num_iso_packets
+
+	/* usbfs places arbitrary limits on iso URBs. this limit has changed
+	 * at least three times, and it's difficult to accurately detect which
+	 * limit this running kernel might impose. so we attempt to submit
+	 * whatever the user has provided. if the kernel rejects the request
+	 * due to its size, we return an error indicating such to the user.
+	 */
+
+	/* swallow up all the packets we can fit into each URB */
+	var urbs []*usbfs_iso_urb
+	buffer_offset := 0
+	for packet_offset := 0; packet_offset < num_packets; {
+		urb := &usbfs_iso_urb{}
+		urb._type = USBFS_URB_TYPE_ISO
+		/* FIXME: interface for non-ASAP data? */
+		urb.flags = USBFS_URB_ISO_ASAP
+		urb.endpoint = transfer.endpoint
+		urb.buffer = buffer_at(transfer.buffer, buffer_offset)
+
+		space_remaining_in_urb := uint(MAX_ISO_BUFFER_LENGTH)
+		j := 0
+		for packet_offset < num_packets && j < MAX_ISO_PACKETS_PER_URB {
+			packet_len := transfer.iso_packet_desc[packet_offset].length
+			if packet_len > space_remaining_in_urb {
+				/* it can't fit, save it for the next URB */
+				break
+			}
+			/* throw it in */
+			urb.iso_frame_desc[j].length = uint32(packet_len)
+			space_remaining_in_urb -= packet_len
+			buffer_offset += int(packet_len)
+			packet_offset++
+			j++
+		}
+		if j == 0 {
+			/* a single packet larger than an URB can hold */
+			return LIBUSB_ERROR_INVALID_PARAM
+		}
+
+		urb.number_of_packets = int32(j)
+		urb.buffer_length = int32(MAX_ISO_BUFFER_LENGTH - space_remaining_in_urb)
+		urbs = append(urbs, urb)
+	}
+	num_urbs := len(urbs)
+	// usbi_dbg("need %d %dk URBs for transfer", num_urbs, MAX_ISO_BUFFER_LENGTH / 1024)
+
+	tpriv.urbs = nil
+	tpriv.iso_urbs = urbs
+	tpriv.num_urbs = num_urbs
+	tpriv.num_retired = 0
+	tpriv.reap_action = REAP_NORMAL
+	tpriv.iso_packet_offset = 0
+
+	/* submit URBs */
+	for i, urb := range urbs {
+		dpriv.track_urb(&urb.usbfs_urb, itransfer)
+		_, errno := ioctl(dpriv.fd, IOCTL_USBFS_SUBMITURB, unsafe.Pointer(urb))
+		if errno == 0 {
+			continue
+		}
+		dpriv.untrack_urb(&urb.usbfs_urb)
+
+		r := LIBUSB_ERROR_IO
+		switch errno {
+		case syscall.ENODEV:
+			r = LIBUSB_ERROR_NO_DEVICE
+		case syscall.EINVAL:
+			// usbi_warn(ctx, "submiturb failed, transfer too large")
+			r = LIBUSB_ERROR_INVALID_PARAM
+		default:
+			// usbi_err(ctx, "submiturb failed errno=%d", errno)
+		}
+
+		/* if the first URB submission fails, we can simply free up and
+		 * return failure immediately. */
+		if i == 0 {
+			// usbi_dbg("first URB failed, easy peasy")
+			tpriv.iso_urbs = nil
+			return r
+		}
+
+		/* if it's not the first URB that failed, the situation is a bit
+		 * tricky. we must discard all previous URBs. there are
+		 * complications:
+		 *  - discarding is asynchronous - discarded urbs will be reaped
+		 *    later. the user must not have freed the transfer when the
+		 *    discarded URBs are reaped, otherwise libusb will be using
+		 *    freed memory.
+		 *  - the earlier URBs may have completed successfully and we do
+		 *    not want to throw away any data.
+		 * so, in this case we discard all the previous URBs BUT we report
+		 * that the transfer was submitted successfully. then later when
+		 * the final discard completes we can report error to the user.
+		 */
+		tpriv.reap_action = REAP_SUBMIT_FAILED
+
+		/* The URBs we haven't submitted yet we count as already
+		 * retired. */
+		tpriv.num_retired = num_urbs - i
+		discard_urbs(itransfer, 0, i)
+
+		// usbi_dbg("reporting successful submission but waiting for %d "
+		// "discards before reporting error", i)
+		return 0
+	}
+
+	return 0
+}
+
+func submit_control_transfer(itransfer *usbi_transfer) libusb_error {
+	transfer := itransfer.libusbTransfer
+	tpriv := _transfer_priv(itransfer)
+	dpriv := _device_handle_priv(transfer.dev_handle)
+
+	if transfer.length-LIBUSB_CONTROL_SETUP_SIZE > MAX_CTRL_BUFFER_LENGTH {
+		return LIBUSB_ERROR_INVALID_PARAM
+	}
+
+	tpriv.urbs = make([]usbfs_urb, 1)
+	tpriv.iso_urbs = nil
+	tpriv.num_urbs = 1
+	tpriv.reap_action = REAP_NORMAL
+
+	urb := &tpriv.urbs[0]
+	urb._type = USBFS_URB_TYPE_CONTROL
+	urb.endpoint = transfer.endpoint
+	urb.buffer = buffer_at(transfer.buffer, 0)
+	urb.buffer_length = int32(transfer.length)
+
+	dpriv.track_urb(urb, itransfer)
+	if _, errno := ioctl(dpriv.fd, IOCTL_USBFS_SUBMITURB, unsafe.Pointer(urb)); errno != 0 {
+		dpriv.untrack_urb(urb)
+		tpriv.urbs = nil
+		if errno == syscall.ENODEV {
+			return LIBUSB_ERROR_NO_DEVICE
+		}
+		// usbi_err(ctx, "submiturb failed errno=%d", errno)
+		return LIBUSB_ERROR_IO
+	}
+	return 0
+}
+
+func (*linux_usbfs_backend) Submit_transfer(itransfer *usbi_transfer) libusb_error {
+	transfer := itransfer.libusbTransfer
+
+	switch libusb_transfer_type(transfer._type) {
+	case LIBUSB_TRANSFER_TYPE_CONTROL:
+		return submit_control_transfer(itransfer)
+	case LIBUSB_TRANSFER_TYPE_BULK, LIBUSB_TRANSFER_TYPE_BULK_STREAM:
+		return submit_bulk_transfer(itransfer)
+	case LIBUSB_TRANSFER_TYPE_INTERRUPT:
+		return submit_bulk_transfer(itransfer)
+	case LIBUSB_TRANSFER_TYPE_ISOCHRONOUS:
+		return submit_iso_transfer(itransfer)
+	}
+	// usbi_err(ctx, "unknown endpoint type %d", transfer._type)
+	return LIBUSB_ERROR_INVALID_PARAM
+}
+
+func (*linux_usbfs_backend) Cancel_transfer(itransfer *usbi_transfer) libusb_error {
+	tpriv := _transfer_priv(itransfer)
+	transfer := itransfer.libusbTransfer
+
+	if tpriv.urbs == nil && tpriv.iso_urbs == nil {
+		return LIBUSB_ERROR_NOT_FOUND
+	}
+
+	r := discard_urbs(itransfer, 0, tpriv.num_urbs)
+	if r != 0 {
+		return r
+	}
+
+	switch libusb_transfer_type(transfer._type) {
+	case LIBUSB_TRANSFER_TYPE_BULK, LIBUSB_TRANSFER_TYPE_BULK_STREAM:
+		if tpriv.reap_action == REAP_ERROR {
+			break
+		}
+		fallthrough
+	default:
+		tpriv.reap_action = REAP_CANCELLED
+	}
+
+	return 0
+}
+
+func (*linux_usbfs_backend) Clear_transfer_priv(itransfer *usbi_transfer) {
+	tpriv := _transfer_priv(itransfer)
+	if itransfer.libusbTransfer.dev_handle != nil {
+		dpriv := _device_handle_priv(itransfer.libusbTransfer.dev_handle)
+		for i := range tpriv.urbs {
+			dpriv.untrack_urb(&tpriv.urbs[i])
+		}
+		for _, urb := range tpriv.iso_urbs {
+			dpriv.untrack_urb(&urb.usbfs_urb)
+		}
+	}
+	tpriv.urbs = nil
+	tpriv.iso_urbs = nil
+}
+
+func handle_bulk_completion(itransfer *usbi_transfer, urb *usbfs_urb) libusb_error {
+	tpriv := _transfer_priv(itransfer)
+	transfer := itransfer.libusbTransfer
+
+	urb_idx := 0
+	for urb_idx < len(tpriv.urbs) && &tpriv.urbs[urb_idx] != urb {
+		urb_idx++
+	}
+
+	itransfer.lock.Lock()
+	// usbi_dbg("handling completion status %d of bulk urb %d/%d", urb.status,
+	// urb_idx+1, tpriv.num_urbs)
+
+	tpriv.num_retired++
+
+	completed := false
+	cancel_remaining := false
+
+	if tpriv.reap_action != REAP_NORMAL {
+		/* cancelled, submit_fail, or completed early */
+		// usbi_dbg("abnormal reap: urb status %d", urb.status)
+
+		/* even though we're in the process of cancelling, it's possible that
+		 * we may receive some data in these URBs that we don't want to lose.
+		 * When this happens, our objectives are not to lose any "surplus" data,
+		 * and also to stick it at the end of the previously-received data
+		 * (closing any holes), so that libusb reports the total amount of
+		 * transferred data and presents it in a contiguous chunk.
+		 */
+		if urb.actual_length > 0 {
+			target := itransfer.transferred
+			source := urb_idx * tpriv.bulk_buffer_len
+			// usbi_dbg("received %d bytes of surplus data", urb.actual_length)
+			if source != target {
+				// usbi_dbg("moving surplus data from offset %d to offset %d", source, target)
+				copy(transfer.buffer[target:], transfer.buffer[source:source+int(urb.actual_length)])
+			}
+			itransfer.transferred += int(urb.actual_length)
+		}
+
+		if tpriv.num_retired == tpriv.num_urbs {
+			// usbi_dbg("abnormal reap: last URB handled, reporting")
+			if tpriv.reap_action != REAP_COMPLETED_EARLY &&
+				tpriv.reap_status == LIBUSB_TRANSFER_COMPLETED {
+				tpriv.reap_status = LIBUSB_TRANSFER_ERROR
+			}
+			completed = true
+		}
+	} else {
+		itransfer.transferred += int(urb.actual_length)
+
+		/* Many of these errors can occur on *any* urb of a multi-urb
+		 * transfer.  When they do, we tear down the rest of the transfer.
+		 */
+		switch syscall.Errno(-urb.status) {
+		case 0:
+		case syscall.EREMOTEIO: /* short transfer */
+		case syscall.ENOENT, syscall.ECONNRESET: /* cancelled */
+		case syscall.ENODEV, syscall.ESHUTDOWN:
+			// usbi_dbg("device removed")
+			tpriv.reap_status = LIBUSB_TRANSFER_NO_DEVICE
+			cancel_remaining = true
+		case syscall.EPIPE:
+			// usbi_dbg("detected endpoint stall")
+			if tpriv.reap_status == LIBUSB_TRANSFER_COMPLETED {
+				tpriv.reap_status = LIBUSB_TRANSFER_STALL
+			}
+			cancel_remaining = true
+		case syscall.EOVERFLOW:
+			/* overflow can only ever occur in the last urb */
+			// usbi_dbg("overflow, actual_length=%d", urb.actual_length)
+			if tpriv.reap_status == LIBUSB_TRANSFER_COMPLETED {
+				tpriv.reap_status = LIBUSB_TRANSFER_OVERFLOW
+			}
+			completed = true
+		case syscall.ETIME, syscall.EPROTO, syscall.EILSEQ, syscall.ECOMM, syscall.ENOSR:
+			// usbi_dbg("low level error %d", urb.status)
+			tpriv.reap_action = REAP_ERROR
+			cancel_remaining = true
+		default:
+			// usbi_warn(ctx, "unrecognised urb status %d", urb.status)
+			tpriv.reap_action = REAP_ERROR
+			cancel_remaining = true
+		}
+
+		/* if we're the last urb or we got less data than requested then we're
+		 * done */
+		if !completed && !cancel_remaining {
+			if urb_idx == tpriv.num_urbs-1 {
+				// usbi_dbg("last URB in transfer --> complete!")
+				completed = true
+			} else if urb.actual_length < urb.buffer_length {
+				// usbi_dbg("short transfer %d/%d --> complete!",
+				// urb.actual_length, urb.buffer_length)
+				if tpriv.reap_action == REAP_NORMAL {
+					tpriv.reap_action = REAP_COMPLETED_EARLY
+				}
+				cancel_remaining = true
+			}
+		}
+	}
+
+	if cancel_remaining {
+		if tpriv.reap_action == REAP_ERROR && tpriv.reap_status == LIBUSB_TRANSFER_COMPLETED {
+			tpriv.reap_status = LIBUSB_TRANSFER_ERROR
+		}
+
+		if tpriv.num_retired == tpriv.num_urbs { /* nothing to cancel */
+			completed = true
+		} else {
+			/* cancel remaining urbs and wait for their completion before
+			 * reporting results */
+			discard_urbs(itransfer, urb_idx+1, tpriv.num_urbs)
+		}
+	}
+
+	if !completed {
+		itransfer.lock.Unlock()
+		return 0
+	}
+
+	tpriv.urbs = nil
+	itransfer.lock.Unlock()
+	return linux_signal_completion(itransfer, tpriv.reap_status)
+}
+
+func handle_iso_completion(itransfer *usbi_transfer, urb *usbfs_urb) libusb_error {
+	transfer := itransfer.libusbTransfer
+	tpriv := _transfer_priv(itransfer)
+	num_urbs := tpriv.num_urbs
+	status := LIBUSB_TRANSFER_COMPLETED
+
+	itransfer.lock.Lock()
+	var iso_urb *usbfs_iso_urb
+	urb_idx := 0
+	for i, u := range tpriv.iso_urbs {
+		if &u.usbfs_urb == urb {
+			iso_urb = u
+			urb_idx = i + 1
+			break
+		}
+	}
+	if iso_urb == nil {
+		// usbi_err(ctx, "could not locate urb!")
+		itransfer.lock.Unlock()
+		return LIBUSB_ERROR_NOT_FOUND
+	}
+
+	// usbi_dbg("handling completion status %d of iso urb %d/%d", urb.status,
+	// urb_idx, num_urbs)
+
+	/* copy isochronous results back in */
+	for i := 0; i < int(urb.number_of_packets); i++ {
+		urb_desc := &iso_urb.iso_frame_desc[i]
+		lib_desc := &transfer.iso_packet_desc[tpriv.iso_packet_offset]
+		tpriv.iso_packet_offset++
+		lib_desc.status = LIBUSB_TRANSFER_COMPLETED
+		switch syscall.Errno(-urb_desc.status) {
+		case 0:
+		case syscall.ENOENT, syscall.ECONNRESET: /* cancelled */
+		case syscall.ENODEV, syscall.ESHUTDOWN:
+			// usbi_dbg("device removed")
+			lib_desc.status = LIBUSB_TRANSFER_NO_DEVICE
+		case syscall.EPIPE:
+			// usbi_dbg("detected endpoint stall")
+			lib_desc.status = LIBUSB_TRANSFER_STALL
+		case syscall.EOVERFLOW:
+			// usbi_dbg("overflow error")
+			lib_desc.status = LIBUSB_TRANSFER_OVERFLOW
+		case syscall.ETIME, syscall.EPROTO, syscall.EILSEQ, syscall.ECOMM, syscall.ENOSR, syscall.EXDEV:
+			// usbi_dbg("low-level USB error %d", urb_desc.status)
+			lib_desc.status = LIBUSB_TRANSFER_ERROR
+		default:
+			// usbi_warn(ctx, "unrecognised urb status %d", urb_desc.status)
+			lib_desc.status = LIBUSB_TRANSFER_ERROR
+		}
+		lib_desc.actual_length = uint(urb_desc.actual_length)
+	}
+
+	tpriv.num_retired++
+
+	if tpriv.reap_action != REAP_NORMAL { /* cancelled or submit_fail */
+		// usbi_dbg("CANCEL: urb status %d", urb.status)
+
+		if tpriv.num_retired == num_urbs {
+			// usbi_dbg("CANCEL: last URB handled, reporting")
+			tpriv.iso_urbs = nil
+			itransfer.lock.Unlock()
+			return linux_signal_completion(itransfer, LIBUSB_TRANSFER_ERROR)
+		}
+		itransfer.lock.Unlock()
+		return 0
+	}
+
+	switch syscall.Errno(-urb.status) {
+	case 0:
+	case syscall.ENOENT, syscall.ECONNRESET: /* cancelled */
+	case syscall.ESHUTDOWN:
+		// usbi_dbg("device removed")
+		status = LIBUSB_TRANSFER_NO_DEVICE
+	default:
+		// usbi_warn(ctx, "unrecognised urb status %d", urb.status)
+		status = LIBUSB_TRANSFER_ERROR
+	}
+
+	/* if we're the last urb then we're done */
+	if urb_idx == num_urbs {
+		// usbi_dbg("last URB in transfer --> complete!")
+		tpriv.iso_urbs = nil
+		itransfer.lock.Unlock()
+		return linux_signal_completion(itransfer, status)
+	}
+
+	itransfer.lock.Unlock()
+	return 0
+}
+
+func handle_control_completion(itransfer *usbi_transfer, urb *usbfs_urb) libusb_error {
+	tpriv := _transfer_priv(itransfer)
+	var status libusb_transfer_status
+
+	itransfer.lock.Lock()
+	// usbi_dbg("handling completion status %d", urb.status)
+
+	itransfer.transferred += int(urb.actual_length)
+
+	if tpriv.reap_action == REAP_CANCELLED {
+		// if urb.status != 0 && urb.status != -ENOENT
+		// usbi_warn(ctx, "cancel: unrecognised urb status %d", urb.status)
+		tpriv.urbs = nil
+		itransfer.lock.Unlock()
+		return linux_signal_completion(itransfer, LIBUSB_TRANSFER_CANCELLED)
+	}
+
+	switch syscall.Errno(-urb.status) {
+	case 0:
+		status = LIBUSB_TRANSFER_COMPLETED
+	case syscall.ENOENT: /* cancelled */
+		status = LIBUSB_TRANSFER_CANCELLED
+	case syscall.ENODEV, syscall.ESHUTDOWN:
+		// usbi_dbg("device removed")
+		status = LIBUSB_TRANSFER_NO_DEVICE
+	case syscall.EPIPE:
+		// usbi_dbg("unsupported control request")
+		status = LIBUSB_TRANSFER_STALL
+	case syscall.EOVERFLOW:
+		// usbi_dbg("control overflow error")
+		status = LIBUSB_TRANSFER_OVERFLOW
+	case syscall.ETIME, syscall.EPROTO, syscall.EILSEQ, syscall.ECOMM, syscall.ENOSR:
+		// usbi_dbg("low-level bus error occurred")
+		status = LIBUSB_TRANSFER_ERROR
+	default:
+		// usbi_warn(ctx, "unrecognised urb status %d", urb.status)
+		status = LIBUSB_TRANSFER_ERROR
+	}
+
+	tpriv.urbs = nil
+	itransfer.lock.Unlock()
+	return linux_signal_completion(itransfer, status)
+}
+
+/* linux_signal_completion records how a transfer ended and hands it to the
+ * event handling goroutine, which reports it from Handle_transfer_completion.
+ * The status is ignored for cancelled transfers. */
+func linux_signal_completion(itransfer *usbi_transfer, status libusb_transfer_status) libusb_error {
+	_transfer_priv(itransfer).reap_status = status
+	usbi_signal_transfer_completion(itransfer)
+	return LIBUSB_SUCCESS
+}
+
+/* reap_for_handle reaps a single URB. It returns 1 when there was nothing
+ * to reap. */
+func reap_for_handle(handle *libusb_device_handle) libusb_error {
+	hpriv := _device_handle_priv(handle)
+
+	var p unsafe.Pointer
+	_, errno := ioctl(hpriv.fd, IOCTL_USBFS_REAPURBNDELAY, unsafe.Pointer(&p))
+	if errno == syscall.EAGAIN {
+		return 1
+	}
+	if errno != 0 {
+		if errno == syscall.ENODEV {
+			return LIBUSB_ERROR_NO_DEVICE
+		}
+		// usbi_err(handle.dev.ctx, "reap failed errno=%d", errno)
+		return LIBUSB_ERROR_IO
+	}
+
+	urb := (*usbfs_urb)(p)
+	itransfer := hpriv.untrack_urb(urb)
+	if itransfer == nil {
+		// usbi_err(handle.dev.ctx, "reaped unknown urb %p", urb)
+		return LIBUSB_ERROR_NOT_FOUND
+	}
+	transfer := itransfer.libusbTransfer
+
+	// usbi_dbg("urb type=%d status=%d transferred=%d", urb._type, urb.status,
+	// urb.actual_length)
+
+	switch libusb_transfer_type(transfer._type) {
+	case LIBUSB_TRANSFER_TYPE_ISOCHRONOUS:
+		return handle_iso_completion(itransfer, urb)
+	case LIBUSB_TRANSFER_TYPE_BULK, LIBUSB_TRANSFER_TYPE_BULK_STREAM, LIBUSB_TRANSFER_TYPE_INTERRUPT:
+		return handle_bulk_completion(itransfer, urb)
+	case LIBUSB_TRANSFER_TYPE_CONTROL:
+		return handle_control_completion(itransfer, urb)
+	}
+	// usbi_err(handle.dev.ctx, "unrecognised endpoint type %x", transfer._type)
+	return LIBUSB_ERROR_OTHER
+}
+
+/* linux_reap_urbs watches the usbfs fd of a handle for completed URBs until
+ * the handle is closed or the device goes away. */
+func linux_reap_urbs(handle *libusb_device_handle, hpriv *linux_device_handle_priv) {
+	defer close(hpriv.reaper_done)
+
+	pfd := kernel_pollfd{fd: int32(hpriv.fd), events: POLLOUT}
+	ts := syscall.NsecToTimespec(int64(LINUX_REAP_INTERVAL))
+	for {
+		select {
+		case <-hpriv.reaper_quit:
+			return
+		default:
+		}
+
+		pfd.revents = 0
+		n, _, errno := syscall.Syscall6(syscall.SYS_PPOLL, uintptr(unsafe.Pointer(&pfd)), 1,
+			uintptr(unsafe.Pointer(&ts)), 0, 0, 0)
+		if errno == syscall.EINTR || (errno == 0 && n == 0) {
+			continue
+		}
+		if errno != 0 {
+			// usbi_err(handle.dev.ctx, "poll failed errno=%d", errno)
+			return
+		}
+
+		if pfd.revents&POLLERR != 0 {
+			/* device will still be marked as attached if the hotplug
+			 * poll hasn't processed the removal yet */
+			linux_hotplug_lock.Lock()
+			if handle.dev.attached {
+				linux_device_disconnected(handle.dev.bus_number,
+					handle.dev.device_address)
+			}
+			linux_hotplug_lock.Unlock()
+
+			if hpriv.caps&USBFS_CAP_REAP_AFTER_DISCONNECT != 0 {
+				for reap_for_handle(handle) == 0 {
+				}
+			}
+
+			/* stop here so that the fd doesn't continuously trigger */
+			usbi_handle_disconnect(handle)
+			return
+		}
+
+		for reap_for_handle(handle) == 0 {
+		}
+	}
+}
+
+func (*linux_usbfs_backend) Handle_transfer_completion(itransfer *usbi_transfer) libusb_error {
+	tpriv := _transfer_priv(itransfer)
+	if tpriv.reap_action == REAP_CANCELLED {
+		return libusb_error(usbi_handle_transfer_cancellation(itransfer))
+	}
+	return libusb_error(usbi_handle_transfer_completion(itransfer, tpriv.reap_status))
+}
+
+func (*linux_usbfs_backend) Device_priv_size() int {
+	return int(unsafe.Sizeof(linux_device_priv{}))
+}
+
+func (*linux_usbfs_backend) Device_handle_priv_size() int {
+	return int(unsafe.Sizeof(linux_device_handle_priv{}))
+}
+
+func (*linux_usbfs_backend) Transfer_priv_size() int {
+	return int(unsafe.Sizeof(linux_transfer_priv{}))
+}
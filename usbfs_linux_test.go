@@ -0,0 +1,175 @@
+//go:build linux
+// +build linux
+
+package usb
+
+import (
+	"bytes"
+	"testing"
+)
+
+// sysfsConfigs are the two configurations in sysfsDescriptors: the first
+// with a bulk interface, the second with a HID interface whose class
+// descriptor must stay part of it.
+var sysfsConfigs = [][]byte{{
+	0x09, 0x02, 0x20, 0x00, 0x01, 0x01, 0x00, 0x80, 0x32, // config 1
+	0x09, 0x04, 0x00, 0x00, 0x02, 0xff, 0x00, 0x00, 0x00, // interface
+	0x07, 0x05, 0x81, 0x02, 0x40, 0x00, 0x00, // bulk IN
+	0x07, 0x05, 0x01, 0x02, 0x40, 0x00, 0x00, // bulk OUT
+}, {
+	0x09, 0x02, 0x1b, 0x00, 0x01, 0x02, 0x00, 0x80, 0x32, // config 2
+	0x09, 0x04, 0x00, 0x00, 0x00, 0x03, 0x00, 0x00, 0x00, // interface
+	0x09, 0x21, 0x11, 0x01, 0x00, 0x01, 0x22, 0x20, 0x00, // HID
+}}
+
+// sysfsDescriptors is the descriptors attribute of a device with two
+// configurations, as sysfs lays it out.
+var sysfsDescriptors = append(append(append([]byte(nil), testDeviceDescriptor...),
+	sysfsConfigs[0]...), sysfsConfigs[1]...)
+
+func sysfsDevice(descriptors []byte) *libusb_device {
+	return &libusb_device{os_priv: &linux_device_priv{descriptors: descriptors}}
+}
+
+func TestSysfsConfigDescriptor(t *testing.T) {
+	b := &linux_usbfs_backend{}
+	dev := sysfsDevice(sysfsDescriptors)
+	var host_endian int
+
+	buf := make([]byte, 64)
+	if r := b.Get_device_descriptor(dev, buf, &host_endian); r != 0 ||
+		!bytes.Equal(buf[:DEVICE_DESC_LENGTH], testDeviceDescriptor) {
+		t.Errorf("device descriptor % x, %v", buf[:DEVICE_DESC_LENGTH], r)
+	}
+	for i, want := range sysfsConfigs {
+		r := b.Get_config_descriptor(dev, uint8(i), buf, len(buf), &host_endian)
+		if r != libusb_error(len(want)) || !bytes.Equal(buf[:len(want)], want) {
+			t.Errorf("config %d: read %v bytes, % x", i, r, buf[:len(want)])
+		}
+
+		var config []uint8
+		r = b.Get_config_descriptor_by_value(dev, uint8(i+1), &config, &host_endian)
+		if r != libusb_error(len(want)) || !bytes.Equal(config, want) {
+			t.Errorf("config value %d: read %v bytes, % x", i+1, r, config)
+		}
+	}
+
+	/* the length bounds the copy, but not the descriptor length */
+	short := make([]byte, 4)
+	if r := b.Get_config_descriptor(dev, 1, short, len(short), &host_endian); r != 4 ||
+		!bytes.Equal(short, sysfsConfigs[1][:4]) {
+		t.Errorf("short read of config 1 returned %v, % x", r, short)
+	}
+	if r := b.Get_config_descriptor(dev, 2, buf, len(buf), &host_endian); r != LIBUSB_ERROR_NOT_FOUND {
+		t.Errorf("config 2 returned %v, want LIBUSB_ERROR_NOT_FOUND", r)
+	}
+	var config []uint8
+	if r := b.Get_config_descriptor_by_value(dev, 3, &config, &host_endian); r != LIBUSB_ERROR_NOT_FOUND {
+		t.Errorf("config value 3 returned %v, want LIBUSB_ERROR_NOT_FOUND", r)
+	}
+}
+
+func TestSysfsMalformedConfig(t *testing.T) {
+	tests := []struct {
+		name   string
+		config []byte
+	}{
+		{"short header", sysfsConfigs[0][:5]},
+		{"not a config descriptor", append([]byte{0x09, 0x04}, sysfsConfigs[0][2:]...)},
+		{"zero bLength", append(append([]byte(nil), sysfsConfigs[0][:9]...), 0x00, 0x04)},
+		{"bLength past the end", sysfsConfigs[0][:12]},
+		{"truncated descriptor header", sysfsConfigs[0][:10]},
+	}
+	b := &linux_usbfs_backend{}
+	for _, tt := range tests {
+		dev := sysfsDevice(append(append([]byte(nil), testDeviceDescriptor...), tt.config...))
+		var host_endian int
+		buf := make([]byte, 64)
+		if r := b.Get_config_descriptor(dev, 0, buf, len(buf), &host_endian); r != LIBUSB_ERROR_IO {
+			t.Errorf("%s: returned %v, want LIBUSB_ERROR_IO", tt.name, r)
+		}
+	}
+}
+
+func TestSysfsIsDevice(t *testing.T) {
+	for name, want := range map[string]bool{
+		"usb1":      true,
+		"1-1":       true,
+		"1-1.2":     true,
+		"1-1.2:1.0": false,
+		"usb1:1.0":  false,
+		"driver":    false,
+	} {
+		if got := sysfs_is_device(name); got != want {
+			t.Errorf("sysfs_is_device(%q) = %v, want %v", name, got, want)
+		}
+	}
+}
+
+func TestSplitBulkTransfer(t *testing.T) {
+	const (
+		notOK   = USBFS_URB_SHORT_NOT_OK
+		cont    = USBFS_URB_BULK_CONTINUATION
+		zero    = USBFS_URB_ZERO_PACKET
+		size    = MAX_BULK_BUFFER_LENGTH
+		addZero = uint8(LIBUSB_TRANSFER_ADD_ZERO_PACKET)
+
+		capScatter = USBFS_CAP_BULK_SCATTER_GATHER
+		capCont    = USBFS_CAP_BULK_CONTINUATION
+		capNoLimit = USBFS_CAP_NO_PACKET_SIZE_LIM
+	)
+	type urb struct {
+		length int32
+		flags  uint32
+	}
+	tests := []struct {
+		name     string
+		caps     uint32
+		endpoint uint8
+		flags    uint8
+		length   int
+		urbs     []urb
+	}{
+		{"scatter gather", capScatter | capCont, 0x81, 0, 3 * size, []urb{{3 * size, 0}}},
+		{"no size limit", capNoLimit, 0x81, 0, 3 * size, []urb{{3 * size, 0}}},
+		{"continuation in", capCont, 0x81, 0, 2*size + 100,
+			[]urb{{size, notOK}, {size, notOK | cont}, {100, cont}}},
+		{"continuation out", capCont, 0x01, addZero, 2 * size,
+			[]urb{{size, 0}, {size, cont | zero}}},
+		{"no continuation", 0, 0x81, 0, size + 1, []urb{{size, 0}, {1, 0}}},
+		{"empty", capCont, 0x81, 0, 0, []urb{{0, 0}}},
+		{"empty with scatter gather", capScatter, 0x01, addZero, 0, []urb{{0, zero}}},
+	}
+	for _, tt := range tests {
+		buf := make([]byte, tt.length)
+		transfer := &libusb_transfer{
+			_type:    uint8(LIBUSB_TRANSFER_TYPE_BULK),
+			endpoint: tt.endpoint,
+			flags:    tt.flags,
+			length:   tt.length,
+			buffer:   buf,
+		}
+		urbs, bulk_buffer_len := split_bulk_transfer(transfer, 0, tt.caps)
+		if len(urbs) != len(tt.urbs) {
+			t.Errorf("%s: %d URBs, want %d", tt.name, len(urbs), len(tt.urbs))
+			continue
+		}
+		for i, want := range tt.urbs {
+			got := urbs[i]
+			if got.buffer_length != want.length || got.flags != want.flags {
+				t.Errorf("%s: URB %d of %d bytes with flags %#x, want %d with %#x",
+					tt.name, i, got.buffer_length, got.flags, want.length, want.flags)
+			}
+			if got._type != USBFS_URB_TYPE_BULK || got.endpoint != tt.endpoint ||
+				got.buffer != buffer_at(buf, i*bulk_buffer_len) {
+				t.Errorf("%s: URB %d is %+v", tt.name, i, got)
+			}
+		}
+	}
+
+	/* bulk streams carry their stream ID in number_of_packets */
+	transfer := &libusb_transfer{_type: uint8(LIBUSB_TRANSFER_TYPE_BULK_STREAM), endpoint: 0x81, length: 8, buffer: make([]byte, 8)}
+	if urbs, _ := split_bulk_transfer(transfer, 7, capScatter); urbs[0].number_of_packets != 7 {
+		t.Errorf("stream URB %+v, want stream ID 7", urbs[0])
+	}
+}
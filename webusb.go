@@ -0,0 +1,146 @@
+package usb
+
+import "fmt"
+
+// WebUSBPlatformUUID is the UUID of the platform capability of devices that
+// support WebUSB.
+var WebUSBPlatformUUID = UUID{
+	0x38, 0xb6, 0x08, 0x34, 0xa9, 0x09, 0xa0, 0x47,
+	0x8b, 0xfd, 0xa0, 0x76, 0x88, 0x15, 0xb6, 0x65,
+}
+
+// webusbGetURL is the wIndex of the WebUSB GET_URL request.
+const webusbGetURL = 0x0002
+
+// webusbDTURL is the bDescriptorType of a WebUSB URL descriptor.
+const webusbDTURL = 0x03
+
+const webusbURLHeaderSize = 3
+
+// URL schemes of a URL descriptor.
+const (
+	URLSchemeHTTP  = 0
+	URLSchemeHTTPS = 1
+
+	// URLSchemeNone means that the URL includes its own scheme.
+	URLSchemeNone = 255
+)
+
+// WebUSBCapability is the WebUSB platform capability, see section 4.3.1 of
+// the WebUSB specification.
+type WebUSBCapability struct {
+	Version uint16 `json:"bcdVersion"`
+
+	// VendorCode is the bRequest of the WebUSB vendor requests.
+	VendorCode uint8 `json:"bVendorCode"`
+
+	// LandingPageIndex is the index of the URL descriptor of the device's
+	// landing page, or 0 if it has none.
+	LandingPageIndex uint8 `json:"iLandingPage"`
+}
+
+// WebUSB decodes a WebUSB platform capability.
+func (p *PlatformCapability) WebUSB() (*WebUSBCapability, error) {
+	const op = "decode WebUSB platform capability"
+	if p.UUID != WebUSBPlatformUUID {
+		return nil, codeError(op, LIBUSB_ERROR_INVALID_PARAM)
+	}
+	r := newDescReader(p.Data)
+	w := &WebUSBCapability{Version: r.u16(), VendorCode: r.u8(), LandingPageIndex: r.u8()}
+	if r.short {
+		return nil, descriptorError(op, &DescriptorError{"capability", LIBUSB_BT_PLATFORM_DESCRIPTOR_MIN_SIZE,
+			fmt.Sprintf("%d bytes of capability data is too short", len(p.Data))})
+	}
+	return w, nil
+}
+
+// WebUSB returns the WebUSB platform capability, or nil if the device has
+// none.
+func (b *BOSDescriptor) WebUSB() (*WebUSBCapability, error) {
+	p, err := b.Platform(WebUSBPlatformUUID)
+	if p == nil || err != nil {
+		return nil, err
+	}
+	return p.WebUSB()
+}
+
+// URLDescriptor is a WebUSB URL descriptor, see section 4.4.1 of the WebUSB
+// specification.
+type URLDescriptor struct {
+	Length         uint8  `json:"bLength"`
+	DescriptorType uint8  `json:"bDescriptorType"`
+	Scheme         uint8  `json:"bScheme"`
+	URL            string `json:"URL"`
+}
+
+// String returns the URL with its scheme.
+func (d *URLDescriptor) String() string {
+	switch d.Scheme {
+	case URLSchemeHTTP:
+		return "http://" + d.URL
+	case URLSchemeHTTPS:
+		return "https://" + d.URL
+	}
+	return d.URL
+}
+
+func (d *URLDescriptor) parse(data []byte) error {
+	const path = "url"
+	if len(data) < webusbURLHeaderSize {
+		return &DescriptorError{path, 0, fmt.Sprintf("%d bytes is too short for the header", len(data))}
+	}
+	d.Length, d.DescriptorType, d.Scheme = data[0], data[1], data[2]
+	switch {
+	case int(d.Length) < webusbURLHeaderSize || int(d.Length) > len(data):
+		return &DescriptorError{path, 0, fmt.Sprintf("invalid bLength %d", d.Length)}
+	case d.DescriptorType != webusbDTURL:
+		return &DescriptorError{path, 1, fmt.Sprintf("bDescriptorType 0x%02x is not a URL descriptor", d.DescriptorType)}
+	case d.Scheme != URLSchemeHTTP && d.Scheme != URLSchemeHTTPS && d.Scheme != URLSchemeNone:
+		return &DescriptorError{path, 2, fmt.Sprintf("unknown bScheme %d", d.Scheme)}
+	}
+	d.URL = string(data[webusbURLHeaderSize:d.Length])
+	return nil
+}
+
+// UnmarshalBinary decodes a WebUSB URL descriptor.
+func (d *URLDescriptor) UnmarshalBinary(data []byte) error {
+	if err := d.parse(data); err != nil {
+		return descriptorError("parse URL descriptor", err)
+	}
+	return nil
+}
+
+// WebUSBURL reads the URL descriptor at index with the WebUSB GET_URL
+// request, using the vendor code from the device's WebUSB capability.
+func (h *DeviceHandle) WebUSBURL(vendorCode, index uint8) (*URLDescriptor, error) {
+	const op = "get URL descriptor"
+	buf := make([]byte, 255)
+	n, err := h.vendorRequest(op, RecipientDevice, vendorCode, uint16(index), webusbGetURL, buf)
+	if err != nil {
+		return nil, err
+	}
+	d := new(URLDescriptor)
+	if err := d.parse(buf[:n]); err != nil {
+		return nil, h.dev.descriptorError(op, err)
+	}
+	return d, nil
+}
+
+// WebUSBLandingPage reads the URL of the landing page a WebUSB device
+// advertises. It returns "" if the device does not support WebUSB or has
+// no landing page.
+func (h *DeviceHandle) WebUSBLandingPage() (string, error) {
+	bos, err := h.BOSDescriptor()
+	if err != nil {
+		return "", err
+	}
+	w, err := bos.WebUSB()
+	if w == nil || w.LandingPageIndex == 0 || err != nil {
+		return "", err
+	}
+	d, err := h.WebUSBURL(w.VendorCode, w.LandingPageIndex)
+	if err != nil {
+		return "", err
+	}
+	return d.String(), nil
+}
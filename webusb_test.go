@@ -0,0 +1,61 @@
+package usb
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestWebUSBURL(t *testing.T) {
+	const vendorCode = 0x01
+	urls := map[uint16][]byte{}
+	d := newLoopbackDevice()
+	/* fuzzBOSPlus advertises vendor code 1 and landing page 1 */
+	d.Descriptors = map[uint16][]byte{uint16(LIBUSB_DT_BOS) << 8: fuzzBOSPlus}
+	d.Control = func(req *SimRequest) (int, error) {
+		if req.RequestType != 0xc0 || req.Request != vendorCode || req.Index != webusbGetURL {
+			return 0, ErrNotSupported
+		}
+		url, ok := urls[req.Value]
+		if !ok {
+			return 0, ErrPipe
+		}
+		return copy(req.Data, url), nil
+	}
+	h, _ := openLoopback(t, d)
+
+	urlDescriptor := func(scheme uint8, url string) []byte {
+		return append([]byte{byte(webusbURLHeaderSize + len(url)), webusbDTURL, scheme}, url...)
+	}
+	tests := []struct {
+		scheme uint8
+		url    string
+		want   string
+	}{
+		{URLSchemeHTTP, "example.com", "http://example.com"},
+		{URLSchemeHTTPS, "example.com/landing", "https://example.com/landing"},
+		{URLSchemeNone, "ftp://example.com", "ftp://example.com"},
+	}
+	for _, tt := range tests {
+		urls[1] = urlDescriptor(tt.scheme, tt.url)
+		got, err := h.WebUSBURL(vendorCode, 1)
+		if err != nil {
+			t.Errorf("bScheme %d: %v", tt.scheme, err)
+			continue
+		}
+		if got.Scheme != tt.scheme || got.URL != tt.url || got.String() != tt.want {
+			t.Errorf("bScheme %d: read %+v as %q, want %q", tt.scheme, got, got.String(), tt.want)
+		}
+		if page, err := h.WebUSBLandingPage(); err != nil || page != tt.want {
+			t.Errorf("bScheme %d: landing page %q, %v, want %q", tt.scheme, page, err, tt.want)
+		}
+	}
+
+	urls[1] = urlDescriptor(2, "example.com")
+	var derr *DescriptorError
+	if _, err := h.WebUSBURL(vendorCode, 1); !errors.As(err, &derr) || derr.Offset != 2 {
+		t.Errorf("unknown bScheme returned %v, want a *DescriptorError at offset 2", err)
+	}
+	if _, err := h.WebUSBURL(vendorCode, 2); !errors.Is(err, ErrPipe) {
+		t.Errorf("missing URL descriptor returned %v, want ErrPipe", err)
+	}
+}